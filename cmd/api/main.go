@@ -47,6 +47,15 @@ func run() error {
 		return err
 	}
 
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	defer stopWorkers()
+
+	go container.EmailSender.Run(workersCtx)
+	go container.PurchaseReminder.Run(workersCtx)
+	go container.Reconciler.Run(workersCtx)
+	go container.Renewer.Run(workersCtx)
+	go container.ReceiptSender.Run(workersCtx)
+
 	srv := http.Server{
 		Addr: ":" + config.Port,
 		Handler: http.TimeoutHandler(
@@ -64,6 +73,7 @@ func run() error {
 		signal.Notify(sigint, os.Interrupt)
 		<-sigint
 
+		stopWorkers()
 		if err := srv.Shutdown(context.Background()); err != nil {
 			log.Print(err)
 		}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/kelseyhightower/envconfig"
+	"github.com/knstch/course/internal/app/services/billing/mockbank"
+)
+
+// mockBankConfig - это настройки локального банка. SBER_API_HOST сервиса должен указывать на MOCK_BANK_ADDRESS,
+// SBER_ACCESS_TOKEN совпадать с MOCK_BANK_ACCESS_TOKEN, а SBER_WEBHOOK_SECRET - с MOCK_BANK_WEBHOOK_SECRET.
+type mockBankConfig struct {
+	Address     string        `envconfig:"MOCK_BANK_ADDRESS" default:":8090"`
+	AccessToken string        `envconfig:"MOCK_BANK_ACCESS_TOKEN"`
+	Delay       time.Duration `envconfig:"MOCK_BANK_DELAY"`
+
+	WebhookUrl    string `envconfig:"MOCK_BANK_WEBHOOK_URL"`
+	WebhookSecret string `envconfig:"MOCK_BANK_WEBHOOK_SECRET"`
+}
+
+func main() {
+	var config mockBankConfig
+	if err := envconfig.Process("", &config); err != nil {
+		log.Fatal(err)
+	}
+
+	bank := mockbank.NewBank(config.AccessToken)
+	bank.SetDelay(config.Delay)
+	bank.SetWebhook(config.WebhookUrl, config.WebhookSecret)
+
+	srv := http.Server{
+		Addr:              config.Address,
+		Handler:           bank.Handler(),
+		ReadHeaderTimeout: time.Millisecond * 500,
+	}
+
+	log.Printf("mock bank слушает %v", config.Address)
+	log.Fatal(srv.ListenAndServe())
+}
@@ -6,6 +6,7 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/kelseyhightower/envconfig"
@@ -14,10 +15,64 @@ import (
 type Config struct {
 	Port string `envconfig:"PORT"`
 
+	Environment string `envconfig:"ENVIRONMENT" default:"production"`
+
 	DSN string `envconfig:"DSN"`
 
-	Secret      string `envconfig:"SECRET"`
-	AdminSecret string `envconfig:"ADMIN_SECRET"`
+	Secret string `envconfig:"SECRET"`
+
+	JwtKeysDir      string `envconfig:"JWT_KEYS_DIR"`
+	JwtSigningKeyId string `envconfig:"JWT_SIGNING_KEY_ID"`
+
+	AccessTokenTTL  time.Duration `envconfig:"ACCESS_TOKEN_TTL" default:"15m"`
+	RefreshTokenTTL time.Duration `envconfig:"REFRESH_TOKEN_TTL" default:"720h"`
+
+	LockoutMaxAttempts   int64         `envconfig:"LOCKOUT_MAX_ATTEMPTS" default:"5"`
+	LockoutMaxIpAttempts int64         `envconfig:"LOCKOUT_MAX_IP_ATTEMPTS" default:"20"`
+	LockoutWindow        time.Duration `envconfig:"LOCKOUT_WINDOW" default:"1h"`
+	LockoutBaseDuration  time.Duration `envconfig:"LOCKOUT_BASE_DURATION" default:"1m"`
+	LockoutMaxDuration   time.Duration `envconfig:"LOCKOUT_MAX_DURATION" default:"1h"`
+
+	OAuthRedirectUrl string              `envconfig:"OAUTH_REDIRECT_URL"`
+	OAuthGoogle      OAuthProviderConfig `envconfig:"OAUTH_GOOGLE"`
+	OAuthYandex      OAuthProviderConfig `envconfig:"OAUTH_YANDEX"`
+	OAuthVk          OAuthProviderConfig `envconfig:"OAUTH_VK"`
+
+	MagicLinkUrl string `envconfig:"MAGIC_LINK_URL"`
+
+	PasswordMinEntropy        float64 `envconfig:"PASSWORD_MIN_ENTROPY" default:"50"`
+	PasswordHistorySize       int     `envconfig:"PASSWORD_HISTORY_SIZE" default:"5"`
+	PasswordCommonListPath    string  `envconfig:"PASSWORD_COMMON_LIST_PATH"`
+	PasswordBreachedHashesDir string  `envconfig:"PASSWORD_BREACHED_HASHES_DIR"`
+
+	EmailOutboxPollInterval time.Duration `envconfig:"EMAIL_OUTBOX_POLL_INTERVAL" default:"5s"`
+	EmailOutboxBatchSize    int           `envconfig:"EMAIL_OUTBOX_BATCH_SIZE" default:"20"`
+	EmailOutboxMaxAttempts  int           `envconfig:"EMAIL_OUTBOX_MAX_ATTEMPTS" default:"8"`
+	EmailOutboxRetryBase    time.Duration `envconfig:"EMAIL_OUTBOX_RETRY_BASE" default:"30s"`
+	EmailOutboxRetryMax     time.Duration `envconfig:"EMAIL_OUTBOX_RETRY_MAX" default:"1h"`
+
+	EmailVerifySyntax             bool          `envconfig:"EMAIL_VERIFY_SYNTAX" default:"true"`
+	EmailVerifyDisposable         bool          `envconfig:"EMAIL_VERIFY_DISPOSABLE" default:"true"`
+	EmailVerifyDisposableListPath string        `envconfig:"EMAIL_VERIFY_DISPOSABLE_LIST_PATH"`
+	EmailVerifyMx                 bool          `envconfig:"EMAIL_VERIFY_MX" default:"true"`
+	EmailVerifyMxTimeout          time.Duration `envconfig:"EMAIL_VERIFY_MX_TIMEOUT" default:"2s"`
+	EmailVerifyMxCacheTTL         time.Duration `envconfig:"EMAIL_VERIFY_MX_CACHE_TTL" default:"24h"`
+	EmailVerifySmtp               bool          `envconfig:"EMAIL_VERIFY_SMTP"`
+	EmailVerifySmtpTimeout        time.Duration `envconfig:"EMAIL_VERIFY_SMTP_TIMEOUT" default:"5s"`
+
+	PurchaseReminderPollInterval time.Duration `envconfig:"PURCHASE_REMINDER_POLL_INTERVAL" default:"1m"`
+	PurchaseReminderBatchSize    int           `envconfig:"PURCHASE_REMINDER_BATCH_SIZE" default:"50"`
+	PurchaseReminderMaxAge       time.Duration `envconfig:"PURCHASE_REMINDER_MAX_AGE" default:"24h"`
+
+	ReconciliationPollInterval time.Duration `envconfig:"RECONCILIATION_POLL_INTERVAL" default:"5m"`
+	ReconciliationBatchSize    int           `envconfig:"RECONCILIATION_BATCH_SIZE" default:"100"`
+	ReconciliationLookback     time.Duration `envconfig:"RECONCILIATION_LOOKBACK" default:"24h"`
+
+	SubscriptionPollInterval  time.Duration `envconfig:"SUBSCRIPTION_POLL_INTERVAL" default:"1h"`
+	SubscriptionBatchSize     int           `envconfig:"SUBSCRIPTION_BATCH_SIZE" default:"50"`
+	SubscriptionRenewBefore   time.Duration `envconfig:"SUBSCRIPTION_RENEW_BEFORE" default:"24h"`
+	SubscriptionRetryInterval time.Duration `envconfig:"SUBSCRIPTION_RETRY_INTERVAL" default:"24h"`
+	SubscriptionGracePeriod   time.Duration `envconfig:"SUBSCRIPTION_GRACE_PERIOD" default:"72h"`
 
 	RedisEmailChannelName string `envconfig:"REDIS_EMAIL_CHANNEL_NAME"`
 	RedisDSN              string `envconfig:"REDIS_DSN"`
@@ -31,8 +86,26 @@ type Config struct {
 	CdnGrpcPort    string `envconfig:"CDN_GRPC_PORT"`
 	CdnGrpcHost    string `envconfig:"CDN_GRPC_HOST"`
 
-	SberApiHost     string `envconfig:"SBER_API_HOST"`
-	SberAccessToken string `envconfig:"SBER_ACCESS_TOKEN"`
+	SberApiHost     string        `envconfig:"SBER_API_HOST"`
+	SberAccessToken string        `envconfig:"SBER_ACCESS_TOKEN"`
+	SberApiTimeout  time.Duration `envconfig:"SBER_API_TIMEOUT" default:"10s"`
+
+	SberWebhookSecret string `envconfig:"SBER_WEBHOOK_SECRET"`
+
+	BillingReturnUrl string `envconfig:"BILLING_RETURN_URL"`
+
+	FiscalProvider       string        `envconfig:"FISCAL_PROVIDER" default:"ofd"`
+	FiscalPollInterval   time.Duration `envconfig:"FISCAL_POLL_INTERVAL" default:"1m"`
+	FiscalBatchSize      int           `envconfig:"FISCAL_BATCH_SIZE" default:"50"`
+	FiscalTaxSystem      uint          `envconfig:"FISCAL_TAX_SYSTEM" default:"0"`
+	FiscalVat            string        `envconfig:"FISCAL_VAT" default:"none"`
+	FiscalInn            string        `envconfig:"FISCAL_INN"`
+	FiscalCompanyEmail   string        `envconfig:"FISCAL_COMPANY_EMAIL"`
+	FiscalPaymentAddress string        `envconfig:"FISCAL_PAYMENT_ADDRESS"`
+
+	OfdApiHost     string        `envconfig:"OFD_API_HOST"`
+	OfdAccessToken string        `envconfig:"OFD_ACCESS_TOKEN"`
+	OfdApiTimeout  time.Duration `envconfig:"OFD_API_TIMEOUT" default:"10s"`
 
 	SuperAdminLogin    string `envconfig:"SUPER_ADMIN_LOGIN"`
 	SuperAdminPassword string `envconfig:"SUPER_ADMIN_PASSWORD"`
@@ -42,15 +115,29 @@ type Config struct {
 	ServiceEmail         string `envconfig:"SERVICE_EMAIL"`
 	ServiceEmailPassword string `envconfig:"SERVICE_EMAIL_PASSWORD"`
 
-	SmtpHost string `envconfig:"SMPT_HOST"`
-	SmtpPort string `envconfig:"SMPT_PORT"`
+	SmtpHost        string `envconfig:"SMPT_HOST"`
+	SmtpPort        string `envconfig:"SMPT_PORT"`
+	SmtpImplicitTls bool   `envconfig:"SMTP_IMPLICIT_TLS"`
 
-	IsTest bool `envconfig:"IS_TEST"`
+	MailTransport string `envconfig:"MAIL_TRANSPORT" default:"smtp"`
+	MailDir       string `envconfig:"MAIL_DIR" default:"mail"`
 
 	TechMetricsLogin    string `envconfig:"TECH_METRICS_LOGIN"`
 	TechMetricsPassword string `envconfig:"TECH_METRICS_PASSWORD"`
 }
 
+// OAuthProviderConfig содержит настройки провайдера социального логина. Провайдер включается,
+// если задан ClientId, адреса эндпоинтов по умолчанию берутся из пресета провайдера.
+type OAuthProviderConfig struct {
+	ClientId     string `envconfig:"CLIENT_ID"`
+	ClientSecret string `envconfig:"CLIENT_SECRET"`
+	AuthUrl      string `envconfig:"AUTH_URL"`
+	TokenUrl     string `envconfig:"TOKEN_URL"`
+	UserInfoUrl  string `envconfig:"USER_INFO_URL"`
+	JwksUrl      string `envconfig:"JWKS_URL"`
+	Issuer       string `envconfig:"ISSUER"`
+}
+
 var (
 	config Config
 	once   sync.Once
@@ -12,36 +12,50 @@ import (
 	"github.com/knstch/course/internal/app/logger"
 	"github.com/knstch/course/internal/app/metrics"
 	authmiddleware "github.com/knstch/course/internal/app/middleware/auth_middleware"
+	"github.com/knstch/course/internal/app/services/apikey"
+	"github.com/knstch/course/internal/app/services/auth"
+	"github.com/knstch/course/internal/app/services/billing"
+	"github.com/knstch/course/internal/app/services/email"
+	"github.com/knstch/course/internal/app/services/password"
 	"github.com/knstch/course/internal/app/services/token"
 	"github.com/knstch/course/internal/app/storage"
 )
 
 // Container используется для сборки проекта.
 type Container struct {
-	Storage    *storage.Storage
-	Handlers   *handlers.Handlers
-	Middleware *authmiddleware.Middleware
+	Storage          *storage.Storage
+	Handlers         *handlers.Handlers
+	Middleware       *authmiddleware.Middleware
+	EmailSender      *email.Sender
+	MailTransport    email.MailTransport
+	PurchaseReminder *billing.Reminder
+	Reconciler       *billing.Reconciler
+	Renewer          *billing.SubscriptionRenewer
+	ReceiptSender    *billing.ReceiptSender
 }
 
 // InitContainer инициализирует контейнер, в качестве параметра принимает конфиг и возвращает готовый контейнер или ошибку.
 func InitContainer(dir string, config *config.Config) (*Container, error) {
 	metrics := metrics.InitMetrics()
 
-	psqlStorage, err := storage.NewStorage(config.DSN, config.Secret)
+	dsnRedis, err := redis.ParseURL(config.RedisDSN)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := psqlStorage.Automigrate(config); err != nil {
-		return nil, err
-	}
+	redisClient := redis.NewClient(dsnRedis)
 
-	dsnRedis, err := redis.ParseURL(config.RedisDSN)
+	revocationCache := token.NewRevocationCache(redisClient)
+
+	psqlStorage, err := storage.NewStorage(config.DSN, config.Secret, config.PasswordHistorySize, revocationCache,
+		auth.NewTotpStepCache(redisClient))
 	if err != nil {
 		return nil, err
 	}
 
-	redisClient := redis.NewClient(dsnRedis)
+	if err := psqlStorage.Automigrate(config); err != nil {
+		return nil, err
+	}
 
 	httpClient := &http.Client{
 		Timeout: time.Second * 5,
@@ -64,15 +78,64 @@ func InitContainer(dir string, config *config.Config) (*Container, error) {
 		return nil, err
 	}
 
-	tokenService := token.NewTokenService(psqlStorage, config)
+	keySet, err := token.NewKeySet(config)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordPolicy, err := password.NewPolicy(config)
+	if err != nil {
+		return nil, err
+	}
 
-	middlware := authmiddleware.NewMiddleware(defaultLogger, config, tokenService)
+	emailVerifier, err := email.NewVerifier(redisClient, net.DefaultResolver, &net.Dialer{}, config, defaultLogger)
+	if err != nil {
+		return nil, err
+	}
 
-	handlers := handlers.NewHandlers(psqlStorage, config, redisClient, httpClient, grpcClient, defaultLogger, metrics)
+	tokenService := token.NewTokenService(psqlStorage, revocationCache, metrics, keySet)
+
+	middlware := authmiddleware.NewMiddleware(defaultLogger, config, tokenService, apikey.NewApiKeyService(psqlStorage))
+
+	paymentProvider := billing.NewSberClient(config)
+
+	handlers := handlers.NewHandlers(psqlStorage, config, redisClient, httpClient, grpcClient, keySet, passwordPolicy, emailVerifier,
+		paymentProvider, defaultLogger, metrics)
+
+	mailTransport, err := email.NewMailTransport(config)
+	if err != nil {
+		return nil, err
+	}
+
+	emailSender := email.NewSender(psqlStorage, mailTransport, config, defaultLogger, metrics)
+
+	workerEmailService := email.NewEmailService(redisClient, psqlStorage, emailVerifier)
+
+	purchaseReminder := billing.NewReminder(psqlStorage, workerEmailService, config, defaultLogger)
+
+	reconciler := billing.NewReconciler(psqlStorage, paymentProvider, workerEmailService, config, defaultLogger)
+
+	renewer := billing.NewSubscriptionRenewer(psqlStorage, paymentProvider, config, defaultLogger)
+
+	fiscalProvider, err := billing.NewFiscalProvider(config)
+	if err != nil {
+		return nil, err
+	}
+
+	receiptSender, err := billing.NewReceiptSender(psqlStorage, fiscalProvider, config, defaultLogger)
+	if err != nil {
+		return nil, err
+	}
 
 	return &Container{
 		psqlStorage,
 		handlers,
 		middlware,
+		emailSender,
+		mailTransport,
+		purchaseReminder,
+		reconciler,
+		renewer,
+		receiptSender,
 	}, nil
 }
@@ -21,7 +21,7 @@ var (
 // @Router /v1/admin/management/register [post]
 // @Tags Методы для администрирования
 // @Param adminData body entity.AdminCredentials true "Логин, пароль"
-// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация, декодирование сообщения или пароль не прошел парольную политику"
 // @Failure 403 {object} courseerror.CourseError "Нет прав"
 // @Failure 409 {object} courseerror.CourseError "Невозможно создать админа"
 // @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
@@ -49,7 +49,7 @@ func (h Handlers) CreateAdmin(ctx *gin.Context) {
 	qr, err := h.adminService.RegisterAdmin(ctx, credentials)
 	if err != nil {
 		h.logger.Error(fmt.Sprintf("не получилось зарегистрировать админа с логином: %v", credentials.Login), "CreateAdmin", err.Message, err.Code)
-		if err.Code == 400 {
+		if err.Code == 400 || err.Code == 11401 || err.Code == 11402 || err.Code == 11404 {
 			statusCode = http.StatusBadRequest
 			ctx.AbortWithStatusJSON(statusCode, err)
 			h.metrics.RecordResponse(statusCode, "POST", "CreateAdmin")
@@ -142,6 +142,7 @@ func (h Handlers) VerifyAuthentificator(ctx *gin.Context) {
 // @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
 // @Failure 403 {object} courseerror.CourseError "Неправильный логин, пароль или код"
 // @Failure 404 {object} courseerror.CourseError "Администратор не найден"
+// @Failure 429 {object} courseerror.CourseError "Слишком много неудачных попыток"
 // @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
 func (h Handlers) LogIn(ctx *gin.Context) {
 	var statusCode int
@@ -155,7 +156,7 @@ func (h Handlers) LogIn(ctx *gin.Context) {
 		return
 	}
 
-	token, err := h.adminService.SignIn(ctx, credentials.Login, credentials.Password, credentials.Code)
+	token, err := h.adminService.SignIn(ctx, credentials.Login, credentials.Password, credentials.Code, ctx.ClientIP())
 	if err != nil {
 		h.logger.Error(fmt.Sprintf("не получилось войти в аккаунт с логином %v", credentials.Login), "LogIn", err.Message, err.Code)
 		if err.Code == 400 {
@@ -176,6 +177,12 @@ func (h Handlers) LogIn(ctx *gin.Context) {
 			h.metrics.RecordResponse(statusCode, "POST", "LogIn")
 			return
 		}
+		if err.Code == 11019 {
+			statusCode = http.StatusTooManyRequests
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "LogIn")
+			return
+		}
 		statusCode = http.StatusOK
 		ctx.AbortWithStatusJSON(statusCode, err)
 		h.metrics.RecordResponse(statusCode, "POST", "LogIn")
@@ -199,7 +206,7 @@ func (h Handlers) LogIn(ctx *gin.Context) {
 // @Router /v1/admin/management/resetPassword [patch]
 // @Tags Методы для администрирования
 // @Param adminData body entity.AdminCredentials true "Логин, пароль"
-// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация, декодирование сообщения или пароль не прошел парольную политику"
 // @Failure 403 {object} courseerror.CourseError "Неправильный логин, пароль или код"
 // @Failure 404 {object} courseerror.CourseError "Администратор не найден"
 // @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
@@ -226,7 +233,7 @@ func (h Handlers) ChangeAdminPassword(ctx *gin.Context) {
 
 	if err := h.adminService.ManageAdminPassword(ctx, credentials); err != nil {
 		h.logger.Error(fmt.Sprintf("не получилось изменить пароль админа с логином %v", credentials.Login), "ChangeAdminPassword", err.Message, err.Code)
-		if err.Code == 400 {
+		if err.Code == 400 || err.Code == 11401 || err.Code == 11402 || err.Code == 11403 || err.Code == 11404 {
 			statusCode = http.StatusBadRequest
 			ctx.AbortWithStatusJSON(statusCode, err)
 			h.metrics.RecordResponse(statusCode, "PATCH", "ChangeAdminPassword")
@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	courseerror "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+// @Summary Создать API ключ
+// @Accept json
+// @Produce json
+// @Description Используется для выпуска персонального API ключа. Ключ передается в заголовке Authorization: Bearer
+// @Description вместо куки auth. Права read разрешают GET запросы, права write - остальные. Ключ показывается только один раз.
+// @Success 201 {object} entity.CreatedApiKey
+// @Router /v1/profile/apiKeys [post]
+// @Tags Методы для администрирования профиля
+// @Param apiKey body entity.ApiKeyToCreate true "Название и права ключа"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
+// @Failure 403 {object} courseerror.CourseError "Запрос выполнен по API ключу"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) CreateApiKey(ctx *gin.Context) {
+	var statusCode int
+
+	userId := ctx.Value("UserId").(uint)
+
+	apiKey := entity.NewApiKeyToCreate()
+	if err := ctx.ShouldBindJSON(&apiKey); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "CreateApiKey", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "POST", "CreateApiKey")
+		return
+	}
+
+	createdApiKey, err := h.apiKeyService.CreateUserApiKey(ctx, apiKey)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("не получилось создать API ключ пользователю с ID: %d", userId), "CreateApiKey", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "CreateApiKey")
+			return
+		}
+		if err.Code == 11303 {
+			statusCode = http.StatusForbidden
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "CreateApiKey")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "CreateApiKey")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("API ключ с ID: %d успешно создан", createdApiKey.Id), "CreateApiKey", fmt.Sprintf("ID: %d", userId))
+
+	statusCode = http.StatusCreated
+	ctx.JSON(statusCode, createdApiKey)
+	h.metrics.RecordResponse(statusCode, "POST", "CreateApiKey")
+}
+
+// @Summary Получить API ключи
+// @Produce json
+// @Description Используется для получения неотозванных API ключей пользователя. Сам ключ не возвращается, только его начало.
+// @Success 200 {array} entity.ApiKey
+// @Router /v1/profile/apiKeys [get]
+// @Tags Методы для администрирования профиля
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) GetApiKeys(ctx *gin.Context) {
+	var statusCode int
+
+	userId := ctx.Value("UserId").(uint)
+
+	apiKeys, err := h.apiKeyService.RetreiveUserApiKeys(ctx)
+	if err != nil {
+		statusCode = http.StatusInternalServerError
+		h.logger.Error(fmt.Sprintf("ошибка при получении API ключей пользователя с ID: %d", userId), "GetApiKeys", err.Message, err.Code)
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "GetApiKeys")
+		return
+	}
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, apiKeys)
+	h.metrics.RecordResponse(statusCode, "GET", "GetApiKeys")
+}
+
+// @Summary Отозвать API ключ
+// @Produce json
+// @Description Используется для отзыва API ключа пользователя.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/profile/apiKeys/{id} [delete]
+// @Tags Методы для администрирования профиля
+// @Param id path string true "ID ключа"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация"
+// @Failure 404 {object} courseerror.CourseError "Ключ не найден"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) RevokeApiKey(ctx *gin.Context) {
+	var statusCode int
+
+	userId := ctx.Value("UserId").(uint)
+	keyId := ctx.Param("id")
+
+	if err := h.apiKeyService.RevokeUserApiKey(ctx, keyId); err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при отзыве API ключа %v пользователя с ID: %d", keyId, userId), "RevokeApiKey", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "DELETE", "RevokeApiKey")
+			return
+		}
+		if err.Code == 11304 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "DELETE", "RevokeApiKey")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "DELETE", "RevokeApiKey")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("API ключ %v успешно отозван", keyId), "RevokeApiKey", fmt.Sprintf("ID: %d", userId))
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("API ключ успешно отозван"))
+	h.metrics.RecordResponse(statusCode, "DELETE", "RevokeApiKey")
+}
+
+// @Summary Создать API ключ админа
+// @Accept json
+// @Produce json
+// @Description Используется для выпуска персонального API ключа администратора. Ключ передается в заголовке
+// @Description Authorization: Bearer вместо куки admin_auth, его права дополнительно ограничены ролью администратора.
+// @Success 201 {object} entity.CreatedApiKey
+// @Router /v1/admin/management/apiKeys [post]
+// @Tags Методы для администрирования
+// @Param apiKey body entity.ApiKeyToCreate true "Название и права ключа"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
+// @Failure 403 {object} courseerror.CourseError "Запрос выполнен по API ключу"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) CreateAdminApiKey(ctx *gin.Context) {
+	var statusCode int
+
+	adminId := ctx.Value("AdminId").(uint)
+
+	apiKey := entity.NewApiKeyToCreate()
+	if err := ctx.ShouldBindJSON(&apiKey); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "CreateAdminApiKey", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "POST", "CreateAdminApiKey")
+		return
+	}
+
+	createdApiKey, err := h.apiKeyService.CreateAdminApiKey(ctx, apiKey)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("не получилось создать API ключ админу с ID: %d", adminId), "CreateAdminApiKey", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "CreateAdminApiKey")
+			return
+		}
+		if err.Code == 11303 {
+			statusCode = http.StatusForbidden
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "CreateAdminApiKey")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "CreateAdminApiKey")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("API ключ с ID: %d успешно создан", createdApiKey.Id), "CreateAdminApiKey", fmt.Sprintf("ID админа: %d", adminId))
+
+	statusCode = http.StatusCreated
+	ctx.JSON(statusCode, createdApiKey)
+	h.metrics.RecordResponse(statusCode, "POST", "CreateAdminApiKey")
+}
+
+// @Summary Получить API ключи админа
+// @Produce json
+// @Description Используется для получения неотозванных API ключей администратора. Сам ключ не возвращается, только его начало.
+// @Success 200 {array} entity.ApiKey
+// @Router /v1/admin/management/apiKeys [get]
+// @Tags Методы для администрирования
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) GetAdminApiKeys(ctx *gin.Context) {
+	var statusCode int
+
+	adminId := ctx.Value("AdminId").(uint)
+
+	apiKeys, err := h.apiKeyService.RetreiveAdminApiKeys(ctx)
+	if err != nil {
+		statusCode = http.StatusInternalServerError
+		h.logger.Error(fmt.Sprintf("ошибка при получении API ключей админа с ID: %d", adminId), "GetAdminApiKeys", err.Message, err.Code)
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "GetAdminApiKeys")
+		return
+	}
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, apiKeys)
+	h.metrics.RecordResponse(statusCode, "GET", "GetAdminApiKeys")
+}
+
+// @Summary Отозвать API ключ админа
+// @Produce json
+// @Description Используется для отзыва API ключа администратора.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/admin/management/apiKeys/{id} [delete]
+// @Tags Методы для администрирования
+// @Param id path string true "ID ключа"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация"
+// @Failure 404 {object} courseerror.CourseError "Ключ не найден"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) RevokeAdminApiKey(ctx *gin.Context) {
+	var statusCode int
+
+	adminId := ctx.Value("AdminId").(uint)
+	keyId := ctx.Param("id")
+
+	if err := h.apiKeyService.RevokeAdminApiKey(ctx, keyId); err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при отзыве API ключа %v админа с ID: %d", keyId, adminId), "RevokeAdminApiKey", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "DELETE", "RevokeAdminApiKey")
+			return
+		}
+		if err.Code == 11304 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "DELETE", "RevokeAdminApiKey")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "DELETE", "RevokeAdminApiKey")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("API ключ %v успешно отозван", keyId), "RevokeAdminApiKey", fmt.Sprintf("ID админа: %d", adminId))
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("API ключ успешно отозван"))
+	h.metrics.RecordResponse(statusCode, "DELETE", "RevokeAdminApiKey")
+}
@@ -8,17 +8,34 @@ import (
 	"github.com/gin-gonic/gin"
 
 	courseerror "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/services/auth"
 	"github.com/knstch/course/internal/domain/entity"
 )
 
 const (
 	fiveDaysInSeconds = 432000
+
+	refreshCookiePath = "/api/v1/auth/refresh"
 )
 
 var (
 	errUserEmailIsAlreadyVerified = errors.New("почта пользователя уже верифицирована")
+	errUserNotAuthentificated     = errors.New("пользователь не авторизован")
 )
 
+// setUserTokens устанавливает пользователю куки с токеном доступа и refresh токеном.
+// Refresh токен отправляется браузером только на эндпоинт обновления токенов.
+func (h Handlers) setUserTokens(ctx *gin.Context, tokens *auth.Tokens) {
+	ctx.SetCookie("auth", tokens.AccessToken, int(h.accessTokenTTL.Seconds()), "/", h.address, true, true)
+	ctx.SetCookie("refresh", tokens.RefreshToken, int(h.refreshTokenTTL.Seconds()), refreshCookiePath, h.address, true, true)
+}
+
+// clearUserTokens удаляет у пользователя куки с токеном доступа и refresh токеном.
+func (h Handlers) clearUserTokens(ctx *gin.Context) {
+	ctx.SetCookie("auth", "", -1, "/", h.address, true, true)
+	ctx.SetCookie("refresh", "", -1, refreshCookiePath, h.address, true, true)
+}
+
 // @Summary Зарегестрироваться пользователю
 // @Produce json
 // @Accept json
@@ -27,7 +44,7 @@ var (
 // @Router /v1/auth/register [post]
 // @Tags Методы для авторизации пользователей
 // @Param credentials body entity.Credentials true "Учетные данные"
-// @Failure 400 {object} courseerror.CourseError "Провалена валидация, или не удалось декодировать сообщение, или почта уже занята"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация, или не удалось декодировать сообщение, или почта уже занята, или пароль не прошел парольную политику"
 // @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
 func (h Handlers) SignUp(ctx *gin.Context) {
 	var statusCode int
@@ -41,10 +58,10 @@ func (h Handlers) SignUp(ctx *gin.Context) {
 		return
 	}
 
-	token, err := h.authService.Register(ctx, credentials)
+	tokens, err := h.authService.Register(ctx, credentials, entity.NewDevice(ctx.Request.UserAgent(), ctx.ClientIP()))
 	if err != nil {
 		h.logger.Error(fmt.Sprintf("не получилось зарегистрировать пользователя с почтой %v", credentials.Email), "SignUp", err.Message, err.Code)
-		if err.Code == 11001 || err.Code == 400 {
+		if err.Code == 11001 || err.Code == 400 || err.Code == 11401 || err.Code == 11402 || err.Code == 11404 {
 			statusCode = http.StatusBadRequest
 			ctx.AbortWithStatusJSON(statusCode, err)
 			h.metrics.RecordResponse(statusCode, "POST", "SignUp")
@@ -56,7 +73,7 @@ func (h Handlers) SignUp(ctx *gin.Context) {
 			h.metrics.RecordResponse(statusCode, "POST", "SignUp")
 			return
 		}
-		if err.Code == 17003 || err.Code == 17004 {
+		if err.Code == 17003 || err.Code == 17004 || err.Code == 17008 || err.Code == 17009 {
 			statusCode = http.StatusBadRequest
 			ctx.AbortWithStatusJSON(statusCode, err)
 			h.metrics.RecordResponse(statusCode, "POST", "SignUp")
@@ -68,7 +85,7 @@ func (h Handlers) SignUp(ctx *gin.Context) {
 		return
 	}
 
-	ctx.SetCookie("auth", *token, fiveDaysInSeconds, "/", h.address, true, true)
+	h.setUserTokens(ctx, tokens)
 
 	h.logger.Info("пользователь успешно зарегистрирован", "SignUp", credentials.Email)
 
@@ -80,14 +97,17 @@ func (h Handlers) SignUp(ctx *gin.Context) {
 // @Summary Залогиниться пользователю
 // @Produce json
 // @Accept json
-// @Description Используется для логина пользователей.
+// @Description Используется для логина пользователей. Если у пользователя включена двухфакторная аутентификация,
+// @Description то возвращается челлендж, вход завершается через /v1/auth/loginTwoFactor.
 // @Success 200 {object} entity.SuccessResponse
+// @Success 202 {object} entity.TwoStepAuthRequired
 // @Router /v1/auth/login [post]
 // @Tags Методы для авторизации пользователей
 // @Param credentials body entity.Credentials true "Учетные данные"
 // @Failure 400 {object} courseerror.CourseError "Провалена валидация, или декодирование сообщения, или почта уже занята"
 // @Failure 404 {object} courseerror.CourseError "Пользователь не найден"
 // @Failure 405 {object} courseerror.CourseError "Пользователь неактивен"
+// @Failure 429 {object} courseerror.CourseError "Слишком много неудачных попыток"
 // @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
 func (h Handlers) SignIn(ctx *gin.Context) {
 	var statusCode int
@@ -101,7 +121,7 @@ func (h Handlers) SignIn(ctx *gin.Context) {
 		return
 	}
 
-	token, err := h.authService.LogIn(ctx, credentials)
+	tokens, challenge, err := h.authService.LogIn(ctx, credentials, entity.NewDevice(ctx.Request.UserAgent(), ctx.ClientIP()))
 	if err != nil {
 		h.logger.Error(fmt.Sprintf("не получилось залогиниться c почтой %v", credentials.Email), "SignIn", err.Message, err.Code)
 		if err.Code == 400 {
@@ -122,13 +142,28 @@ func (h Handlers) SignIn(ctx *gin.Context) {
 			h.metrics.RecordResponse(statusCode, "POST", "SignIn")
 			return
 		}
+		if err.Code == 11019 {
+			statusCode = http.StatusTooManyRequests
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "SignIn")
+			return
+		}
 		statusCode = http.StatusInternalServerError
 		ctx.AbortWithStatusJSON(statusCode, err)
 		h.metrics.RecordResponse(statusCode, "POST", "SignIn")
 		return
 	}
 
-	ctx.SetCookie("auth", *token, fiveDaysInSeconds, "/", h.address, true, true)
+	if challenge != nil {
+		h.logger.Info(fmt.Sprintf("пользователю с IP: %v требуется второй шаг аутентификации", ctx.ClientIP()), "SignIn", credentials.Email)
+
+		statusCode = http.StatusAccepted
+		ctx.JSON(statusCode, entity.CreateTwoStepAuthRequired(*challenge))
+		h.metrics.RecordResponse(statusCode, "POST", "SignIn")
+		return
+	}
+
+	h.setUserTokens(ctx, tokens)
 
 	h.logger.Info(fmt.Sprintf("пользователь успешно залогинен c IP: %v", ctx.ClientIP()), "SignIn", credentials.Email)
 
@@ -147,6 +182,7 @@ func (h Handlers) SignIn(ctx *gin.Context) {
 // @Failure 400 {object} courseerror.CourseError "Провалена валидация, или декодирование сообщения, или почта пользователя уже подтверждена"
 // @Failure 403 {object} courseerror.CourseError "Код подтверждения не совпал"
 // @Failure 404 {object} courseerror.CourseError "Код подтверждения не найден"
+// @Failure 429 {object} courseerror.CourseError "Слишком много неудачных попыток"
 // @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
 func (h Handlers) Verification(ctx *gin.Context) {
 	var statusCode int
@@ -162,7 +198,7 @@ func (h Handlers) Verification(ctx *gin.Context) {
 		return
 	}
 
-	token, err := h.authService.VerifyEmail(ctx, confirmCode, userId)
+	tokens, err := h.authService.VerifyEmail(ctx, confirmCode, userId, entity.NewDevice(ctx.Request.UserAgent(), ctx.ClientIP()))
 	if err != nil {
 		h.logger.Error(fmt.Sprintf("не получилось верифицировать почту пользователя c ID = %d", userId), "Verification", err.Message, err.Code)
 		if err.Code == 11003 {
@@ -183,13 +219,19 @@ func (h Handlers) Verification(ctx *gin.Context) {
 			h.metrics.RecordResponse(statusCode, "POST", "Verification")
 			return
 		}
+		if err.Code == 11019 {
+			statusCode = http.StatusTooManyRequests
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "Verification")
+			return
+		}
 		statusCode = http.StatusInternalServerError
 		ctx.AbortWithStatusJSON(statusCode, err)
 		h.metrics.RecordResponse(statusCode, "POST", "Verification")
 		return
 	}
 
-	ctx.SetCookie("auth", *token, fiveDaysInSeconds, "/", h.address, true, true)
+	h.setUserTokens(ctx, tokens)
 
 	h.logger.Info("пользователь успешно верифицирован", "Verification", fmt.Sprintf("userId: %d", userId))
 
@@ -298,8 +340,9 @@ func (h Handlers) SendRecoverPasswordCode(ctx *gin.Context) {
 // @Router /v1/auth/recoverPassword [post]
 // @Tags Методы для авторизации пользователей
 // @Param recoverCredentials body entity.PasswordRecoverCredentials true "Почта, новый пароль и код"
-// @Failure 400 {object} courseerror.CourseError "Провалена валидация или код подтверждения неверный"
-// @Failure 404 {object} courseerror.CourseError "Код не найден"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация, код подтверждения неверный или пароль не прошел парольную политику"
+// @Failure 404 {object} courseerror.CourseError "Код или пользователь не найден"
+// @Failure 429 {object} courseerror.CourseError "Слишком много неудачных попыток"
 // @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
 func (h Handlers) SetNewPassword(ctx *gin.Context) {
 	var statusCode int
@@ -313,20 +356,26 @@ func (h Handlers) SetNewPassword(ctx *gin.Context) {
 		return
 	}
 
-	if err := h.authService.RecoverPassword(ctx, *recoverCredentials); err != nil {
+	if err := h.authService.RecoverPassword(ctx, *recoverCredentials, ctx.ClientIP()); err != nil {
 		h.logger.Error(fmt.Sprintf("не получилось изменить пароль пользователя с email = %v", recoverCredentials.Email), "SetNewPassword", err.Message, err.Code)
-		if err.Code == 400 || err.Code == 11003 {
+		if err.Code == 400 || err.Code == 11003 || err.Code == 11401 || err.Code == 11402 || err.Code == 11403 || err.Code == 11404 {
 			statusCode = http.StatusBadRequest
 			ctx.AbortWithStatusJSON(statusCode, err)
 			h.metrics.RecordResponse(statusCode, "POST", "SetNewPassword")
 			return
 		}
-		if err.Code == 11004 {
+		if err.Code == 11004 || err.Code == 11002 {
 			statusCode = http.StatusNotFound
 			ctx.AbortWithStatusJSON(statusCode, err)
 			h.metrics.RecordResponse(statusCode, "POST", "SetNewPassword")
 			return
 		}
+		if err.Code == 11019 {
+			statusCode = http.StatusTooManyRequests
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "SetNewPassword")
+			return
+		}
 		statusCode = http.StatusInternalServerError
 		ctx.AbortWithStatusJSON(statusCode, err)
 		h.metrics.RecordResponse(statusCode, "POST", "SetNewPassword")
@@ -339,3 +388,61 @@ func (h Handlers) SetNewPassword(ctx *gin.Context) {
 	ctx.JSON(statusCode, entity.CreateSuccessResponse("пароль успешно восстановлен"))
 	h.metrics.RecordResponse(statusCode, "POST", "SetNewPassword")
 }
+
+// @Summary Обновить токены пользователя
+// @Produce json
+// @Description Используется для получения нового токена доступа по refresh токену из куки. Refresh токен одноразовый,
+// @Description при повторном использовании все сессии, выпущенные по этому входу, будут завершены.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/auth/refresh [post]
+// @Tags Методы для авторизации пользователей
+// @Failure 403 {object} courseerror.CourseError "Refresh токен отсутствует, устарел, отозван или использован повторно"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) RefreshTokens(ctx *gin.Context) {
+	var statusCode int
+
+	cookie, cookieErr := ctx.Request.Cookie("refresh")
+	if cookieErr != nil {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("отсутствует refresh токен, вызов с IP: %v", ctx.ClientIP()), "RefreshTokens", cookieErr.Error(), 11009)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errUserNotAuthentificated, 11009))
+		h.metrics.RecordResponse(statusCode, "POST", "RefreshTokens")
+		return
+	}
+
+	tokens, err := h.authService.RefreshTokens(ctx, cookie.Value, entity.NewDevice(ctx.Request.UserAgent(), ctx.ClientIP()))
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("не получилось обновить токены c IP: %v", ctx.ClientIP()), "RefreshTokens", err.Message, err.Code)
+		if err.Code == 11006 || err.Code == 11007 || err.Code == 11012 {
+			statusCode = http.StatusForbidden
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "RefreshTokens")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "RefreshTokens")
+		return
+	}
+
+	h.setUserTokens(ctx, tokens)
+
+	h.logger.Info(fmt.Sprintf("токены успешно обновлены c IP: %v", ctx.ClientIP()), "RefreshTokens", "")
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("токены обновлены"))
+	h.metrics.RecordResponse(statusCode, "POST", "RefreshTokens")
+}
+
+// @Summary Получить публичные ключи для проверки JWT
+// @Produce json
+// @Description Используется другими сервисами для проверки подписи токенов пользователей и администраторов.
+// @Description Ключ для проверки выбирается по kid из заголовка токена.
+// @Success 200 {object} entity.JWKS
+// @Router /.well-known/jwks.json [get]
+// @Tags Методы для авторизации пользователей
+func (h Handlers) GetJWKS(ctx *gin.Context) {
+	statusCode := http.StatusOK
+	ctx.JSON(statusCode, h.keys.JWKS())
+	h.metrics.RecordResponse(statusCode, "GET", "GetJWKS")
+}
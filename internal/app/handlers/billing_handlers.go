@@ -4,14 +4,20 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	courseerror "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/services/billing"
 	"github.com/knstch/course/internal/domain/entity"
 )
 
+const (
+	maxWebhookSize = 1 << 20
+)
+
 var (
 	errNotVerified = errors.New("для покупки курса нужно верифицировать почту")
 )
@@ -22,10 +28,11 @@ var (
 // @Success 307 "Temporary Redirect"
 // @Router /v1/billing/buyCourse [post]
 // @Tags Методы биллинга
-// @Param orderDetails body entity.BuyDetails true "ID курса и способ платежа"
-// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
-// @Failure 409 {object} courseerror.CourseError "Курс уже куплен"
+// @Param orderDetails body entity.BuyDetails true "ID курса, способ платежа, необязательные валюта и промокод"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация, декодирование сообщения, курс не продается в валюте или промокод не найден, истек или не действует на курс"
+// @Failure 409 {object} courseerror.CourseError "Курс уже куплен, лимит промокода исчерпан или промокод только для первой покупки"
 // @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+// @Failure 502 {object} courseerror.CourseError "Банк отклонил запрос или недоступен"
 func (h Handlers) BuyCourse(ctx *gin.Context) {
 	var statusCode int
 
@@ -60,12 +67,24 @@ func (h Handlers) BuyCourse(ctx *gin.Context) {
 			h.metrics.RecordResponse(statusCode, "POST", "BuyCourse")
 			return
 		}
-		if err.Code == 15004 {
+		if err.Code == 15010 || err.Code == 15011 || err.Code == 15012 || err.Code == 15027 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "BuyCourse")
+			return
+		}
+		if err.Code == 15004 || err.Code == 15013 || err.Code == 15014 {
 			statusCode = http.StatusConflict
 			ctx.AbortWithStatusJSON(statusCode, err)
 			h.metrics.RecordResponse(statusCode, "POST", "BuyCourse")
 			return
 		}
+		if err.Code == 15005 || err.Code == 15006 {
+			statusCode = http.StatusBadGateway
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "BuyCourse")
+			return
+		}
 		statusCode = http.StatusInternalServerError
 		ctx.AbortWithStatusJSON(statusCode, err)
 		h.metrics.RecordResponse(statusCode, "POST", "BuyCourse")
@@ -80,49 +99,52 @@ func (h Handlers) BuyCourse(ctx *gin.Context) {
 }
 
 // @Summary Оплата курса подтверждена
-// @Description Используется для подтверждения оплаты платежным шлюзом. Если оплата прошла успешно, редиректит на этот хендлер и затем происходит редирект на страницу с курсом.
+// @Produce json
+// @Description Сюда банк возвращает пользователя после оплаты. Оплата отмечается по уведомлению от банка, метод только показывает статус: если курс оплачен, редиректит на страницу с курсом, иначе возвращает статус оплаты.
+// @Success 200 {object} entity.PaymentStatus
 // @Success 307 "Temporary Redirect"
 // @Router /v1/billing/successPayment/{userData} [get]
 // @Tags Методы биллинга
 // @Param userData path string true "Захешированные данные пользователя"
-// @Failure 400 {object} courseerror.CourseError "Инвойс ID не совпадает с хэшем из path"
 // @Failure 404 {object} courseerror.CourseError "Заказ не найден"
 // @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
 func (h Handlers) CompletePurchase(ctx *gin.Context) {
 	var statusCode int
 
 	userData := ctx.Param("userData")
-	courseName, err := h.sberBillingService.ConfirmPayment(ctx, userData)
+	status, err := h.sberBillingService.GetPaymentStatus(ctx, userData)
 	if err != nil {
-		h.logger.Error(fmt.Sprintf("ошибка при завершении покупки заказа %v", userData), "CompletePurchase", err.Message, err.Code)
-		if err.Code == 11004 || err.Code == 15001 || err.Code == 15002 {
+		h.logger.Error(fmt.Sprintf("ошибка при получении статуса оплаты заказа %v", userData), "CompletePurchase", err.Message, err.Code)
+		if err.Code == 11004 || err.Code == 15001 {
 			statusCode = http.StatusNotFound
 			ctx.AbortWithStatusJSON(statusCode, err)
 			h.metrics.RecordResponse(statusCode, "GET", "CompletePurchase")
 			return
 		}
-		if err.Code == 15003 {
-			statusCode = http.StatusBadRequest
-			ctx.AbortWithStatusJSON(statusCode, err)
-			h.metrics.RecordResponse(statusCode, "GET", "CompletePurchase")
-			return
-		}
 		statusCode = http.StatusInternalServerError
 		ctx.AbortWithStatusJSON(statusCode, err)
 		h.metrics.RecordResponse(statusCode, "GET", "CompletePurchase")
 		return
 	}
 
-	h.logger.Info("курс успешно приобретен", "CompletePurchase", userData)
+	if status.Status == billing.PaymentStatusPaid {
+		h.logger.Info("курс успешно приобретен", "CompletePurchase", userData)
 
-	statusCode = http.StatusTemporaryRedirect
-	ctx.Redirect(statusCode, fmt.Sprintf("%v/%v", h.address, courseName))
+		statusCode = http.StatusTemporaryRedirect
+		ctx.Redirect(statusCode, fmt.Sprintf("%v/%v", h.address, status.CourseName))
+		h.metrics.RecordResponse(statusCode, "GET", "CompletePurchase")
+		return
+	}
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, status)
 	h.metrics.RecordResponse(statusCode, "GET", "CompletePurchase")
 }
 
 // @Summary Оплата курса провалена
-// @Description Используется для отмены заказа, если платеж был провален. Метод редиректит на страницу с заказами пользователя.
-// @Success 307 "Temporary Redirect"
+// @Produce json
+// @Description Сюда банк возвращает пользователя, если платеж был провален. Заказ отменяется по уведомлению от банка, метод только показывает статус оплаты.
+// @Success 200 {object} entity.PaymentStatus
 // @Router /v1/billing/failPayment/{userData} [get]
 // @Tags Методы биллинга
 // @Param userData path string true "Захешированные данные пользователя"
@@ -132,8 +154,9 @@ func (h Handlers) DeclineOrder(ctx *gin.Context) {
 	var statusCode int
 
 	userData := ctx.Param("userData")
-	if err := h.sberBillingService.FailPayment(ctx, userData); err != nil {
-		h.logger.Error(fmt.Sprintf("ошибка при отмененной оплатае с заказом: %v", userData), "DeclineOrder", err.Message, err.Code)
+	status, err := h.sberBillingService.GetPaymentStatus(ctx, userData)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при получении статуса отмененной оплаты заказа: %v", userData), "DeclineOrder", err.Message, err.Code)
 		if err.Code == 11004 || err.Code == 15001 {
 			statusCode = http.StatusNotFound
 			ctx.AbortWithStatusJSON(statusCode, err)
@@ -146,13 +169,75 @@ func (h Handlers) DeclineOrder(ctx *gin.Context) {
 		return
 	}
 
-	h.logger.Info("заказ успешно отменен", "DeclineOrder", userData)
-
-	statusCode = http.StatusTemporaryRedirect
-	ctx.Redirect(statusCode, fmt.Sprintf("%v/orders", h.address))
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, status)
 	h.metrics.RecordResponse(statusCode, "GET", "DeclineOrder")
 }
 
+// @Summary Уведомление от банка
+// @Accept json
+// @Produce json
+// @Description Используется банком для уведомления о статусе инвойса и возвратах. Тело подписывается HMAC-SHA256 с SBER_WEBHOOK_SECRET, подпись в hex передается в заголовке X-Signature. Повторное уведомление по тому же инвойсу ничего не меняет.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/billing/webhook [post]
+// @Tags Методы биллинга
+// @Param webhook body entity.SberWebhook true "ID инвойса и статус"
+// @Failure 400 {object} courseerror.CourseError "Уведомление передано в неверном формате"
+// @Failure 401 {object} courseerror.CourseError "Неверная подпись"
+// @Failure 404 {object} courseerror.CourseError "Инвойс не найден"
+// @Failure 409 {object} courseerror.CourseError "Инвойс не оплачен или сумма возврата больше оплаченной"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) PaymentWebhook(ctx *gin.Context) {
+	var statusCode int
+
+	payload, readErr := io.ReadAll(http.MaxBytesReader(ctx.Writer, ctx.Request.Body, maxWebhookSize))
+	if readErr != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось прочитать уведомление от банка", "PaymentWebhook", readErr.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "POST", "PaymentWebhook")
+		return
+	}
+
+	if err := h.sberBillingService.HandleWebhook(ctx, payload, ctx.GetHeader(billing.WebhookSignatureHeader)); err != nil {
+		h.logger.Error("ошибка при обработке уведомления от банка", "PaymentWebhook", err.Message, err.Code)
+		if err.Code == 15007 {
+			statusCode = http.StatusUnauthorized
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "PaymentWebhook")
+			return
+		}
+		if err.Code == 10101 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "PaymentWebhook")
+			return
+		}
+		if err.Code == 15001 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "PaymentWebhook")
+			return
+		}
+		if err.Code == 15008 || err.Code == 15009 {
+			statusCode = http.StatusConflict
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "PaymentWebhook")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "PaymentWebhook")
+		return
+	}
+
+	h.logger.Info("уведомление от банка обработано", "PaymentWebhook", string(payload))
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("уведомление обработано"))
+	h.metrics.RecordResponse(statusCode, "POST", "PaymentWebhook")
+}
+
 // @Summary Изменить billing host
 // @Produce json
 // @Accept json
@@ -249,3 +334,129 @@ func (h Handlers) ManageAccessToken(ctx *gin.Context) {
 	ctx.JSON(statusCode, entity.CreateSuccessResponse("токен успешно изменен"))
 	h.metrics.RecordResponse(statusCode, "PATCH", "ManageAccessToken")
 }
+
+// @Summary Получить отчеты о сверке с банком
+// @Produce json
+// @Description Используется для просмотра отчетов фоновой сверки заказов со статусами инвойсов в банке. В отчете
+// @Description перечислены расхождения: paid_not_confirmed - банк получил оплату, а уведомление не дошло (оплата отмечается
+// @Description автоматически), paid_not_at_provider - заказ оплачен у нас, но не в банке, status_mismatch - возврат у нас и в банке отличается, missing_at_provider - банк не знает инвойс.
+// @Description Метод доступен супер админу и админу.
+// @Success 200 {object} entity.ReconciliationReportsWithPagination
+// @Router /v1/admin/management/reconciliationReports [get]
+// @Tags Методы биллинга
+// @Param page query string true "Страница"
+// @Param limit query string true "Лимит"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) GetReconciliationReports(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "GetReconciliationReports", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "GET", "GetReconciliationReports")
+		return
+	}
+
+	page := ctx.Query("page")
+	limit := ctx.Query("limit")
+
+	reports, err := h.sberBillingService.RetreiveReconciliationReports(ctx, page, limit)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при получении отчетов о сверке по запросу: page - %v, limit - %v", page, limit), "GetReconciliationReports", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "GET", "GetReconciliationReports")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "GetReconciliationReports")
+		return
+	}
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, reports)
+	h.metrics.RecordResponse(statusCode, "GET", "GetReconciliationReports")
+}
+
+// @Summary Вернуть деньги за курс
+// @Accept json
+// @Produce json
+// @Description Используется для полного или частичного возврата денег по платежу. Суммы передаются в минимальных единицах
+// @Description валюты платежа. Если сумма не передана, возвращается весь остаток оплаты. После полного возврата пользователь теряет доступ к курсу. Пользователю отправляется
+// @Description письмо о возврате. Метод доступен только супер админу.
+// @Success 200 {object} entity.RefundResult
+// @Router /v1/admin/management/refunds [post]
+// @Tags Методы биллинга
+// @Param refund body entity.RefundRequest true "ID платежа, сумма и причина возврата"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 404 {object} courseerror.CourseError "Платеж не найден"
+// @Failure 409 {object} courseerror.CourseError "Платеж нельзя вернуть или сумма больше оплаченной"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+// @Failure 502 {object} courseerror.CourseError "Банк отклонил запрос или недоступен"
+func (h Handlers) RefundPurchase(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "RefundPurchase", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "POST", "RefundPurchase")
+		return
+	}
+
+	request := entity.CreateNewRefundRequest()
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "RefundPurchase", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "POST", "RefundPurchase")
+		return
+	}
+
+	result, err := h.sberBillingService.RefundPurchase(ctx, request)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при возврате денег по платежу с ID: %d", request.BillingId), "RefundPurchase", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "RefundPurchase")
+			return
+		}
+		if err.Code == 15001 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "RefundPurchase")
+			return
+		}
+		if err.Code == 15008 || err.Code == 15009 {
+			statusCode = http.StatusConflict
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "RefundPurchase")
+			return
+		}
+		if err.Code == 15005 || err.Code == 15006 {
+			statusCode = http.StatusBadGateway
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "RefundPurchase")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "RefundPurchase")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("админ с ID: %d вернул деньги по платежу с ID: %d", ctx.Value("AdminId"), request.BillingId), "RefundPurchase", fmt.Sprint(result.Amount))
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, result)
+	h.metrics.RecordResponse(statusCode, "POST", "RefundPurchase")
+}
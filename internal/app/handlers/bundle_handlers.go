@@ -0,0 +1,349 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	courseerror "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+// @Summary Получить наборы курсов
+// @Produce json
+// @Description Используется для просмотра наборов курсов, которые можно купить. Вместе с ценой набора возвращается цена его курсов по отдельности.
+// @Success 200 {array} entity.Bundle
+// @Router /v1/billing/bundles [get]
+// @Tags Методы биллинга
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) GetBundles(ctx *gin.Context) {
+	var statusCode int
+
+	bundles, err := h.sberBillingService.RetreiveBundles(ctx, true)
+	if err != nil {
+		h.logger.Error("ошибка при получении наборов курсов", "GetBundles", err.Message, err.Code)
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "GetBundles")
+		return
+	}
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, bundles)
+	h.metrics.RecordResponse(statusCode, "GET", "GetBundles")
+}
+
+// @Summary Оплатить корзину
+// @Accept json
+// @Description Используется для покупки нескольких курсов и наборов одним платежом. Уже купленные курсы пропускаются, а цена
+// @Description набора уменьшается на их долю. Промокод применяется к первому курсу, на который действует. Метод редиректит на страницу оплаты.
+// @Success 307 "Temporary Redirect"
+// @Router /v1/billing/checkout [post]
+// @Tags Методы биллинга
+// @Param cart body entity.CartDetails true "ID курсов и наборов, способ платежа, необязательные валюта и промокод"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация, декодирование сообщения, курс или набор не продается в валюте или промокод не найден, истек или не действует на курсы"
+// @Failure 403 {object} courseerror.CourseError "Почта не верифицирована"
+// @Failure 404 {object} courseerror.CourseError "Набор или курс не найден"
+// @Failure 409 {object} courseerror.CourseError "Все курсы уже куплены, лимит промокода исчерпан или промокод только для первой покупки"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+// @Failure 502 {object} courseerror.CourseError "Банк отклонил запрос или недоступен"
+func (h Handlers) Checkout(ctx *gin.Context) {
+	var statusCode int
+
+	userId := ctx.Value("UserId").(uint)
+	verifiedStatus := ctx.GetBool("verified")
+	if !verifiedStatus {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("пользователь не верифицирован, ID: %d", userId), "Checkout", errNotVerified.Error(), 11008)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNotVerified, 11008))
+		h.metrics.RecordResponse(statusCode, "POST", "Checkout")
+		return
+	}
+
+	cart := entity.CreateNewCartDetails()
+	if err := ctx.ShouldBindJSON(&cart); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "Checkout", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "POST", "Checkout")
+		return
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Minute*15)
+	defer cancel()
+
+	linkToPay, err := h.sberBillingService.Checkout(timeoutCtx, cart)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при оплате корзины пользователя с ID: %d, курсы: %v, наборы: %v", userId, cart.CourseIds, cart.BundleIds), "Checkout", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "Checkout")
+			return
+		}
+		if err.Code == 15010 || err.Code == 15011 || err.Code == 15012 || err.Code == 15027 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "Checkout")
+			return
+		}
+		if err.Code == 15020 || err.Code == 13003 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "Checkout")
+			return
+		}
+		if err.Code == 15004 || err.Code == 15013 || err.Code == 15014 {
+			statusCode = http.StatusConflict
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "Checkout")
+			return
+		}
+		if err.Code == 15005 || err.Code == 15006 {
+			statusCode = http.StatusBadGateway
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "Checkout")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "Checkout")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("заказ пользователя с ID: %d был успешно размещен", userId), "Checkout", fmt.Sprintf("курсы: %v, наборы: %v", cart.CourseIds, cart.BundleIds))
+
+	statusCode = http.StatusTemporaryRedirect
+	ctx.Redirect(statusCode, *linkToPay)
+	h.metrics.RecordResponse(statusCode, "POST", "Checkout")
+}
+
+// @Summary Создать набор курсов
+// @Accept json
+// @Produce json
+// @Description Используется для создания набора курсов. В наборе должно быть хотя бы два курса, цена набора задается в рублях
+// @Description и при покупке делится между курсами пропорционально их цене по отдельности. Если active не передан, набор включен.
+// @Description Метод доступен супер админу и админу.
+// @Success 201 {object} entity.Bundle
+// @Router /v1/admin/management/bundles [post]
+// @Tags Методы биллинга
+// @Param bundle body entity.BundleToSave true "Условия набора"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 404 {object} courseerror.CourseError "Курс не найден"
+// @Failure 409 {object} courseerror.CourseError "Набор с таким названием уже существует"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) CreateBundle(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "CreateBundle", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "POST", "CreateBundle")
+		return
+	}
+
+	bundleToSave := entity.CreateNewBundleToSave()
+	if err := ctx.ShouldBindJSON(&bundleToSave); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "CreateBundle", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "POST", "CreateBundle")
+		return
+	}
+
+	bundle, err := h.sberBillingService.CreateBundle(ctx, bundleToSave)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при создании набора курсов %v", bundleToSave.Name), "CreateBundle", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "CreateBundle")
+			return
+		}
+		if err.Code == 13003 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "CreateBundle")
+			return
+		}
+		if err.Code == 15021 {
+			statusCode = http.StatusConflict
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "CreateBundle")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "CreateBundle")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("админ с ID: %d создал набор курсов %v", ctx.Value("AdminId"), bundle.Name), "CreateBundle", fmt.Sprint(bundle.Id))
+
+	statusCode = http.StatusCreated
+	ctx.JSON(statusCode, bundle)
+	h.metrics.RecordResponse(statusCode, "POST", "CreateBundle")
+}
+
+// @Summary Получить все наборы курсов
+// @Produce json
+// @Description Используется для просмотра всех наборов курсов, включая выключенные. Метод доступен супер админу и админу.
+// @Success 200 {array} entity.Bundle
+// @Router /v1/admin/management/bundles [get]
+// @Tags Методы биллинга
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) GetAllBundles(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "GetAllBundles", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "GET", "GetAllBundles")
+		return
+	}
+
+	bundles, err := h.sberBillingService.RetreiveBundles(ctx, false)
+	if err != nil {
+		h.logger.Error("ошибка при получении наборов курсов", "GetAllBundles", err.Message, err.Code)
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "GetAllBundles")
+		return
+	}
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, bundles)
+	h.metrics.RecordResponse(statusCode, "GET", "GetAllBundles")
+}
+
+// @Summary Изменить набор курсов
+// @Accept json
+// @Produce json
+// @Description Используется для изменения набора курсов, условия перезаписываются целиком. Уже оплаченные заказы
+// @Description не меняются. Метод доступен супер админу и админу.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/admin/management/bundles/{id} [patch]
+// @Tags Методы биллинга
+// @Param id path string true "ID набора"
+// @Param bundle body entity.BundleToSave true "Условия набора"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 404 {object} courseerror.CourseError "Набор или курс не найден"
+// @Failure 409 {object} courseerror.CourseError "Набор с таким названием уже существует"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) UpdateBundle(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "UpdateBundle", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "PATCH", "UpdateBundle")
+		return
+	}
+
+	bundleId := ctx.Param("id")
+
+	bundleToSave := entity.CreateNewBundleToSave()
+	if err := ctx.ShouldBindJSON(&bundleToSave); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "UpdateBundle", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "PATCH", "UpdateBundle")
+		return
+	}
+
+	if err := h.sberBillingService.EditBundle(ctx, bundleId, bundleToSave); err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при изменении набора курсов с ID: %v", bundleId), "UpdateBundle", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "PATCH", "UpdateBundle")
+			return
+		}
+		if err.Code == 15020 || err.Code == 13003 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "PATCH", "UpdateBundle")
+			return
+		}
+		if err.Code == 15021 {
+			statusCode = http.StatusConflict
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "PATCH", "UpdateBundle")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "PATCH", "UpdateBundle")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("админ с ID: %d изменил набор курсов с ID: %v", ctx.Value("AdminId"), bundleId), "UpdateBundle", bundleToSave.Name)
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("набор курсов успешно изменен"))
+	h.metrics.RecordResponse(statusCode, "PATCH", "UpdateBundle")
+}
+
+// @Summary Удалить набор курсов
+// @Produce json
+// @Description Используется для удаления набора курсов. Оплаченные заказы на набор остаются в истории платежей.
+// @Description Метод доступен супер админу и админу.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/admin/management/bundles/{id} [delete]
+// @Tags Методы биллинга
+// @Param id path string true "ID набора"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 404 {object} courseerror.CourseError "Набор не найден"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) DeleteBundle(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "DeleteBundle", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "DELETE", "DeleteBundle")
+		return
+	}
+
+	bundleId := ctx.Param("id")
+
+	if err := h.sberBillingService.RemoveBundle(ctx, bundleId); err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при удалении набора курсов с ID: %v", bundleId), "DeleteBundle", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "DELETE", "DeleteBundle")
+			return
+		}
+		if err.Code == 15020 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "DELETE", "DeleteBundle")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "DELETE", "DeleteBundle")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("админ с ID: %d удалил набор курсов с ID: %v", ctx.Value("AdminId"), bundleId), "DeleteBundle", bundleId)
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("набор курсов успешно удален"))
+	h.metrics.RecordResponse(statusCode, "DELETE", "DeleteBundle")
+}
@@ -0,0 +1,175 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	courseerror "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+// @Summary Получить письма из outbox
+// @Produce json
+// @Description Используется для просмотра писем, поставленных в очередь на отправку. Письма со статусом failed
+// @Description исчерпали попытки отправки. Текст письма не возвращается. Метод доступен супер админу и админу.
+// @Success 200 {object} entity.OutboxEmailsWithPagination
+// @Router /v1/admin/management/emails [get]
+// @Tags Методы для администрирования
+// @Param status query string false "Статус письма: pending, sent или failed"
+// @Param page query string true "Страница"
+// @Param limit query string true "Лимит"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) GetOutboxEmails(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "GetOutboxEmails", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "GET", "GetOutboxEmails")
+		return
+	}
+
+	status := ctx.Query("status")
+	page := ctx.Query("page")
+	limit := ctx.Query("limit")
+
+	emails, err := h.emailService.RetreiveOutboxEmails(ctx, status, page, limit)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при получении писем по запросу: status - %v, page - %v, limit - %v", status, page, limit), "GetOutboxEmails", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "GET", "GetOutboxEmails")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "GetOutboxEmails")
+		return
+	}
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, emails)
+	h.metrics.RecordResponse(statusCode, "GET", "GetOutboxEmails")
+}
+
+// @Summary Переотправить письмо
+// @Produce json
+// @Description Используется для повторной отправки письма со статусом failed. Счетчик попыток сбрасывается,
+// @Description письмо отправится при следующем проходе отправщика. Метод доступен супер админу и админу.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/admin/management/emails/{id}/resend [post]
+// @Tags Методы для администрирования
+// @Param id path string true "ID письма"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 404 {object} courseerror.CourseError "Письмо не найдено или не в статусе failed"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) ResendOutboxEmail(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "ResendOutboxEmail", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "POST", "ResendOutboxEmail")
+		return
+	}
+
+	id := ctx.Param("id")
+
+	if err := h.emailService.ResendOutboxEmail(ctx, id); err != nil {
+		h.logger.Error(fmt.Sprintf("не получилось переотправить письмо с ID: %v", id), "ResendOutboxEmail", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "ResendOutboxEmail")
+			return
+		}
+		if err.Code == 17005 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "ResendOutboxEmail")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "ResendOutboxEmail")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("письмо с ID: %v возвращено в очередь", id), "ResendOutboxEmail", fmt.Sprintf("ID админа: %d", ctx.Value("AdminId")))
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("письмо поставлено в очередь на отправку"))
+	h.metrics.RecordResponse(statusCode, "POST", "ResendOutboxEmail")
+}
+
+// @Summary Предпросмотр шаблона письма
+// @Produce html
+// @Description Используется для проверки шаблона письма без отправки. Шаблон рендерится с примером данных.
+// @Description Доступные шаблоны: confirm_code, recover_password, login_link, welcome, purchase_receipt, purchase_reminder, purchase_refund.
+// @Description В текстовом формате первой строкой возвращается тема письма. Метод доступен супер админу и админу.
+// @Success 200 {string} string "Письмо"
+// @Router /v1/admin/management/emailTemplates/{name} [get]
+// @Tags Методы для администрирования
+// @Param name path string true "Название шаблона"
+// @Param locale query string false "Язык письма: ru или en, по умолчанию ru"
+// @Param format query string false "Формат письма: html или text, по умолчанию html"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 404 {object} courseerror.CourseError "Шаблон не найден"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) PreviewEmailTemplate(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "PreviewEmailTemplate", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "GET", "PreviewEmailTemplate")
+		return
+	}
+
+	name := ctx.Param("name")
+	locale := ctx.Query("locale")
+	format := ctx.Query("format")
+
+	preview, err := h.emailService.PreviewTemplate(ctx, name, locale, format)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("не получилось отрендерить шаблон: name - %v, locale - %v, format - %v", name, locale, format), "PreviewEmailTemplate", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "GET", "PreviewEmailTemplate")
+			return
+		}
+		if err.Code == 17006 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "GET", "PreviewEmailTemplate")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "PreviewEmailTemplate")
+		return
+	}
+
+	contentType := "text/html; charset=utf-8"
+	if format == "text" {
+		contentType = "text/plain; charset=utf-8"
+	}
+
+	statusCode = http.StatusOK
+	ctx.Data(statusCode, contentType, []byte(preview))
+	h.metrics.RecordResponse(statusCode, "GET", "PreviewEmailTemplate")
+}
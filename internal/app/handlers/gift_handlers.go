@@ -0,0 +1,292 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	courseerror "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+// @Summary Купить курс в подарок
+// @Accept json
+// @Description Используется для покупки курса в подарок. Курс получает пользователь, который активирует код подарка, покупателю
+// @Description приходит чек с кодом. Если передана почта получателя, код отправляется ему письмом после оплаты, и активировать подарок
+// @Description может только пользователь с этой почтой. Метод редиректит на страницу оплаты.
+// @Success 307 "Temporary Redirect"
+// @Router /v1/billing/buyGift [post]
+// @Tags Методы биллинга
+// @Param giftOrder body entity.GiftOrderDetails true "ID курса, способ платежа, необязательные валюта, промокод и почта получателя"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация, декодирование сообщения, курс не продается в валюте или промокод не найден, истек или не действует на курс"
+// @Failure 409 {object} courseerror.CourseError "Лимит промокода исчерпан или промокод только для первой покупки"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+// @Failure 502 {object} courseerror.CourseError "Банк отклонил запрос или недоступен"
+func (h Handlers) BuyGift(ctx *gin.Context) {
+	var statusCode int
+
+	userId := ctx.Value("UserId").(uint)
+	verifiedStatus := ctx.GetBool("verified")
+	if !verifiedStatus {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("пользователь не верифицирован, ID: %d", userId), "BuyGift", errNotVerified.Error(), 11008)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNotVerified, 11008))
+		h.metrics.RecordResponse(statusCode, "POST", "BuyGift")
+		return
+	}
+
+	giftOrder := entity.CreateNewGiftOrderDetails()
+	if err := ctx.ShouldBindJSON(&giftOrder); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "BuyGift", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "POST", "BuyGift")
+		return
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Minute*15)
+	defer cancel()
+
+	linkToPay, err := h.sberBillingService.PlaceGiftOrder(timeoutCtx, giftOrder)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при размещении заказа пользователя с ID: %d при покупке в подарок курса с ID: %d", userId, giftOrder.CourseId), "BuyGift", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "BuyGift")
+			return
+		}
+		if err.Code == 15010 || err.Code == 15011 || err.Code == 15012 || err.Code == 15027 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "BuyGift")
+			return
+		}
+		if err.Code == 15013 || err.Code == 15014 {
+			statusCode = http.StatusConflict
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "BuyGift")
+			return
+		}
+		if err.Code == 15005 || err.Code == 15006 {
+			statusCode = http.StatusBadGateway
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "BuyGift")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "BuyGift")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("заказ подарка пользователя с ID: %d был успешно размещен", userId), "BuyGift", fmt.Sprint(giftOrder.CourseId))
+
+	statusCode = http.StatusTemporaryRedirect
+	ctx.Redirect(statusCode, *linkToPay)
+	h.metrics.RecordResponse(statusCode, "POST", "BuyGift")
+}
+
+// @Summary Активировать подарок
+// @Accept json
+// @Produce json
+// @Description Используется для активации кода подарка. После активации курс из подарка появляется в профиле пользователя.
+// @Description Подарок активируется один раз и только после оплаты, подарок с почтой получателя активирует только пользователь с этой почтой.
+// @Success 200 {object} entity.Gift
+// @Router /v1/billing/gifts/redeem [post]
+// @Tags Методы биллинга
+// @Param giftCode body entity.GiftCode true "Код подарка"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
+// @Failure 403 {object} courseerror.CourseError "Подарок предназначен другому пользователю"
+// @Failure 404 {object} courseerror.CourseError "Подарок не найден"
+// @Failure 409 {object} courseerror.CourseError "Подарок уже активирован, аннулирован, еще не оплачен или курс уже куплен"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) RedeemGift(ctx *gin.Context) {
+	var statusCode int
+
+	userId := ctx.Value("UserId").(uint)
+
+	giftCode := entity.CreateNewGiftCode()
+	if err := ctx.ShouldBindJSON(&giftCode); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "RedeemGift", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "POST", "RedeemGift")
+		return
+	}
+
+	gift, err := h.sberBillingService.RedeemGift(ctx, giftCode.Code)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при активации подарка пользователем с ID: %d", userId), "RedeemGift", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "RedeemGift")
+			return
+		}
+		if err.Code == 15026 {
+			statusCode = http.StatusForbidden
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "RedeemGift")
+			return
+		}
+		if err.Code == 15022 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "RedeemGift")
+			return
+		}
+		if err.Code == 15004 || err.Code == 15023 || err.Code == 15024 || err.Code == 15025 {
+			statusCode = http.StatusConflict
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "RedeemGift")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "RedeemGift")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("пользователь с ID: %d активировал подарок с ID: %d", userId, gift.Id), "RedeemGift", fmt.Sprint(gift.CourseId))
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, gift)
+	h.metrics.RecordResponse(statusCode, "POST", "RedeemGift")
+}
+
+// @Summary Получить купленные подарки
+// @Produce json
+// @Description Используется для просмотра подарков, купленных пользователем, вместе с кодами и статусами: pending - ждет оплаты,
+// @Description active - оплачен и ждет активации, redeemed - активирован, voided - аннулирован или деньги за него вернули.
+// @Success 200 {array} entity.Gift
+// @Router /v1/billing/gifts [get]
+// @Tags Методы биллинга
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) GetUserGifts(ctx *gin.Context) {
+	var statusCode int
+
+	gifts, err := h.sberBillingService.RetreiveUserGifts(ctx)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при получении подарков пользователя с ID: %d", ctx.Value("UserId")), "GetUserGifts", err.Message, err.Code)
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "GetUserGifts")
+		return
+	}
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, gifts)
+	h.metrics.RecordResponse(statusCode, "GET", "GetUserGifts")
+}
+
+// @Summary Получить подарки
+// @Produce json
+// @Description Используется для просмотра подарков всех пользователей, начиная с последнего. С unredeemed=true возвращаются
+// @Description только неактивированные и неаннулированные подарки. Метод доступен супер админу и админу.
+// @Success 200 {object} entity.GiftsWithPagination
+// @Router /v1/admin/management/gifts [get]
+// @Tags Методы биллинга
+// @Param page query string true "Страница"
+// @Param limit query string true "Лимит"
+// @Param unredeemed query bool false "Только неактивированные подарки"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) GetGifts(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "GetGifts", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "GET", "GetGifts")
+		return
+	}
+
+	page := ctx.Query("page")
+	limit := ctx.Query("limit")
+	onlyUnredeemed := ctx.Query("unredeemed") == "true"
+
+	gifts, err := h.sberBillingService.RetreiveGifts(ctx, page, limit, onlyUnredeemed)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при получении подарков по запросу: page - %v, limit - %v", page, limit), "GetGifts", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "GET", "GetGifts")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "GetGifts")
+		return
+	}
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, gifts)
+	h.metrics.RecordResponse(statusCode, "GET", "GetGifts")
+}
+
+// @Summary Аннулировать подарок
+// @Produce json
+// @Description Используется для аннулирования неактивированного подарка, после этого код подарка больше не активируется. Деньги
+// @Description покупателю возвращаются отдельно через возврат. Метод доступен супер админу и админу.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/admin/management/gifts/{id}/void [post]
+// @Tags Методы биллинга
+// @Param id path string true "ID подарка"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 404 {object} courseerror.CourseError "Подарок не найден"
+// @Failure 409 {object} courseerror.CourseError "Подарок уже активирован или аннулирован"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) VoidGift(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "VoidGift", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "POST", "VoidGift")
+		return
+	}
+
+	giftId := ctx.Param("id")
+
+	if err := h.sberBillingService.VoidGift(ctx, giftId); err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при аннулировании подарка с ID: %v", giftId), "VoidGift", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "VoidGift")
+			return
+		}
+		if err.Code == 15022 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "VoidGift")
+			return
+		}
+		if err.Code == 15023 || err.Code == 15024 {
+			statusCode = http.StatusConflict
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "VoidGift")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "VoidGift")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("админ с ID: %d аннулировал подарок с ID: %v", ctx.Value("AdminId"), giftId), "VoidGift", giftId)
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("подарок успешно аннулирован"))
+	h.metrics.RecordResponse(statusCode, "POST", "VoidGift")
+}
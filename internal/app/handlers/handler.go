@@ -3,16 +3,22 @@ package handlers
 import (
 	"errors"
 	"net/http"
+	"time"
 
 	"github.com/go-redis/redis"
 	"github.com/knstch/course/internal/app/config"
 	"github.com/knstch/course/internal/app/grpc"
 	"github.com/knstch/course/internal/app/logger"
 	"github.com/knstch/course/internal/app/services/admin"
+	"github.com/knstch/course/internal/app/services/apikey"
 	"github.com/knstch/course/internal/app/services/auth"
 	"github.com/knstch/course/internal/app/services/billing"
 	contentmanagement "github.com/knstch/course/internal/app/services/content_management"
 	"github.com/knstch/course/internal/app/services/email"
+	"github.com/knstch/course/internal/app/services/lockout"
+	"github.com/knstch/course/internal/app/services/oidc"
+	"github.com/knstch/course/internal/app/services/password"
+	"github.com/knstch/course/internal/app/services/token"
 	"github.com/knstch/course/internal/app/services/user"
 	usermanagement "github.com/knstch/course/internal/app/services/user_management"
 	"github.com/knstch/course/internal/app/storage"
@@ -31,7 +37,11 @@ type Handlers struct {
 	contentManagementService contentmanagement.ContentManagementServcie
 	sberBillingService       billing.SberBillingService
 	adminService             admin.AdminService
+	apiKeyService            apikey.ApiKeyService
+	keys                     *token.KeySet
 	address                  string
+	accessTokenTTL           time.Duration
+	refreshTokenTTL          time.Duration
 	logger                   logger.Logger
 	metrics                  MetricsRecorder
 }
@@ -45,18 +55,27 @@ func NewHandlers(storage *storage.Storage,
 	redisClient *redis.Client,
 	client *http.Client,
 	grpcClient *grpc.GrpcClient,
+	keys *token.KeySet,
+	passwordPolicy *password.Policy,
+	emailVerifier *email.Verifier,
+	paymentProvider billing.PaymentProvider,
 	logger logger.Logger,
 	metrics MetricsRecorder) *Handlers {
-	emailService := email.NewEmailService(redisClient, config)
+	emailService := email.NewEmailService(redisClient, storage, emailVerifier)
+	lockout := lockout.NewLockout(redisClient, config)
 	return &Handlers{
-		authService:              auth.NewAuthService(storage, config, redisClient, emailService),
-		userService:              user.NewUserService(storage, emailService, redisClient, client, config.CdnApiKey, config.CdnHost),
-		userManagementService:    usermanagement.NewUserManagementService(storage),
+		authService:              auth.NewAuthService(storage, config, keys, redisClient, emailService, lockout, oidc.NewProviders(config, client), passwordPolicy),
+		userService:              user.NewUserService(storage, emailService, redisClient, client, config.CdnApiKey, config.CdnHost, passwordPolicy),
+		userManagementService:    usermanagement.NewUserManagementService(storage, lockout),
 		contentManagementService: contentmanagement.NewContentManagementServcie(storage, config, client, grpcClient),
-		sberBillingService:       billing.NewSberBillingService(config, storage, redisClient),
-		adminService:             admin.NewAdminService(storage, config.AdminSecret),
+		sberBillingService:       billing.NewSberBillingService(config, storage, paymentProvider, redisClient, emailService),
+		adminService:             admin.NewAdminService(storage, keys, lockout, passwordPolicy),
+		apiKeyService:            apikey.NewApiKeyService(storage),
 		emailService:             emailService,
+		keys:                     keys,
 		address:                  config.HostAddress,
+		accessTokenTTL:           config.AccessTokenTTL,
+		refreshTokenTTL:          config.RefreshTokenTTL,
 		logger:                   logger,
 		metrics:                  metrics,
 	}
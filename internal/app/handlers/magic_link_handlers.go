@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	courseerror "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+// @Summary Отправить ссылку для входа
+// @Produce json
+// @Description Используется для входа без пароля. Отправляет на почту одноразовую ссылку, которая действует 15 минут.
+// @Description Если пользователя с такой почтой нет, то он будет создан при переходе по ссылке.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/auth/sendLoginLink [get]
+// @Tags Методы для авторизации пользователей
+// @Param email query string true "Почта для входа"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация"
+// @Failure 429 {object} courseerror.CourseError "Слишком много запросов"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) SendLoginLink(ctx *gin.Context) {
+	var statusCode int
+
+	email := ctx.Query("email")
+	if err := h.authService.SendLoginLink(ctx, email); err != nil {
+		h.logger.Error(fmt.Sprintf("не получилось отправить ссылку для входа на почту %v", email), "SendLoginLink", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "GET", "SendLoginLink")
+			return
+		}
+		if err.Code == 17002 {
+			statusCode = http.StatusTooManyRequests
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "GET", "SendLoginLink")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "SendLoginLink")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("ссылка для входа успешно отправлена c IP: %v", ctx.ClientIP()), "SendLoginLink", email)
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("ссылка для входа успешно отправлена"))
+	h.metrics.RecordResponse(statusCode, "GET", "SendLoginLink")
+}
+
+// @Summary Войти по ссылке из письма
+// @Produce json
+// @Accept json
+// @Description Используется для входа по токену из ссылки, которую фронтенд получает на MAGIC_LINK_URL. Ссылкой можно
+// @Description воспользоваться один раз. Если у пользователя включена двухфакторная аутентификация,
+// @Description то возвращается челлендж, вход завершается через /v1/auth/loginTwoFactor.
+// @Success 200 {object} entity.SuccessResponse
+// @Success 202 {object} entity.TwoStepAuthRequired
+// @Router /v1/auth/magicLogin [post]
+// @Tags Методы для авторизации пользователей
+// @Param token body entity.MagicLinkToken true "Токен из ссылки"
+// @Failure 400 {object} courseerror.CourseError "Провалено декодирование сообщения"
+// @Failure 403 {object} courseerror.CourseError "Ссылка недействительна, устарела или уже использована"
+// @Failure 405 {object} courseerror.CourseError "Пользователь неактивен или заблокирован"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) MagicLinkSignIn(ctx *gin.Context) {
+	var statusCode int
+
+	link := entity.NewMagicLinkToken()
+	if err := ctx.ShouldBindJSON(&link); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "MagicLinkSignIn", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "POST", "MagicLinkSignIn")
+		return
+	}
+
+	tokens, challenge, err := h.authService.LogInByLink(ctx, link, entity.NewDevice(ctx.Request.UserAgent(), ctx.ClientIP()))
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("не получилось войти по ссылке c IP: %v", ctx.ClientIP()), "MagicLinkSignIn", err.Message, err.Code)
+		if err.Code == 11021 {
+			statusCode = http.StatusForbidden
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "MagicLinkSignIn")
+			return
+		}
+		if err.Code == 11010 || err.Code == 11011 {
+			statusCode = http.StatusMethodNotAllowed
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "MagicLinkSignIn")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "MagicLinkSignIn")
+		return
+	}
+
+	if challenge != nil {
+		h.logger.Info(fmt.Sprintf("пользователю с IP: %v требуется второй шаг аутентификации", ctx.ClientIP()), "MagicLinkSignIn", "")
+
+		statusCode = http.StatusAccepted
+		ctx.JSON(statusCode, entity.CreateTwoStepAuthRequired(*challenge))
+		h.metrics.RecordResponse(statusCode, "POST", "MagicLinkSignIn")
+		return
+	}
+
+	h.setUserTokens(ctx, tokens)
+
+	h.logger.Info(fmt.Sprintf("пользователь успешно вошел по ссылке c IP: %v", ctx.ClientIP()), "MagicLinkSignIn", "")
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("доступ разрешен"))
+	h.metrics.RecordResponse(statusCode, "POST", "MagicLinkSignIn")
+}
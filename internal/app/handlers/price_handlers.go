@@ -0,0 +1,118 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	courseerror "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+// @Summary Получить цены курса
+// @Produce json
+// @Description Используется для просмотра цен курса во всех валютах, в которых он продается. Первой идет цена в рублях с учетом скидки.
+// @Description Цены передаются в минимальных единицах валюты: копейках, центах и тиынах.
+// @Success 200 {array} entity.CoursePrice
+// @Router /v1/billing/coursePrices/{id} [get]
+// @Tags Методы биллинга
+// @Param id path string true "ID курса"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация"
+// @Failure 404 {object} courseerror.CourseError "Курс не найден"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) GetCoursePrices(ctx *gin.Context) {
+	var statusCode int
+
+	courseId := ctx.Param("id")
+
+	prices, err := h.sberBillingService.RetreiveCoursePrices(ctx, courseId)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при получении цен курса с ID: %v", courseId), "GetCoursePrices", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "GET", "GetCoursePrices")
+			return
+		}
+		if err.Code == 13003 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "GET", "GetCoursePrices")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "GetCoursePrices")
+		return
+	}
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, prices)
+	h.metrics.RecordResponse(statusCode, "GET", "GetCoursePrices")
+}
+
+// @Summary Задать цены курса в валютах
+// @Accept json
+// @Produce json
+// @Description Используется для задания цен курса в USD, EUR и KZT, прайс-лист перезаписывается целиком. В валютах, которых нет
+// @Description в прайс-листе, курс не продается. Цены передаются в минимальных единицах валюты, например 1999 - это 19.99 USD.
+// @Description Цена в рублях задается в самом курсе. Уже оформленные заказы не меняются.
+// @Description Метод доступен супер админу и админу.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/admin/management/coursePrices/{id} [patch]
+// @Tags Методы биллинга
+// @Param id path string true "ID курса"
+// @Param prices body entity.CoursePricesToSave true "Цены курса в валютах"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 404 {object} courseerror.CourseError "Курс не найден"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) UpdateCoursePrices(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "UpdateCoursePrices", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "PATCH", "UpdateCoursePrices")
+		return
+	}
+
+	courseId := ctx.Param("id")
+
+	pricesToSave := entity.CreateNewCoursePricesToSave()
+	if err := ctx.ShouldBindJSON(&pricesToSave); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "UpdateCoursePrices", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "PATCH", "UpdateCoursePrices")
+		return
+	}
+
+	if err := h.sberBillingService.SetCoursePrices(ctx, courseId, pricesToSave); err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при изменении цен курса с ID: %v", courseId), "UpdateCoursePrices", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "PATCH", "UpdateCoursePrices")
+			return
+		}
+		if err.Code == 13003 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "PATCH", "UpdateCoursePrices")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "PATCH", "UpdateCoursePrices")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("админ с ID: %d изменил цены курса с ID: %v", ctx.Value("AdminId"), courseId), "UpdateCoursePrices", "")
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("цены курса успешно изменены"))
+	h.metrics.RecordResponse(statusCode, "PATCH", "UpdateCoursePrices")
+}
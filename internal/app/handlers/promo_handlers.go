@@ -0,0 +1,245 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	courseerror "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+// @Summary Создать промокод
+// @Accept json
+// @Produce json
+// @Description Используется для создания промокода. Скидка задается в процентах (percent, от 1 до 99) или в рублях (fixed),
+// @Description цена со скидкой не опускается ниже 1 рубля. Без courseId промокод действует на все курсы. Нулевые лимиты
+// @Description использований и пустые даты ничего не ограничивают. Если active не передан, промокод включен.
+// @Description Метод доступен супер админу и админу.
+// @Success 201 {object} entity.PromoCode
+// @Router /v1/admin/management/promoCodes [post]
+// @Tags Методы биллинга
+// @Param promoCode body entity.PromoCodeToSave true "Условия промокода"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 409 {object} courseerror.CourseError "Промокод уже существует"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) CreatePromoCode(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "CreatePromoCode", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "POST", "CreatePromoCode")
+		return
+	}
+
+	promoToSave := entity.CreateNewPromoCodeToSave()
+	if err := ctx.ShouldBindJSON(&promoToSave); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "CreatePromoCode", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "POST", "CreatePromoCode")
+		return
+	}
+
+	promo, err := h.sberBillingService.CreatePromoCode(ctx, promoToSave)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при создании промокода %v", promoToSave.Code), "CreatePromoCode", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "CreatePromoCode")
+			return
+		}
+		if err.Code == 15015 {
+			statusCode = http.StatusConflict
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "CreatePromoCode")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "CreatePromoCode")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("админ с ID: %d создал промокод %v", ctx.Value("AdminId"), promo.Code), "CreatePromoCode", fmt.Sprint(promo.Id))
+
+	statusCode = http.StatusCreated
+	ctx.JSON(statusCode, promo)
+	h.metrics.RecordResponse(statusCode, "POST", "CreatePromoCode")
+}
+
+// @Summary Получить промокоды
+// @Produce json
+// @Description Используется для просмотра промокодов с количеством использований и суммой скидок. Использования в
+// @Description неудавшихся заказах не учитываются. Метод доступен супер админу и админу.
+// @Success 200 {object} entity.PromoCodesWithPagination
+// @Router /v1/admin/management/promoCodes [get]
+// @Tags Методы биллинга
+// @Param page query string true "Страница"
+// @Param limit query string true "Лимит"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) GetPromoCodes(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "GetPromoCodes", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "GET", "GetPromoCodes")
+		return
+	}
+
+	page := ctx.Query("page")
+	limit := ctx.Query("limit")
+
+	promos, err := h.sberBillingService.RetreivePromoCodes(ctx, page, limit)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при получении промокодов по запросу: page - %v, limit - %v", page, limit), "GetPromoCodes", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "GET", "GetPromoCodes")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "GetPromoCodes")
+		return
+	}
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, promos)
+	h.metrics.RecordResponse(statusCode, "GET", "GetPromoCodes")
+}
+
+// @Summary Изменить промокод
+// @Accept json
+// @Produce json
+// @Description Используется для изменения условий промокода, условия перезаписываются целиком. Уже сделанные по
+// @Description промокоду заказы не меняются. Метод доступен супер админу и админу.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/admin/management/promoCodes/{id} [patch]
+// @Tags Методы биллинга
+// @Param id path string true "ID промокода"
+// @Param promoCode body entity.PromoCodeToSave true "Условия промокода"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 404 {object} courseerror.CourseError "Промокод не найден"
+// @Failure 409 {object} courseerror.CourseError "Промокод уже существует"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) UpdatePromoCode(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "UpdatePromoCode", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "PATCH", "UpdatePromoCode")
+		return
+	}
+
+	promoId := ctx.Param("id")
+
+	promoToSave := entity.CreateNewPromoCodeToSave()
+	if err := ctx.ShouldBindJSON(&promoToSave); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "UpdatePromoCode", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "PATCH", "UpdatePromoCode")
+		return
+	}
+
+	if err := h.sberBillingService.EditPromoCode(ctx, promoId, promoToSave); err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при изменении промокода с ID: %v", promoId), "UpdatePromoCode", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "PATCH", "UpdatePromoCode")
+			return
+		}
+		if err.Code == 15010 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "PATCH", "UpdatePromoCode")
+			return
+		}
+		if err.Code == 15015 {
+			statusCode = http.StatusConflict
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "PATCH", "UpdatePromoCode")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "PATCH", "UpdatePromoCode")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("админ с ID: %d изменил промокод с ID: %v", ctx.Value("AdminId"), promoId), "UpdatePromoCode", promoToSave.Code)
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("промокод успешно изменен"))
+	h.metrics.RecordResponse(statusCode, "PATCH", "UpdatePromoCode")
+}
+
+// @Summary Удалить промокод
+// @Produce json
+// @Description Используется для удаления промокода. История использований промокода остается в статистике продаж.
+// @Description Метод доступен супер админу и админу.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/admin/management/promoCodes/{id} [delete]
+// @Tags Методы биллинга
+// @Param id path string true "ID промокода"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 404 {object} courseerror.CourseError "Промокод не найден"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) DeletePromoCode(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "DeletePromoCode", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "DELETE", "DeletePromoCode")
+		return
+	}
+
+	promoId := ctx.Param("id")
+
+	if err := h.sberBillingService.RemovePromoCode(ctx, promoId); err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при удалении промокода с ID: %v", promoId), "DeletePromoCode", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "DELETE", "DeletePromoCode")
+			return
+		}
+		if err.Code == 15010 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "DELETE", "DeletePromoCode")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "DELETE", "DeletePromoCode")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("админ с ID: %d удалил промокод с ID: %v", ctx.Value("AdminId"), promoId), "DeletePromoCode", promoId)
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("промокод успешно удален"))
+	h.metrics.RecordResponse(statusCode, "DELETE", "DeletePromoCode")
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	courseerror "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+// @Summary Переотправить фискальный чек
+// @Produce json
+// @Description Используется для повторной отправки фискального чека, который отклонила онлайн-касса. Передается ID платежа
+// @Description за любой курс заказа, чек за весь заказ отправится при следующем проходе отправки чеков. Метод доступен
+// @Description супер админу и админу.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/admin/management/receipts/{id}/resend [post]
+// @Tags Методы для администрирования
+// @Param id path string true "ID платежа"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 404 {object} courseerror.CourseError "Чек не найден или не был отклонен"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) ResendReceipt(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "ResendReceipt", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "POST", "ResendReceipt")
+		return
+	}
+
+	billingId := ctx.Param("id")
+
+	if err := h.sberBillingService.ResendReceipt(ctx, billingId); err != nil {
+		h.logger.Error(fmt.Sprintf("не получилось переотправить чек по платежу с ID: %v", billingId), "ResendReceipt", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "ResendReceipt")
+			return
+		}
+		if err.Code == 15030 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "ResendReceipt")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "ResendReceipt")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("чек по платежу с ID: %v возвращен в очередь", billingId), "ResendReceipt", fmt.Sprintf("ID админа: %d", ctx.Value("AdminId")))
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("чек поставлен в очередь на отправку"))
+	h.metrics.RecordResponse(statusCode, "POST", "ResendReceipt")
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+const (
+	socialLoginStateCookie     = "oauth_state"
+	socialLoginStateCookiePath = "/api/v1/auth/oauth"
+	socialLoginStateMaxAge     = 600
+)
+
+// @Summary Войти через провайдера социального логина
+// @Description Используется для входа через Google, Yandex ID или VK ID. Перенаправляет пользователя на страницу
+// @Description авторизации провайдера, после которой провайдер вернет пользователя на /v1/auth/oauth/{provider}/callback.
+// @Success 302
+// @Router /v1/auth/oauth/{provider} [get]
+// @Tags Методы для авторизации пользователей
+// @Param provider path string true "Провайдер: google, yandex или vk"
+// @Failure 404 {object} courseerror.CourseError "Провайдер не найден"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) SocialLogin(ctx *gin.Context) {
+	var statusCode int
+
+	provider := ctx.Param("provider")
+
+	authUrl, state, err := h.authService.StartSocialLogin(provider)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("не получилось начать вход через провайдера %v", provider), "SocialLogin", err.Message, err.Code)
+		if err.Code == 11201 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "GET", "SocialLogin")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "SocialLogin")
+		return
+	}
+
+	ctx.SetCookie(socialLoginStateCookie, *state, socialLoginStateMaxAge, socialLoginStateCookiePath, h.address, true, true)
+
+	statusCode = http.StatusFound
+	ctx.Redirect(statusCode, *authUrl)
+	h.metrics.RecordResponse(statusCode, "GET", "SocialLogin")
+}
+
+// @Summary Завершить вход через провайдера социального логина
+// @Produce json
+// @Description Используется провайдером для возврата пользователя после авторизации. Если пользователя с такой почтой нет,
+// @Description то он будет создан с подтвержденной почтой. Если у пользователя включена двухфакторная аутентификация,
+// @Description то возвращается челлендж, вход завершается через /v1/auth/loginTwoFactor.
+// @Success 200 {object} entity.SuccessResponse
+// @Success 202 {object} entity.TwoStepAuthRequired
+// @Router /v1/auth/oauth/{provider}/callback [get]
+// @Tags Методы для авторизации пользователей
+// @Param provider path string true "Провайдер: google, yandex или vk"
+// @Param code query string true "Код авторизации"
+// @Param state query string true "State"
+// @Failure 403 {object} courseerror.CourseError "State не совпал, провайдер отклонил запрос или почта не подтверждена у провайдера"
+// @Failure 404 {object} courseerror.CourseError "Провайдер не найден"
+// @Failure 405 {object} courseerror.CourseError "Пользователь неактивен или заблокирован"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) SocialLoginCallback(ctx *gin.Context) {
+	var statusCode int
+
+	provider := ctx.Param("provider")
+
+	stateFromCookie, _ := ctx.Cookie(socialLoginStateCookie)
+	ctx.SetCookie(socialLoginStateCookie, "", -1, socialLoginStateCookiePath, h.address, true, true)
+
+	callback := entity.NewSocialLoginCallback(ctx.Query("code"), ctx.Query("state"), ctx.Query("device_id"))
+
+	tokens, challenge, err := h.authService.CompleteSocialLogin(ctx, provider, callback, stateFromCookie,
+		entity.NewDevice(ctx.Request.UserAgent(), ctx.ClientIP()))
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("не получилось войти через провайдера %v c IP: %v", provider, ctx.ClientIP()), "SocialLoginCallback", err.Message, err.Code)
+		if err.Code == 11201 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "GET", "SocialLoginCallback")
+			return
+		}
+		if err.Code == 11202 || err.Code == 11203 || err.Code == 11204 || err.Code == 11205 {
+			statusCode = http.StatusForbidden
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "GET", "SocialLoginCallback")
+			return
+		}
+		if err.Code == 11010 || err.Code == 11011 {
+			statusCode = http.StatusMethodNotAllowed
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "GET", "SocialLoginCallback")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "SocialLoginCallback")
+		return
+	}
+
+	if challenge != nil {
+		h.logger.Info(fmt.Sprintf("пользователю с IP: %v требуется второй шаг аутентификации", ctx.ClientIP()), "SocialLoginCallback", provider)
+
+		statusCode = http.StatusAccepted
+		ctx.JSON(statusCode, entity.CreateTwoStepAuthRequired(*challenge))
+		h.metrics.RecordResponse(statusCode, "GET", "SocialLoginCallback")
+		return
+	}
+
+	h.setUserTokens(ctx, tokens)
+
+	h.logger.Info(fmt.Sprintf("пользователь успешно вошел через провайдера c IP: %v", ctx.ClientIP()), "SocialLoginCallback", provider)
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("доступ разрешен"))
+	h.metrics.RecordResponse(statusCode, "GET", "SocialLoginCallback")
+}
@@ -0,0 +1,354 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	courseerror "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+// @Summary Получить планы подписки
+// @Produce json
+// @Description Используется для просмотра планов подписки, на которые можно подписаться. План открывает все открытые курсы или выбранный набор курсов.
+// @Success 200 {array} entity.SubscriptionPlan
+// @Router /v1/billing/subscriptionPlans [get]
+// @Tags Методы биллинга
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) GetSubscriptionPlans(ctx *gin.Context) {
+	var statusCode int
+
+	plans, err := h.sberBillingService.RetreiveSubscriptionPlans(ctx, true)
+	if err != nil {
+		h.logger.Error("ошибка при получении планов подписки", "GetSubscriptionPlans", err.Message, err.Code)
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "GetSubscriptionPlans")
+		return
+	}
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, plans)
+	h.metrics.RecordResponse(statusCode, "GET", "GetSubscriptionPlans")
+}
+
+// @Summary Оформить подписку
+// @Accept json
+// @Description Используется для оформления подписки. Формирует инвойс за первый период и редиректит на страницу оплаты. Карта
+// @Description привязывается при первой оплате, дальше деньги списываются с нее автоматически перед концом каждого периода.
+// @Success 307 "Temporary Redirect"
+// @Router /v1/billing/subscribe [post]
+// @Tags Методы биллинга
+// @Param subscribeDetails body entity.SubscribeDetails true "ID плана и способ платежа"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
+// @Failure 403 {object} courseerror.CourseError "Почта не верифицирована"
+// @Failure 404 {object} courseerror.CourseError "План не найден"
+// @Failure 409 {object} courseerror.CourseError "Подписка уже оформлена"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+// @Failure 502 {object} courseerror.CourseError "Банк отклонил запрос или недоступен"
+func (h Handlers) Subscribe(ctx *gin.Context) {
+	var statusCode int
+
+	userId := ctx.Value("UserId").(uint)
+	verifiedStatus := ctx.GetBool("verified")
+	if !verifiedStatus {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("пользователь не верифицирован, ID: %d", userId), "Subscribe", errNotVerified.Error(), 11008)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNotVerified, 11008))
+		h.metrics.RecordResponse(statusCode, "POST", "Subscribe")
+		return
+	}
+
+	subscribeDetails := entity.CreateNewSubscribeDetails()
+	if err := ctx.ShouldBindJSON(&subscribeDetails); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "Subscribe", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "POST", "Subscribe")
+		return
+	}
+
+	timeoutCtx, cancel := context.WithTimeout(ctx, time.Minute*15)
+	defer cancel()
+
+	linkToPay, err := h.sberBillingService.Subscribe(timeoutCtx, subscribeDetails)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при оформлении подписки пользователя с ID: %d на план с ID: %d", userId, subscribeDetails.PlanId), "Subscribe", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "Subscribe")
+			return
+		}
+		if err.Code == 15017 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "Subscribe")
+			return
+		}
+		if err.Code == 15016 {
+			statusCode = http.StatusConflict
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "Subscribe")
+			return
+		}
+		if err.Code == 15005 || err.Code == 15006 {
+			statusCode = http.StatusBadGateway
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "Subscribe")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "Subscribe")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("пользователь с ID: %d оформил подписку", userId), "Subscribe", fmt.Sprint(subscribeDetails.PlanId))
+
+	statusCode = http.StatusTemporaryRedirect
+	ctx.Redirect(statusCode, *linkToPay)
+	h.metrics.RecordResponse(statusCode, "POST", "Subscribe")
+}
+
+// @Summary Получить подписку
+// @Produce json
+// @Description Используется для просмотра подписки пользователя: план, статус, оплаченный период и льготный период, если списание не прошло.
+// @Success 200 {object} entity.Subscription
+// @Router /v1/billing/subscription [get]
+// @Tags Методы биллинга
+// @Failure 404 {object} courseerror.CourseError "Подписка не найдена"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) GetSubscription(ctx *gin.Context) {
+	var statusCode int
+
+	subscription, err := h.sberBillingService.GetSubscription(ctx)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при получении подписки пользователя с ID: %d", ctx.Value("UserId")), "GetSubscription", err.Message, err.Code)
+		if err.Code == 15018 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "GET", "GetSubscription")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "GetSubscription")
+		return
+	}
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, subscription)
+	h.metrics.RecordResponse(statusCode, "GET", "GetSubscription")
+}
+
+// @Summary Отменить подписку
+// @Produce json
+// @Description Используется для отмены продления подписки. Деньги больше не списываются, курсы остаются открыты до конца оплаченного периода.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/billing/subscription/cancel [post]
+// @Tags Методы биллинга
+// @Failure 404 {object} courseerror.CourseError "Действующая подписка не найдена"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) CancelSubscription(ctx *gin.Context) {
+	var statusCode int
+
+	if err := h.sberBillingService.CancelSubscription(ctx); err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при отмене подписки пользователя с ID: %d", ctx.Value("UserId")), "CancelSubscription", err.Message, err.Code)
+		if err.Code == 15018 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "CancelSubscription")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "CancelSubscription")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("пользователь с ID: %d отменил продление подписки", ctx.Value("UserId")), "CancelSubscription", "")
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("продление подписки отменено"))
+	h.metrics.RecordResponse(statusCode, "POST", "CancelSubscription")
+}
+
+// @Summary Создать план подписки
+// @Accept json
+// @Produce json
+// @Description Используется для создания плана подписки на месяц (month) или год (year). План открывает все открытые курсы,
+// @Description если allCourses равен true, иначе курсы из courseIds. Если active не передан, план включен.
+// @Description Метод доступен супер админу и админу.
+// @Success 201 {object} entity.SubscriptionPlan
+// @Router /v1/admin/management/subscriptionPlans [post]
+// @Tags Методы биллинга
+// @Param plan body entity.SubscriptionPlanToSave true "Условия плана"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 404 {object} courseerror.CourseError "Курс не найден"
+// @Failure 409 {object} courseerror.CourseError "План с таким названием уже существует"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) CreateSubscriptionPlan(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "CreateSubscriptionPlan", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "POST", "CreateSubscriptionPlan")
+		return
+	}
+
+	planToSave := entity.CreateNewSubscriptionPlanToSave()
+	if err := ctx.ShouldBindJSON(&planToSave); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "CreateSubscriptionPlan", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "POST", "CreateSubscriptionPlan")
+		return
+	}
+
+	plan, err := h.sberBillingService.CreateSubscriptionPlan(ctx, planToSave)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при создании плана подписки %v", planToSave.Name), "CreateSubscriptionPlan", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "CreateSubscriptionPlan")
+			return
+		}
+		if err.Code == 13003 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "CreateSubscriptionPlan")
+			return
+		}
+		if err.Code == 15019 {
+			statusCode = http.StatusConflict
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "CreateSubscriptionPlan")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "CreateSubscriptionPlan")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("админ с ID: %d создал план подписки %v", ctx.Value("AdminId"), plan.Name), "CreateSubscriptionPlan", fmt.Sprint(plan.Id))
+
+	statusCode = http.StatusCreated
+	ctx.JSON(statusCode, plan)
+	h.metrics.RecordResponse(statusCode, "POST", "CreateSubscriptionPlan")
+}
+
+// @Summary Получить все планы подписки
+// @Produce json
+// @Description Используется для просмотра всех планов подписки, включая выключенные. Метод доступен супер админу и админу.
+// @Success 200 {array} entity.SubscriptionPlan
+// @Router /v1/admin/management/subscriptionPlans [get]
+// @Tags Методы биллинга
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) GetAllSubscriptionPlans(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "GetAllSubscriptionPlans", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "GET", "GetAllSubscriptionPlans")
+		return
+	}
+
+	plans, err := h.sberBillingService.RetreiveSubscriptionPlans(ctx, false)
+	if err != nil {
+		h.logger.Error("ошибка при получении планов подписки", "GetAllSubscriptionPlans", err.Message, err.Code)
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "GetAllSubscriptionPlans")
+		return
+	}
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, plans)
+	h.metrics.RecordResponse(statusCode, "GET", "GetAllSubscriptionPlans")
+}
+
+// @Summary Изменить план подписки
+// @Accept json
+// @Produce json
+// @Description Используется для изменения плана подписки, условия перезаписываются целиком. Оформленные подписки продлеваются
+// @Description по новой цене. Выключенный план нельзя оформить, но оформленные подписки продолжают продлеваться.
+// @Description Метод доступен супер админу и админу.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/admin/management/subscriptionPlans/{id} [patch]
+// @Tags Методы биллинга
+// @Param id path string true "ID плана"
+// @Param plan body entity.SubscriptionPlanToSave true "Условия плана"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
+// @Failure 403 {object} courseerror.CourseError "Нет прав"
+// @Failure 404 {object} courseerror.CourseError "План или курс не найден"
+// @Failure 409 {object} courseerror.CourseError "План с таким названием уже существует"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) UpdateSubscriptionPlan(ctx *gin.Context) {
+	var statusCode int
+
+	role := ctx.Value("Role").(string)
+	if role != "super_admin" && role != "admin" {
+		statusCode = http.StatusForbidden
+		h.logger.Error(fmt.Sprintf("у админа не хватило прав, id: %d", ctx.Value("AdminId")), "UpdateSubscriptionPlan", errNoRights.Error(), 16004)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errNoRights, 16004))
+		h.metrics.RecordResponse(statusCode, "PATCH", "UpdateSubscriptionPlan")
+		return
+	}
+
+	planId := ctx.Param("id")
+
+	planToSave := entity.CreateNewSubscriptionPlanToSave()
+	if err := ctx.ShouldBindJSON(&planToSave); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "UpdateSubscriptionPlan", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "PATCH", "UpdateSubscriptionPlan")
+		return
+	}
+
+	if err := h.sberBillingService.EditSubscriptionPlan(ctx, planId, planToSave); err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при изменении плана подписки с ID: %v", planId), "UpdateSubscriptionPlan", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "PATCH", "UpdateSubscriptionPlan")
+			return
+		}
+		if err.Code == 15017 || err.Code == 13003 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "PATCH", "UpdateSubscriptionPlan")
+			return
+		}
+		if err.Code == 15019 {
+			statusCode = http.StatusConflict
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "PATCH", "UpdateSubscriptionPlan")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "PATCH", "UpdateSubscriptionPlan")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("админ с ID: %d изменил план подписки с ID: %v", ctx.Value("AdminId"), planId), "UpdateSubscriptionPlan", planToSave.Name)
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("план подписки успешно изменен"))
+	h.metrics.RecordResponse(statusCode, "PATCH", "UpdateSubscriptionPlan")
+}
@@ -0,0 +1,228 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	courseerror "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+// @Summary Завершить логин кодом двухфакторной аутентификации
+// @Produce json
+// @Accept json
+// @Description Используется для второго шага логина пользователя с включенной двухфакторной аутентификацией.
+// @Description Принимает челлендж из ответа /v1/auth/login и код из аутентификатора или код восстановления.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/auth/loginTwoFactor [post]
+// @Tags Методы для авторизации пользователей
+// @Param challenge body entity.TwoStepAuthChallenge true "Челлендж и код"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения"
+// @Failure 403 {object} courseerror.CourseError "Неверный код"
+// @Failure 404 {object} courseerror.CourseError "Челлендж не найден или устарел"
+// @Failure 429 {object} courseerror.CourseError "Слишком много неудачных попыток"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) SignInSecondStep(ctx *gin.Context) {
+	var statusCode int
+
+	challenge := entity.NewTwoStepAuthChallenge()
+	if err := ctx.ShouldBindJSON(&challenge); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "SignInSecondStep", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "POST", "SignInSecondStep")
+		return
+	}
+
+	tokens, err := h.authService.LogInSecondStep(ctx, challenge, entity.NewDevice(ctx.Request.UserAgent(), ctx.ClientIP()))
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("не получилось завершить логин c IP: %v", ctx.ClientIP()), "SignInSecondStep", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "SignInSecondStep")
+			return
+		}
+		if err.Code == 11016 {
+			statusCode = http.StatusForbidden
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "SignInSecondStep")
+			return
+		}
+		if err.Code == 11017 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "SignInSecondStep")
+			return
+		}
+		if err.Code == 11019 {
+			statusCode = http.StatusTooManyRequests
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "SignInSecondStep")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "SignInSecondStep")
+		return
+	}
+
+	h.setUserTokens(ctx, tokens)
+
+	h.logger.Info(fmt.Sprintf("пользователь успешно прошел второй шаг логина c IP: %v", ctx.ClientIP()), "SignInSecondStep", "")
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("доступ разрешен"))
+	h.metrics.RecordResponse(statusCode, "POST", "SignInSecondStep")
+}
+
+// @Summary Привязать аутентификатор
+// @Produce png
+// @Description Используется для привязки аутентификатора к профилю. Возвращает QR-код, который нужно отсканировать
+// @Description в приложении и подтвердить кодом через /v1/profile/confirmTwoFactor.
+// @Success 200 {file} png
+// @Router /v1/profile/enableTwoFactor [post]
+// @Tags Методы для администрирования профиля
+// @Failure 409 {object} courseerror.CourseError "Двухфакторная аутентификация уже включена"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) EnableTwoFactor(ctx *gin.Context) {
+	var statusCode int
+
+	userId := ctx.Value("UserId").(uint)
+
+	qr, err := h.authService.EnrollTwoStepAuth(ctx)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("не получилось привязать аутентификатор пользователю с ID: %d", userId), "EnableTwoFactor", err.Message, err.Code)
+		if err.Code == 11014 {
+			statusCode = http.StatusConflict
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "EnableTwoFactor")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "EnableTwoFactor")
+		return
+	}
+
+	h.logger.Info("ключ аутентификатора успешно создан", "EnableTwoFactor", fmt.Sprintf("ID: %d", userId))
+
+	statusCode = http.StatusOK
+	ctx.Data(statusCode, "image/png", qr)
+	h.metrics.RecordResponse(statusCode, "POST", "EnableTwoFactor")
+}
+
+// @Summary Подтвердить привязку аутентификатора
+// @Produce json
+// @Accept json
+// @Description Используется для включения двухфакторной аутентификации. Принимает код из приложения
+// @Description и возвращает коды восстановления, которые показываются только один раз.
+// @Success 200 {object} entity.RecoveryCodes
+// @Router /v1/profile/confirmTwoFactor [post]
+// @Tags Методы для администрирования профиля
+// @Param code body entity.TwoStepAuthCode true "Код из аутентификатора"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения, или аутентификатор не привязан"
+// @Failure 403 {object} courseerror.CourseError "Неверный код"
+// @Failure 409 {object} courseerror.CourseError "Двухфакторная аутентификация уже включена"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) ConfirmTwoFactor(ctx *gin.Context) {
+	var statusCode int
+
+	userId := ctx.Value("UserId").(uint)
+
+	code := entity.NewTwoStepAuthCode()
+	if err := ctx.ShouldBindJSON(&code); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "ConfirmTwoFactor", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "POST", "ConfirmTwoFactor")
+		return
+	}
+
+	recoveryCodes, err := h.authService.ConfirmTwoStepAuth(ctx, code.Code)
+	if err != nil {
+		h.logger.Error(fmt.Sprintf("не получилось включить двухфакторную аутентификацию пользователю с ID: %d", userId), "ConfirmTwoFactor", err.Message, err.Code)
+		if err.Code == 400 || err.Code == 11015 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "ConfirmTwoFactor")
+			return
+		}
+		if err.Code == 11016 {
+			statusCode = http.StatusForbidden
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "ConfirmTwoFactor")
+			return
+		}
+		if err.Code == 11014 {
+			statusCode = http.StatusConflict
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "ConfirmTwoFactor")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "ConfirmTwoFactor")
+		return
+	}
+
+	h.logger.Info("двухфакторная аутентификация успешно включена", "ConfirmTwoFactor", fmt.Sprintf("ID: %d", userId))
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateRecoveryCodes(recoveryCodes))
+	h.metrics.RecordResponse(statusCode, "POST", "ConfirmTwoFactor")
+}
+
+// @Summary Отключить двухфакторную аутентификацию
+// @Produce json
+// @Accept json
+// @Description Используется для отключения двухфакторной аутентификации. Принимает код из приложения или код восстановления.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/profile/disableTwoFactor [post]
+// @Tags Методы для администрирования профиля
+// @Param code body entity.TwoStepAuthCode true "Код из аутентификатора или код восстановления"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация или декодирование сообщения, или двухфакторная аутентификация не включена"
+// @Failure 403 {object} courseerror.CourseError "Неверный код"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) DisableTwoFactor(ctx *gin.Context) {
+	var statusCode int
+
+	userId := ctx.Value("UserId").(uint)
+
+	code := entity.NewTwoStepAuthCode()
+	if err := ctx.ShouldBindJSON(&code); err != nil {
+		statusCode = http.StatusBadRequest
+		h.logger.Error("не получилось обработать тело запроса", "DisableTwoFactor", err.Error(), 10101)
+		ctx.AbortWithStatusJSON(statusCode, courseerror.CreateError(errBrokenJSON, 10101))
+		h.metrics.RecordResponse(statusCode, "POST", "DisableTwoFactor")
+		return
+	}
+
+	if err := h.authService.DisableTwoStepAuth(ctx, code.Code); err != nil {
+		h.logger.Error(fmt.Sprintf("не получилось отключить двухфакторную аутентификацию пользователю с ID: %d", userId), "DisableTwoFactor", err.Message, err.Code)
+		if err.Code == 400 || err.Code == 11015 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "DisableTwoFactor")
+			return
+		}
+		if err.Code == 11016 {
+			statusCode = http.StatusForbidden
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "DisableTwoFactor")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "DisableTwoFactor")
+		return
+	}
+
+	h.logger.Info("двухфакторная аутентификация успешно отключена", "DisableTwoFactor", fmt.Sprintf("ID: %d", userId))
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("двухфакторная аутентификация отключена"))
+	h.metrics.RecordResponse(statusCode, "POST", "DisableTwoFactor")
+}
@@ -140,6 +140,50 @@ func (h Handlers) UnbanUser(ctx *gin.Context) {
 	h.metrics.RecordResponse(statusCode, "POST", "UnbanUser")
 }
 
+// @Summary Снять блокировку входа с пользователя
+// @Produce json
+// @Description Используется для снятия временной блокировки, которая ставится после неудачных попыток входа,
+// @Description подтверждения почты или восстановления пароля. Требуется токен администратора.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/admin/management/unlock [post]
+// @Tags Методы для администрирования
+// @Param id query string true "ID"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация"
+// @Failure 404 {object} courseerror.CourseError "Пользователь не найден"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) UnlockUser(ctx *gin.Context) {
+	var statusCode int
+
+	id := ctx.Query("id")
+
+	if err := h.userManagementService.UnlockUser(ctx, id); err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при снятии блокировки входа с пользователя с ID: %v", id), "UnlockUser", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "UnlockUser")
+			return
+		}
+		if err.Code == 11101 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "UnlockUser")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "UnlockUser")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("блокировка входа снята админом с ID: %v",
+		ctx.Value("AdminId").(uint)), "UnlockUser", fmt.Sprintf("userId: %v", id))
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("блокировка входа снята"))
+	h.metrics.RecordResponse(statusCode, "POST", "UnlockUser")
+}
+
 // @Summary Получить пользователя по ID
 // @Produce json
 // @Description Используется для получения данных о пользователе по ID. Требуется токен администратора.
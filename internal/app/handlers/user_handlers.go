@@ -69,7 +69,7 @@ func (h Handlers) ManageProfile(ctx *gin.Context) {
 // @Router /v1/profile/editPassword [patch]
 // @Tags Методы для администрирования профиля
 // @Param passwords body entity.Passwords true "Пароли"
-// @Failure 400 {object} courseerror.CourseError "Провалена валидация или не удалось декодировать сообщение"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация, не удалось декодировать сообщение или пароль не прошел парольную политику"
 // @Failure 404 {object} courseerror.CourseError "Пользователь не найден"
 // @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
 func (h Handlers) ManagePassword(ctx *gin.Context) {
@@ -88,7 +88,8 @@ func (h Handlers) ManagePassword(ctx *gin.Context) {
 
 	if err := h.userService.EditPassword(ctx, passwords); err != nil {
 		h.logger.Error(fmt.Sprintf("ошибка при изменении пароля пользователем с ID: %d", userId), "ManagePassword", err.Message, err.Code)
-		if err.Code == 400 || err.Code == 11102 || err.Code == 11104 || err.Code == 11103 {
+		if err.Code == 400 || err.Code == 11102 || err.Code == 11104 || err.Code == 11103 ||
+			err.Code == 11401 || err.Code == 11402 || err.Code == 11403 || err.Code == 11404 {
 			statusCode = http.StatusBadRequest
 			ctx.AbortWithStatusJSON(statusCode, err)
 			h.metrics.RecordResponse(statusCode, "PATCH", "ManagePassword")
@@ -345,3 +346,135 @@ func (h Handlers) WatchVideo(ctx *gin.Context) {
 	ctx.JSON(statusCode, entity.CreateSuccessResponse("урок успешно помечен как просмотренный"))
 	h.metrics.RecordResponse(statusCode, "POST", "WatchVideo")
 }
+
+// @Summary Получить активные сессии
+// @Produce json
+// @Description Используется для получения списка устройств, с которых пользователь вошел в аккаунт.
+// @Success 200 {array} entity.Session
+// @Router /v1/profile/sessions [get]
+// @Tags Методы для администрирования профиля
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) GetSessions(ctx *gin.Context) {
+	var statusCode int
+
+	userId := ctx.Value("UserId").(uint)
+
+	sessions, err := h.userService.RetreiveSessions(ctx)
+	if err != nil {
+		statusCode = http.StatusInternalServerError
+		h.logger.Error(fmt.Sprintf("ошибка при получении сессий пользователя с ID: %d", userId), "GetSessions", err.Message, err.Code)
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "GET", "GetSessions")
+		return
+	}
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, sessions)
+	h.metrics.RecordResponse(statusCode, "GET", "GetSessions")
+}
+
+// @Summary Завершить сессию
+// @Produce json
+// @Description Используется для завершения сессии пользователя на одном из устройств.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/profile/sessions/{id} [delete]
+// @Tags Методы для администрирования профиля
+// @Param id path string true "ID сессии"
+// @Failure 400 {object} courseerror.CourseError "Провалена валидация"
+// @Failure 404 {object} courseerror.CourseError "Сессия не найдена"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) RevokeSession(ctx *gin.Context) {
+	var statusCode int
+
+	userId := ctx.Value("UserId").(uint)
+	sessionId := ctx.Param("id")
+
+	if err := h.userService.TerminateSession(ctx, sessionId); err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при завершении сессии %v пользователя с ID: %d", sessionId, userId), "RevokeSession", err.Message, err.Code)
+		if err.Code == 400 {
+			statusCode = http.StatusBadRequest
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "DELETE", "RevokeSession")
+			return
+		}
+		if err.Code == 11013 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "DELETE", "RevokeSession")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "DELETE", "RevokeSession")
+		return
+	}
+
+	h.logger.Info(fmt.Sprintf("сессия %v успешно завершена", sessionId), "RevokeSession", fmt.Sprintf("ID: %d", userId))
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("сессия успешно завершена"))
+	h.metrics.RecordResponse(statusCode, "DELETE", "RevokeSession")
+}
+
+// @Summary Выйти на всех других устройствах
+// @Produce json
+// @Description Используется для завершения всех сессий пользователя, кроме текущей.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/profile/logoutOthers [post]
+// @Tags Методы для администрирования профиля
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) RevokeOtherSessions(ctx *gin.Context) {
+	var statusCode int
+
+	userId := ctx.Value("UserId").(uint)
+
+	if err := h.userService.TerminateOtherSessions(ctx); err != nil {
+		statusCode = http.StatusInternalServerError
+		h.logger.Error(fmt.Sprintf("ошибка при завершении других сессий пользователя с ID: %d", userId), "RevokeOtherSessions", err.Message, err.Code)
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "RevokeOtherSessions")
+		return
+	}
+
+	h.logger.Info("другие сессии пользователя успешно завершены", "RevokeOtherSessions", fmt.Sprintf("ID: %d", userId))
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("выполнен выход на всех других устройствах"))
+	h.metrics.RecordResponse(statusCode, "POST", "RevokeOtherSessions")
+}
+
+// @Summary Выйти из аккаунта
+// @Produce json
+// @Description Используется для завершения текущей сессии пользователя. Удаляет куки с токенами.
+// @Success 200 {object} entity.SuccessResponse
+// @Router /v1/profile/logout [post]
+// @Tags Методы для администрирования профиля
+// @Failure 404 {object} courseerror.CourseError "Сессия не найдена"
+// @Failure 500 {object} courseerror.CourseError "Возникла внутренняя ошибка"
+func (h Handlers) LogOut(ctx *gin.Context) {
+	var statusCode int
+
+	userId := ctx.Value("UserId").(uint)
+
+	if err := h.userService.LogOut(ctx); err != nil {
+		h.logger.Error(fmt.Sprintf("ошибка при выходе пользователя с ID: %d", userId), "LogOut", err.Message, err.Code)
+		if err.Code == 11013 {
+			statusCode = http.StatusNotFound
+			ctx.AbortWithStatusJSON(statusCode, err)
+			h.metrics.RecordResponse(statusCode, "POST", "LogOut")
+			return
+		}
+		statusCode = http.StatusInternalServerError
+		ctx.AbortWithStatusJSON(statusCode, err)
+		h.metrics.RecordResponse(statusCode, "POST", "LogOut")
+		return
+	}
+
+	h.clearUserTokens(ctx)
+
+	h.logger.Info("пользователь успешно вышел из аккаунта", "LogOut", fmt.Sprintf("ID: %d", userId))
+
+	statusCode = http.StatusOK
+	ctx.JSON(statusCode, entity.CreateSuccessResponse("выход выполнен"))
+	h.metrics.RecordResponse(statusCode, "POST", "LogOut")
+}
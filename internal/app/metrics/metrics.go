@@ -7,7 +7,9 @@ import (
 )
 
 type Metrics struct {
-	StatusCodesCounter *prometheus.CounterVec
+	StatusCodesCounter       *prometheus.CounterVec
+	RevocationLookupsCounter *prometheus.CounterVec
+	OutboxEmailsCounter      *prometheus.CounterVec
 }
 
 func InitMetrics() *Metrics {
@@ -16,13 +18,35 @@ func InitMetrics() *Metrics {
 		Help: "HTTP статус коды, которые возвращает приложение",
 	}, []string{"code", "method", "function"})
 
+	revocationLookups := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "token_revocation_lookups",
+		Help: "Проверки токенов по denylist, result=fallback означает промах кэша и обращение в БД",
+	}, []string{"token", "result"})
+
+	outboxEmails := prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "outbox_emails",
+		Help: "Попытки отправки писем из outbox, result=dead означает, что попытки закончились",
+	}, []string{"result"})
+
 	prometheus.Register(statusCodes)
+	prometheus.Register(revocationLookups)
+	prometheus.Register(outboxEmails)
 
 	return &Metrics{
 		statusCodes,
+		revocationLookups,
+		outboxEmails,
 	}
 }
 
 func (m Metrics) RecordResponse(statusCode int, method, function string) {
 	m.StatusCodesCounter.WithLabelValues(fmt.Sprint(statusCode), method, function).Inc()
 }
+
+func (m Metrics) RecordRevocationLookup(tokenType, result string) {
+	m.RevocationLookupsCounter.WithLabelValues(tokenType, result).Inc()
+}
+
+func (m Metrics) RecordOutboxEmail(result string) {
+	m.OutboxEmailsCounter.WithLabelValues(result).Inc()
+}
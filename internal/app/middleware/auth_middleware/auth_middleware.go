@@ -4,25 +4,27 @@ import (
 	"errors"
 	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/knstch/course/internal/app/config"
 	courseError "github.com/knstch/course/internal/app/course_error"
 	"github.com/knstch/course/internal/app/logger"
+	"github.com/knstch/course/internal/app/services/apikey"
 	"github.com/knstch/course/internal/app/services/token"
+	"github.com/knstch/course/internal/domain/entity"
 )
 
 var (
 	errUserNotAuthentificated = errors.New("пользователь не авторизован")
 )
 
-func NewMiddleware(logger logger.Logger, config *config.Config, tokenService *token.TokenService) *Middleware {
+func NewMiddleware(logger logger.Logger, config *config.Config, tokenService *token.TokenService, apiKeyService apikey.ApiKeyService) *Middleware {
 	return &Middleware{
 		logger,
-		config.Secret,
-		config.AdminSecret,
 		tokenService,
+		apiKeyService,
 		config.TechMetricsLogin,
 		config.TechMetricsPassword,
 	}
@@ -30,9 +32,8 @@ func NewMiddleware(logger logger.Logger, config *config.Config, tokenService *to
 
 type Middleware struct {
 	logger          logger.Logger
-	userSecret      string
-	adminSecret     string
 	tokenService    *token.TokenService
+	apiKeyService   apikey.ApiKeyService
 	metricsLogin    string
 	metricsPassword string
 }
@@ -40,10 +41,11 @@ type Middleware struct {
 // Claims содержит в себе поля, которые хранятся в JWT.
 type UserClaims struct {
 	jwt.RegisteredClaims
-	Iat      int
-	Exp      int
-	UserID   uint
-	Verified bool
+	Iat       int
+	Exp       int
+	UserID    uint
+	Verified  bool
+	SessionId string
 }
 
 // Claims содержит в себе типы данных, которые хранятся в JWT.
@@ -55,8 +57,32 @@ type AdminClaims struct {
 	Role    string
 }
 
+// WithCookieAuth пускает пользователя по куки auth или по API ключу пользователя в заголовке Authorization: Bearer.
 func (m Middleware) WithCookieAuth() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		if key, ok := bearerApiKey(ctx); ok {
+			owner, ok := m.authenticateApiKey(ctx, key, "WithCookieAuth")
+			if !ok {
+				return
+			}
+
+			if owner.UserId == 0 {
+				m.logger.Error(fmt.Sprintf("передан API ключ админа, вызов с IP: %v", ctx.ClientIP()), "WithCookieAuth", apikey.ErrBadApiKey.Error(), 11301)
+				ctx.AbortWithStatusJSON(http.StatusForbidden, courseError.CreateError(apikey.ErrBadApiKey, 11301))
+				return
+			}
+
+			ctx.Set("UserId", owner.UserId)
+			ctx.Set("verified", owner.Verified)
+			ctx.Set("SessionId", "")
+			ctx.Set("ApiKeyId", owner.KeyId)
+
+			m.logger.Info(fmt.Sprintf("пользователь успешно перел по URL: %v c IP: %v по API ключу %d", ctx.Request.URL.String(), ctx.ClientIP(), owner.KeyId), "WithCookieAuth", fmt.Sprint(owner.UserId))
+
+			ctx.Next()
+			return
+		}
+
 		cookie, err := ctx.Request.Cookie("auth")
 		if err != nil {
 			m.logger.Error(fmt.Sprintf("отсутствуют куки, вызов с IP: %v", ctx.ClientIP()), "WithCookieAuth", err.Error(), 11009)
@@ -64,29 +90,30 @@ func (m Middleware) WithCookieAuth() gin.HandlerFunc {
 			return
 		}
 
-		if err := m.tokenService.ValidateAccessToken(ctx, &cookie.Value); err != nil {
-			m.logger.Error("не получилось валидировать токен", "WithCookieAuth", err.Message, err.Code)
-			if err.Code == 11006 {
-				ctx.AbortWithStatusJSON(http.StatusForbidden, err)
+		payload, tokenError := m.tokenService.DecodeUserToken(ctx, cookie.Value)
+		if tokenError != nil {
+			m.logger.Error("не получилось декодировать токен", "WithCookieAuth", tokenError.Message, tokenError.Code)
+			if tokenError.Code == 11006 || tokenError.Code == 11007 {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, tokenError)
 				return
 			}
-			ctx.AbortWithStatusJSON(http.StatusInternalServerError, err)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, tokenError)
 			return
 		}
 
-		payload, tokenError := m.tokenService.DecodeUserToken(ctx, cookie.Value)
-		if tokenError != nil {
-			m.logger.Error("не получилось декодировать токен", "WithCookieAuth", tokenError.Message, tokenError.Code)
-			if tokenError.Code == 11006 || tokenError.Code == 11007 {
+		if err := m.tokenService.ValidateAccessToken(ctx, payload.ID); err != nil {
+			m.logger.Error("не получилось валидировать токен", "WithCookieAuth", err.Message, err.Code)
+			if err.Code == 11006 {
 				ctx.AbortWithStatusJSON(http.StatusForbidden, err)
 				return
 			}
-			ctx.AbortWithStatusJSON(http.StatusInternalServerError, tokenError)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, err)
 			return
 		}
 
 		ctx.Set("UserId", payload.UserID)
 		ctx.Set("verified", payload.Verified)
+		ctx.Set("SessionId", payload.SessionId)
 
 		m.logger.Info(fmt.Sprintf("пользователь успешно перел по URL: %v c IP: %v", ctx.Request.URL.String(), ctx.ClientIP()), "WithCookieAuth", fmt.Sprint(payload.UserID))
 
@@ -94,8 +121,31 @@ func (m Middleware) WithCookieAuth() gin.HandlerFunc {
 	}
 }
 
+// WithAdminCookieAuth пускает администратора по куки admin_auth или по API ключу администратора в заголовке Authorization: Bearer.
 func (m Middleware) WithAdminCookieAuth() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
+		if key, ok := bearerApiKey(ctx); ok {
+			owner, ok := m.authenticateApiKey(ctx, key, "WithAdminCookieAuth")
+			if !ok {
+				return
+			}
+
+			if owner.AdminId == 0 {
+				m.logger.Error(fmt.Sprintf("передан API ключ пользователя, запрос с IP: %v", ctx.ClientIP()), "WithAdminCookieAuth", apikey.ErrBadApiKey.Error(), 11301)
+				ctx.AbortWithStatusJSON(http.StatusForbidden, courseError.CreateError(apikey.ErrBadApiKey, 11301))
+				return
+			}
+
+			ctx.Set("AdminId", owner.AdminId)
+			ctx.Set("Role", owner.Role)
+			ctx.Set("ApiKeyId", owner.KeyId)
+
+			m.logger.Info(fmt.Sprintf("админ перешел по URL: %v c IP: %v по API ключу %d", ctx.Request.URL.String(), ctx.ClientIP(), owner.KeyId), "WithAdminCookieAuth", fmt.Sprint(owner.AdminId))
+
+			ctx.Next()
+			return
+		}
+
 		cookie, err := ctx.Request.Cookie("admin_auth")
 		if err != nil {
 			m.logger.Error(fmt.Sprintf("не получилось получить куки, запрос с IP: %v", ctx.ClientIP()), "WithAdminCookieAuth", errUserNotAuthentificated.Error(), 11009)
@@ -103,24 +153,24 @@ func (m Middleware) WithAdminCookieAuth() gin.HandlerFunc {
 			return
 		}
 
-		if err := m.tokenService.ValidateAdminAccessToken(ctx, &cookie.Value); err != nil {
-			m.logger.Error("не получилось валидировать токен", "WithAdminCookieAuth", err.Message, err.Code)
-			if err.Code == 11006 {
-				ctx.AbortWithStatusJSON(http.StatusForbidden, err)
+		payload, tokenError := m.tokenService.DecodeAdminToken(ctx, cookie.Value)
+		if tokenError != nil {
+			m.logger.Error("не получилось декодировать токен", "WithAdminCookieAuth", tokenError.Message, tokenError.Code)
+			if tokenError.Code == 11006 || tokenError.Code == 11007 {
+				ctx.AbortWithStatusJSON(http.StatusForbidden, tokenError)
 				return
 			}
-			ctx.AbortWithStatusJSON(http.StatusInternalServerError, err)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, tokenError)
 			return
 		}
 
-		payload, tokenError := m.tokenService.DecodeAdminToken(ctx, cookie.Value)
-		if tokenError != nil {
-			m.logger.Error("не получилось декодировать токен", "WithAdminCookieAuth", tokenError.Message, tokenError.Code)
-			if tokenError.Code == 11006 || tokenError.Code == 11007 {
+		if err := m.tokenService.ValidateAdminAccessToken(ctx, payload.ID); err != nil {
+			m.logger.Error("не получилось валидировать токен", "WithAdminCookieAuth", err.Message, err.Code)
+			if err.Code == 11006 {
 				ctx.AbortWithStatusJSON(http.StatusForbidden, err)
 				return
 			}
-			ctx.AbortWithStatusJSON(http.StatusInternalServerError, tokenError)
+			ctx.AbortWithStatusJSON(http.StatusInternalServerError, err)
 			return
 		}
 
@@ -133,6 +183,48 @@ func (m Middleware) WithAdminCookieAuth() gin.HandlerFunc {
 	}
 }
 
+// WithInteractiveSession пускает запрос, только если он пришел по куки, а не по API ключу. Используется для
+// смены пароля, почты, двухфакторной аутентификации, управления сессиями и админами, настроек эквайринга, возвратов
+// и снятия блокировок, чтобы утекший ключ не давал захватить аккаунт, завести себе новый или распоряжаться деньгами.
+// Ставится после WithCookieAuth или WithAdminCookieAuth.
+func (m Middleware) WithInteractiveSession() gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		if keyId, ok := ctx.Get("ApiKeyId"); ok {
+			m.logger.Error(fmt.Sprintf("запрос к URL: %v по API ключу %v, запрос с IP: %v", ctx.Request.URL.String(), keyId, ctx.ClientIP()), "WithInteractiveSession", apikey.ErrInteractiveOnly.Error(), 11305)
+			ctx.AbortWithStatusJSON(http.StatusForbidden, courseError.CreateError(apikey.ErrInteractiveOnly, 11305))
+			return
+		}
+
+		ctx.Next()
+	}
+}
+
+// bearerApiKey достает API ключ из заголовка Authorization. Возвращает false, если заголовок не передан.
+func bearerApiKey(ctx *gin.Context) (string, bool) {
+	header := ctx.GetHeader("Authorization")
+	if len(header) < len("Bearer ") || !strings.EqualFold(header[:len("Bearer ")], "Bearer ") {
+		return "", false
+	}
+
+	return strings.TrimSpace(header[len("Bearer "):]), true
+}
+
+// authenticateApiKey проверяет API ключ и права ключа на запрос. Если ключ не подошел, то прерывает запрос и возвращает false.
+func (m Middleware) authenticateApiKey(ctx *gin.Context, key, function string) (*entity.ApiKeyOwner, bool) {
+	owner, err := m.apiKeyService.Authenticate(ctx, key, ctx.Request.Method)
+	if err != nil {
+		m.logger.Error(fmt.Sprintf("не получилось проверить API ключ, запрос с IP: %v", ctx.ClientIP()), function, err.Message, err.Code)
+		if err.Code == 11301 || err.Code == 11302 || err.Code == 11010 || err.Code == 11011 {
+			ctx.AbortWithStatusJSON(http.StatusForbidden, err)
+			return nil, false
+		}
+		ctx.AbortWithStatusJSON(http.StatusInternalServerError, err)
+		return nil, false
+	}
+
+	return owner, true
+}
+
 func (m Middleware) WithMetricsAuth() gin.HandlerFunc {
 	return func(ctx *gin.Context) {
 		user, password, hasAuth := ctx.Request.BasicAuth()
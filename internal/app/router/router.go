@@ -15,6 +15,8 @@ import (
 func RequestsRouter(h *handlers.Handlers, m *authmiddleware.Middleware) *gin.Engine {
 	router := gin.Default()
 
+	router.GET("/.well-known/jwks.json", h.GetJWKS)
+
 	api := router.Group("/api")
 
 	docs.SwaggerInfo.BasePath = "/api"
@@ -31,6 +33,12 @@ func RequestsRouter(h *handlers.Handlers, m *authmiddleware.Middleware) *gin.Eng
 	auth.POST("/login", h.SignIn)
 	auth.GET("/sendRecoveryCode", h.SendRecoverPasswordCode)
 	auth.POST("/recoverPassword", h.SetNewPassword)
+	auth.POST("/refresh", h.RefreshTokens)
+	auth.POST("/loginTwoFactor", h.SignInSecondStep)
+	auth.GET("/oauth/:provider", h.SocialLogin)
+	auth.GET("/oauth/:provider/callback", h.SocialLoginCallback)
+	auth.GET("/sendLoginLink", h.SendLoginLink)
+	auth.POST("/magicLogin", h.MagicLinkSignIn)
 
 	email := auth.Group("email")
 	email.Use(m.WithCookieAuth())
@@ -40,16 +48,26 @@ func RequestsRouter(h *handlers.Handlers, m *authmiddleware.Middleware) *gin.Eng
 	profile := v1.Group("profile")
 	profile.Use(m.WithCookieAuth())
 	profile.PATCH("/editProfile", h.ManageProfile)
-	profile.PATCH("/editPassword", h.ManagePassword)
-	profile.PATCH("/editEmail", h.ManageEmail)
-	profile.POST("/confirmEmailChange", h.ConfirmEmailChange)
+	profile.PATCH("/editPassword", m.WithInteractiveSession(), h.ManagePassword)
+	profile.PATCH("/editEmail", m.WithInteractiveSession(), h.ManageEmail)
+	profile.POST("/confirmEmailChange", m.WithInteractiveSession(), h.ConfirmEmailChange)
 	profile.PATCH("/setPhoto", h.ChangeProfilePhoto)
 	profile.GET("/getUser", h.GetUser)
 	profile.GET("/getCourses", h.RetreiveCourses)
 	profile.GET("/modules", h.RetreiveModules)
 	profile.GET("/lessons", h.RetreiveLessons)
-	profile.POST("/disable", h.FreezeProfile)
+	profile.POST("/disable", m.WithInteractiveSession(), h.FreezeProfile)
 	profile.POST("/watchLesson", h.WatchVideo)
+	profile.GET("/sessions", h.GetSessions)
+	profile.DELETE("/sessions/:id", m.WithInteractiveSession(), h.RevokeSession)
+	profile.POST("/logoutOthers", m.WithInteractiveSession(), h.RevokeOtherSessions)
+	profile.POST("/logout", h.LogOut)
+	profile.POST("/enableTwoFactor", m.WithInteractiveSession(), h.EnableTwoFactor)
+	profile.POST("/confirmTwoFactor", m.WithInteractiveSession(), h.ConfirmTwoFactor)
+	profile.POST("/disableTwoFactor", m.WithInteractiveSession(), h.DisableTwoFactor)
+	profile.POST("/apiKeys", h.CreateApiKey)
+	profile.GET("/apiKeys", h.GetApiKeys)
+	profile.DELETE("/apiKeys/:id", h.RevokeApiKey)
 
 	admin := v1.Group("admin")
 	admin.POST("/login", h.LogIn)
@@ -57,14 +75,15 @@ func RequestsRouter(h *handlers.Handlers, m *authmiddleware.Middleware) *gin.Eng
 
 	management := admin.Group("management")
 	management.Use(m.WithAdminCookieAuth())
-	management.POST("/register", h.CreateAdmin)
-	management.PATCH("/resetPassword", h.ChangeAdminPassword)
-	management.PATCH("/resetKey", h.ChangeAdminAuthKey)
+	management.POST("/register", m.WithInteractiveSession(), h.CreateAdmin)
+	management.PATCH("/resetPassword", m.WithInteractiveSession(), h.ChangeAdminPassword)
+	management.PATCH("/resetKey", m.WithInteractiveSession(), h.ChangeAdminAuthKey)
 	management.GET("/users", h.FindUsersByFilters)
 	management.PATCH("/editUserProfile", h.EditUserProfile)
 	management.DELETE("/deleteProfilePhoto", h.RemoveUserProfilePhoto)
 	management.POST("/ban", h.BanUser)
 	management.POST("/unban", h.UnbanUser)
+	management.POST("/unlock", m.WithInteractiveSession(), h.UnlockUser)
 	management.GET("/user", h.GetUserById)
 	management.POST("/createCourse", h.CreateNewCourse)
 	management.POST("/createModule", h.CreateNewModule)
@@ -73,13 +92,36 @@ func RequestsRouter(h *handlers.Handlers, m *authmiddleware.Middleware) *gin.Eng
 	management.PATCH("/editModule", h.UpdateModule)
 	management.PATCH("/editLesson", h.UpdateLesson)
 	management.PATCH("/editVisibility", h.ManageVisibility)
+	management.PATCH("/coursePrices/:id", h.UpdateCoursePrices)
 	management.DELETE("/deleteModule/:id", h.EraseModule)
 	management.DELETE("/deleteLesson/:id", h.EraseLesson)
-	management.PATCH("/manageBillingHost", h.ManageBillingHost)
-	management.PATCH("/manageBillingToken", h.ManageAccessToken)
-	management.DELETE("/removeAdmin", h.DeleteAdmin)
-	management.PATCH("/changeRole", h.ChangeRole)
+	management.PATCH("/manageBillingHost", m.WithInteractiveSession(), h.ManageBillingHost)
+	management.PATCH("/manageBillingToken", m.WithInteractiveSession(), h.ManageAccessToken)
+	management.GET("/reconciliationReports", h.GetReconciliationReports)
+	management.POST("/refunds", m.WithInteractiveSession(), h.RefundPurchase)
+	management.POST("/receipts/:id/resend", h.ResendReceipt)
+	management.POST("/promoCodes", h.CreatePromoCode)
+	management.GET("/promoCodes", h.GetPromoCodes)
+	management.PATCH("/promoCodes/:id", h.UpdatePromoCode)
+	management.DELETE("/promoCodes/:id", h.DeletePromoCode)
+	management.POST("/subscriptionPlans", h.CreateSubscriptionPlan)
+	management.GET("/subscriptionPlans", h.GetAllSubscriptionPlans)
+	management.PATCH("/subscriptionPlans/:id", h.UpdateSubscriptionPlan)
+	management.POST("/bundles", h.CreateBundle)
+	management.GET("/bundles", h.GetAllBundles)
+	management.PATCH("/bundles/:id", h.UpdateBundle)
+	management.DELETE("/bundles/:id", h.DeleteBundle)
+	management.GET("/gifts", h.GetGifts)
+	management.POST("/gifts/:id/void", h.VoidGift)
+	management.DELETE("/removeAdmin", m.WithInteractiveSession(), h.DeleteAdmin)
+	management.PATCH("/changeRole", m.WithInteractiveSession(), h.ChangeRole)
 	management.GET("/getAdmins", h.FindAdmins)
+	management.POST("/apiKeys", h.CreateAdminApiKey)
+	management.GET("/apiKeys", h.GetAdminApiKeys)
+	management.DELETE("/apiKeys/:id", h.RevokeAdminApiKey)
+	management.GET("/emails", h.GetOutboxEmails)
+	management.POST("/emails/:id/resend", h.ResendOutboxEmail)
+	management.GET("/emailTemplates/:name", h.PreviewEmailTemplate)
 	management.GET("/courses", h.RetreiveCourses)
 	management.GET("/modules", h.RetreiveModules)
 	management.GET("/lessons", h.RetreiveLessons)
@@ -91,11 +133,23 @@ func RequestsRouter(h *handlers.Handlers, m *authmiddleware.Middleware) *gin.Eng
 	content.GET("/modules", h.RetreiveModules)
 	content.GET("/lessons", h.RetreiveLessons)
 
+	v1.POST("/billing/webhook", h.PaymentWebhook)
+
 	billing := v1.Group("billing")
 	billing.Use(m.WithCookieAuth())
 	billing.POST("/buyCourse", h.BuyCourse)
+	billing.GET("/coursePrices/:id", h.GetCoursePrices)
+	billing.GET("/bundles", h.GetBundles)
+	billing.POST("/checkout", h.Checkout)
+	billing.POST("/buyGift", h.BuyGift)
+	billing.GET("/gifts", h.GetUserGifts)
+	billing.POST("/gifts/redeem", h.RedeemGift)
 	billing.GET("/successPayment/:userData", h.CompletePurchase)
 	billing.GET("/failPayment/:userData", h.DeclineOrder)
+	billing.GET("/subscriptionPlans", h.GetSubscriptionPlans)
+	billing.POST("/subscribe", h.Subscribe)
+	billing.GET("/subscription", h.GetSubscription)
+	billing.POST("/subscription/cancel", h.CancelSubscription)
 
 	return router
 }
@@ -7,18 +7,26 @@ import (
 
 	"github.com/golang-jwt/jwt/v5"
 	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/services/lockout"
+	"github.com/knstch/course/internal/app/services/password"
+	"github.com/knstch/course/internal/app/services/token"
 	"github.com/knstch/course/internal/domain/dto"
 	"github.com/knstch/course/internal/domain/entity"
 )
 
+const (
+	adminTokenTTL = 3 * 24 * time.Hour
+	tokenIdLength = 16
+)
+
 // adminManager объединяет в себе методы администратора по взаимодействию с БД.
 type adminManager interface {
 	AddAdmin(ctx context.Context, login, password, role, key string) *courseError.CourseError
 	CheckIfAdminCanBeCreated(ctx context.Context, login string) *courseError.CourseError
 	Login(ctx context.Context, login, password, code string) (*uint, *string, *courseError.CourseError)
 	EnableTwoStepAuth(ctx context.Context, login, code string) *courseError.CourseError
-	StoreAdminAccessToken(ctx context.Context, id *uint, token *string) *courseError.CourseError
-	CheckAdminAccessToken(ctx context.Context, token *string) *courseError.CourseError
+	StoreAdminAccessToken(ctx context.Context, accessToken *dto.AdminAccessToken) *courseError.CourseError
+	CheckAdminAccessToken(ctx context.Context, tokenId string) *courseError.CourseError
 	DisableAdminToken(ctx context.Context, token *string) *courseError.CourseError
 	RemoveAdmin(ctx context.Context, login string) *courseError.CourseError
 	ChangeRole(ctx context.Context, login, role string) *courseError.CourseError
@@ -38,16 +46,20 @@ type Claims struct {
 	Role    string
 }
 
-// AdminService объединяет в себе методы администратора и ключ для шифрования и расшифровки JWT.
+// AdminService объединяет в себе методы администратора, набор ключей для подписи JWT, защиту от перебора и парольную политику.
 type AdminService struct {
-	adminManager adminManager
-	secret       string
+	adminManager   adminManager
+	keys           *token.KeySet
+	lockout        *lockout.Lockout
+	passwordPolicy *password.Policy
 }
 
 // NewAdminService билдер для сервиса администратров.
-func NewAdminService(storage adminManager, secret string) AdminService {
+func NewAdminService(storage adminManager, keys *token.KeySet, lockout *lockout.Lockout, passwordPolicy *password.Policy) AdminService {
 	return AdminService{
-		adminManager: storage,
-		secret:       secret,
+		adminManager:   storage,
+		keys:           keys,
+		lockout:        lockout,
+		passwordPolicy: passwordPolicy,
 	}
 }
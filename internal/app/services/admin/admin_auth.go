@@ -2,25 +2,34 @@ package admin
 
 import (
 	"context"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/services/lockout"
+	"github.com/knstch/course/internal/app/services/token"
 	"github.com/knstch/course/internal/app/validation"
+	"github.com/knstch/course/internal/domain/dto"
 	"github.com/knstch/course/internal/domain/entity"
 	"github.com/pquerna/otp/totp"
 	qrcode "github.com/skip2/go-qrcode"
 )
 
 // RegisterAdmin используется для регистрации нового админа, принимает в качестве параметра
-// логин и пароль нового администратора, валидирует их, создает ключ для Google Authentificator,
-// генерирует QR-код для добавления ключа в приложение, и возвращает QR-код в виде массива байт и ошибку.
+// логин и пароль нового администратора, валидирует их, проверяет пароль по парольной политике, создает ключ для
+// Google Authentificator, генерирует QR-код для добавления ключа в приложение, и возвращает QR-код в виде массива байт и ошибку.
 func (admin AdminService) RegisterAdmin(ctx context.Context, credentials *entity.AdminCredentials) ([]byte, *courseError.CourseError) {
 	if err := validation.NewAdminCredentialsToValidate(credentials).Validate(ctx); err != nil {
 		return nil, err
 	}
 
+	if err := admin.passwordPolicy.Check(credentials.Password); err != nil {
+		return nil, err
+	}
+
 	if err := admin.adminManager.CheckIfAdminCanBeCreated(ctx, credentials.Login); err != nil {
 		return nil, err
 	}
@@ -80,9 +89,10 @@ func (admin *AdminService) ApproveTwoStepAuth(ctx context.Context, login, passwo
 	return nil
 }
 
-// SignIn используется для логина администраторов, принимает логин, пароль, и код из Autherntificator.
-// Метод валидирует параметры, и если они валидны, возвращает подписанный JWT.
-func (admin AdminService) SignIn(ctx context.Context, login, password, code string) (*string, *courseError.CourseError) {
+// SignIn используется для логина администраторов, принимает логин, пароль, код из Autherntificator и IP.
+// Метод валидирует параметры, и если они валидны, возвращает подписанный JWT. Неудачные попытки учитываются
+// в защите от перебора по логину и IP, после превышения лимита вход временно блокируется.
+func (admin AdminService) SignIn(ctx context.Context, login, password, code, ip string) (*string, *courseError.CourseError) {
 	signInCredentials := entity.Credentials{
 		Email:    login,
 		Password: password,
@@ -92,34 +102,54 @@ func (admin AdminService) SignIn(ctx context.Context, login, password, code stri
 		return nil, err
 	}
 
+	if err := admin.lockout.Check(lockout.ScopeAdminLogin, login, ip); err != nil {
+		return nil, err
+	}
+
 	id, role, err := admin.adminManager.Login(ctx, login, password, code)
+	if err != nil {
+		if err.Code == 16002 || err.Code == 16003 || err.Code == 16052 {
+			if err := admin.lockout.RegisterFailure(lockout.ScopeAdminLogin, login, ip); err != nil {
+				return nil, err
+			}
+		}
+		return nil, err
+	}
+
+	if err := admin.lockout.Reset(lockout.ScopeAdminLogin, login); err != nil {
+		return nil, err
+	}
+
+	tokenId, err := generateTokenId()
 	if err != nil {
 		return nil, err
 	}
 
-	token, err := admin.mintJWT(id, role)
+	expiresAt := time.Now().Add(adminTokenTTL)
+
+	accessToken, err := admin.mintJWT(id, role, tokenId, expiresAt)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := admin.adminManager.StoreAdminAccessToken(ctx, id, token); err != nil {
+	if err := admin.adminManager.StoreAdminAccessToken(ctx, dto.CreateNewAdminAccessToken(*id, *accessToken, tokenId, expiresAt)); err != nil {
 		return nil, err
 	}
 
-	return token, nil
+	return accessToken, nil
 }
 
-// mintJWT используется для минта JWT, принимает ID администратора и его роль, возвращает токен или ошибку.
-func (admin AdminService) mintJWT(id *uint, role *string) (*string, *courseError.CourseError) {
-	timeNow := time.Now()
-	authToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"Iat":     timeNow.Unix(),
-		"Exp":     timeNow.Add(3 * 24 * time.Hour).Unix(),
+// mintJWT используется для минта JWT, принимает ID администратора, его роль, ID токена
+// и время истечения токена, возвращает токен или ошибку.
+func (admin AdminService) mintJWT(id *uint, role *string, tokenId string, expiresAt time.Time) (*string, *courseError.CourseError) {
+	signedAuthToken, err := admin.keys.Sign(jwt.MapClaims{
+		"jti":     tokenId,
+		"aud":     token.AdminAudience,
+		"iat":     time.Now().Unix(),
+		"exp":     expiresAt.Unix(),
 		"AdminId": *id,
 		"Role":    *role,
 	})
-
-	signedAuthToken, err := authToken.SignedString([]byte(admin.secret))
 	if err != nil {
 		return nil, courseError.CreateError(err, 11010)
 	}
@@ -127,13 +157,28 @@ func (admin AdminService) mintJWT(id *uint, role *string) (*string, *courseError
 	return &signedAuthToken, nil
 }
 
+// generateTokenId генерирует случайный ID токена, по которому токен проверяется в denylist.
+func generateTokenId() (string, *courseError.CourseError) {
+	buf := make([]byte, tokenIdLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", courseError.CreateError(err, 11010)
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
 // ManageAdminPassword используется для изменения пароля администратора. Принимает логин
-// и новый пароль администратора, валидирует их, и изменяет пароль в БД. Возвращает ошибку.
+// и новый пароль администратора, валидирует их, проверяет пароль по парольной политике и изменяет пароль в БД.
+// Возвращает ошибку.
 func (admin AdminService) ManageAdminPassword(ctx context.Context, credentials *entity.AdminCredentials) *courseError.CourseError {
 	if err := validation.NewAdminCredentialsToValidate(credentials).Validate(ctx); err != nil {
 		return err
 	}
 
+	if err := admin.passwordPolicy.Check(credentials.Password); err != nil {
+		return err
+	}
+
 	if err := admin.adminManager.ResetAdminPassword(ctx, credentials.Login, credentials.Password); err != nil {
 		return err
 	}
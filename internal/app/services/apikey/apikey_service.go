@@ -0,0 +1,207 @@
+// apikey содержит методы для работы с персональными API ключами пользователей и администраторов.
+package apikey
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/validation"
+	"github.com/knstch/course/internal/domain/dto"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+const (
+	// ScopeRead разрешает запросы, которые не меняют данные.
+	ScopeRead = "read"
+	// ScopeWrite разрешает запросы, которые меняют данные.
+	ScopeWrite = "write"
+
+	// KeyPrefix добавляется в начало каждого ключа, чтобы отличать ключи от JWT и находить их в логах и репозиториях.
+	KeyPrefix = "crs_"
+
+	keyLength       = 32
+	displayedLength = 8
+)
+
+var (
+	ErrBadApiKey          = errors.New("API ключ не найден или отозван")
+	ErrNotEnoughScopes    = errors.New("у API ключа нет прав на этот запрос")
+	ErrManagingWithApiKey = errors.New("управлять API ключами можно только после входа в аккаунт")
+	ErrInteractiveOnly    = errors.New("действие доступно только после входа в аккаунт, а не по API ключу")
+)
+
+// apiKeyManager содержит методы для работы с API ключами в БД.
+type apiKeyManager interface {
+	StoreApiKey(ctx context.Context, apiKey *dto.ApiKey) *courseError.CourseError
+	GetUserApiKeys(ctx context.Context, userId uint) ([]dto.ApiKey, *courseError.CourseError)
+	GetAdminApiKeys(ctx context.Context, adminId uint) ([]dto.ApiKey, *courseError.CourseError)
+	RevokeUserApiKey(ctx context.Context, userId, keyId uint) *courseError.CourseError
+	RevokeAdminApiKey(ctx context.Context, adminId, keyId uint) *courseError.CourseError
+	UseApiKey(ctx context.Context, hashedKey string) (*entity.ApiKeyOwner, *courseError.CourseError)
+}
+
+// ApiKeyService используется для выпуска, отзыва и проверки API ключей.
+type ApiKeyService struct {
+	manager apiKeyManager
+}
+
+// NewApiKeyService - это билдер для ApiKeyService.
+func NewApiKeyService(manager apiKeyManager) ApiKeyService {
+	return ApiKeyService{
+		manager: manager,
+	}
+}
+
+// CreateUserApiKey используется для выпуска API ключа пользователя. Принимает название и права ключа, валидирует их,
+// генерирует ключ и сохраняет в БД его хэш. Ключ возвращается только один раз, восстановить его потом нельзя.
+// Возвращает ключ или ошибку.
+func (a ApiKeyService) CreateUserApiKey(ctx context.Context, apiKey *entity.ApiKeyToCreate) (*entity.CreatedApiKey, *courseError.CourseError) {
+	if ctx.Value("ApiKeyId") != nil {
+		return nil, courseError.CreateError(ErrManagingWithApiKey, 11303)
+	}
+
+	return a.createApiKey(ctx, apiKey, func(key *dto.ApiKey) *dto.ApiKey {
+		return key.AddUserId(ctx.Value("UserId").(uint))
+	})
+}
+
+// CreateAdminApiKey используется для выпуска API ключа администратора. Права ключа ограничены ролью администратора,
+// которая проверяется при каждом запросе. Возвращает ключ или ошибку.
+func (a ApiKeyService) CreateAdminApiKey(ctx context.Context, apiKey *entity.ApiKeyToCreate) (*entity.CreatedApiKey, *courseError.CourseError) {
+	if ctx.Value("ApiKeyId") != nil {
+		return nil, courseError.CreateError(ErrManagingWithApiKey, 11303)
+	}
+
+	return a.createApiKey(ctx, apiKey, func(key *dto.ApiKey) *dto.ApiKey {
+		return key.AddAdminId(ctx.Value("AdminId").(uint))
+	})
+}
+
+func (a ApiKeyService) createApiKey(ctx context.Context, apiKey *entity.ApiKeyToCreate,
+	addOwner func(key *dto.ApiKey) *dto.ApiKey) (*entity.CreatedApiKey, *courseError.CourseError) {
+	if err := validation.NewApiKeyToValidate(apiKey).Validate(ctx); err != nil {
+		return nil, err
+	}
+
+	key, err := generateKey()
+	if err != nil {
+		return nil, err
+	}
+
+	scopes := uniqueScopes(apiKey.Scopes)
+
+	newApiKey := addOwner(dto.CreateNewApiKey(apiKey.Name, key[:len(KeyPrefix)+displayedLength], hashKey(key), strings.Join(scopes, ",")))
+	if err := a.manager.StoreApiKey(ctx, newApiKey); err != nil {
+		return nil, err
+	}
+
+	return entity.CreateCreatedApiKey(newApiKey.ID, newApiKey.Name, key, scopes), nil
+}
+
+// RetreiveUserApiKeys используется для получения неотозванных API ключей пользователя. Возвращает ключи без секрета или ошибку.
+func (a ApiKeyService) RetreiveUserApiKeys(ctx context.Context) ([]entity.ApiKey, *courseError.CourseError) {
+	apiKeys, err := a.manager.GetUserApiKeys(ctx, ctx.Value("UserId").(uint))
+	if err != nil {
+		return nil, err
+	}
+
+	return entity.CreateApiKeys(apiKeys), nil
+}
+
+// RetreiveAdminApiKeys используется для получения неотозванных API ключей администратора. Возвращает ключи без секрета или ошибку.
+func (a ApiKeyService) RetreiveAdminApiKeys(ctx context.Context) ([]entity.ApiKey, *courseError.CourseError) {
+	apiKeys, err := a.manager.GetAdminApiKeys(ctx, ctx.Value("AdminId").(uint))
+	if err != nil {
+		return nil, err
+	}
+
+	return entity.CreateApiKeys(apiKeys), nil
+}
+
+// RevokeUserApiKey используется для отзыва API ключа пользователя. Принимает ID ключа, валидирует его и отзывает ключ.
+// Возвращает ошибку.
+func (a ApiKeyService) RevokeUserApiKey(ctx context.Context, keyId string) *courseError.CourseError {
+	if err := validation.NewStringIdToValidate(keyId).Validate(ctx); err != nil {
+		return err
+	}
+
+	id, _ := strconv.Atoi(keyId)
+
+	return a.manager.RevokeUserApiKey(ctx, ctx.Value("UserId").(uint), uint(id))
+}
+
+// RevokeAdminApiKey используется для отзыва API ключа администратора. Принимает ID ключа, валидирует его и отзывает ключ.
+// Возвращает ошибку.
+func (a ApiKeyService) RevokeAdminApiKey(ctx context.Context, keyId string) *courseError.CourseError {
+	if err := validation.NewStringIdToValidate(keyId).Validate(ctx); err != nil {
+		return err
+	}
+
+	id, _ := strconv.Atoi(keyId)
+
+	return a.manager.RevokeAdminApiKey(ctx, ctx.Value("AdminId").(uint), uint(id))
+}
+
+// Authenticate используется в middleware для входа по API ключу. Принимает ключ и HTTP метод запроса,
+// находит ключ по хэшу и проверяет, что у него есть права на запрос: для чтения нужен read, для остальных
+// запросов write. Возвращает владельца ключа или ошибку.
+func (a ApiKeyService) Authenticate(ctx context.Context, key, method string) (*entity.ApiKeyOwner, *courseError.CourseError) {
+	if !strings.HasPrefix(key, KeyPrefix) {
+		return nil, courseError.CreateError(ErrBadApiKey, 11301)
+	}
+
+	owner, err := a.manager.UseApiKey(ctx, hashKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	requiredScope := ScopeWrite
+	if method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions {
+		requiredScope = ScopeRead
+	}
+
+	for _, v := range owner.Scopes {
+		if v == requiredScope {
+			return owner, nil
+		}
+	}
+
+	return nil, courseError.CreateError(ErrNotEnoughScopes, 11302)
+}
+
+func generateKey() (string, *courseError.CourseError) {
+	bytes := make([]byte, keyLength)
+	if _, err := rand.Read(bytes); err != nil {
+		return "", courseError.CreateError(err, 11010)
+	}
+
+	return KeyPrefix + hex.EncodeToString(bytes), nil
+}
+
+// hashKey возвращает SHA-256 хэш ключа. Ключ содержит достаточно случайных бит, поэтому медленный хэш не нужен,
+// а детерминированный хэш позволяет найти ключ одним запросом.
+func hashKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func uniqueScopes(scopes []string) []string {
+	result := make([]string, 0, len(scopes))
+	for _, v := range []string{ScopeRead, ScopeWrite} {
+		for _, scope := range scopes {
+			if scope == v {
+				result = append(result, v)
+				break
+			}
+		}
+	}
+
+	return result
+}
@@ -3,6 +3,9 @@ package auth
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"time"
@@ -12,28 +15,61 @@ import (
 	"github.com/knstch/course/internal/app/config"
 	courseError "github.com/knstch/course/internal/app/course_error"
 	"github.com/knstch/course/internal/app/services/email"
+	"github.com/knstch/course/internal/app/services/lockout"
+	"github.com/knstch/course/internal/app/services/oidc"
+	"github.com/knstch/course/internal/app/services/password"
+	"github.com/knstch/course/internal/app/services/token"
 	"github.com/knstch/course/internal/app/validation"
+	"github.com/knstch/course/internal/domain/dto"
 	"github.com/knstch/course/internal/domain/entity"
 )
 
+const (
+	refreshTokenLength = 32
+	sessionIdLength    = 16
+	tokenIdLength      = 16
+)
+
 // authentificater содержит методы аутентификации для работы с БД.
 type authentificater interface {
-	RegisterUser(ctx context.Context, email, password string) (*uint, *courseError.CourseError)
-	StoreToken(ctx context.Context, token *string, id *uint) *courseError.CourseError
+	RegisterUser(ctx context.Context, email, password, locale string, confirmEmail *dto.OutboxEmail) (*uint, *courseError.CourseError)
+	StoreToken(ctx context.Context, accessToken *dto.AccessToken) *courseError.CourseError
+	ConsumeRefreshToken(ctx context.Context, refreshToken string) (*dto.AccessToken, *bool, *courseError.CourseError)
 	SignIn(ctx context.Context, email, password string) (*uint, *bool, *courseError.CourseError)
-	VerifyEmail(ctx context.Context, userId uint, isEdit bool) *courseError.CourseError
+	VerifyEmail(ctx context.Context, userId uint, isEdit bool, welcomeEmail *dto.OutboxEmail) *courseError.CourseError
 	DisableTokens(ctx context.Context, userId uint) *courseError.CourseError
 	RecoverPassword(ctx context.Context, email, password string) *courseError.CourseError
+	RetreiveUserData(ctx context.Context) (*entity.UserData, *courseError.CourseError)
+	SetUserTotpKey(ctx context.Context, userId uint, key string) *courseError.CourseError
+	EnableUserTwoStepAuth(ctx context.Context, userId uint, code string, recoveryCodes []string) *courseError.CourseError
+	IsTwoStepAuthEnabled(ctx context.Context, userId uint) (*bool, *courseError.CourseError)
+	CheckTwoStepAuthCode(ctx context.Context, userId uint, code, hashedCode string) *courseError.CourseError
+	DisableUserTwoStepAuth(ctx context.Context, userId uint) *courseError.CourseError
+	SignInWithExternalIdentity(ctx context.Context, provider, subject, email string, emailVerified bool) (*uint, *bool, *courseError.CourseError)
+	SignInByEmail(ctx context.Context, email string) (*uint, *bool, *courseError.CourseError)
 }
 
 // AuthService объединяет в себе методы для работы с аутентификацией.
-// Содержит в себе redis клиент, email сервис, ключ для подписи
-// и декодированию токена и интерфей для взаимодействия с БД.
+// Содержит в себе redis клиент, email сервис, набор ключей для подписи
+// токенов, защиту от перебора, провайдеров социального логина, парольную политику и интерфей для взаимодействия с БД.
 type AuthService struct {
 	authentificater authentificater
-	secret          string
+	keys            *token.KeySet
 	redis           *redis.Client
 	emailService    *email.EmailService
+	lockout         *lockout.Lockout
+	providers       map[string]*oidc.Provider
+	passwordPolicy  *password.Policy
+	magicLinkUrl    string
+	accessTokenTTL  time.Duration
+	refreshTokenTTL time.Duration
+}
+
+// Tokens содержит пару токенов, которые выдаются пользователю при входе:
+// короткоживущий JWT для доступа и refresh токен для его обновления.
+type Tokens struct {
+	AccessToken  string
+	RefreshToken string
 }
 
 // Claims содержит в себе поля, которые хранятся в JWT.
@@ -52,71 +88,101 @@ var (
 )
 
 // NewAuthService - это билдер для сервиса аутентификации.
-func NewAuthService(authentificater authentificater, config *config.Config, client *redis.Client, emailService *email.EmailService) AuthService {
+func NewAuthService(authentificater authentificater, config *config.Config, keys *token.KeySet, client *redis.Client,
+	emailService *email.EmailService, lockout *lockout.Lockout, providers map[string]*oidc.Provider, passwordPolicy *password.Policy) AuthService {
 	return AuthService{
 		authentificater: authentificater,
-		secret:          config.Secret,
+		keys:            keys,
 		redis:           client,
 		emailService:    emailService,
+		lockout:         lockout,
+		providers:       providers,
+		passwordPolicy:  passwordPolicy,
+		magicLinkUrl:    config.MagicLinkUrl,
+		accessTokenTTL:  config.AccessTokenTTL,
+		refreshTokenTTL: config.RefreshTokenTTL,
 	}
 }
 
 // Register используется для регистрации нового пользователя. Принимает в качестве
-// параметра логин + пароль, валидирует их, регистрирует пользователя, минтит JWT и
-// отправляет код подтверждения на почту пользователя. Возвращает JWT и ошибку.
-func (auth AuthService) Register(ctx context.Context, credentials *entity.Credentials) (*string, *courseError.CourseError) {
+// параметра логин + пароль, язык писем и устройство пользователя, валидирует их, проверяет пароль по парольной политике, регистрирует
+// пользователя вместе с письмом с кодом подтверждения в outbox и выпускает пару токенов. Возвращает токены и ошибку.
+func (auth AuthService) Register(ctx context.Context, credentials *entity.Credentials, device *entity.Device) (*Tokens, *courseError.CourseError) {
 	if err := validation.NewCredentialsToValidate(credentials).Validate(ctx); err != nil {
 		return nil, err
 	}
 
-	if err := auth.emailService.ValidateEmail(credentials.Email); err != nil {
+	if err := auth.passwordPolicy.Check(credentials.Password); err != nil {
 		return nil, err
 	}
 
-	userId, err := auth.authentificater.RegisterUser(ctx, credentials.Email, credentials.Password)
+	if err := auth.emailService.ValidateEmail(ctx, credentials.Email); err != nil {
+		return nil, err
+	}
+
+	confirmEmail, confirmCode, err := auth.emailService.PrepareConfirmCode(credentials.Email, email.ConfirmEmail, credentials.Locale)
 	if err != nil {
 		return nil, err
 	}
 
-	token, err := auth.mintJWT(*userId, false)
+	userId, err := auth.authentificater.RegisterUser(ctx, credentials.Email, credentials.Password, credentials.Locale, confirmEmail)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := auth.authentificater.StoreToken(ctx, token, userId); err != nil {
+	if err := auth.emailService.StoreConfirmCode(*userId, credentials.Email, confirmCode); err != nil {
 		return nil, err
 	}
 
-	if err := auth.emailService.SendConfirmCode(userId, &credentials.Email, email.ConfirmEmail); err != nil {
+	tokens, err := auth.issueTokens(ctx, *userId, false, device, nil)
+	if err != nil {
 		return nil, err
 	}
 
-	return token, nil
+	return tokens, nil
 }
 
-// VerifyEmail используется для верификации почты. Принимает код и ID пользователя в качестве параметров.
+// VerifyEmail используется для верификации почты. Принимает код, ID пользователя и устройство в качестве параметров.
 // Далее валидируется код, проверяется наличия кода по ID в Redis, если код не совпал, то возвращается ошибка.
-// После этого запись удаляется из Redis, пользователь получает статус verified и новый JWT.
+// После этого запись удаляется из Redis, пользователь получает статус verified и новую пару токенов.
+// При первом подтверждении почты пользователю отправляется приветственное письмо на его языке.
 // Метод также используется при смене почты, поэтому все другие токены пользователя будут отключены.
-func (auth AuthService) VerifyEmail(ctx context.Context, code string, userId uint) (*string, *courseError.CourseError) {
+// Неверные коды учитываются в защите от перебора, после превышения лимита попытки временно блокируются.
+func (auth AuthService) VerifyEmail(ctx context.Context, code string, userId uint, device *entity.Device) (*Tokens, *courseError.CourseError) {
 	if err := validation.NewConfirmCodeToValidate(code).Validate(ctx); err != nil {
 		return nil, err
 	}
 
+	if err := auth.lockout.Check(lockout.ScopeEmailVerification, fmt.Sprint(userId), device.Ip); err != nil {
+		return nil, err
+	}
+
 	codeFromRedis, err := auth.redis.Get(fmt.Sprint(userId)).Result()
 	if err != nil {
 		return nil, courseError.CreateError(ErrConfirmCodeNotFound, 11004)
 	}
 
 	if code != codeFromRedis {
+		if err := auth.lockout.RegisterFailure(lockout.ScopeEmailVerification, fmt.Sprint(userId), device.Ip); err != nil {
+			return nil, err
+		}
 		return nil, courseError.CreateError(ErrBadConfirmCode, 11003)
 	}
 
+	welcomeEmail, welcomeErr := auth.emailService.PrepareWelcome(ctx, userId)
+	if welcomeErr != nil {
+		return nil, welcomeErr
+	}
+
+	if err := auth.lockout.Reset(lockout.ScopeEmailVerification, fmt.Sprint(userId)); err != nil {
+		return nil, err
+	}
+
 	if err := auth.redis.Del(fmt.Sprint(userId)).Err(); err != nil {
 		return nil, courseError.CreateError(err, 10033)
 	}
 
-	verificationErr := auth.authentificater.VerifyEmail(ctx, userId, false)
+	verificationErr := auth.authentificater.VerifyEmail(ctx, userId, false, welcomeEmail)
 	if verificationErr != nil {
 		return nil, verificationErr
 	}
@@ -125,16 +191,12 @@ func (auth AuthService) VerifyEmail(ctx context.Context, code string, userId uin
 		return nil, err
 	}
 
-	token, mintError := auth.mintJWT(userId, true)
-	if mintError != nil {
-		return nil, courseError.CreateError(mintError.Error, 11010)
+	tokens, issueErr := auth.issueTokens(ctx, userId, true, device, nil)
+	if issueErr != nil {
+		return nil, issueErr
 	}
 
-	if err := auth.authentificater.StoreToken(ctx, token, &userId); err != nil {
-		return nil, err
-	}
-
-	return token, nil
+	return tokens, nil
 }
 
 // SendNewCofirmationCode используется для отправки нового кода на почту пользователя.
@@ -160,24 +222,26 @@ func (auth AuthService) SendNewCofirmationCode(ctx context.Context, userEmail st
 		}
 	}
 
-	if err := auth.emailService.SendConfirmCode(&userId, &userEmail, email.ConfirmEmail); err != nil {
+	if err := auth.emailService.SendConfirmCode(ctx, &userId, &userEmail, email.ConfirmEmail); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// mintJWT используется для минта нового токена доступа для пользователя. Возвращает токен и ошибку.
-func (auth AuthService) mintJWT(id uint, verified bool) (*string, *courseError.CourseError) {
-	timeNow := time.Now()
-	authToken := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.MapClaims{
-		"Iat":      timeNow.Unix(),
-		"Exp":      timeNow.Add(30 * 24 * time.Hour).Unix(),
-		"UserId":   id,
-		"Verified": verified,
+// mintJWT используется для минта нового токена доступа для пользователя. Время жизни токена
+// задается в конфиге и проверяется при декодировании. В токен также записываются ID токена, по которому
+// он проверяется в denylist, и ID сессии. Возвращает токен и ошибку.
+func (auth AuthService) mintJWT(id uint, verified bool, sessionId, tokenId string, expiresAt time.Time) (*string, *courseError.CourseError) {
+	signedAuthToken, err := auth.keys.Sign(jwt.MapClaims{
+		"jti":       tokenId,
+		"aud":       token.UserAudience,
+		"iat":       time.Now().Unix(),
+		"exp":       expiresAt.Unix(),
+		"UserId":    id,
+		"Verified":  verified,
+		"SessionId": sessionId,
 	})
-
-	signedAuthToken, err := authToken.SignedString([]byte(auth.secret))
 	if err != nil {
 		return nil, courseError.CreateError(err, 11010)
 	}
@@ -185,29 +249,153 @@ func (auth AuthService) mintJWT(id uint, verified bool) (*string, *courseError.C
 	return &signedAuthToken, nil
 }
 
-// LogIn метод логина, принимает в качестве параметра пару логин + пароль, валидирует их,
-// обращается в БД и проверяет валидность. Далее создает новый токен для пользователя и сохраняет его в БД.
-// Возвращает JWT или ошибку.
-func (auth AuthService) LogIn(ctx context.Context, credentials *entity.Credentials) (*string, *courseError.CourseError) {
-	if err := validation.NewSignInCredentials(credentials).Validate(ctx); err != nil {
+// issueTokens используется для выпуска новой пары токенов. Принимает ID пользователя, статус верификации,
+// устройство и предыдущую запись сессии. Если предыдущая запись не передана, то создается новая сессия,
+// иначе ID и время начала сессии переносятся из нее. Минтит JWT, генерирует refresh токен
+// и сохраняет их в БД, при этом refresh токен хранится в виде хэша. Возвращает токены или ошибку.
+func (auth AuthService) issueTokens(ctx context.Context, userId uint, verified bool, device *entity.Device,
+	previous *dto.AccessToken) (*Tokens, *courseError.CourseError) {
+	timeNow := time.Now()
+
+	var sessionId string
+	sessionStartedAt := timeNow
+	if previous != nil {
+		sessionId = previous.SessionId
+		sessionStartedAt = previous.SessionStartedAt
+	} else {
+		newSessionId, err := generateRandomString(sessionIdLength)
+		if err != nil {
+			return nil, err
+		}
+		sessionId = newSessionId
+	}
+
+	tokenId, err := generateRandomString(tokenIdLength)
+	if err != nil {
 		return nil, err
 	}
 
-	userId, verified, err := auth.authentificater.SignIn(ctx, credentials.Email, credentials.Password)
+	expiresAt := timeNow.Add(auth.accessTokenTTL)
+
+	accessToken, err := auth.mintJWT(userId, verified, sessionId, tokenId, expiresAt)
 	if err != nil {
 		return nil, err
 	}
 
-	token, err := auth.mintJWT(*userId, *verified)
+	refreshToken, err := generateRandomString(refreshTokenLength)
 	if err != nil {
 		return nil, err
 	}
 
-	if err := auth.authentificater.StoreToken(ctx, token, userId); err != nil {
+	session := dto.CreateNewAccessToken().
+		AddToken(accessToken).
+		AddTokenId(tokenId).
+		AddExpiration(expiresAt).
+		AddUsedId(&userId).
+		AddRefreshToken(hashToken(refreshToken)).
+		AddRefreshExpiration(timeNow.Add(auth.refreshTokenTTL)).
+		AddSessionId(sessionId).
+		AddSessionStart(sessionStartedAt).
+		AddLastSeen(timeNow).
+		AddDevice(device.UserAgent, device.Ip).
+		SetStatusAvailable()
+
+	if err := auth.authentificater.StoreToken(ctx, session); err != nil {
+		return nil, err
+	}
+
+	return &Tokens{
+		AccessToken:  *accessToken,
+		RefreshToken: refreshToken,
+	}, nil
+}
+
+// RefreshTokens используется для обновления токенов. Принимает refresh токен и устройство, помечает токен как использованный
+// и выпускает новую пару токенов в той же сессии. Если refresh токен уже был использован ранее,
+// то все токены сессии отключаются. Возвращает новые токены или ошибку.
+func (auth AuthService) RefreshTokens(ctx context.Context, refreshToken string, device *entity.Device) (*Tokens, *courseError.CourseError) {
+	session, verified, err := auth.authentificater.ConsumeRefreshToken(ctx, hashToken(refreshToken))
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := auth.issueTokens(ctx, session.UserId, *verified, device, session)
+	if err != nil {
 		return nil, err
 	}
 
-	return token, nil
+	return tokens, nil
+}
+
+// generateRandomString генерирует криптостойкую случайную строку из переданного количества байт в hex.
+func generateRandomString(length int) (string, *courseError.CourseError) {
+	buf := make([]byte, length)
+	if _, err := rand.Read(buf); err != nil {
+		return "", courseError.CreateError(err, 11010)
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// hashToken возвращает sha256 хэш refresh токена или кода восстановления, в таком виде они хранятся в БД.
+func hashToken(value string) string {
+	hash := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(hash[:])
+}
+
+// LogIn метод логина, принимает в качестве параметра пару логин + пароль и устройство пользователя, валидирует их,
+// обращается в БД и проверяет валидность. Неверные пароли учитываются в защите от перебора по почте и IP,
+// после превышения лимита вход временно блокируется. Если у пользователя включена двухфакторная аутентификация, то токены не выпускаются,
+// а возвращается челлендж, по которому вход завершается через LogInSecondStep. Иначе выпускает новую пару токенов
+// для пользователя и сохраняет ее в БД. Возвращает токены, челлендж или ошибку.
+func (auth AuthService) LogIn(ctx context.Context, credentials *entity.Credentials, device *entity.Device) (*Tokens, *string, *courseError.CourseError) {
+	if err := validation.NewSignInCredentials(credentials).Validate(ctx); err != nil {
+		return nil, nil, err
+	}
+
+	if err := auth.lockout.Check(lockout.ScopeLogin, credentials.Email, device.Ip); err != nil {
+		return nil, nil, err
+	}
+
+	userId, verified, err := auth.authentificater.SignIn(ctx, credentials.Email, credentials.Password)
+	if err != nil {
+		if err.Code == 11002 {
+			if err := auth.lockout.RegisterFailure(lockout.ScopeLogin, credentials.Email, device.Ip); err != nil {
+				return nil, nil, err
+			}
+		}
+		return nil, nil, err
+	}
+
+	if err := auth.lockout.Reset(lockout.ScopeLogin, credentials.Email); err != nil {
+		return nil, nil, err
+	}
+
+	return auth.completeLogIn(ctx, *userId, *verified, device)
+}
+
+// completeLogIn завершает вход пользователя, который подтвердил свою личность. Если у пользователя включена
+// двухфакторная аутентификация, то возвращает челлендж для второго шага, иначе выпускает новую пару токенов.
+func (auth AuthService) completeLogIn(ctx context.Context, userId uint, verified bool, device *entity.Device) (*Tokens, *string, *courseError.CourseError) {
+	twoStepAuthEnabled, err := auth.authentificater.IsTwoStepAuthEnabled(ctx, userId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if *twoStepAuthEnabled {
+		challenge, err := auth.createTwoStepAuthChallenge(userId, verified)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, challenge, nil
+	}
+
+	tokens, err := auth.issueTokens(ctx, userId, verified, device, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return tokens, nil, nil
 }
 
 // SendPasswordRecoverRequest используется для отправки кода для восстановления пароля на email
@@ -217,30 +405,45 @@ func (auth AuthService) SendPasswordRecoverRequest(ctx context.Context, email st
 		return err
 	}
 
-	if err := auth.emailService.SendPasswordRecoverConfirmCode(email); err != nil {
+	if err := auth.emailService.SendPasswordRecoverConfirmCode(ctx, email); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// RecoverPassword используется для восстановления пароля. В качестве параметра принимает код, почту и пароль.
-// Далее валидирует их, проверяет наличие кода в Redis по ключу email, если запись найдена, удаляет ее,
-// и меняет пароль в БД. Возвращает ошибку.
-func (auth AuthService) RecoverPassword(ctx context.Context, passwordRecover entity.PasswordRecoverCredentials) *courseError.CourseError {
+// RecoverPassword используется для восстановления пароля. В качестве параметра принимает код, почту, пароль и IP пользователя.
+// Далее валидирует их, проверяет пароль по парольной политике и наличие кода в Redis по ключу email, если запись найдена,
+// удаляет ее и меняет пароль в БД. Неверные коды учитываются в защите от перебора. Возвращает ошибку.
+func (auth AuthService) RecoverPassword(ctx context.Context, passwordRecover entity.PasswordRecoverCredentials, ip string) *courseError.CourseError {
 	if err := validation.NewPasswordRecoverCredentialsToValidate(passwordRecover).Validate(ctx); err != nil {
 		return err
 	}
 
+	if err := auth.passwordPolicy.Check(passwordRecover.Password); err != nil {
+		return err
+	}
+
+	if err := auth.lockout.Check(lockout.ScopePasswordRecovery, passwordRecover.Email, ip); err != nil {
+		return err
+	}
+
 	codeFromRedis, err := auth.redis.Get(passwordRecover.Email).Result()
 	if err != nil {
 		return courseError.CreateError(ErrConfirmCodeNotFound, 11004)
 	}
 
 	if fmt.Sprint(passwordRecover.Code) != codeFromRedis {
+		if err := auth.lockout.RegisterFailure(lockout.ScopePasswordRecovery, passwordRecover.Email, ip); err != nil {
+			return err
+		}
 		return courseError.CreateError(ErrBadConfirmCode, 11003)
 	}
 
+	if err := auth.lockout.Reset(lockout.ScopePasswordRecovery, passwordRecover.Email); err != nil {
+		return err
+	}
+
 	if err := auth.redis.Del(passwordRecover.Email).Err(); err != nil {
 		return courseError.CreateError(err, 10033)
 	}
@@ -0,0 +1,95 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/services/token"
+	"github.com/knstch/course/internal/app/validation"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+const magicLinkTTL = 15 * time.Minute
+
+var (
+	ErrBadMagicLink = errors.New("ссылка для входа недействительна или уже использована")
+)
+
+// MagicLinkClaims содержит в себе поля, которые хранятся в токене ссылки для входа.
+type MagicLinkClaims struct {
+	jwt.RegisteredClaims
+	Email string
+}
+
+// SendLoginLink используется для входа без пароля. Принимает почту, валидирует ее, минтит подписанный токен
+// со временем жизни 15 минут, запоминает его ID в Redis и отправляет ссылку с токеном на почту.
+// Если пользователя с такой почтой нет, то он будет создан при переходе по ссылке. Возвращает ошибку.
+func (auth AuthService) SendLoginLink(ctx context.Context, email string) *courseError.CourseError {
+	if err := validation.NewEmailToValidate(email).Validate(ctx); err != nil {
+		return err
+	}
+
+	tokenId, err := generateRandomString(tokenIdLength)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	signedToken, signErr := auth.keys.Sign(MagicLinkClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenId,
+			Audience:  jwt.ClaimStrings{token.MagicLinkAudience},
+			IssuedAt:  jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(magicLinkTTL)),
+		},
+		Email: email,
+	})
+	if signErr != nil {
+		return courseError.CreateError(signErr, 11010)
+	}
+
+	if err := auth.redis.Set(magicLinkKey(tokenId), email, magicLinkTTL).Err(); err != nil {
+		return courseError.CreateError(err, 10031)
+	}
+
+	if err := auth.emailService.SendLoginLink(ctx, email, auth.magicLinkUrl+"?token="+url.QueryEscape(signedToken)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// LogInByLink используется для входа по ссылке из письма. Принимает токен из ссылки и устройство пользователя,
+// проверяет подпись и срок жизни токена и удаляет его ID из Redis, поэтому ссылкой можно воспользоваться только один раз.
+// Находит пользователя по почте или создает нового с подтвержденной почтой и завершает вход так же, как LogIn.
+// Возвращает токены, челлендж двухфакторной аутентификации или ошибку.
+func (auth AuthService) LogInByLink(ctx context.Context, link *entity.MagicLinkToken, device *entity.Device) (*Tokens, *string, *courseError.CourseError) {
+	claims := &MagicLinkClaims{}
+	if err := auth.keys.Verify(link.Token, claims, token.MagicLinkAudience); err != nil || claims.ID == "" {
+		return nil, nil, courseError.CreateError(ErrBadMagicLink, 11021)
+	}
+
+	deleted, err := auth.redis.Del(magicLinkKey(claims.ID)).Result()
+	if err != nil {
+		return nil, nil, courseError.CreateError(err, 10033)
+	}
+
+	if deleted == 0 {
+		return nil, nil, courseError.CreateError(ErrBadMagicLink, 11021)
+	}
+
+	userId, verified, signInErr := auth.authentificater.SignInByEmail(ctx, claims.Email)
+	if signInErr != nil {
+		return nil, nil, signInErr
+	}
+
+	return auth.completeLogIn(ctx, *userId, *verified, device)
+}
+
+func magicLinkKey(tokenId string) string {
+	return "magic:" + tokenId
+}
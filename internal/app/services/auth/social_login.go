@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+const (
+	socialLoginStateTTL    = 10 * time.Minute
+	socialLoginStateLength = 16
+	socialLoginNonceLength = 16
+	codeVerifierLength     = 32
+)
+
+var (
+	ErrProviderNotFound    = errors.New("провайдер социального логина не найден")
+	ErrBadSocialLoginState = errors.New("вход через провайдера устарел или был начат в другом браузере, попробуйте снова")
+	ErrNoAuthCode          = errors.New("провайдер не вернул код авторизации")
+)
+
+// StartSocialLogin используется для начала входа через провайдера социального логина. Генерирует state, nonce
+// и PKCE verifier, сохраняет их в Redis и возвращает адрес страницы авторизации провайдера и state,
+// который нужно сохранить в куки браузера для защиты от CSRF. Возвращает ошибку, если провайдер не настроен.
+func (auth AuthService) StartSocialLogin(providerName string) (*string, *string, *courseError.CourseError) {
+	provider, ok := auth.providers[providerName]
+	if !ok {
+		return nil, nil, courseError.CreateError(ErrProviderNotFound, 11201)
+	}
+
+	state, err := generateRandomString(socialLoginStateLength)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	nonce, err := generateRandomString(socialLoginNonceLength)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	codeVerifier, err := generateRandomString(codeVerifierLength)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := auth.redis.Set(socialLoginStateKey(state), strings.Join([]string{providerName, nonce, codeVerifier}, ":"),
+		socialLoginStateTTL).Err(); err != nil {
+		return nil, nil, courseError.CreateError(err, 10031)
+	}
+
+	challenge := sha256.Sum256([]byte(codeVerifier))
+	authUrl := provider.AuthCodeURL(state, nonce, base64.RawURLEncoding.EncodeToString(challenge[:]))
+
+	return &authUrl, &state, nil
+}
+
+// CompleteSocialLogin используется для завершения входа через провайдера. Принимает имя провайдера, параметры колбэка,
+// state из куки и устройство. Проверяет, что state совпадает с куки и был выдан этому провайдеру, обменивает код
+// на данные пользователя, находит или создает пользователя и завершает вход так же, как LogIn.
+// Возвращает токены, челлендж двухфакторной аутентификации или ошибку.
+func (auth AuthService) CompleteSocialLogin(ctx context.Context, providerName string, callback *entity.SocialLoginCallback,
+	stateFromCookie string, device *entity.Device) (*Tokens, *string, *courseError.CourseError) {
+	provider, ok := auth.providers[providerName]
+	if !ok {
+		return nil, nil, courseError.CreateError(ErrProviderNotFound, 11201)
+	}
+
+	if callback.State == "" || callback.State != stateFromCookie {
+		return nil, nil, courseError.CreateError(ErrBadSocialLoginState, 11202)
+	}
+
+	key := socialLoginStateKey(callback.State)
+
+	var value *redis.StringCmd
+	if _, err := auth.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		value = pipe.Get(key)
+		pipe.Del(key)
+		return nil
+	}); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, nil, courseError.CreateError(err, 10030)
+	}
+
+	parts := strings.Split(value.Val(), ":")
+	if len(parts) != 3 || parts[0] != providerName {
+		return nil, nil, courseError.CreateError(ErrBadSocialLoginState, 11202)
+	}
+	nonce, codeVerifier := parts[1], parts[2]
+
+	if callback.Code == "" {
+		return nil, nil, courseError.CreateError(ErrNoAuthCode, 11203)
+	}
+
+	identity, err := provider.Exchange(ctx, callback.Code, codeVerifier, callback.DeviceId, nonce)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	userId, verified, err := auth.authentificater.SignInWithExternalIdentity(ctx, providerName, identity.Subject, identity.Email, identity.EmailVerified)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return auth.completeLogIn(ctx, *userId, *verified, device)
+}
+
+func socialLoginStateKey(state string) string {
+	return "oauth:" + state
+}
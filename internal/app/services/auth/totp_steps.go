@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+	courseError "github.com/knstch/course/internal/app/course_error"
+)
+
+const (
+	totpStepKeyPrefix = "totp-step:"
+
+	// totpStepTTL покрывает окно, в котором принимается код: текущий шаг и по одному шагу до и после него.
+	totpStepTTL = 3 * 30 * time.Second
+)
+
+// useTotpStepScript запоминает шаг, только если он позже последнего принятого. Проверка и запись идут
+// одной командой, поэтому два параллельных запроса с одним кодом не пройдут оба.
+var useTotpStepScript = redis.NewScript(`
+local last = redis.call("GET", KEYS[1])
+if last and tonumber(last) >= tonumber(ARGV[1]) then
+	return 0
+end
+redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[2])
+return 1
+`)
+
+// TotpStepCache хранит в Redis последний принятый шаг TOTP каждого пользователя, чтобы код из аутентификатора
+// нельзя было использовать повторно, пока он не истек.
+type TotpStepCache struct {
+	redis *redis.Client
+}
+
+// NewTotpStepCache - это билдер для TotpStepCache.
+func NewTotpStepCache(client *redis.Client) *TotpStepCache {
+	return &TotpStepCache{
+		redis: client,
+	}
+}
+
+// UseStep запоминает шаг step, которым пользователь подтвердил код. Возвращает false, если этот или более поздний шаг
+// уже был принят, или ошибку Redis.
+func (cache TotpStepCache) UseStep(userId uint, step uint64) (bool, *courseError.CourseError) {
+	used, err := useTotpStepScript.Run(cache.redis, []string{fmt.Sprintf("%s%d", totpStepKeyPrefix, userId)},
+		step, totpStepTTL.Milliseconds()).Int()
+	if err != nil {
+		return false, courseError.CreateError(err, 10031)
+	}
+
+	return used == 1, nil
+}
@@ -0,0 +1,187 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/services/lockout"
+	"github.com/knstch/course/internal/app/validation"
+	"github.com/knstch/course/internal/domain/entity"
+	"github.com/pquerna/otp/totp"
+	qrcode "github.com/skip2/go-qrcode"
+)
+
+const (
+	twoStepAuthChallengeTTL    = 5 * time.Minute
+	twoStepAuthChallengeLength = 16
+	twoStepAuthMaxAttempts     = 5
+
+	recoveryCodesCount   = 10
+	recoveryCodeLength   = 5
+	twoStepAuthKeyIssuer = "Course"
+)
+
+var (
+	ErrChallengeNotFound = errors.New("вход не найден или устарел, залогиньтесь заново")
+)
+
+// EnrollTwoStepAuth используется для привязки аутентификатора к профилю пользователя. Генерирует новый ключ,
+// сохраняет его неподтвержденным и возвращает QR-код для добавления ключа в приложение или ошибку.
+func (auth AuthService) EnrollTwoStepAuth(ctx context.Context) ([]byte, *courseError.CourseError) {
+	userId := ctx.Value("UserId").(uint)
+
+	userData, err := auth.authentificater.RetreiveUserData(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	key, keyErr := totp.Generate(totp.GenerateOpts{
+		Issuer:      twoStepAuthKeyIssuer,
+		AccountName: userData.Email,
+	})
+	if keyErr != nil {
+		return nil, courseError.CreateError(keyErr, 11018)
+	}
+
+	if err := auth.authentificater.SetUserTotpKey(ctx, userId, key.Secret()); err != nil {
+		return nil, err
+	}
+
+	qr, qrErr := qrcode.Encode(key.String(), qrcode.Medium, 256)
+	if qrErr != nil {
+		return nil, courseError.CreateError(qrErr, 11018)
+	}
+
+	return qr, nil
+}
+
+// ConfirmTwoStepAuth используется для подтверждения привязки аутентификатора. Принимает код из приложения,
+// валидирует его, включает двухфакторную аутентификацию и возвращает одноразовые коды восстановления или ошибку.
+// Коды хранятся в БД в виде хэша, поэтому показываются пользователю только один раз.
+func (auth AuthService) ConfirmTwoStepAuth(ctx context.Context, code string) ([]string, *courseError.CourseError) {
+	if err := validation.NewTwoStepAuthCodeToValidate(code).Validate(ctx); err != nil {
+		return nil, err
+	}
+
+	recoveryCodes := make([]string, 0, recoveryCodesCount)
+	hashedCodes := make([]string, 0, recoveryCodesCount)
+	for i := 0; i < recoveryCodesCount; i++ {
+		recoveryCode, err := generateRandomString(recoveryCodeLength)
+		if err != nil {
+			return nil, err
+		}
+		recoveryCodes = append(recoveryCodes, recoveryCode)
+		hashedCodes = append(hashedCodes, hashToken(recoveryCode))
+	}
+
+	if err := auth.authentificater.EnableUserTwoStepAuth(ctx, ctx.Value("UserId").(uint), code, hashedCodes); err != nil {
+		return nil, err
+	}
+
+	return recoveryCodes, nil
+}
+
+// DisableTwoStepAuth используется для отключения двухфакторной аутентификации. Принимает код из приложения
+// или код восстановления, проверяет его и удаляет ключ и коды восстановления. Возвращает ошибку.
+func (auth AuthService) DisableTwoStepAuth(ctx context.Context, code string) *courseError.CourseError {
+	if err := validation.NewTwoStepAuthCodeToValidate(code).Validate(ctx); err != nil {
+		return err
+	}
+
+	userId := ctx.Value("UserId").(uint)
+
+	if err := auth.authentificater.CheckTwoStepAuthCode(ctx, userId, code, hashToken(code)); err != nil {
+		return err
+	}
+
+	if err := auth.authentificater.DisableUserTwoStepAuth(ctx, userId); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// createTwoStepAuthChallenge сохраняет в Redis данные пользователя, прошедшего первый шаг логина,
+// и возвращает ID челленджа, по которому пользователь завершит вход кодом из аутентификатора.
+func (auth AuthService) createTwoStepAuthChallenge(userId uint, verified bool) (*string, *courseError.CourseError) {
+	challenge, err := generateRandomString(twoStepAuthChallengeLength)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := auth.redis.Set(twoStepAuthChallengeKey(challenge), fmt.Sprintf("%d:%t", userId, verified), twoStepAuthChallengeTTL).Err(); err != nil {
+		return nil, courseError.CreateError(err, 10031)
+	}
+
+	return &challenge, nil
+}
+
+// LogInSecondStep используется для завершения логина с двухфакторной аутентификацией. Принимает челлендж,
+// полученный на первом шаге, код из аутентификатора или код восстановления и устройство. После нескольких
+// неудачных попыток челлендж удаляется и логин нужно начать заново. Неверные коды учитываются в защите от перебора
+// по пользователю и IP, поэтому новые челленджи не дают подбирать код дальше. Возвращает токены или ошибку.
+func (auth AuthService) LogInSecondStep(ctx context.Context, challenge *entity.TwoStepAuthChallenge, device *entity.Device) (*Tokens, *courseError.CourseError) {
+	if err := validation.NewTwoStepAuthChallengeToValidate(challenge).Validate(ctx); err != nil {
+		return nil, err
+	}
+
+	key := twoStepAuthChallengeKey(challenge.Challenge)
+
+	value, err := auth.redis.Get(key).Result()
+	if err != nil {
+		if errors.Is(err, redis.Nil) {
+			return nil, courseError.CreateError(ErrChallengeNotFound, 11017)
+		}
+		return nil, courseError.CreateError(err, 10030)
+	}
+
+	rawUserId, rawVerified, _ := strings.Cut(value, ":")
+	userId, _ := strconv.ParseUint(rawUserId, 10, 64)
+	verified, _ := strconv.ParseBool(rawVerified)
+
+	if err := auth.lockout.Check(lockout.ScopeTwoFactor, rawUserId, device.Ip); err != nil {
+		return nil, err
+	}
+
+	if checkErr := auth.authentificater.CheckTwoStepAuthCode(ctx, uint(userId), challenge.Code, hashToken(challenge.Code)); checkErr != nil {
+		if checkErr.Code == 11016 {
+			if err := auth.lockout.RegisterFailure(lockout.ScopeTwoFactor, rawUserId, device.Ip); err != nil {
+				return nil, err
+			}
+			attempts, err := auth.redis.Incr(key + ":attempts").Result()
+			if err != nil {
+				return nil, courseError.CreateError(err, 10030)
+			}
+			auth.redis.Expire(key+":attempts", twoStepAuthChallengeTTL)
+			if attempts >= twoStepAuthMaxAttempts {
+				auth.redis.Del(key, key+":attempts")
+			}
+		}
+		return nil, checkErr
+	}
+
+	if err := auth.redis.Del(key, key+":attempts").Err(); err != nil {
+		return nil, courseError.CreateError(err, 10033)
+	}
+
+	if err := auth.lockout.Reset(lockout.ScopeTwoFactor, rawUserId); err != nil {
+		return nil, err
+	}
+
+	tokens, issueErr := auth.issueTokens(ctx, uint(userId), verified, device, nil)
+	if issueErr != nil {
+		return nil, issueErr
+	}
+
+	return tokens, nil
+}
+
+func twoStepAuthChallengeKey(challenge string) string {
+	return "2fa:" + challenge
+}
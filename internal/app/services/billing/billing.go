@@ -7,7 +7,6 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
-	"math/rand"
 	"strconv"
 	"strings"
 	"time"
@@ -15,52 +14,111 @@ import (
 	"github.com/go-redis/redis"
 	"github.com/knstch/course/internal/app/config"
 	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/services/email"
 	"github.com/knstch/course/internal/app/validation"
 	"github.com/knstch/course/internal/domain/dto"
 	"github.com/knstch/course/internal/domain/entity"
 )
 
+const (
+	// orderTTL - это время жизни ссылки на оплату, после него неоплаченный заказ считается просроченным.
+	orderTTL = 15 * time.Minute
+
+	// PaymentStatusPaid, PaymentStatusPending, PaymentStatusCanceled и PaymentStatusRefunded - это статусы оплаты,
+	// которые видит пользователь.
+	PaymentStatusPaid     = "paid"
+	PaymentStatusPending  = "pending"
+	PaymentStatusCanceled = "canceled"
+	PaymentStatusRefunded = "refunded"
+
+	// foreignCardCurrency - это валюта заказа по иностранной карте, если пользователь не выбрал валюту сам,
+	// а у всех курсов заказа есть цена в этой валюте.
+	foreignCardCurrency = dto.CurrencyUSD
+)
+
 var (
 	ErrInvoiceNotFound        = errors.New("инвойс не найден")
 	ErrCourseAlreadyPurchased = errors.New("этот курс уже куплен")
+	ErrBundleCurrency         = errors.New("наборы курсов продаются только в рублях")
 )
 
-// SberBillingService содержит данные для работы с API сбербанка, Redis клиент
-// и методы для взаимодействия с БД.
+// SberBillingService содержит платежный шлюз, Redis клиент и методы для взаимодействия с БД.
 type SberBillingService struct {
-	apiHost     string
-	accessToken string
-	banker      Banker
-	redis       *redis.Client
+	provider          PaymentProvider
+	returnUrl         string
+	webhookSecret     string
+	subscriptionGrace time.Duration
+	banker            Banker
+	redis             *redis.Client
+	emailService      *email.EmailService
 }
 
 // Banker объединяет в себе методы для работы с биллингом.
 type Banker interface {
-	CreateNewOrder(ctx context.Context, courseId, price uint, ruCard bool) (*dto.OrderEssentials, *courseError.CourseError)
-	GetCourseCost(ctx context.Context, courseId uint) (*uint, *courseError.CourseError)
+	CreateNewOrder(ctx context.Context, items []dto.CheckoutItem, ruCard bool, currency, promoCode string) (*dto.OrderEssentials, *courseError.CourseError)
+	GetCourseCost(ctx context.Context, courseId uint, currency string) (*uint, *courseError.CourseError)
+	StoreCoursePrices(ctx context.Context, courseId uint, prices []dto.CoursePrice) *courseError.CourseError
+	GetCoursePrices(ctx context.Context, courseId uint) ([]dto.CoursePrice, *courseError.CourseError)
 	SetInvoiceId(ctx context.Context, invoiceId, orderId uint) *courseError.CourseError
-	ApprovePayment(ctx context.Context, invoiceId, hashedUserData string) (*string, *courseError.CourseError)
-	DeleteOrder(ctx context.Context, invoiceId string) *courseError.CourseError
+	ApprovePayment(ctx context.Context, invoiceId uint, receiptEmail, giftEmail *dto.OutboxEmail) *courseError.CourseError
+	FailOrder(ctx context.Context, invoiceId string, reminderEmail *dto.OutboxEmail) *courseError.CourseError
 	GetUserCourses(ctx context.Context) ([]dto.Order, *courseError.CourseError)
+	GetPurchaseDetails(ctx context.Context, invoiceId string) (*dto.PurchaseDetails, *courseError.CourseError)
+	GetBillingPurchaseDetails(ctx context.Context, billingId uint) (*dto.PurchaseDetails, *courseError.CourseError)
+	RefundBilling(ctx context.Context, billingId, amount, invoiceRefundedTotal uint, refund *dto.Refund, refundEmail *dto.OutboxEmail) *courseError.CourseError
+	RefundPayment(ctx context.Context, invoiceId uint, refundedTotal uint, refund *dto.Refund, refundEmail *dto.OutboxEmail) *courseError.CourseError
+	GetReconciliationReports(ctx context.Context, limit, offset int) ([]dto.ReconciliationReport, int64, *courseError.CourseError)
+	SaveReconciliationReport(ctx context.Context, report *dto.ReconciliationReport) *courseError.CourseError
+	StorePromoCode(ctx context.Context, promo *dto.PromoCode) *courseError.CourseError
+	UpdatePromoCode(ctx context.Context, promo *dto.PromoCode) *courseError.CourseError
+	DeletePromoCode(ctx context.Context, id uint) *courseError.CourseError
+	GetPromoCodes(ctx context.Context, limit, offset int) ([]dto.PromoCode, []entity.PromoStats, int64, *courseError.CourseError)
+	StoreSubscriptionPlan(ctx context.Context, plan *dto.SubscriptionPlan) *courseError.CourseError
+	UpdateSubscriptionPlan(ctx context.Context, plan *dto.SubscriptionPlan) *courseError.CourseError
+	GetSubscriptionPlans(ctx context.Context, onlyActive bool) ([]dto.SubscriptionPlan, *courseError.CourseError)
+	CreateSubscription(ctx context.Context, planId uint, ruCard bool) (*dto.SubscriptionCharge, *courseError.CourseError)
+	SetSubscriptionInvoiceId(ctx context.Context, invoiceId, paymentId uint) *courseError.CourseError
+	GetSubscriptionPayment(ctx context.Context, invoiceId uint) (*dto.SubscriptionPayment, *courseError.CourseError)
+	PaySubscription(ctx context.Context, invoiceId uint, bindingId string) *courseError.CourseError
+	FailSubscriptionPayment(ctx context.Context, invoiceId uint, gracePeriod time.Duration) *courseError.CourseError
+	GetUserSubscription(ctx context.Context) (*dto.Subscription, *courseError.CourseError)
+	CancelSubscription(ctx context.Context) *courseError.CourseError
+	StoreBundle(ctx context.Context, bundle *dto.Bundle) *courseError.CourseError
+	UpdateBundle(ctx context.Context, bundle *dto.Bundle) *courseError.CourseError
+	DeleteBundle(ctx context.Context, id uint) *courseError.CourseError
+	GetBundles(ctx context.Context, onlyActive bool) ([]dto.Bundle, *courseError.CourseError)
+	GetBundle(ctx context.Context, id uint) (*dto.Bundle, *courseError.CourseError)
+	CreateGift(ctx context.Context, orderId uint, code, recipientEmail string) *courseError.CourseError
+	RedeemGift(ctx context.Context, code string) (*dto.GiftDetails, *courseError.CourseError)
+	VoidGift(ctx context.Context, id uint) *courseError.CourseError
+	GetUserGifts(ctx context.Context) ([]dto.GiftDetails, *courseError.CourseError)
+	GetGifts(ctx context.Context, onlyUnredeemed bool, limit, offset int) ([]dto.GiftDetails, int64, *courseError.CourseError)
+	ResendReceipt(ctx context.Context, billingId uint) *courseError.CourseError
 }
 
 // NewSberBillingService - это билдер для сервиса биллинга.
-func NewSberBillingService(config *config.Config, banker Banker, redis *redis.Client) SberBillingService {
+func NewSberBillingService(config *config.Config, banker Banker, provider PaymentProvider, redis *redis.Client, emailService *email.EmailService) SberBillingService {
 	return SberBillingService{
-		apiHost:     config.SberApiHost,
-		accessToken: config.SberAccessToken,
-		banker:      banker,
-		redis:       redis,
+		provider:          provider,
+		returnUrl:         strings.TrimSuffix(config.BillingReturnUrl, "/"),
+		webhookSecret:     config.SberWebhookSecret,
+		subscriptionGrace: config.SubscriptionGracePeriod,
+		banker:            banker,
+		redis:             redis,
+		emailService:      emailService,
 	}
 }
 
 // PlaceOrder используется для размещения заказа пользователя. В качестве параметра принимает
-// ID курса и страна платежного инструмента. Далее валидирует параметра, проверяет куплен ли уже этот
-// курс у пользователя, если он куплен, то возвращаем ошибку. Потом сервис запрашивает цену курса
-// формирует новый заказ, подготавливает инвойс и отправялет его в банк. Далее из ID пользователя и
+// ID курса, страна платежного инструмента, необязательные валюту и промокод. Далее валидирует параметра, проверяет куплен ли уже этот
+// курс у пользователя, если он куплен, то возвращаем ошибку. Потом сервис запрашивает цену курса в валюте заказа,
+// формирует новый заказ со скидкой по промокоду, подготавливает инвойс и отправялет его в банк. Далее из ID пользователя и
 // идентификатора заказа формируется хэш, который записывается в Redis и формируется ссылка на оплату для пользователя.
 // Метод возвращает ссылку на оплату для пользователя и ошибку.
 func (billing SberBillingService) PlaceOrder(ctx context.Context, buyDetails *entity.BuyDetails) (*string, *courseError.CourseError) {
+	buyDetails.PromoCode = strings.ToUpper(strings.TrimSpace(buyDetails.PromoCode))
+	buyDetails.Currency = strings.ToUpper(strings.TrimSpace(buyDetails.Currency))
+
 	if err := validation.NewPaymentCredentialsToValidate(buyDetails).Validate(ctx); err != nil {
 		return nil, err
 	}
@@ -76,86 +134,120 @@ func (billing SberBillingService) PlaceOrder(ctx context.Context, buyDetails *en
 		}
 	}
 
-	price, err := billing.banker.GetCourseCost(ctx, buyDetails.CourseId)
+	currency, err := billing.orderCurrency(ctx, buyDetails.Currency, buyDetails.IsRusCard, buyDetails.CourseId)
 	if err != nil {
 		return nil, err
 	}
 
-	order, err := billing.banker.CreateNewOrder(ctx, buyDetails.CourseId, *price, buyDetails.IsRusCard)
+	price, err := billing.banker.GetCourseCost(ctx, buyDetails.CourseId, currency)
 	if err != nil {
 		return nil, err
 	}
 
-	userId := ctx.Value("UserId").(uint)
+	items := []dto.CheckoutItem{{CourseId: buyDetails.CourseId, Amount: *price}}
 
-	invoice := entity.CreateOrder(*order, int(buyDetails.CourseId), fmt.Sprint(userId), 0)
+	return billing.placeItems(ctx, items, buyDetails.IsRusCard, currency, buyDetails.PromoCode)
+}
 
-	invoiceId, err := billing.sendInvoice(invoice)
-	if err != nil {
-		return nil, err
+// orderCurrency возвращает валюту заказа на курсы courseIds. Если пользователь не выбрал валюту, она определяется
+// по стране карты: по российской карте заказ оплачивается в рублях, по иностранной - в долларах, если у всех курсов
+// заказа есть цена в долларах, иначе в рублях. Возвращает валюту или ошибку.
+func (billing SberBillingService) orderCurrency(ctx context.Context, currency string, ruCard bool, courseIds ...uint) (string, *courseError.CourseError) {
+	if currency != "" {
+		return currency, nil
 	}
-
-	if err := billing.banker.SetInvoiceId(ctx, *invoiceId, order.OrderId); err != nil {
-		return nil, err
+	if ruCard {
+		return dto.CurrencyRUB, nil
 	}
 
-	userDataHash := md5.New()
+	for _, courseId := range courseIds {
+		prices, err := billing.banker.GetCoursePrices(ctx, courseId)
+		if err != nil {
+			return "", err
+		}
 
-	userDataHash.Write([]byte(fmt.Sprintf("%d%v", userId, order.Order)))
+		priced := false
+		for _, v := range prices {
+			if v.Currency == foreignCardCurrency {
+				priced = true
+				break
+			}
+		}
+		if !priced {
+			return dto.CurrencyRUB, nil
+		}
+	}
 
-	hashedUserData := hex.EncodeToString(userDataHash.Sum(nil))
+	return foreignCardCurrency, nil
+}
 
-	linkToPay, err := billing.getPayLink(invoiceId, hashedUserData)
+// placeItems формирует заказ на курсы items в валюте currency и выставляет инвойс на общую сумму. Возвращает
+// ссылку на оплату или ошибку.
+func (billing SberBillingService) placeItems(ctx context.Context, items []dto.CheckoutItem, ruCard bool, currency, promoCode string) (*string, *courseError.CourseError) {
+	order, err := billing.banker.CreateNewOrder(ctx, items, ruCard, currency, promoCode)
 	if err != nil {
 		return nil, err
 	}
 
-	return linkToPay, nil
+	return billing.invoiceOrder(ctx, order, items[0].CourseId)
 }
 
-// UNIMPLEMENTED
-// sendInvoice отправляет инвойс в банк. Возвращает номер инвойса или ошибку.
-func (billing SberBillingService) sendInvoice(_ entity.InvoiceData) (*uint, *courseError.CourseError) {
-	return billing.mockInvoiceNumGenerator(), nil
-}
+// invoiceOrder подготавливает инвойс по заказу и отправляет его в банк. Далее из ID пользователя и идентификатора
+// заказа формируется хэш, который записывается в Redis и формируется ссылка на оплату для пользователя.
+// Возвращает ссылку на оплату или ошибку.
+func (billing SberBillingService) invoiceOrder(ctx context.Context, order *dto.OrderEssentials, courseId uint) (*string, *courseError.CourseError) {
+	userId := ctx.Value("UserId").(uint)
 
-// mockInvoiceNumGenerator генерирует mock номер инвойса и возвращает его.
-func (billing SberBillingService) mockInvoiceNumGenerator() *uint {
-	rand.NewSource(time.Now().UnixNano())
+	invoice := entity.CreateOrder(*order, int(courseId), fmt.Sprint(userId), 0)
 
-	randomNumbers := make([]string, 10)
+	invoiceId, err := billing.provider.CreateInvoice(ctx, invoice)
+	if err != nil {
+		return nil, err
+	}
 
-	for i := 0; i < 10; i++ {
-		randomNumbers[i] = fmt.Sprint(rand.Intn(100))
+	if err := billing.banker.SetInvoiceId(ctx, invoiceId, order.OrderId); err != nil {
+		return nil, err
 	}
 
-	invoice := strings.Join(randomNumbers, "")
+	userDataHash := md5.New()
+
+	userDataHash.Write([]byte(fmt.Sprintf("%d%v", userId, order.Order)))
 
-	invoiceInt, _ := strconv.Atoi(invoice)
+	hashedUserData := hex.EncodeToString(userDataHash.Sum(nil))
 
-	invoiceUint := uint(invoiceInt)
+	linkToPay, err := billing.getPayLink(ctx, invoiceId, hashedUserData)
+	if err != nil {
+		return nil, err
+	}
 
-	return &invoiceUint
+	return linkToPay, nil
 }
 
-// UNIMPLEMENTED
 // getPayLink подготавливает ссылку на оплату для пользователя. В качестве параметров принимает ID инвойса и
-// зашэшированные данные заказа пользователя, записывает их в Redis и отправляет данные в банк,
-// указывая в качестве return ссылки GET метод сервиса, принимающим в качестве параметра хэш из данных заказа пользователя.
+// зашэшированные данные заказа пользователя, записывает их в Redis и запрашивает у банка форму оплаты,
+// указывая в качестве return ссылок GET методы сервиса, принимающие в качестве параметра хэш из данных заказа пользователя.
 // Возвращает ссылку на оплату для пользователя или ошибку.
-func (billing SberBillingService) getPayLink(invoiceId *uint, hashedUserData string) (*string, *courseError.CourseError) {
-	if err := billing.redis.Set(hashedUserData, *invoiceId, 15*time.Minute).Err(); err != nil {
+func (billing SberBillingService) getPayLink(ctx context.Context, invoiceId uint, hashedUserData string) (*string, *courseError.CourseError) {
+	if err := billing.redis.Set(hashedUserData, invoiceId, orderTTL).Err(); err != nil {
 		return nil, courseError.CreateError(err, 10031)
 	}
 
-	payLink := fmt.Sprintf("%v/invoices/%v", billing.apiHost, *invoiceId)
+	payLink, err := billing.provider.CreatePayLink(ctx, invoiceId,
+		fmt.Sprintf("%v/successPayment/%v", billing.returnUrl, hashedUserData),
+		fmt.Sprintf("%v/failPayment/%v", billing.returnUrl, hashedUserData))
+	if err != nil {
+		return nil, err
+	}
+
 	return &payLink, nil
 }
 
-// ConfirmPayment используется для подтверждения оплаты пользователем. Принимает в качестве параметра
-// захэшированные данные заказа пользователя, проверяет наличие такой записи в Redis. Если она была найдена
-// и ID инвойса совпали с данными из БД, то оплата считается завершенной. Метод возвращает название курса или ошибку.
-func (billing SberBillingService) ConfirmPayment(ctx context.Context, hashedUserData string) (*string, *courseError.CourseError) {
+// GetPaymentStatus используется для показа статуса оплаты, когда банк возвращает пользователя на сервис.
+// Принимает в качестве параметра захэшированные данные заказа пользователя, по ним находит инвойс в Redis.
+// Оплата отмечается только по уведомлению от банка, поэтому метод ничего не меняет. Если инвойс выставлен
+// за подписку, возвращается статус оплаты подписки. Возвращает статус оплаты и название курса или ошибку. Если
+// в заказе несколько курсов, название не возвращается.
+func (billing SberBillingService) GetPaymentStatus(ctx context.Context, hashedUserData string) (*entity.PaymentStatus, *courseError.CourseError) {
 	invoiceId, err := billing.redis.Get(hashedUserData).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
@@ -164,38 +256,64 @@ func (billing SberBillingService) ConfirmPayment(ctx context.Context, hashedUser
 		return nil, courseError.CreateError(err, 10030)
 	}
 
-	courseName, courseErr := billing.banker.ApprovePayment(ctx, invoiceId, hashedUserData)
+	details, courseErr := billing.banker.GetPurchaseDetails(ctx, invoiceId)
 	if courseErr != nil {
+		if courseErr.Code == 15001 {
+			return billing.getSubscriptionPaymentStatus(ctx, invoiceId)
+		}
 		return nil, courseErr
 	}
 
-	if err := billing.redis.Del(hashedUserData).Err(); err != nil {
-		return nil, courseError.CreateError(err, 10033)
+	if details.UserId != ctx.Value("UserId").(uint) {
+		return nil, courseError.CreateError(ErrInvoiceNotFound, 15001)
 	}
 
-	return courseName, nil
+	courseName := details.CourseName
+	if details.CourseCount > 1 {
+		courseName = ""
+	}
+
+	switch details.Status {
+	case dto.BillingStatusPaid, dto.BillingStatusPartiallyRefunded:
+		return entity.CreatePaymentStatus(PaymentStatusPaid, courseName), nil
+	case dto.BillingStatusRefunded:
+		return entity.CreatePaymentStatus(PaymentStatusRefunded, courseName), nil
+	case dto.BillingStatusFailed:
+		return entity.CreatePaymentStatus(PaymentStatusCanceled, courseName), nil
+	default:
+		return entity.CreatePaymentStatus(PaymentStatusPending, courseName), nil
+	}
 }
 
-// FailPayment используется для отмены заказа. Принимает в качестве параметра зашэшированные данные заказа пользователя,
-// проверяет по этому ключу данные в Redis. Если они были найдены, удаляет заказ и данные из Redis. Возвращает ошибку.
-func (billing SberBillingService) FailPayment(ctx context.Context, hashedUserData string) *courseError.CourseError {
-	invoiceId, err := billing.redis.Get(hashedUserData).Result()
-	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			return courseError.CreateError(ErrInvoiceNotFound, 11004)
-		}
-		return courseError.CreateError(err, 10030)
+// RetreiveReconciliationReports используется для просмотра отчетов о сверке заказов с банком. Принимает страницу
+// и лимит, валидирует их и возвращает отчеты с пагинацией или ошибку.
+func (billing SberBillingService) RetreiveReconciliationReports(ctx context.Context, page, limit string) (*entity.ReconciliationReportsWithPagination, *courseError.CourseError) {
+	if err := validation.NewReportsQueryToValidate(page, limit).Validate(ctx); err != nil {
+		return nil, err
 	}
 
-	if err := billing.banker.DeleteOrder(ctx, invoiceId); err != nil {
-		return err
+	pageInt, _ := strconv.Atoi(page)
+	limitInt, _ := strconv.Atoi(limit)
+
+	reports, totalCount, err := billing.banker.GetReconciliationReports(ctx, limitInt, pageInt*limitInt)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := billing.redis.Del(hashedUserData).Err(); err != nil {
-		return courseError.CreateError(err, 10033)
+	pagesCount := int(totalCount) / limitInt
+	if int(totalCount)%limitInt != 0 {
+		pagesCount++
 	}
 
-	return nil
+	return &entity.ReconciliationReportsWithPagination{
+		Pagination: entity.Pagination{
+			Page:       pageInt,
+			Limit:      limitInt,
+			TotalCount: int(totalCount),
+			PagesCount: pagesCount,
+		},
+		Reports: entity.CreateReconciliationReports(reports),
+	}, nil
 }
 
 // ChangeApiHost используется для изменения API хоста банка. В качестве
@@ -205,7 +323,7 @@ func (billing *SberBillingService) ChangeApiHost(ctx context.Context, apiHost st
 		return err
 	}
 
-	billing.apiHost = apiHost
+	billing.provider.SetApiHost(apiHost)
 
 	return nil
 }
@@ -217,7 +335,7 @@ func (billing *SberBillingService) ChangeAccessToken(ctx context.Context, token
 		return err
 	}
 
-	billing.accessToken = token
+	billing.provider.SetAccessToken(token)
 
 	return nil
 }
@@ -0,0 +1,162 @@
+package billing
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/validation"
+	"github.com/knstch/course/internal/domain/dto"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+// Checkout используется для оплаты корзины из нескольких курсов и наборов одним инвойсом. В качестве параметра
+// принимает ID курсов и наборов, страну платежного инструмента, необязательные валюту и промокод, валидирует их
+// и собирает заказ. Наборы продаются только в рублях, корзина с набором без выбранной валюты оплачивается в рублях.
+// Цена набора делится между его курсами пропорционально их цене по отдельности. Уже купленные курсы и курсы,
+// которые встречаются в корзине повторно, пропускаются, а цена набора уменьшается на их долю. Если покупать нечего,
+// возвращается ошибка. Промокод применяется к первому курсу, на который он действует. Возвращает ссылку на оплату
+// для пользователя или ошибку.
+func (billing SberBillingService) Checkout(ctx context.Context, cart *entity.CartDetails) (*string, *courseError.CourseError) {
+	cart.PromoCode = strings.ToUpper(strings.TrimSpace(cart.PromoCode))
+	cart.Currency = strings.ToUpper(strings.TrimSpace(cart.Currency))
+
+	if err := validation.NewCartDetailsToValidate(cart).Validate(ctx); err != nil {
+		return nil, err
+	}
+
+	currency := cart.Currency
+	if len(cart.BundleIds) != 0 {
+		if currency != "" && currency != dto.CurrencyRUB {
+			return nil, courseError.CreateError(ErrBundleCurrency, 15027)
+		}
+		currency = dto.CurrencyRUB
+	}
+
+	currency, err := billing.orderCurrency(ctx, currency, cart.IsRusCard, cart.CourseIds...)
+	if err != nil {
+		return nil, err
+	}
+
+	userCourses, err := billing.banker.GetUserCourses(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	skip := make(map[uint]bool, len(userCourses))
+	for _, v := range userCourses {
+		skip[v.CourseId] = true
+	}
+
+	items := make([]dto.CheckoutItem, 0, len(cart.CourseIds)+len(cart.BundleIds))
+	for _, bundleId := range cart.BundleIds {
+		bundle, err := billing.banker.GetBundle(ctx, bundleId)
+		if err != nil {
+			return nil, err
+		}
+
+		id := bundle.ID
+		prices := bundle.CoursePrices()
+		for i, course := range bundle.Courses {
+			if skip[course.ID] {
+				continue
+			}
+			skip[course.ID] = true
+
+			items = append(items, dto.CheckoutItem{CourseId: course.ID, BundleId: &id, Amount: prices[i]})
+		}
+	}
+
+	for _, courseId := range cart.CourseIds {
+		if skip[courseId] {
+			continue
+		}
+		skip[courseId] = true
+
+		price, err := billing.banker.GetCourseCost(ctx, courseId, currency)
+		if err != nil {
+			return nil, err
+		}
+
+		items = append(items, dto.CheckoutItem{CourseId: courseId, Amount: *price})
+	}
+
+	if len(items) == 0 {
+		return nil, courseError.CreateError(ErrCourseAlreadyPurchased, 15004)
+	}
+
+	return billing.placeItems(ctx, items, cart.IsRusCard, currency, cart.PromoCode)
+}
+
+// RetreiveBundles используется для просмотра наборов курсов. Пользователю показываются только наборы, которые
+// можно купить, админу - все наборы. Возвращает наборы с ценой курсов по отдельности или ошибку.
+func (billing SberBillingService) RetreiveBundles(ctx context.Context, onlyActive bool) ([]entity.Bundle, *courseError.CourseError) {
+	bundles, err := billing.banker.GetBundles(ctx, onlyActive)
+	if err != nil {
+		return nil, err
+	}
+
+	return entity.CreateBundles(bundles), nil
+}
+
+// CreateBundle используется админом для создания набора курсов. Принимает название, описание, цену и курсы
+// набора, валидирует их и сохраняет набор. Возвращает созданный набор или ошибку.
+func (billing SberBillingService) CreateBundle(ctx context.Context, bundleToSave *entity.BundleToSave) (*entity.Bundle, *courseError.CourseError) {
+	bundle, err := billing.prepareBundle(ctx, bundleToSave)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := billing.banker.StoreBundle(ctx, bundle); err != nil {
+		return nil, err
+	}
+
+	return entity.CreateBundle(*bundle), nil
+}
+
+// EditBundle используется админом для изменения набора курсов. Принимает ID набора и новые условия, валидирует
+// их и перезаписывает набор. Уже оплаченные заказы не меняются. Возвращает ошибку.
+func (billing SberBillingService) EditBundle(ctx context.Context, id string, bundleToSave *entity.BundleToSave) *courseError.CourseError {
+	if err := validation.NewStringIdToValidate(id).Validate(ctx); err != nil {
+		return err
+	}
+
+	bundle, err := billing.prepareBundle(ctx, bundleToSave)
+	if err != nil {
+		return err
+	}
+
+	bundleId, _ := strconv.Atoi(id)
+	bundle.ID = uint(bundleId)
+
+	return billing.banker.UpdateBundle(ctx, bundle)
+}
+
+// RemoveBundle используется админом для удаления набора курсов. Принимает ID набора, валидирует его
+// и удаляет набор. Возвращает ошибку.
+func (billing SberBillingService) RemoveBundle(ctx context.Context, id string) *courseError.CourseError {
+	if err := validation.NewStringIdToValidate(id).Validate(ctx); err != nil {
+		return err
+	}
+
+	bundleId, _ := strconv.Atoi(id)
+
+	return billing.banker.DeleteBundle(ctx, uint(bundleId))
+}
+
+// prepareBundle валидирует условия набора курсов и собирает его модель. Если активность не передана, набор включен.
+func (billing SberBillingService) prepareBundle(ctx context.Context, bundleToSave *entity.BundleToSave) (*dto.Bundle, *courseError.CourseError) {
+	if err := validation.NewBundleToValidate(bundleToSave).Validate(ctx); err != nil {
+		return nil, err
+	}
+
+	active := true
+	if bundleToSave.Active != nil {
+		active = *bundleToSave.Active
+	}
+
+	return dto.CreateNewBundle(bundleToSave.Name, bundleToSave.Description, bundleToSave.Price).
+		AddCourses(bundleToSave.CourseIds).
+		SetActive(active), nil
+}
@@ -0,0 +1,154 @@
+package billing
+
+import (
+	"context"
+	"crypto/rand"
+	"strconv"
+	"strings"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/validation"
+	"github.com/knstch/course/internal/domain/dto"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+const (
+	// giftCodeAlphabet - это символы кода подарка, без похожих друг на друга 0, O, 1 и I.
+	giftCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+	giftCodeLength   = 16
+	giftCodeGroup    = 4
+)
+
+// PlaceGiftOrder используется для покупки курса в подарок. В качестве параметра принимает ID курса, страну
+// платежного инструмента, необязательные валюту, промокод и почту получателя, валидирует их и формирует
+// заказ с кодом подарка. Покупатель может подарить и курс, который купил сам. Курс не открывается покупателю,
+// его получает пользователь, который активирует код. Если передана почта получателя, код отправляется ему
+// письмом после оплаты, и активировать подарок может только пользователь с этой почтой. Возвращает ссылку
+// на оплату для пользователя или ошибку.
+func (billing SberBillingService) PlaceGiftOrder(ctx context.Context, giftOrder *entity.GiftOrderDetails) (*string, *courseError.CourseError) {
+	giftOrder.PromoCode = strings.ToUpper(strings.TrimSpace(giftOrder.PromoCode))
+	giftOrder.RecipientEmail = strings.ToLower(strings.TrimSpace(giftOrder.RecipientEmail))
+	giftOrder.Currency = strings.ToUpper(strings.TrimSpace(giftOrder.Currency))
+
+	if err := validation.NewGiftOrderToValidate(giftOrder).Validate(ctx); err != nil {
+		return nil, err
+	}
+
+	currency, err := billing.orderCurrency(ctx, giftOrder.Currency, giftOrder.IsRusCard, giftOrder.CourseId)
+	if err != nil {
+		return nil, err
+	}
+
+	price, err := billing.banker.GetCourseCost(ctx, giftOrder.CourseId, currency)
+	if err != nil {
+		return nil, err
+	}
+
+	code, err := generateGiftCode()
+	if err != nil {
+		return nil, err
+	}
+
+	items := []dto.CheckoutItem{{CourseId: giftOrder.CourseId, Amount: *price}}
+
+	order, err := billing.banker.CreateNewOrder(ctx, items, giftOrder.IsRusCard, currency, giftOrder.PromoCode)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := billing.banker.CreateGift(ctx, order.OrderId, code, giftOrder.RecipientEmail); err != nil {
+		return nil, err
+	}
+
+	return billing.invoiceOrder(ctx, order, giftOrder.CourseId)
+}
+
+// generateGiftCode генерирует случайный код подарка в формате XXXX-XXXX-XXXX-XXXX.
+func generateGiftCode() (string, *courseError.CourseError) {
+	buf := make([]byte, giftCodeLength)
+	if _, err := rand.Read(buf); err != nil {
+		return "", courseError.CreateError(err, 11010)
+	}
+
+	var code strings.Builder
+	for i, v := range buf {
+		if i > 0 && i%giftCodeGroup == 0 {
+			code.WriteByte('-')
+		}
+		code.WriteByte(giftCodeAlphabet[int(v)%len(giftCodeAlphabet)])
+	}
+
+	return code.String(), nil
+}
+
+// RedeemGift используется для активации подарка. Принимает код подарка, валидирует его и открывает курс из подарка
+// текущему пользователю. Подарок активируется один раз и только после оплаты. Возвращает активированный подарок
+// или ошибку.
+func (billing SberBillingService) RedeemGift(ctx context.Context, code string) (*entity.Gift, *courseError.CourseError) {
+	code = strings.ToUpper(strings.TrimSpace(code))
+
+	if err := validation.ValidateGiftCode(ctx, code); err != nil {
+		return nil, err
+	}
+
+	gift, err := billing.banker.RedeemGift(ctx, code)
+	if err != nil {
+		return nil, err
+	}
+
+	return entity.CreateGift(*gift), nil
+}
+
+// RetreiveUserGifts используется для просмотра подарков, купленных пользователем, вместе с их кодами и статусами.
+// Возвращает подарки или ошибку.
+func (billing SberBillingService) RetreiveUserGifts(ctx context.Context) ([]entity.Gift, *courseError.CourseError) {
+	gifts, err := billing.banker.GetUserGifts(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return entity.CreateGifts(gifts), nil
+}
+
+// RetreiveGifts используется админом для просмотра подарков. Принимает страницу, лимит и признак того, что нужны
+// только неактивированные подарки, валидирует их и возвращает подарки с пагинацией или ошибку.
+func (billing SberBillingService) RetreiveGifts(ctx context.Context, page, limit string, onlyUnredeemed bool) (*entity.GiftsWithPagination, *courseError.CourseError) {
+	if err := validation.NewReportsQueryToValidate(page, limit).Validate(ctx); err != nil {
+		return nil, err
+	}
+
+	pageInt, _ := strconv.Atoi(page)
+	limitInt, _ := strconv.Atoi(limit)
+
+	gifts, totalCount, err := billing.banker.GetGifts(ctx, onlyUnredeemed, limitInt, pageInt*limitInt)
+	if err != nil {
+		return nil, err
+	}
+
+	pagesCount := int(totalCount) / limitInt
+	if int(totalCount)%limitInt != 0 {
+		pagesCount++
+	}
+
+	return &entity.GiftsWithPagination{
+		Pagination: entity.Pagination{
+			Page:       pageInt,
+			Limit:      limitInt,
+			TotalCount: int(totalCount),
+			PagesCount: pagesCount,
+		},
+		Gifts: entity.CreateGifts(gifts),
+	}, nil
+}
+
+// VoidGift используется админом для аннулирования неактивированного подарка. Принимает ID подарка, валидирует его
+// и аннулирует подарок, после чего код больше не активируется. Деньги покупателю возвращаются отдельно. Возвращает ошибку.
+func (billing SberBillingService) VoidGift(ctx context.Context, id string) *courseError.CourseError {
+	if err := validation.NewStringIdToValidate(id).Validate(ctx); err != nil {
+		return err
+	}
+
+	giftId, _ := strconv.Atoi(id)
+
+	return billing.banker.VoidGift(ctx, uint(giftId))
+}
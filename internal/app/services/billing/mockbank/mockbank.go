@@ -0,0 +1,562 @@
+// mockbank содержит локальный банк с API инвойсов сбербанка для разработки и тестов без сети.
+package mockbank
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/knstch/course/internal/app/services/billing"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+var (
+	ErrInvoiceNotFound  = errors.New("инвойс не найден")
+	ErrInvoiceNotActive = errors.New("инвойс уже оплачен, отклонен или просрочен")
+	ErrNoPaymentForm    = errors.New("форма оплаты для инвойса не создана")
+	ErrWebhookRejected  = errors.New("сервис не принял уведомление")
+	ErrInvoiceNotPaid   = errors.New("инвойс не оплачен или уже возвращен полностью")
+	ErrRefundTooLarge   = errors.New("сумма возврата больше оплаченной")
+	ErrBindingNotFound  = errors.New("привязка карты не найдена")
+)
+
+var payPage = template.Must(template.New("pay").Parse(`<!DOCTYPE html>
+<html lang="ru">
+<head><meta charset="utf-8"><title>Mock bank: инвойс {{.Id}}</title></head>
+<body>
+<h1>Инвойс {{.Id}}</h1>
+<p>{{.Purpose}}</p>
+<p>Сумма: {{.Amount}} {{.Currency}} в минимальных единицах валюты</p>
+<p>Статус: {{.Status}}</p>
+{{if eq .Status "created"}}
+<form method="post" action="/pay/{{.Id}}/approve"><button>Оплатить</button></form>
+<form method="post" action="/pay/{{.Id}}/decline"><button>Отклонить</button></form>
+<form method="post" action="/pay/{{.Id}}/timeout"><button>Истекло время оплаты</button></form>
+{{end}}
+{{if or (eq .Status "paid") (eq .Status "partially_refunded")}}
+<p>Возвращено: {{.Refunded}} {{.Currency}}</p>
+<form method="post" action="/pay/{{.Id}}/refund"><input name="amount" type="number" min="1" max="{{.Amount}}"><button>Вернуть</button></form>
+{{end}}
+</body>
+</html>
+`))
+
+// invoice - это инвойс в банке вместе с адресами возврата пользователя после оплаты, суммой возвратов
+// и привязкой карты, сохраненной при оплате рекуррентного инвойса.
+type invoice struct {
+	id         uint
+	data       entity.InvoiceData
+	status     string
+	refunded   int
+	bindingId  string
+	successUrl string
+	failUrl    string
+}
+
+// Bank - это банк в памяти. Выставляет инвойсы, отдает форму оплаты и по кнопкам на ней подтверждает,
+// отклоняет или просрочивает оплату, отправляя сервису подписанное уведомление. Инвойс, не оплаченный
+// до expiration_date, просрочивается сам. По оплаченному инвойсу можно вернуть деньги полностью или частично.
+// При оплате рекуррентного инвойса банк привязывает карту, с которой потом можно списывать деньги без формы.
+type Bank struct {
+	mu            sync.Mutex
+	accessToken   string
+	delay         time.Duration
+	webhookUrl    string
+	webhookSecret string
+	lastId        uint
+	invoices      map[uint]*invoice
+	bindings      map[string]bool
+	client        *http.Client
+}
+
+// NewBank - это билдер для Bank. Если accessToken не пустой, запросы к API без этого токена отклоняются.
+func NewBank(accessToken string) *Bank {
+	return &Bank{
+		accessToken: accessToken,
+		invoices:    make(map[uint]*invoice),
+		bindings:    make(map[string]bool),
+		client:      &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// SetWebhook задает адрес и секрет для уведомлений о статусе инвойса. Без адреса уведомления не отправляются.
+func (bank *Bank) SetWebhook(webhookUrl, secret string) {
+	bank.mu.Lock()
+	defer bank.mu.Unlock()
+
+	bank.webhookUrl = webhookUrl
+	bank.webhookSecret = secret
+}
+
+// SetDelay задает задержку ответов API, чтобы проверить таймауты клиента.
+func (bank *Bank) SetDelay(delay time.Duration) {
+	bank.mu.Lock()
+	defer bank.mu.Unlock()
+
+	bank.delay = delay
+}
+
+// Handler возвращает HTTP обработчик банка: API инвойсов под /v1 и форму оплаты под /pay.
+func (bank *Bank) Handler() http.Handler {
+	router := gin.New()
+
+	api := router.Group("/v1", bank.withDelay, bank.withAccessToken)
+	api.POST("/invoices", bank.createInvoice)
+	api.GET("/invoices/:id", bank.getInvoice)
+	api.POST("/invoices/:id/payment", bank.createPaymentForm)
+	api.POST("/invoices/:id/refund", bank.createRefund)
+	api.POST("/invoices/:id/recurrent", bank.chargeBinding)
+
+	router.GET("/pay/:id", bank.showPaymentForm)
+	router.POST("/pay/:id/approve", bank.complete(bank.Approve))
+	router.POST("/pay/:id/decline", bank.complete(bank.Decline))
+	router.POST("/pay/:id/timeout", bank.complete(bank.Expire))
+	router.POST("/pay/:id/refund", bank.refundFromForm)
+
+	return router
+}
+
+// Status возвращает статус инвойса или ошибку, если инвойса нет.
+func (bank *Bank) Status(id uint) (string, error) {
+	bank.mu.Lock()
+	defer bank.mu.Unlock()
+
+	invoice, ok := bank.invoices[id]
+	if !ok {
+		return "", ErrInvoiceNotFound
+	}
+	bank.expireIfOverdue(invoice)
+
+	return invoice.status, nil
+}
+
+// Approve подтверждает оплату инвойса. Возвращает адрес, на который банк возвращает пользователя, или ошибку.
+func (bank *Bank) Approve(id uint) (string, error) {
+	return bank.finish(id, billing.InvoiceStatusPaid)
+}
+
+// Decline отклоняет оплату инвойса. Возвращает адрес, на который банк возвращает пользователя, или ошибку.
+func (bank *Bank) Decline(id uint) (string, error) {
+	return bank.finish(id, billing.InvoiceStatusDeclined)
+}
+
+// Expire просрочивает инвойс, как будто пользователь не оплатил его вовремя. Пользователь никуда не возвращается.
+func (bank *Bank) Expire(id uint) (string, error) {
+	return bank.finish(id, billing.InvoiceStatusExpired)
+}
+
+// DeclineBinding заставляет банк отклонять списания с привязанной карты, как будто на ней закончились деньги.
+func (bank *Bank) DeclineBinding(bindingId string) {
+	bank.mu.Lock()
+	defer bank.mu.Unlock()
+
+	if _, ok := bank.bindings[bindingId]; ok {
+		bank.bindings[bindingId] = false
+	}
+}
+
+// Refund возвращает amount по оплаченному инвойсу, как будто возврат начали в банке, и отправляет сервису
+// уведомление. Возвращает общую сумму возврата по инвойсу или ошибку.
+func (bank *Bank) Refund(id uint, amount int) (int, error) {
+	refunded, err := bank.refund(id, amount)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := bank.Notify(id); err != nil {
+		return 0, err
+	}
+
+	return refunded, nil
+}
+
+func (bank *Bank) refund(id uint, amount int) (int, error) {
+	bank.mu.Lock()
+	defer bank.mu.Unlock()
+
+	invoice, ok := bank.invoices[id]
+	if !ok {
+		return 0, ErrInvoiceNotFound
+	}
+
+	if invoice.status != billing.InvoiceStatusPaid && invoice.status != billing.InvoiceStatusPartiallyRefunded {
+		return 0, ErrInvoiceNotPaid
+	}
+
+	price := invoice.data.Invoice.Order.Amount
+	if amount <= 0 || invoice.refunded+amount > price {
+		return 0, ErrRefundTooLarge
+	}
+
+	invoice.refunded += amount
+	invoice.status = billing.InvoiceStatusPartiallyRefunded
+	if invoice.refunded == price {
+		invoice.status = billing.InvoiceStatusRefunded
+	}
+
+	return invoice.refunded, nil
+}
+
+// finish меняет статус инвойса и отправляет уведомление сервису до того, как пользователь вернется на сервис.
+func (bank *Bank) finish(id uint, status string) (string, error) {
+	bank.mu.Lock()
+	invoice, ok := bank.invoices[id]
+	if !ok {
+		bank.mu.Unlock()
+		return "", ErrInvoiceNotFound
+	}
+	bank.expireIfOverdue(invoice)
+
+	if invoice.status != billing.InvoiceStatusCreated {
+		bank.mu.Unlock()
+		return "", ErrInvoiceNotActive
+	}
+	if invoice.successUrl == "" {
+		bank.mu.Unlock()
+		return "", ErrNoPaymentForm
+	}
+
+	invoice.status = status
+	if status == billing.InvoiceStatusPaid && invoice.data.Recurrent {
+		invoice.bindingId = fmt.Sprintf("binding-%d", id)
+		bank.bindings[invoice.bindingId] = true
+	}
+	successUrl, failUrl := invoice.successUrl, invoice.failUrl
+	bank.mu.Unlock()
+
+	if err := bank.Notify(id); err != nil {
+		return "", err
+	}
+
+	switch status {
+	case billing.InvoiceStatusPaid:
+		return successUrl, nil
+	case billing.InvoiceStatusDeclined:
+		return failUrl, nil
+	default:
+		return "", nil
+	}
+}
+
+// Notify отправляет сервису подписанное уведомление о текущем статусе инвойса. Повторный вызов имитирует
+// повторную доставку уведомления банком. Возвращает ошибку, если сервис не принял уведомление.
+func (bank *Bank) Notify(id uint) error {
+	bank.mu.Lock()
+	invoice, ok := bank.invoices[id]
+	if !ok {
+		bank.mu.Unlock()
+		return ErrInvoiceNotFound
+	}
+	webhookUrl, secret := bank.webhookUrl, bank.webhookSecret
+	payload, err := json.Marshal(entity.SberWebhook{InvoiceId: id, Status: invoice.status, RefundedAmount: invoice.refunded, BindingId: invoice.bindingId})
+	bank.mu.Unlock()
+
+	if err != nil || webhookUrl == "" {
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, webhookUrl, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(billing.WebhookSignatureHeader, billing.SignWebhook(secret, payload))
+
+	resp, err := bank.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%w: статус %d", ErrWebhookRejected, resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (bank *Bank) expireIfOverdue(invoice *invoice) {
+	expiresAt := invoice.data.Invoice.Order.ExpirationDate
+	if invoice.status == billing.InvoiceStatusCreated && !expiresAt.IsZero() && time.Now().After(expiresAt) {
+		invoice.status = billing.InvoiceStatusExpired
+	}
+}
+
+func (bank *Bank) withDelay(ctx *gin.Context) {
+	bank.mu.Lock()
+	delay := bank.delay
+	bank.mu.Unlock()
+
+	if delay == 0 {
+		return
+	}
+
+	select {
+	case <-time.After(delay):
+	case <-ctx.Request.Context().Done():
+		ctx.Abort()
+	}
+}
+
+func (bank *Bank) withAccessToken(ctx *gin.Context) {
+	if bank.accessToken != "" && ctx.GetHeader("Authorization") != "Bearer "+bank.accessToken {
+		ctx.AbortWithStatusJSON(http.StatusUnauthorized, entity.SberError{Code: "unauthorized", Description: "неверный токен доступа"})
+	}
+}
+
+func (bank *Bank) createInvoice(ctx *gin.Context) {
+	var data entity.InvoiceData
+	if err := ctx.ShouldBindJSON(&data); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, entity.SberError{Code: "bad_request", Description: err.Error()})
+		return
+	}
+
+	if data.Invoice.Order.OrderID == "" || data.Invoice.Order.Amount <= 0 {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, entity.SberError{Code: "bad_request", Description: "не указан заказ или сумма"})
+		return
+	}
+
+	bank.mu.Lock()
+	bank.lastId++
+	created := &invoice{
+		id:     bank.lastId,
+		data:   data,
+		status: billing.InvoiceStatusCreated,
+	}
+	bank.invoices[created.id] = created
+	bank.mu.Unlock()
+
+	ctx.JSON(http.StatusCreated, entity.SberInvoice{InvoiceId: created.id, Status: created.status})
+}
+
+func (bank *Bank) getInvoice(ctx *gin.Context) {
+	id, ok := invoiceId(ctx)
+	if !ok {
+		return
+	}
+
+	status, err := bank.Status(id)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, entity.SberError{Code: "not_found", Description: err.Error()})
+		return
+	}
+
+	bank.mu.Lock()
+	refunded, bindingId := bank.invoices[id].refunded, bank.invoices[id].bindingId
+	bank.mu.Unlock()
+
+	ctx.JSON(http.StatusOK, entity.SberInvoice{InvoiceId: id, Status: status, RefundedAmount: refunded, BindingId: bindingId})
+}
+
+// chargeBinding оплачивает инвойс с привязанной карты. Сервис сам записывает результат списания по ответу,
+// поэтому уведомление не отправляется.
+func (bank *Bank) chargeBinding(ctx *gin.Context) {
+	id, ok := invoiceId(ctx)
+	if !ok {
+		return
+	}
+
+	var request entity.SberRecurrentRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, entity.SberError{Code: "bad_request", Description: err.Error()})
+		return
+	}
+
+	bank.mu.Lock()
+	defer bank.mu.Unlock()
+
+	invoice, found := bank.invoices[id]
+	if !found {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, entity.SberError{Code: "not_found", Description: ErrInvoiceNotFound.Error()})
+		return
+	}
+
+	active, found := bank.bindings[request.BindingId]
+	if !found {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, entity.SberError{Code: "bad_binding", Description: ErrBindingNotFound.Error()})
+		return
+	}
+
+	bank.expireIfOverdue(invoice)
+	if invoice.status != billing.InvoiceStatusCreated {
+		ctx.AbortWithStatusJSON(http.StatusConflict, entity.SberError{Code: "not_active", Description: ErrInvoiceNotActive.Error()})
+		return
+	}
+
+	invoice.status = billing.InvoiceStatusDeclined
+	if active {
+		invoice.status = billing.InvoiceStatusPaid
+		invoice.bindingId = request.BindingId
+	}
+
+	ctx.JSON(http.StatusOK, entity.SberInvoice{InvoiceId: id, Status: invoice.status})
+}
+
+// createRefund возвращает деньги по запросу сервиса. Сервис сам записывает такой возврат по ответу,
+// поэтому уведомление отправляется только о возвратах, начатых в банке.
+func (bank *Bank) createRefund(ctx *gin.Context) {
+	id, ok := invoiceId(ctx)
+	if !ok {
+		return
+	}
+
+	var request entity.SberRefundRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, entity.SberError{Code: "bad_request", Description: err.Error()})
+		return
+	}
+
+	refunded, err := bank.refund(id, request.Amount)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrInvoiceNotFound):
+			ctx.AbortWithStatusJSON(http.StatusNotFound, entity.SberError{Code: "not_found", Description: err.Error()})
+		case errors.Is(err, ErrInvoiceNotPaid):
+			ctx.AbortWithStatusJSON(http.StatusConflict, entity.SberError{Code: "not_paid", Description: err.Error()})
+		default:
+			ctx.AbortWithStatusJSON(http.StatusBadRequest, entity.SberError{Code: "bad_amount", Description: err.Error()})
+		}
+		return
+	}
+
+	status, _ := bank.Status(id)
+	ctx.JSON(http.StatusOK, entity.SberInvoice{InvoiceId: id, Status: status, RefundedAmount: refunded})
+}
+
+func (bank *Bank) createPaymentForm(ctx *gin.Context) {
+	id, ok := invoiceId(ctx)
+	if !ok {
+		return
+	}
+
+	var request entity.SberPaymentRequest
+	if err := ctx.ShouldBindJSON(&request); err != nil || request.SuccessUrl == "" || request.FailUrl == "" {
+		ctx.AbortWithStatusJSON(http.StatusBadRequest, entity.SberError{Code: "bad_request", Description: "не указаны адреса возврата"})
+		return
+	}
+
+	bank.mu.Lock()
+	invoice, found := bank.invoices[id]
+	if found {
+		invoice.successUrl = request.SuccessUrl
+		invoice.failUrl = request.FailUrl
+	}
+	bank.mu.Unlock()
+
+	if !found {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, entity.SberError{Code: "not_found", Description: ErrInvoiceNotFound.Error()})
+		return
+	}
+
+	scheme := "http"
+	if ctx.Request.TLS != nil {
+		scheme = "https"
+	}
+
+	ctx.JSON(http.StatusOK, entity.SberPaymentForm{FormUrl: fmt.Sprintf("%v://%v/pay/%d", scheme, ctx.Request.Host, id)})
+}
+
+func (bank *Bank) showPaymentForm(ctx *gin.Context) {
+	id, ok := invoiceId(ctx)
+	if !ok {
+		return
+	}
+
+	status, err := bank.Status(id)
+	if err != nil {
+		ctx.String(http.StatusNotFound, err.Error())
+		return
+	}
+
+	bank.mu.Lock()
+	order := bank.invoices[id].data.Invoice.Order
+	refunded := bank.invoices[id].refunded
+	bank.mu.Unlock()
+
+	ctx.Status(http.StatusOK)
+	ctx.Header("Content-Type", "text/html; charset=utf-8")
+	payPage.Execute(ctx.Writer, map[string]interface{}{
+		"Id":       id,
+		"Purpose":  order.Purpose,
+		"Amount":   order.Amount,
+		"Currency": order.Currency,
+		"Status":   status,
+		"Refunded": refunded,
+	})
+}
+
+// refundFromForm возвращает деньги по кнопке с формы и показывает форму заново.
+func (bank *Bank) refundFromForm(ctx *gin.Context) {
+	id, ok := invoiceId(ctx)
+	if !ok {
+		return
+	}
+
+	amount, err := strconv.Atoi(ctx.PostForm("amount"))
+	if err != nil {
+		ctx.String(http.StatusBadRequest, ErrRefundTooLarge.Error())
+		return
+	}
+
+	if _, err := bank.Refund(id, amount); err != nil {
+		switch {
+		case errors.Is(err, ErrInvoiceNotFound):
+			ctx.String(http.StatusNotFound, err.Error())
+		case errors.Is(err, ErrInvoiceNotPaid), errors.Is(err, ErrRefundTooLarge):
+			ctx.String(http.StatusConflict, err.Error())
+		default:
+			ctx.String(http.StatusBadGateway, err.Error())
+		}
+		return
+	}
+
+	ctx.Redirect(http.StatusSeeOther, fmt.Sprintf("/pay/%d", id))
+}
+
+// complete завершает оплату по кнопке с формы и возвращает пользователя на адрес сервиса.
+func (bank *Bank) complete(finish func(id uint) (string, error)) gin.HandlerFunc {
+	return func(ctx *gin.Context) {
+		id, ok := invoiceId(ctx)
+		if !ok {
+			return
+		}
+
+		returnUrl, err := finish(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, ErrInvoiceNotFound):
+				ctx.String(http.StatusNotFound, err.Error())
+			case errors.Is(err, ErrInvoiceNotActive), errors.Is(err, ErrNoPaymentForm):
+				ctx.String(http.StatusConflict, err.Error())
+			default:
+				ctx.String(http.StatusBadGateway, err.Error())
+			}
+			return
+		}
+
+		if returnUrl == "" {
+			ctx.String(http.StatusOK, "время оплаты истекло")
+			return
+		}
+
+		ctx.Redirect(http.StatusSeeOther, returnUrl)
+	}
+}
+
+func invoiceId(ctx *gin.Context) (uint, bool) {
+	id, err := strconv.ParseUint(strings.TrimSpace(ctx.Param("id")), 10, 64)
+	if err != nil {
+		ctx.AbortWithStatusJSON(http.StatusNotFound, entity.SberError{Code: "not_found", Description: ErrInvoiceNotFound.Error()})
+		return 0, false
+	}
+
+	return uint(id), true
+}
@@ -0,0 +1,268 @@
+package billing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knstch/course/internal/app/config"
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+const (
+	// FiscalProviderOfd и FiscalProviderMemory - это допустимые значения FISCAL_PROVIDER.
+	FiscalProviderOfd    = "ofd"
+	FiscalProviderMemory = "memory"
+
+	// FiscalStatusWait, FiscalStatusDone и FiscalStatusFail - это статусы чека в онлайн-кассе.
+	FiscalStatusWait = "wait"
+	FiscalStatusDone = "done"
+	FiscalStatusFail = "fail"
+)
+
+var (
+	errUnknownFiscalProvider = errors.New("неизвестная онлайн-касса")
+	errReceiptRejected       = errors.New("онлайн-касса отклонила чек")
+	errOfdUnavailable        = errors.New("онлайн-касса недоступна")
+)
+
+// FiscalProvider - это онлайн-касса, которая регистрирует чеки по 54-ФЗ и передает их в ОФД.
+type FiscalProvider interface {
+	SendReceipt(ctx context.Context, receipt *dto.Receipt) (string, *courseError.CourseError)
+	GetReceipt(ctx context.Context, receiptId string) (*dto.FiscalData, *courseError.CourseError)
+}
+
+// NewFiscalProvider собирает онлайн-кассу по FISCAL_PROVIDER: ofd (по умолчанию) или memory.
+func NewFiscalProvider(config *config.Config) (FiscalProvider, error) {
+	switch config.FiscalProvider {
+	case FiscalProviderOfd, "":
+		return NewOfdClient(config), nil
+	case FiscalProviderMemory:
+		return NewMemoryOfd(), nil
+	default:
+		return nil, fmt.Errorf("%w: %v", errUnknownFiscalProvider, config.FiscalProvider)
+	}
+}
+
+// OfdClient - это HTTP клиент API онлайн-кассы. Чеки регистрируются от имени компании с ИНН FISCAL_INN,
+// каждый запрос ограничен OFD_API_TIMEOUT.
+type OfdClient struct {
+	apiHost     string
+	accessToken string
+	company     entity.FiscalCompany
+
+	client  *http.Client
+	timeout time.Duration
+}
+
+// NewOfdClient - это билдер для OfdClient.
+func NewOfdClient(config *config.Config) *OfdClient {
+	return &OfdClient{
+		apiHost:     config.OfdApiHost,
+		accessToken: config.OfdAccessToken,
+		company: entity.FiscalCompany{
+			Email:          config.FiscalCompanyEmail,
+			Inn:            config.FiscalInn,
+			PaymentAddress: config.FiscalPaymentAddress,
+		},
+		client:  &http.Client{},
+		timeout: config.OfdApiTimeout,
+	}
+}
+
+// SendReceipt отправляет чек на регистрацию. Повторная отправка чека за тот же заказ не регистрирует
+// его дважды. Возвращает ID чека в онлайн-кассе или ошибку.
+func (ofd *OfdClient) SendReceipt(ctx context.Context, receipt *dto.Receipt) (string, *courseError.CourseError) {
+	var report entity.FiscalReport
+	if err := ofd.do(ctx, http.MethodPost, "/v1/receipts", entity.CreateFiscalReceipt(*receipt, ofd.company), &report); err != nil {
+		return "", err
+	}
+
+	if report.Status == FiscalStatusFail {
+		return "", fiscalError(report.Error)
+	}
+
+	return report.Uuid, nil
+}
+
+// GetReceipt запрашивает статус чека. Возвращает фискальные данные зарегистрированного чека, nil, если
+// чек еще регистрируется, или ошибку 15028, если онлайн-касса его отклонила.
+func (ofd *OfdClient) GetReceipt(ctx context.Context, receiptId string) (*dto.FiscalData, *courseError.CourseError) {
+	var report entity.FiscalReport
+	if err := ofd.do(ctx, http.MethodGet, fmt.Sprintf("/v1/receipts/%s", receiptId), nil, &report); err != nil {
+		return nil, err
+	}
+
+	switch report.Status {
+	case FiscalStatusDone:
+		if report.Payload == nil {
+			return nil, courseError.CreateError(fmt.Errorf("%w: нет фискальных данных", errOfdUnavailable), 15029)
+		}
+		return entity.CreateFiscalData(*report.Payload), nil
+	case FiscalStatusFail:
+		return nil, fiscalError(report.Error)
+	default:
+		return nil, nil
+	}
+}
+
+// do отправляет запрос в API онлайн-кассы и декодирует ответ в result. Недоступная касса, таймаут и ответы 5xx
+// возвращают ошибку 15029, отказ кассы - 15028.
+func (ofd *OfdClient) do(ctx context.Context, method, path string, body, result interface{}) *courseError.CourseError {
+	var payload io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return courseError.CreateError(err, 10101)
+		}
+		payload = bytes.NewReader(encoded)
+	}
+
+	requestCtx, cancel := context.WithTimeout(ctx, ofd.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(requestCtx, method, strings.TrimSuffix(ofd.apiHost, "/")+path, payload)
+	if err != nil {
+		return courseError.CreateError(err, 15029)
+	}
+	req.Header.Set("Authorization", "Bearer "+ofd.accessToken)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := ofd.client.Do(req)
+	if err != nil {
+		return courseError.CreateError(fmt.Errorf("%w: %v", errOfdUnavailable, err), 15029)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return courseError.CreateError(fmt.Errorf("%w: статус %d", errOfdUnavailable, resp.StatusCode), 15029)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var report entity.FiscalReport
+		json.NewDecoder(resp.Body).Decode(&report)
+
+		return fiscalError(report.Error)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return courseError.CreateError(fmt.Errorf("%w: %v", errOfdUnavailable, err), 15029)
+	}
+
+	return nil
+}
+
+// fiscalError собирает ошибку 15028 с причиной отказа онлайн-кассы.
+func fiscalError(reason *entity.FiscalError) *courseError.CourseError {
+	if reason == nil {
+		return courseError.CreateError(errReceiptRejected, 15028)
+	}
+	return courseError.CreateError(fmt.Errorf("%w: %v %v", errReceiptRejected, reason.Code, reason.Text), 15028)
+}
+
+// memoryReceipt - это чек в MemoryOfd вместе с фискальными данными или причиной отказа.
+type memoryReceipt struct {
+	receipt      dto.Receipt
+	fiscalData   dto.FiscalData
+	rejectReason string
+}
+
+// MemoryOfd - это онлайн-касса в памяти. Сразу регистрирует чеки и выдает им фискальные данные, а после
+// SetRejectReason отклоняет новые чеки. Используется для разработки и в тестах, чтобы проверять
+// собранные чеки без ОФД.
+type MemoryOfd struct {
+	mu           sync.Mutex
+	lastNumber   uint
+	rejectReason string
+	receipts     map[string]*memoryReceipt
+	orders       map[string]string
+}
+
+// NewMemoryOfd - это билдер для MemoryOfd.
+func NewMemoryOfd() *MemoryOfd {
+	return &MemoryOfd{
+		receipts: make(map[string]*memoryReceipt),
+		orders:   make(map[string]string),
+	}
+}
+
+// SetRejectReason задает причину, по которой будут отклоняться новые чеки. С пустой причиной чеки снова регистрируются.
+func (ofd *MemoryOfd) SetRejectReason(reason string) {
+	ofd.mu.Lock()
+	defer ofd.mu.Unlock()
+
+	ofd.rejectReason = reason
+}
+
+func (ofd *MemoryOfd) SendReceipt(ctx context.Context, receipt *dto.Receipt) (string, *courseError.CourseError) {
+	ofd.mu.Lock()
+	defer ofd.mu.Unlock()
+
+	if receiptId, ok := ofd.orders[receipt.Order]; ok && ofd.receipts[receiptId].rejectReason == "" {
+		return receiptId, nil
+	}
+
+	ofd.lastNumber++
+	receiptId := fmt.Sprintf("memory-%d", ofd.lastNumber)
+	fiscalizedAt := time.Now().UTC()
+
+	ofd.receipts[receiptId] = &memoryReceipt{
+		receipt: *receipt,
+		fiscalData: dto.FiscalData{
+			FiscalDriveNumber:    "9999078900000000",
+			FiscalDocumentNumber: ofd.lastNumber,
+			FiscalSign:           fmt.Sprintf("%010d", ofd.lastNumber),
+			RegisterNumber:       "0000000000000000",
+			FiscalizedAt:         &fiscalizedAt,
+			ReceiptUrl:           fmt.Sprintf("https://ofd.example/receipts/%s", receiptId),
+		},
+		rejectReason: ofd.rejectReason,
+	}
+	ofd.orders[receipt.Order] = receiptId
+
+	return receiptId, nil
+}
+
+func (ofd *MemoryOfd) GetReceipt(ctx context.Context, receiptId string) (*dto.FiscalData, *courseError.CourseError) {
+	ofd.mu.Lock()
+	defer ofd.mu.Unlock()
+
+	stored, ok := ofd.receipts[receiptId]
+	if !ok {
+		return nil, fiscalError(&entity.FiscalError{Text: "чек не найден"})
+	}
+
+	if stored.rejectReason != "" {
+		return nil, fiscalError(&entity.FiscalError{Text: stored.rejectReason})
+	}
+
+	fiscalData := stored.fiscalData
+	return &fiscalData, nil
+}
+
+// Receipts возвращает зарегистрированные чеки в порядке отправки.
+func (ofd *MemoryOfd) Receipts() []dto.Receipt {
+	ofd.mu.Lock()
+	defer ofd.mu.Unlock()
+
+	receipts := make([]dto.Receipt, 0, len(ofd.receipts))
+	for number := uint(1); number <= ofd.lastNumber; number++ {
+		if stored, ok := ofd.receipts[fmt.Sprintf("memory-%d", number)]; ok && stored.rejectReason == "" {
+			receipts = append(receipts, stored.receipt)
+		}
+	}
+
+	return receipts
+}
@@ -0,0 +1,55 @@
+package billing
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/validation"
+	"github.com/knstch/course/internal/domain/dto"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+// SetCoursePrices используется админом для задания цен курса в валютах, отличных от рубля. Принимает ID курса
+// и прайс-лист, валидирует их и перезаписывает прайс-лист курса. Валюты, которых нет в прайс-листе, перестают
+// продаваться. Цена в рублях задается в самом курсе. Возвращает ошибку.
+func (billing SberBillingService) SetCoursePrices(ctx context.Context, id string, pricesToSave *entity.CoursePricesToSave) *courseError.CourseError {
+	for i := range pricesToSave.Prices {
+		pricesToSave.Prices[i].Currency = strings.ToUpper(strings.TrimSpace(pricesToSave.Prices[i].Currency))
+	}
+
+	if err := validation.NewStringIdToValidate(id).Validate(ctx); err != nil {
+		return err
+	}
+
+	if err := validation.NewCoursePricesToValidate(pricesToSave).Validate(ctx); err != nil {
+		return err
+	}
+
+	courseId, _ := strconv.Atoi(id)
+
+	prices := make([]dto.CoursePrice, 0, len(pricesToSave.Prices))
+	for _, v := range pricesToSave.Prices {
+		prices = append(prices, *dto.CreateNewCoursePrice(uint(courseId), v.Currency, v.Amount))
+	}
+
+	return billing.banker.StoreCoursePrices(ctx, uint(courseId), prices)
+}
+
+// RetreiveCoursePrices используется для просмотра цен курса во всех валютах, в которых он продается. Принимает
+// ID курса, валидирует его и возвращает цены, начиная с цены в рублях, или ошибку.
+func (billing SberBillingService) RetreiveCoursePrices(ctx context.Context, id string) ([]entity.CoursePrice, *courseError.CourseError) {
+	if err := validation.NewStringIdToValidate(id).Validate(ctx); err != nil {
+		return nil, err
+	}
+
+	courseId, _ := strconv.Atoi(id)
+
+	prices, err := billing.banker.GetCoursePrices(ctx, uint(courseId))
+	if err != nil {
+		return nil, err
+	}
+
+	return entity.CreateCoursePrices(prices), nil
+}
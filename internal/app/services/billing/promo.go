@@ -0,0 +1,109 @@
+package billing
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/validation"
+	"github.com/knstch/course/internal/domain/dto"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+// CreatePromoCode используется админом для создания промокода. Принимает условия промокода, приводит код
+// к верхнему регистру, валидирует условия и сохраняет промокод. Возвращает созданный промокод или ошибку.
+func (billing SberBillingService) CreatePromoCode(ctx context.Context, promoToSave *entity.PromoCodeToSave) (*entity.PromoCode, *courseError.CourseError) {
+	promo, err := billing.preparePromoCode(ctx, promoToSave)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := billing.banker.StorePromoCode(ctx, promo); err != nil {
+		return nil, err
+	}
+
+	return entity.CreatePromoCode(*promo), nil
+}
+
+// EditPromoCode используется админом для изменения условий промокода. Принимает ID промокода и новые условия,
+// валидирует их и перезаписывает промокод. Уже сделанные по промокоду заказы не меняются. Возвращает ошибку.
+func (billing SberBillingService) EditPromoCode(ctx context.Context, id string, promoToSave *entity.PromoCodeToSave) *courseError.CourseError {
+	if err := validation.NewStringIdToValidate(id).Validate(ctx); err != nil {
+		return err
+	}
+
+	promo, err := billing.preparePromoCode(ctx, promoToSave)
+	if err != nil {
+		return err
+	}
+
+	promoId, _ := strconv.Atoi(id)
+	promo.ID = uint(promoId)
+
+	return billing.banker.UpdatePromoCode(ctx, promo)
+}
+
+// RemovePromoCode используется админом для удаления промокода. Принимает ID промокода и возвращает ошибку.
+func (billing SberBillingService) RemovePromoCode(ctx context.Context, id string) *courseError.CourseError {
+	if err := validation.NewStringIdToValidate(id).Validate(ctx); err != nil {
+		return err
+	}
+
+	promoId, _ := strconv.Atoi(id)
+
+	return billing.banker.DeletePromoCode(ctx, uint(promoId))
+}
+
+// RetreivePromoCodes используется для просмотра промокодов. Принимает страницу и лимит, валидирует их и
+// возвращает промокоды с количеством использований и суммой скидок вместе с пагинацией или ошибку.
+func (billing SberBillingService) RetreivePromoCodes(ctx context.Context, page, limit string) (*entity.PromoCodesWithPagination, *courseError.CourseError) {
+	if err := validation.NewReportsQueryToValidate(page, limit).Validate(ctx); err != nil {
+		return nil, err
+	}
+
+	pageInt, _ := strconv.Atoi(page)
+	limitInt, _ := strconv.Atoi(limit)
+
+	promos, stats, totalCount, err := billing.banker.GetPromoCodes(ctx, limitInt, pageInt*limitInt)
+	if err != nil {
+		return nil, err
+	}
+
+	pagesCount := int(totalCount) / limitInt
+	if int(totalCount)%limitInt != 0 {
+		pagesCount++
+	}
+
+	return &entity.PromoCodesWithPagination{
+		Pagination: entity.Pagination{
+			Page:       pageInt,
+			Limit:      limitInt,
+			TotalCount: int(totalCount),
+			PagesCount: pagesCount,
+		},
+		PromoCodes: entity.CreatePromoCodes(promos, stats),
+	}, nil
+}
+
+// preparePromoCode приводит код к верхнему регистру, валидирует условия промокода и собирает его модель.
+// Если активность не передана, промокод включен.
+func (billing SberBillingService) preparePromoCode(ctx context.Context, promoToSave *entity.PromoCodeToSave) (*dto.PromoCode, *courseError.CourseError) {
+	promoToSave.Code = strings.ToUpper(strings.TrimSpace(promoToSave.Code))
+
+	if err := validation.NewPromoCodeToValidate(promoToSave).Validate(ctx); err != nil {
+		return nil, err
+	}
+
+	active := true
+	if promoToSave.Active != nil {
+		active = *promoToSave.Active
+	}
+
+	return dto.CreateNewPromoCode(promoToSave.Code, promoToSave.DiscountType, promoToSave.Value).
+		AddCourseId(promoToSave.CourseId).
+		AddLimits(promoToSave.MaxRedemptions, promoToSave.MaxPerUser).
+		AddValidity(promoToSave.ValidFrom, promoToSave.ValidUntil).
+		SetFirstPurchaseOnly(promoToSave.FirstPurchaseOnly).
+		SetActive(active), nil
+}
@@ -0,0 +1,198 @@
+package billing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/knstch/course/internal/app/config"
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+const (
+	// InvoiceStatusCreated, InvoiceStatusPaid, InvoiceStatusDeclined и InvoiceStatusExpired - это статусы инвойса в банке.
+	InvoiceStatusCreated  = "created"
+	InvoiceStatusPaid     = "paid"
+	InvoiceStatusDeclined = "declined"
+	InvoiceStatusExpired  = "expired"
+
+	// InvoiceStatusPartiallyRefunded и InvoiceStatusRefunded - это статусы оплаченного инвойса после частичного
+	// и полного возврата.
+	InvoiceStatusPartiallyRefunded = "partially_refunded"
+	InvoiceStatusRefunded          = "refunded"
+)
+
+var (
+	errBankRejected    = errors.New("банк отклонил запрос")
+	errBankUnavailable = errors.New("банк недоступен")
+)
+
+// PaymentProvider - это платежный шлюз, в котором выставляются инвойсы и формируются ссылки на оплату.
+type PaymentProvider interface {
+	CreateInvoice(ctx context.Context, invoice entity.InvoiceData) (uint, *courseError.CourseError)
+	CreatePayLink(ctx context.Context, invoiceId uint, successUrl, failUrl string) (string, *courseError.CourseError)
+	GetInvoiceStatus(ctx context.Context, invoiceId uint) (string, *courseError.CourseError)
+	Refund(ctx context.Context, invoiceId uint, amount int) (int, *courseError.CourseError)
+	ChargeBinding(ctx context.Context, invoiceId uint, bindingId string) (string, *courseError.CourseError)
+	SetApiHost(apiHost string)
+	SetAccessToken(token string)
+}
+
+// SberClient - это HTTP клиент API инвойсов сбербанка. Хост и токен можно поменять из админки
+// без перезапуска сервиса, каждый запрос ограничен SBER_API_TIMEOUT.
+type SberClient struct {
+	mu          sync.RWMutex
+	apiHost     string
+	accessToken string
+
+	client  *http.Client
+	timeout time.Duration
+}
+
+// NewSberClient - это билдер для SberClient. У клиента свой HTTP клиент, так как банк может отвечать дольше,
+// чем позволяет общий клиент сервиса.
+func NewSberClient(config *config.Config) *SberClient {
+	return &SberClient{
+		apiHost:     config.SberApiHost,
+		accessToken: config.SberAccessToken,
+		client:      &http.Client{},
+		timeout:     config.SberApiTimeout,
+	}
+}
+
+// CreateInvoice выставляет инвойс в банке. Возвращает ID инвойса или ошибку.
+func (sber *SberClient) CreateInvoice(ctx context.Context, invoice entity.InvoiceData) (uint, *courseError.CourseError) {
+	var created entity.SberInvoice
+	if err := sber.do(ctx, http.MethodPost, "/v1/invoices", invoice, &created); err != nil {
+		return 0, err
+	}
+
+	return created.InvoiceId, nil
+}
+
+// CreatePayLink запрашивает у банка форму оплаты инвойса. Банк возвращает пользователя на successUrl
+// после оплаты и на failUrl после отказа. Возвращает ссылку на форму оплаты или ошибку.
+func (sber *SberClient) CreatePayLink(ctx context.Context, invoiceId uint, successUrl, failUrl string) (string, *courseError.CourseError) {
+	request := entity.SberPaymentRequest{
+		SuccessUrl: successUrl,
+		FailUrl:    failUrl,
+	}
+
+	var form entity.SberPaymentForm
+	if err := sber.do(ctx, http.MethodPost, fmt.Sprintf("/v1/invoices/%d/payment", invoiceId), request, &form); err != nil {
+		return "", err
+	}
+
+	return form.FormUrl, nil
+}
+
+// GetInvoiceStatus возвращает статус инвойса в банке или ошибку.
+func (sber *SberClient) GetInvoiceStatus(ctx context.Context, invoiceId uint) (string, *courseError.CourseError) {
+	var invoice entity.SberInvoice
+	if err := sber.do(ctx, http.MethodGet, fmt.Sprintf("/v1/invoices/%d", invoiceId), nil, &invoice); err != nil {
+		return "", err
+	}
+
+	return invoice.Status, nil
+}
+
+// Refund возвращает пользователю amount в минимальных единицах валюты по оплаченному инвойсу. Возвращает общую
+// сумму возврата по инвойсу с учетом прошлых возвратов или ошибку.
+func (sber *SberClient) Refund(ctx context.Context, invoiceId uint, amount int) (int, *courseError.CourseError) {
+	var invoice entity.SberInvoice
+	if err := sber.do(ctx, http.MethodPost, fmt.Sprintf("/v1/invoices/%d/refund", invoiceId), entity.SberRefundRequest{Amount: amount}, &invoice); err != nil {
+		return 0, err
+	}
+
+	return invoice.RefundedAmount, nil
+}
+
+// ChargeBinding оплачивает инвойс с карты, привязанной при первой оплате рекуррентного инвойса, без участия
+// пользователя. Возвращает статус инвойса после списания или ошибку.
+func (sber *SberClient) ChargeBinding(ctx context.Context, invoiceId uint, bindingId string) (string, *courseError.CourseError) {
+	var invoice entity.SberInvoice
+	if err := sber.do(ctx, http.MethodPost, fmt.Sprintf("/v1/invoices/%d/recurrent", invoiceId), entity.SberRecurrentRequest{BindingId: bindingId}, &invoice); err != nil {
+		return "", err
+	}
+
+	return invoice.Status, nil
+}
+
+func (sber *SberClient) SetApiHost(apiHost string) {
+	sber.mu.Lock()
+	defer sber.mu.Unlock()
+
+	sber.apiHost = apiHost
+}
+
+func (sber *SberClient) SetAccessToken(token string) {
+	sber.mu.Lock()
+	defer sber.mu.Unlock()
+
+	sber.accessToken = token
+}
+
+// do отправляет запрос в API банка и декодирует ответ в result. Недоступный банк, таймаут и ответы 5xx
+// возвращают ошибку 15006, отказ банка - 15005, неизвестный инвойс - 15001.
+func (sber *SberClient) do(ctx context.Context, method, path string, body, result interface{}) *courseError.CourseError {
+	sber.mu.RLock()
+	apiHost, accessToken := sber.apiHost, sber.accessToken
+	sber.mu.RUnlock()
+
+	var payload io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return courseError.CreateError(err, 10101)
+		}
+		payload = bytes.NewReader(encoded)
+	}
+
+	requestCtx, cancel := context.WithTimeout(ctx, sber.timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(requestCtx, method, strings.TrimSuffix(apiHost, "/")+path, payload)
+	if err != nil {
+		return courseError.CreateError(err, 15006)
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := sber.client.Do(req)
+	if err != nil {
+		return courseError.CreateError(fmt.Errorf("%w: %v", errBankUnavailable, err), 15006)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return courseError.CreateError(fmt.Errorf("%w: статус %d", errBankUnavailable, resp.StatusCode), 15006)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var bankErr entity.SberError
+		json.NewDecoder(resp.Body).Decode(&bankErr)
+
+		if resp.StatusCode == http.StatusNotFound {
+			return courseError.CreateError(ErrInvoiceNotFound, 15001)
+		}
+
+		return courseError.CreateError(fmt.Errorf("%w: %v %v", errBankRejected, bankErr.Code, bankErr.Description), 15005)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return courseError.CreateError(fmt.Errorf("%w: %v", errBankUnavailable, err), 15006)
+	}
+
+	return nil
+}
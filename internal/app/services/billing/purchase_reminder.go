@@ -0,0 +1,92 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/knstch/course/internal/app/config"
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/logger"
+	"github.com/knstch/course/internal/domain/dto"
+)
+
+// reminderStorage содержит методы для поиска просроченных заказов и отметки об отправленном напоминании.
+type reminderStorage interface {
+	GetExpiredOrders(ctx context.Context, createdAfter, createdBefore time.Time, limit int) ([]dto.PurchaseDetails, *courseError.CourseError)
+	MarkOrderReminded(ctx context.Context, billingId uint, reminderEmail *dto.OutboxEmail) *courseError.CourseError
+}
+
+// reminderPreparer собирает письмо с напоминанием о покупке.
+type reminderPreparer interface {
+	PreparePurchaseReminder(details *dto.PurchaseDetails) (*dto.OutboxEmail, *courseError.CourseError)
+}
+
+// Reminder - это фоновая задача, которая отправляет напоминание о незавершенной покупке по заказам,
+// не оплаченным за время жизни ссылки на оплату. Напоминание по заказу отправляется один раз, заказы старше
+// PURCHASE_REMINDER_MAX_AGE не напоминаются.
+type Reminder struct {
+	storage      reminderStorage
+	emailService reminderPreparer
+	logger       logger.Logger
+	pollInterval time.Duration
+	batchSize    int
+	maxAge       time.Duration
+}
+
+// NewReminder - это билдер для Reminder.
+func NewReminder(storage reminderStorage, emailService reminderPreparer, config *config.Config, logger logger.Logger) *Reminder {
+	return &Reminder{
+		storage:      storage,
+		emailService: emailService,
+		logger:       logger,
+		pollInterval: config.PurchaseReminderPollInterval,
+		batchSize:    config.PurchaseReminderBatchSize,
+		maxAge:       config.PurchaseReminderMaxAge,
+	}
+}
+
+// Run отправляет напоминания раз в PURCHASE_REMINDER_POLL_INTERVAL, пока не будет отменен контекст.
+func (reminder *Reminder) Run(ctx context.Context) {
+	ticker := time.NewTicker(reminder.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		reminder.RemindBatch(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// RemindBatch находит пачку просроченных заказов и ставит в outbox напоминания по ним. Возвращает количество
+// найденных заказов.
+func (reminder *Reminder) RemindBatch(ctx context.Context) int {
+	now := time.Now()
+	orders, err := reminder.storage.GetExpiredOrders(ctx, now.Add(-reminder.maxAge), now.Add(-orderTTL), reminder.batchSize)
+	if err != nil {
+		reminder.logger.Error("не получилось получить просроченные заказы", "Reminder", err.Message, err.Code)
+		return 0
+	}
+
+	for i := range orders {
+		if ctx.Err() != nil {
+			return len(orders)
+		}
+
+		reminderEmail, err := reminder.emailService.PreparePurchaseReminder(&orders[i])
+		if err != nil {
+			reminder.logger.Error(fmt.Sprintf("не получилось собрать напоминание по заказу: %v", orders[i].Order), "Reminder", err.Message, err.Code)
+			continue
+		}
+
+		if err := reminder.storage.MarkOrderReminded(ctx, orders[i].BillingId, reminderEmail); err != nil {
+			reminder.logger.Error(fmt.Sprintf("не получилось отправить напоминание по заказу: %v", orders[i].Order), "Reminder", err.Message, err.Code)
+		}
+	}
+
+	return len(orders)
+}
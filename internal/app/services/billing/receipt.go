@@ -0,0 +1,21 @@
+package billing
+
+import (
+	"context"
+	"strconv"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/validation"
+)
+
+// ResendReceipt используется админом для повторной отправки фискального чека, который отклонила онлайн-касса.
+// Принимает ID платежа за любой курс заказа, валидирует его и возвращает чек за заказ в очередь. Возвращает ошибку.
+func (billing SberBillingService) ResendReceipt(ctx context.Context, id string) *courseError.CourseError {
+	if err := validation.NewStringIdToValidate(id).Validate(ctx); err != nil {
+		return err
+	}
+
+	billingId, _ := strconv.Atoi(id)
+
+	return billing.banker.ResendReceipt(ctx, uint(billingId))
+}
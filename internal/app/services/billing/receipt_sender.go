@@ -0,0 +1,237 @@
+package billing
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/knstch/course/internal/app/config"
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/logger"
+	"github.com/knstch/course/internal/domain/dto"
+)
+
+var (
+	errUnknownVat = errors.New("неизвестная ставка НДС")
+)
+
+// receiptStorage содержит методы для поиска чеков к отправке и сохранения результата их регистрации.
+// Чеки каждого источника хранятся отдельно и ищутся по ключу: ID инвойса, платежа за подписку или возврата.
+type receiptStorage interface {
+	GetPendingReceipts(ctx context.Context, source string, afterId uint, limit int) ([]dto.ReceiptLine, *courseError.CourseError)
+	SetReceiptSent(ctx context.Context, source string, id uint, receiptId string) *courseError.CourseError
+	GetSentReceipts(ctx context.Context, source string, afterId uint, limit int) ([]dto.SentReceipt, *courseError.CourseError)
+	SaveFiscalData(ctx context.Context, source string, id uint, fiscalData *dto.FiscalData) *courseError.CourseError
+	FailReceipt(ctx context.Context, source string, id uint, reason string) *courseError.CourseError
+}
+
+// receiptSources - это источники чеков в порядке отправки: чеки "возврат прихода" отправляются после
+// чеков "приход".
+var receiptSources = []string{dto.ReceiptSourceBilling, dto.ReceiptSourceSubscription, dto.ReceiptSourceRefund}
+
+// ReceiptResult - это итог одного прохода отправки чеков.
+type ReceiptResult struct {
+	Sent       int
+	Fiscalized int
+	Failed     int
+}
+
+// ReceiptSender - это фоновая задача, которая регистрирует фискальные чеки по 54-ФЗ за оплаты и возвраты
+// в рублях. Платежи за курсы одного инвойса становятся позициями одного чека "приход", за оплату подписки
+// регистрируется отдельный чек "приход", а за каждый возврат - чек "возврат прихода". Чеки регистрируются
+// с системой налогообложения FISCAL_TAX_SYSTEM и ставкой НДС FISCAL_VAT и отправляются на почту покупателя.
+// Если онлайн-касса недоступна, чек отправляется повторно на следующем проходе, отклоненный чек ждет
+// повторной отправки админом.
+type ReceiptSender struct {
+	storage      receiptStorage
+	provider     FiscalProvider
+	logger       logger.Logger
+	pollInterval time.Duration
+	batchSize    int
+	taxSystem    uint
+	vat          string
+}
+
+// NewReceiptSender - это билдер для ReceiptSender. Возвращает ошибку, если ставка НДС FISCAL_VAT неизвестна.
+func NewReceiptSender(storage receiptStorage, provider FiscalProvider, config *config.Config, logger logger.Logger) (*ReceiptSender, error) {
+	if !dto.IsVatRate(config.FiscalVat) {
+		return nil, fmt.Errorf("%w: %v", errUnknownVat, config.FiscalVat)
+	}
+
+	return &ReceiptSender{
+		storage:      storage,
+		provider:     provider,
+		logger:       logger,
+		pollInterval: config.FiscalPollInterval,
+		batchSize:    config.FiscalBatchSize,
+		taxSystem:    config.FiscalTaxSystem,
+		vat:          config.FiscalVat,
+	}, nil
+}
+
+// Run регистрирует чеки раз в FISCAL_POLL_INTERVAL, пока не будет отменен контекст.
+func (sender *ReceiptSender) Run(ctx context.Context) {
+	ticker := time.NewTicker(sender.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := sender.Send(ctx); err != nil {
+			sender.logger.Error("не получилось зарегистрировать чеки", "ReceiptSender", err.Message, err.Code)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Send отправляет в онлайн-кассу ожидающие чеки, а затем сохраняет фискальные данные отправленных чеков,
+// которые касса уже зарегистрировала. Ошибка по одному чеку не останавливает проход. Возвращает итог прохода
+// или ошибку.
+func (sender *ReceiptSender) Send(ctx context.Context) (*ReceiptResult, *courseError.CourseError) {
+	result := &ReceiptResult{}
+
+	for _, source := range receiptSources {
+		if err := sender.sendPending(ctx, result, source); err != nil {
+			return result, err
+		}
+	}
+
+	for _, source := range receiptSources {
+		if err := sender.checkSent(ctx, result, source); err != nil {
+			return result, err
+		}
+	}
+
+	return result, nil
+}
+
+// sendPending отправляет в онлайн-кассу ожидающие чеки источника source.
+func (sender *ReceiptSender) sendPending(ctx context.Context, result *ReceiptResult, source string) *courseError.CourseError {
+	var afterId uint
+	for {
+		lines, err := sender.storage.GetPendingReceipts(ctx, source, afterId, sender.batchSize)
+		if err != nil {
+			return err
+		}
+
+		receipts := sender.buildReceipts(source, lines)
+		for i := range receipts {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			sender.send(ctx, result, &receipts[i])
+			afterId = receipts[i].SourceId
+		}
+
+		if len(receipts) < sender.batchSize {
+			return nil
+		}
+	}
+}
+
+// checkSent сохраняет фискальные данные отправленных чеков источника source, которые касса уже зарегистрировала.
+func (sender *ReceiptSender) checkSent(ctx context.Context, result *ReceiptResult, source string) *courseError.CourseError {
+	var afterId uint
+	for {
+		receipts, err := sender.storage.GetSentReceipts(ctx, source, afterId, sender.batchSize)
+		if err != nil {
+			return err
+		}
+
+		for i := range receipts {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			sender.check(ctx, result, source, &receipts[i])
+			afterId = receipts[i].SourceId
+		}
+
+		if len(receipts) < sender.batchSize {
+			return nil
+		}
+	}
+}
+
+// buildReceipts собирает чеки источника source из позиций, упорядоченных по ключу чека: позиции с одним
+// ключом, например платежи за курсы одного инвойса, становятся одним чеком. Чек за возврат получает признак
+// расчета "возврат прихода", остальные - "приход".
+func (sender *ReceiptSender) buildReceipts(source string, lines []dto.ReceiptLine) []dto.Receipt {
+	var receipts []dto.Receipt
+	for _, line := range lines {
+		if len(receipts) == 0 || receipts[len(receipts)-1].SourceId != line.SourceId {
+			receipt := dto.NewReceipt().
+				AddSource(source, line.SourceId).
+				AddInvoiceId(line.InvoiceId).
+				AddOrder(line.Order).
+				AddTaxSystem(sender.taxSystem).
+				AddEmail(line.Email).
+				AddContactEmail()
+			if source == dto.ReceiptSourceRefund {
+				receipt.AddSellRefundOperation()
+			} else {
+				receipt.AddSellOperation()
+			}
+			receipts = append(receipts, *receipt)
+		}
+
+		receipts[len(receipts)-1].AddItem(dto.NewReceiptItem(line.CourseName, line.Amount).
+			AddVat(sender.vat).
+			AddServiceSubject().
+			AddFullPaymentMethod())
+	}
+
+	return receipts
+}
+
+func (sender *ReceiptSender) send(ctx context.Context, result *ReceiptResult, receipt *dto.Receipt) {
+	receiptId, err := sender.provider.SendReceipt(ctx, receipt)
+	if err == nil {
+		err = sender.storage.SetReceiptSent(ctx, receipt.Source, receipt.SourceId, receiptId)
+	} else if err.Code == 15028 {
+		sender.fail(ctx, result, receipt.Source, receipt.SourceId, err)
+		return
+	}
+	if err != nil {
+		sender.logger.Error(fmt.Sprintf("не получилось отправить чек %s: %d", receipt.Source, receipt.SourceId), "ReceiptSender", err.Message, err.Code)
+		return
+	}
+
+	result.Sent++
+}
+
+func (sender *ReceiptSender) check(ctx context.Context, result *ReceiptResult, source string, receipt *dto.SentReceipt) {
+	fiscalData, err := sender.provider.GetReceipt(ctx, receipt.ReceiptId)
+	if err != nil && err.Code == 15028 {
+		sender.fail(ctx, result, source, receipt.SourceId, err)
+		return
+	}
+	if err == nil && fiscalData == nil {
+		return
+	}
+	if err == nil {
+		err = sender.storage.SaveFiscalData(ctx, source, receipt.SourceId, fiscalData)
+	}
+	if err != nil {
+		sender.logger.Error(fmt.Sprintf("не получилось получить статус чека %s: %d", source, receipt.SourceId), "ReceiptSender", err.Message, err.Code)
+		return
+	}
+
+	result.Fiscalized++
+}
+
+func (sender *ReceiptSender) fail(ctx context.Context, result *ReceiptResult, source string, id uint, reason *courseError.CourseError) {
+	sender.logger.Error(fmt.Sprintf("онлайн-касса отклонила чек %s: %d", source, id), "ReceiptSender", reason.Message, reason.Code)
+
+	if err := sender.storage.FailReceipt(ctx, source, id, reason.Message); err != nil {
+		sender.logger.Error(fmt.Sprintf("не получилось отметить отклоненный чек %s: %d", source, id), "ReceiptSender", err.Message, err.Code)
+		return
+	}
+
+	result.Failed++
+}
@@ -0,0 +1,274 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/knstch/course/internal/app/config"
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/logger"
+	"github.com/knstch/course/internal/domain/dto"
+)
+
+// reconciliationStorage содержит методы для поиска заказов, сверки их с банком и сохранения отчета о сверке.
+type reconciliationStorage interface {
+	GetUnpaidOrders(ctx context.Context, afterId uint, limit int) ([]dto.PurchaseDetails, *courseError.CourseError)
+	GetPaidOrders(ctx context.Context, paidAfter time.Time, afterId uint, limit int) ([]dto.PurchaseDetails, *courseError.CourseError)
+	GetFailedOrders(ctx context.Context, failedAfter time.Time, afterId uint, limit int) ([]dto.PurchaseDetails, *courseError.CourseError)
+	ApprovePayment(ctx context.Context, invoiceId uint, receiptEmail, giftEmail *dto.OutboxEmail) *courseError.CourseError
+	ExpireOrder(ctx context.Context, billingId uint, reminderEmail *dto.OutboxEmail) *courseError.CourseError
+	SaveReconciliationReport(ctx context.Context, report *dto.ReconciliationReport) *courseError.CourseError
+}
+
+// purchaseEmailPreparer собирает чек, письмо получателю подарка и напоминание о покупке.
+type purchaseEmailPreparer interface {
+	PreparePurchaseReceipt(details *dto.PurchaseDetails) (*dto.OutboxEmail, *courseError.CourseError)
+	PreparePurchaseReminder(details *dto.PurchaseDetails) (*dto.OutboxEmail, *courseError.CourseError)
+	PrepareGiftNotification(details *dto.PurchaseDetails) (*dto.OutboxEmail, *courseError.CourseError)
+}
+
+// invoiceStatuses сопоставляет статус оплаченного платежа со статусом инвойса, который должен быть в банке.
+var invoiceStatuses = map[string]string{
+	dto.BillingStatusPaid:              InvoiceStatusPaid,
+	dto.BillingStatusPartiallyRefunded: InvoiceStatusPartiallyRefunded,
+	dto.BillingStatusRefunded:          InvoiceStatusRefunded,
+}
+
+// Reconciler - это фоновая задача, которая сверяет заказы со статусами инвойсов в банке. Заказы, отклоненные
+// или просроченные банком, и заказы без инвойса старше времени жизни ссылки на оплату отмечаются неудавшимися.
+// Оплату, уведомление о которой не дошло, Reconciler отмечает сам. Результат каждой сверки сохраняется в отчет,
+// в котором отмечены расхождения между billings и банком.
+type Reconciler struct {
+	storage      reconciliationStorage
+	provider     PaymentProvider
+	emailService purchaseEmailPreparer
+	logger       logger.Logger
+	pollInterval time.Duration
+	batchSize    int
+	lookback     time.Duration
+}
+
+// NewReconciler - это билдер для Reconciler.
+func NewReconciler(storage reconciliationStorage, provider PaymentProvider, emailService purchaseEmailPreparer,
+	config *config.Config, logger logger.Logger) *Reconciler {
+	return &Reconciler{
+		storage:      storage,
+		provider:     provider,
+		emailService: emailService,
+		logger:       logger,
+		pollInterval: config.ReconciliationPollInterval,
+		batchSize:    config.ReconciliationBatchSize,
+		lookback:     config.ReconciliationLookback,
+	}
+}
+
+// Run сверяет заказы раз в RECONCILIATION_POLL_INTERVAL, пока не будет отменен контекст.
+func (reconciler *Reconciler) Run(ctx context.Context) {
+	ticker := time.NewTicker(reconciler.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := reconciler.Reconcile(ctx); err != nil {
+			reconciler.logger.Error("не получилось сверить заказы с банком", "Reconciler", err.Message, err.Code)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reconcile проходит по заказам, оплаченным или отмеченным неудавшимися за RECONCILIATION_LOOKBACK, и по всем
+// неоплаченным заказам, сверяет их со статусом инвойса в банке и сохраняет отчет о сверке. Возвращает отчет
+// или ошибку.
+func (reconciler *Reconciler) Reconcile(ctx context.Context) (*dto.ReconciliationReport, *courseError.CourseError) {
+	now := time.Now()
+	report := dto.CreateNewReconciliationReport(now)
+
+	// Сначала проверяются оплаченные заказы, чтобы не сверять повторно заказы, подтвержденные в этом же проходе.
+	var afterId uint
+	for {
+		orders, err := reconciler.storage.GetPaidOrders(ctx, now.Add(-reconciler.lookback), afterId, reconciler.batchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range orders {
+			reconciler.reconcilePaid(ctx, report, &orders[i])
+			afterId = orders[i].BillingId
+		}
+
+		if len(orders) < reconciler.batchSize || ctx.Err() != nil {
+			break
+		}
+	}
+
+	afterId = 0
+	for {
+		orders, err := reconciler.storage.GetFailedOrders(ctx, now.Add(-reconciler.lookback), afterId, reconciler.batchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range orders {
+			reconciler.reconcileFailed(ctx, report, &orders[i])
+			afterId = orders[i].BillingId
+		}
+
+		if len(orders) < reconciler.batchSize || ctx.Err() != nil {
+			break
+		}
+	}
+
+	afterId = 0
+	for {
+		orders, err := reconciler.storage.GetUnpaidOrders(ctx, afterId, reconciler.batchSize)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range orders {
+			reconciler.reconcileUnpaid(ctx, report, &orders[i], now.Add(-orderTTL))
+			afterId = orders[i].BillingId
+		}
+
+		if len(orders) < reconciler.batchSize || ctx.Err() != nil {
+			break
+		}
+	}
+
+	report.Finish(time.Now())
+
+	if err := reconciler.storage.SaveReconciliationReport(ctx, report); err != nil {
+		return nil, err
+	}
+
+	return report, nil
+}
+
+// reconcileUnpaid сверяет неоплаченный заказ. Если банк считает инвойс оплаченным, оплата отмечается,
+// если отклоненным или просроченным - заказ отмечается неудавшимся. Заказ без инвойса или неизвестный банку
+// отмечается неудавшимся после времени жизни ссылки. Пока банк ждет оплату, заказ не меняется, даже если
+// ссылка устарела: оплата может пройти, и тогда она будет отмечена.
+func (reconciler *Reconciler) reconcileUnpaid(ctx context.Context, report *dto.ReconciliationReport, order *dto.PurchaseDetails, staleBefore time.Time) {
+	stale := order.CreatedAt.Before(staleBefore)
+
+	if order.InvoiceId == 0 {
+		if stale {
+			reconciler.expire(ctx, report, order)
+		}
+		return
+	}
+
+	report.Checked++
+
+	status, err := reconciler.provider.GetInvoiceStatus(ctx, order.InvoiceId)
+	if err != nil {
+		if err.Code != 15001 {
+			report.Errors++
+			reconciler.logger.Error(fmt.Sprintf("не получилось получить статус инвойса: %d", order.InvoiceId), "Reconciler", err.Message, err.Code)
+			return
+		}
+
+		resolution := ""
+		if stale && reconciler.expire(ctx, report, order) {
+			resolution = dto.ReconciliationExpired
+		}
+		report.AddMismatch(*order, dto.ReconciliationMissingAtProvider, "", resolution)
+		return
+	}
+
+	switch {
+	case status == InvoiceStatusPaid:
+		resolution := ""
+		if reconciler.confirm(ctx, report, order) {
+			resolution = dto.ReconciliationConfirmed
+		}
+		report.AddMismatch(*order, dto.ReconciliationPaidNotConfirmed, status, resolution)
+	case status == InvoiceStatusDeclined, status == InvoiceStatusExpired:
+		reconciler.expire(ctx, report, order)
+	}
+}
+
+// reconcileFailed отмечает в отчете неудавшийся заказ, который банк считает оплаченным. Статус такого заказа
+// не меняется автоматически: админ решает, открыть курсы или вернуть деньги.
+func (reconciler *Reconciler) reconcileFailed(ctx context.Context, report *dto.ReconciliationReport, order *dto.PurchaseDetails) {
+	report.Checked++
+
+	status, err := reconciler.provider.GetInvoiceStatus(ctx, order.InvoiceId)
+	if err != nil {
+		if err.Code != 15001 {
+			report.Errors++
+			reconciler.logger.Error(fmt.Sprintf("не получилось получить статус инвойса: %d", order.InvoiceId), "Reconciler", err.Message, err.Code)
+		}
+		return
+	}
+
+	switch status {
+	case InvoiceStatusPaid, InvoiceStatusPartiallyRefunded, InvoiceStatusRefunded:
+		report.AddMismatch(*order, dto.ReconciliationPaidAfterFailed, status, "")
+	}
+}
+
+// reconcilePaid отмечает в отчете оплаченный заказ, который банк не считает оплаченным, и заказ, возврат
+// по которому у нас и в банке отличается. Такие расхождения не исправляются автоматически.
+func (reconciler *Reconciler) reconcilePaid(ctx context.Context, report *dto.ReconciliationReport, order *dto.PurchaseDetails) {
+	report.Checked++
+
+	status, err := reconciler.provider.GetInvoiceStatus(ctx, order.InvoiceId)
+	if err != nil {
+		if err.Code != 15001 {
+			report.Errors++
+			reconciler.logger.Error(fmt.Sprintf("не получилось получить статус инвойса: %d", order.InvoiceId), "Reconciler", err.Message, err.Code)
+			return
+		}
+		report.AddMismatch(*order, dto.ReconciliationMissingAtProvider, "", "")
+		return
+	}
+
+	switch status {
+	case InvoiceStatusPaid, InvoiceStatusPartiallyRefunded, InvoiceStatusRefunded:
+		if status != invoiceStatuses[order.Status] {
+			report.AddMismatch(*order, dto.ReconciliationStatusMismatch, status, "")
+		}
+	default:
+		report.AddMismatch(*order, dto.ReconciliationPaidNotAtProvider, status, "")
+	}
+}
+
+func (reconciler *Reconciler) confirm(ctx context.Context, report *dto.ReconciliationReport, order *dto.PurchaseDetails) bool {
+	receiptEmail, err := reconciler.emailService.PreparePurchaseReceipt(order)
+	var giftEmail *dto.OutboxEmail
+	if err == nil {
+		giftEmail, err = reconciler.emailService.PrepareGiftNotification(order)
+	}
+	if err == nil {
+		err = reconciler.storage.ApprovePayment(ctx, order.InvoiceId, receiptEmail, giftEmail)
+	}
+	if err != nil {
+		report.Errors++
+		reconciler.logger.Error(fmt.Sprintf("не получилось отметить оплату по заказу: %v", order.Order), "Reconciler", err.Message, err.Code)
+		return false
+	}
+
+	report.Confirmed++
+	return true
+}
+
+func (reconciler *Reconciler) expire(ctx context.Context, report *dto.ReconciliationReport, order *dto.PurchaseDetails) bool {
+	reminderEmail, err := reconciler.emailService.PreparePurchaseReminder(order)
+	if err == nil {
+		err = reconciler.storage.ExpireOrder(ctx, order.BillingId, reminderEmail)
+	}
+	if err != nil {
+		report.Errors++
+		reconciler.logger.Error(fmt.Sprintf("не получилось отметить неудавшимся просроченный заказ: %v", order.Order), "Reconciler", err.Message, err.Code)
+		return false
+	}
+
+	report.Expired++
+	return true
+}
@@ -0,0 +1,85 @@
+package billing
+
+import (
+	"context"
+	"errors"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/validation"
+	"github.com/knstch/course/internal/domain/dto"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+var (
+	ErrPaymentNotRefundable = errors.New("платеж не оплачен или уже возвращен полностью")
+	ErrRefundTooLarge       = errors.New("сумма возврата больше оплаченной")
+)
+
+// RefundPurchase используется админом для возврата денег за курс. Принимает ID платежа за курс, сумму в минимальных
+// единицах валюты и причину возврата, если сумма не передана, возвращается весь остаток оплаты этого курса. Если заказ
+// оплачивал несколько курсов, возврат касается только выбранного. Возврат проводится в банке, после чего записывается
+// у нас, пользователь получает письмо, а после полного возврата теряет доступ к курсу.
+// Возвращает данные платежа после возврата или ошибку.
+func (billing SberBillingService) RefundPurchase(ctx context.Context, request *entity.RefundRequest) (*entity.RefundResult, *courseError.CourseError) {
+	if err := validation.NewRefundRequestToValidate(request).Validate(ctx); err != nil {
+		return nil, err
+	}
+
+	details, err := billing.banker.GetBillingPurchaseDetails(ctx, request.BillingId)
+	if err != nil {
+		return nil, err
+	}
+
+	if details.Status != dto.BillingStatusPaid && details.Status != dto.BillingStatusPartiallyRefunded {
+		return nil, courseError.CreateError(ErrPaymentNotRefundable, 15008)
+	}
+
+	remaining := details.Amount - details.RefundedAmount
+
+	amount := request.Amount
+	if amount == 0 {
+		amount = remaining
+	}
+
+	if amount > remaining {
+		return nil, courseError.CreateError(ErrRefundTooLarge, 15009)
+	}
+
+	invoiceRefundedTotal, err := billing.provider.Refund(ctx, details.InvoiceId, int(amount))
+	if err != nil {
+		return nil, err
+	}
+
+	refundedTotal := details.RefundedAmount + amount
+
+	refundEmail, err := billing.emailService.PreparePurchaseRefund(details, refundedTotal)
+	if err != nil {
+		return nil, err
+	}
+
+	refund := dto.CreateNewRefund(dto.RefundSourceAdmin).
+		AddAdminId(ctx.Value("AdminId").(uint)).
+		AddReason(request.Reason)
+
+	if err := billing.banker.RefundBilling(ctx, details.BillingId, amount, uint(invoiceRefundedTotal), refund, refundEmail); err != nil {
+		return nil, err
+	}
+
+	return entity.CreateRefundResult(details.BillingId, details.InvoiceId, dto.RefundStatus(details.Amount, refundedTotal),
+		amount, refundedTotal), nil
+}
+
+// recordRefund готовит письмо о возврате и записывает возврат по инвойсу до общей суммы refundedTotal в минимальных
+// единицах валюты. Если эта сумма уже записана, например после возврата админом, ничего не меняется.
+func (billing SberBillingService) recordRefund(ctx context.Context, details *dto.PurchaseDetails, refundedTotal uint, refund *dto.Refund) *courseError.CourseError {
+	if refundedTotal <= details.RefundedAmount {
+		return nil
+	}
+
+	refundEmail, err := billing.emailService.PreparePurchaseRefund(details, refundedTotal)
+	if err != nil {
+		return err
+	}
+
+	return billing.banker.RefundPayment(ctx, details.InvoiceId, refundedTotal, refund, refundEmail)
+}
@@ -0,0 +1,197 @@
+package billing
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"time"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/validation"
+	"github.com/knstch/course/internal/domain/dto"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+// Subscribe используется для оформления подписки на план. В качестве параметра принимает ID плана и страну
+// платежного инструмента, валидирует их и создает подписку, ожидающую первой оплаты. Инвойс за первый период
+// выставляется как рекуррентный, чтобы банк привязал карту для следующих списаний. Возвращает ссылку на оплату
+// для пользователя или ошибку.
+func (billing SberBillingService) Subscribe(ctx context.Context, details *entity.SubscribeDetails) (*string, *courseError.CourseError) {
+	if err := validation.NewSubscribeDetailsToValidate(details).Validate(ctx); err != nil {
+		return nil, err
+	}
+
+	charge, err := billing.banker.CreateSubscription(ctx, details.PlanId, details.IsRusCard)
+	if err != nil {
+		return nil, err
+	}
+
+	invoiceId, err := billing.provider.CreateInvoice(ctx, subscriptionInvoice(charge))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := billing.banker.SetSubscriptionInvoiceId(ctx, invoiceId, charge.PaymentId); err != nil {
+		return nil, err
+	}
+
+	userDataHash := md5.New()
+
+	userDataHash.Write([]byte(fmt.Sprintf("%dsubscription%d", charge.UserId, charge.PaymentId)))
+
+	hashedUserData := hex.EncodeToString(userDataHash.Sum(nil))
+
+	return billing.getPayLink(ctx, invoiceId, hashedUserData)
+}
+
+// subscriptionInvoice собирает рекуррентный инвойс за период подписки. Планы подписки продаются только в рублях.
+func subscriptionInvoice(charge *dto.SubscriptionCharge) entity.InvoiceData {
+	now := time.Now()
+
+	orderHash := md5.New()
+
+	orderHash.Write([]byte(fmt.Sprintf("%d%d%d", charge.SubscriptionId, charge.PaymentId, now.Unix())))
+
+	order := dto.NewOrderEssentials().
+		AddOrderId(charge.PaymentId).
+		AddOrder(hex.EncodeToString(orderHash.Sum(nil))).
+		AddOrderDate(uint(now.Unix())).
+		AddExpDate(uint(now.Add(orderTTL).Unix())).
+		AddAmountToPay(charge.Amount).
+		AddCurrencyRub().
+		AddRusLang().
+		AddPurpose(fmt.Sprintf("Подписка: %v", charge.PlanName)).
+		AddDefaultTaxSystem().
+		AddEmail(charge.Email).
+		AddContactEmail()
+
+	invoice := entity.CreateOrder(*order, int(charge.PlanId), fmt.Sprint(charge.UserId), 0)
+	invoice.Recurrent = true
+
+	return invoice
+}
+
+// handleSubscriptionWebhook применяет уведомление банка к платежу за подписку. По оплаченному инвойсу подписка
+// продлевается и сохраняется привязка карты, по отклоненному или просроченному платеж отмечается неудавшимся.
+func (billing SberBillingService) handleSubscriptionWebhook(ctx context.Context, webhook entity.SberWebhook) *courseError.CourseError {
+	switch webhook.Status {
+	case InvoiceStatusPaid:
+		return billing.banker.PaySubscription(ctx, webhook.InvoiceId, webhook.BindingId)
+	case InvoiceStatusDeclined, InvoiceStatusExpired:
+		return billing.banker.FailSubscriptionPayment(ctx, webhook.InvoiceId, billing.subscriptionGrace)
+	default:
+		return nil
+	}
+}
+
+// getSubscriptionPaymentStatus возвращает статус оплаты подписки, если инвойс выставлен за подписку.
+func (billing SberBillingService) getSubscriptionPaymentStatus(ctx context.Context, invoiceId string) (*entity.PaymentStatus, *courseError.CourseError) {
+	id, _ := strconv.ParseUint(invoiceId, 10, 64)
+
+	payment, err := billing.banker.GetSubscriptionPayment(ctx, uint(id))
+	if err != nil {
+		if err.Code == 15001 {
+			return entity.CreatePaymentStatus(PaymentStatusCanceled, ""), nil
+		}
+		return nil, err
+	}
+
+	if payment.Subscription.UserId != ctx.Value("UserId").(uint) {
+		return nil, courseError.CreateError(ErrInvoiceNotFound, 15001)
+	}
+
+	switch payment.Status {
+	case dto.BillingStatusPaid:
+		return entity.CreatePaymentStatus(PaymentStatusPaid, ""), nil
+	case dto.BillingStatusFailed:
+		return entity.CreatePaymentStatus(PaymentStatusCanceled, ""), nil
+	default:
+		return entity.CreatePaymentStatus(PaymentStatusPending, ""), nil
+	}
+}
+
+// GetSubscription используется для просмотра подписки пользователя. Возвращает последнюю оплаченную подписку
+// с планом и признаком того, открывает ли она сейчас курсы, или ошибку.
+func (billing SberBillingService) GetSubscription(ctx context.Context) (*entity.Subscription, *courseError.CourseError) {
+	subscription, err := billing.banker.GetUserSubscription(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	return entity.CreateSubscription(*subscription, time.Now()), nil
+}
+
+// CancelSubscription используется для отмены продления подписки. Деньги больше не списываются, а курсы остаются
+// открыты до конца оплаченного периода. Возвращает ошибку.
+func (billing SberBillingService) CancelSubscription(ctx context.Context) *courseError.CourseError {
+	return billing.banker.CancelSubscription(ctx)
+}
+
+// RetreiveSubscriptionPlans используется для просмотра планов подписки. Пользователю показываются только планы,
+// на которые можно подписаться, админу - все планы. Возвращает планы или ошибку.
+func (billing SberBillingService) RetreiveSubscriptionPlans(ctx context.Context, onlyActive bool) ([]entity.SubscriptionPlan, *courseError.CourseError) {
+	plans, err := billing.banker.GetSubscriptionPlans(ctx, onlyActive)
+	if err != nil {
+		return nil, err
+	}
+
+	return entity.CreateSubscriptionPlans(plans), nil
+}
+
+// CreateSubscriptionPlan используется админом для создания плана подписки. Принимает условия плана, валидирует
+// их и сохраняет план. Возвращает созданный план или ошибку.
+func (billing SberBillingService) CreateSubscriptionPlan(ctx context.Context, planToSave *entity.SubscriptionPlanToSave) (*entity.SubscriptionPlan, *courseError.CourseError) {
+	plan, err := billing.prepareSubscriptionPlan(ctx, planToSave)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := billing.banker.StoreSubscriptionPlan(ctx, plan); err != nil {
+		return nil, err
+	}
+
+	return entity.CreateSubscriptionPlan(*plan), nil
+}
+
+// EditSubscriptionPlan используется админом для изменения плана подписки. Принимает ID плана и новые условия,
+// валидирует их и перезаписывает план. Оформленные подписки продлеваются по новой цене. Возвращает ошибку.
+func (billing SberBillingService) EditSubscriptionPlan(ctx context.Context, id string, planToSave *entity.SubscriptionPlanToSave) *courseError.CourseError {
+	if err := validation.NewStringIdToValidate(id).Validate(ctx); err != nil {
+		return err
+	}
+
+	plan, err := billing.prepareSubscriptionPlan(ctx, planToSave)
+	if err != nil {
+		return err
+	}
+
+	planId, _ := strconv.Atoi(id)
+	plan.ID = uint(planId)
+
+	return billing.banker.UpdateSubscriptionPlan(ctx, plan)
+}
+
+// prepareSubscriptionPlan валидирует условия плана подписки и собирает его модель. Если активность
+// не передана, план включен.
+func (billing SberBillingService) prepareSubscriptionPlan(ctx context.Context, planToSave *entity.SubscriptionPlanToSave) (*dto.SubscriptionPlan, *courseError.CourseError) {
+	if err := validation.NewSubscriptionPlanToValidate(planToSave).Validate(ctx); err != nil {
+		return nil, err
+	}
+
+	active := true
+	if planToSave.Active != nil {
+		active = *planToSave.Active
+	}
+
+	courseIds := planToSave.CourseIds
+	if planToSave.AllCourses {
+		courseIds = nil
+	}
+
+	return dto.CreateNewSubscriptionPlan(planToSave.Name, planToSave.Period, planToSave.Price).
+		SetAllCourses(planToSave.AllCourses).
+		AddCourses(courseIds).
+		SetActive(active), nil
+}
@@ -0,0 +1,195 @@
+package billing
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/knstch/course/internal/app/config"
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/logger"
+	"github.com/knstch/course/internal/domain/dto"
+)
+
+// subscriptionStorage содержит методы для поиска подписок к продлению, записи списаний и закрытия подписок.
+type subscriptionStorage interface {
+	ClaimSubscriptionsToRenew(ctx context.Context, renewBefore, retryAfter time.Time, limit int) ([]dto.SubscriptionCharge, *courseError.CourseError)
+	GetPendingRenewals(ctx context.Context, afterId uint, limit int) ([]dto.SubscriptionPayment, *courseError.CourseError)
+	SetSubscriptionInvoiceId(ctx context.Context, invoiceId, paymentId uint) *courseError.CourseError
+	PaySubscription(ctx context.Context, invoiceId uint, bindingId string) *courseError.CourseError
+	FailSubscriptionPayment(ctx context.Context, invoiceId uint, gracePeriod time.Duration) *courseError.CourseError
+	ExpireSubscriptions(ctx context.Context, now, pendingBefore time.Time) (int64, *courseError.CourseError)
+}
+
+// RenewalResult - это итог одного прохода продления подписок.
+type RenewalResult struct {
+	Renewed int
+	Failed  int
+	Expired int64
+}
+
+// SubscriptionRenewer - это фоновая задача, которая продлевает подписки, списывая деньги с привязанной карты
+// незадолго до конца оплаченного периода. Если списание не прошло, подписка открывает курсы еще
+// SUBSCRIPTION_GRACE_PERIOD, а списание повторяется раз в SUBSCRIPTION_RETRY_INTERVAL. Если банк не ответил
+// на списание, статус платежа запрашивается у банка на следующих проходах, и пока он не известен, деньги
+// за подписку повторно не списываются. Подписки с отмененным продлением и неоплаченные после льготного
+// периода закрываются.
+type SubscriptionRenewer struct {
+	storage       subscriptionStorage
+	provider      PaymentProvider
+	logger        logger.Logger
+	pollInterval  time.Duration
+	batchSize     int
+	renewBefore   time.Duration
+	retryInterval time.Duration
+	gracePeriod   time.Duration
+}
+
+// NewSubscriptionRenewer - это билдер для SubscriptionRenewer.
+func NewSubscriptionRenewer(storage subscriptionStorage, provider PaymentProvider, config *config.Config, logger logger.Logger) *SubscriptionRenewer {
+	return &SubscriptionRenewer{
+		storage:       storage,
+		provider:      provider,
+		logger:        logger,
+		pollInterval:  config.SubscriptionPollInterval,
+		batchSize:     config.SubscriptionBatchSize,
+		renewBefore:   config.SubscriptionRenewBefore,
+		retryInterval: config.SubscriptionRetryInterval,
+		gracePeriod:   config.SubscriptionGracePeriod,
+	}
+}
+
+// Run продлевает подписки раз в SUBSCRIPTION_POLL_INTERVAL, пока не будет отменен контекст.
+func (renewer *SubscriptionRenewer) Run(ctx context.Context) {
+	ticker := time.NewTicker(renewer.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		if _, err := renewer.Renew(ctx); err != nil {
+			renewer.logger.Error("не получилось продлить подписки", "SubscriptionRenewer", err.Message, err.Code)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Renew закрывает подписки, которые больше не продлеваются, записывает результат списаний, на которые банк
+// не ответил в прошлых проходах, и списывает деньги за следующий период подписок, оплаченный период которых
+// заканчивается в ближайшие SUBSCRIPTION_RENEW_BEFORE. Ошибка списания по одной подписке не останавливает
+// проход. Возвращает итог прохода или ошибку.
+func (renewer *SubscriptionRenewer) Renew(ctx context.Context) (*RenewalResult, *courseError.CourseError) {
+	now := time.Now()
+	result := &RenewalResult{}
+
+	expired, err := renewer.storage.ExpireSubscriptions(ctx, now, now.Add(-orderTTL))
+	if err != nil {
+		return nil, err
+	}
+	result.Expired = expired
+
+	var afterId uint
+	for {
+		payments, err := renewer.storage.GetPendingRenewals(ctx, afterId, renewer.batchSize)
+		if err != nil {
+			return result, err
+		}
+
+		for i := range payments {
+			if ctx.Err() != nil {
+				return result, nil
+			}
+
+			renewer.resolve(ctx, result, &payments[i])
+			afterId = payments[i].ID
+		}
+
+		if len(payments) < renewer.batchSize {
+			break
+		}
+	}
+
+	for {
+		charges, err := renewer.storage.ClaimSubscriptionsToRenew(ctx, now.Add(renewer.renewBefore), now.Add(-renewer.retryInterval), renewer.batchSize)
+		if err != nil {
+			return result, err
+		}
+
+		for i := range charges {
+			if ctx.Err() != nil {
+				return result, nil
+			}
+
+			paid, err := renewer.charge(ctx, &charges[i])
+			if err != nil {
+				renewer.logger.Error(fmt.Sprintf("не получилось продлить подписку с ID: %d", charges[i].SubscriptionId), "SubscriptionRenewer", err.Message, err.Code)
+				continue
+			}
+
+			if paid {
+				result.Renewed++
+			} else {
+				result.Failed++
+			}
+		}
+
+		if len(charges) < renewer.batchSize {
+			return result, nil
+		}
+	}
+}
+
+// resolve запрашивает у банка статус инвойса за продление, на списание по которому банк не ответил, и записывает
+// результат. Если банк не знает инвойс, деньги по нему не списаны, и платеж отмечается неудавшимся. Пока банк
+// ждет оплату, платеж не меняется.
+func (renewer *SubscriptionRenewer) resolve(ctx context.Context, result *RenewalResult, payment *dto.SubscriptionPayment) {
+	status, err := renewer.provider.GetInvoiceStatus(ctx, payment.InvoiceId)
+	if err != nil && err.Code == 15001 {
+		status, err = InvoiceStatusDeclined, nil
+	}
+
+	switch {
+	case err != nil:
+	case status == InvoiceStatusPaid:
+		if err = renewer.storage.PaySubscription(ctx, payment.InvoiceId, ""); err == nil {
+			result.Renewed++
+		}
+	case status == InvoiceStatusDeclined, status == InvoiceStatusExpired:
+		if err = renewer.storage.FailSubscriptionPayment(ctx, payment.InvoiceId, renewer.gracePeriod); err == nil {
+			result.Failed++
+		}
+	}
+
+	if err != nil {
+		renewer.logger.Error(fmt.Sprintf("не получилось получить статус продления подписки с ID: %d", payment.SubscriptionId), "SubscriptionRenewer", err.Message, err.Code)
+	}
+}
+
+// charge выставляет инвойс за следующий период подписки и оплачивает его с привязанной карты. Платеж уже
+// создан при выборе подписки. Если банк отклонил списание, платеж отмечается неудавшимся. Если банк не ответил,
+// платеж остается ожидающим, и его статус запрашивается у банка на следующем проходе. Возвращает true,
+// если подписка продлена.
+func (renewer *SubscriptionRenewer) charge(ctx context.Context, charge *dto.SubscriptionCharge) (bool, *courseError.CourseError) {
+	invoiceId, err := renewer.provider.CreateInvoice(ctx, subscriptionInvoice(charge))
+	if err != nil {
+		return false, err
+	}
+
+	if err := renewer.storage.SetSubscriptionInvoiceId(ctx, invoiceId, charge.PaymentId); err != nil {
+		return false, err
+	}
+
+	status, err := renewer.provider.ChargeBinding(ctx, invoiceId, charge.BindingId)
+	if err != nil && err.Code != 15005 {
+		return false, err
+	}
+
+	if err == nil && status == InvoiceStatusPaid {
+		return true, renewer.storage.PaySubscription(ctx, invoiceId, "")
+	}
+
+	return false, renewer.storage.FailSubscriptionPayment(ctx, invoiceId, renewer.gracePeriod)
+}
@@ -0,0 +1,147 @@
+package billing
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+const (
+	// WebhookSignatureHeader - это заголовок с HMAC-SHA256 подписью тела уведомления от банка.
+	WebhookSignatureHeader = "X-Signature"
+)
+
+var (
+	errBadWebhookSignature = errors.New("неверная подпись уведомления от банка")
+	errBadWebhook          = errors.New("уведомление от банка передано в неверном формате")
+)
+
+// SignWebhook подписывает тело уведомления HMAC-SHA256 с общим секретом. Возвращает подпись в hex.
+func SignWebhook(secret string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(payload)
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HandleWebhook используется для обработки уведомления банка о статусе инвойса. Принимает тело уведомления
+// и подпись из заголовка, проверяет подпись по SBER_WEBHOOK_SECRET. Оплаченный инвойс отмечается оплаченным
+// и пользователю отправляется чек, а получателю подарка - код подарка. Если заказ уже был отмечен неудавшимся,
+// оплата не отмечается, а сохраняется отчет о сверке с расхождением. По отклоненному или просроченному инвойсу заказ отмечается неудавшимся
+// и отправляется напоминание о покупке. По возвращенному инвойсу записывается возврат на сумму refunded_amount.
+// Уведомления по инвойсам за подписку продлевают подписку или отмечают неудавшееся списание. Повторное
+// уведомление по тому же инвойсу ничего не меняет. Возвращает ошибку.
+func (billing SberBillingService) HandleWebhook(ctx context.Context, payload []byte, signature string) *courseError.CourseError {
+	if billing.webhookSecret == "" || !hmac.Equal([]byte(SignWebhook(billing.webhookSecret, payload)), []byte(signature)) {
+		return courseError.CreateError(errBadWebhookSignature, 15007)
+	}
+
+	var webhook entity.SberWebhook
+	if err := json.Unmarshal(payload, &webhook); err != nil || webhook.InvoiceId == 0 {
+		return courseError.CreateError(errBadWebhook, 10101)
+	}
+
+	if _, err := billing.banker.GetSubscriptionPayment(ctx, webhook.InvoiceId); err == nil {
+		return billing.handleSubscriptionWebhook(ctx, webhook)
+	} else if err.Code != 15001 {
+		return err
+	}
+
+	switch webhook.Status {
+	case InvoiceStatusPaid:
+		return billing.approvePayment(ctx, webhook.InvoiceId)
+	case InvoiceStatusDeclined, InvoiceStatusExpired:
+		return billing.cancelOrder(ctx, webhook.InvoiceId)
+	case InvoiceStatusPartiallyRefunded, InvoiceStatusRefunded:
+		return billing.applyProviderRefund(ctx, webhook)
+	default:
+		return nil
+	}
+}
+
+func (billing SberBillingService) approvePayment(ctx context.Context, invoiceId uint) *courseError.CourseError {
+	details, err := billing.banker.GetPurchaseDetails(ctx, fmt.Sprint(invoiceId))
+	if err != nil {
+		return err
+	}
+
+	if details.Status == dto.BillingStatusFailed {
+		return billing.reportPaidAfterFailed(ctx, details)
+	}
+
+	if details.Status != dto.BillingStatusPending {
+		return nil
+	}
+
+	receiptEmail, err := billing.emailService.PreparePurchaseReceipt(details)
+	if err != nil {
+		return err
+	}
+
+	giftEmail, err := billing.emailService.PrepareGiftNotification(details)
+	if err != nil {
+		return err
+	}
+
+	return billing.banker.ApprovePayment(ctx, invoiceId, receiptEmail, giftEmail)
+}
+
+// reportPaidAfterFailed сохраняет отчет о сверке с расхождением paid_after_failed по заказу, который банк оплатил
+// после того, как заказ был отмечен неудавшимся. Статус заказа не меняется: админ решает, открыть курсы
+// или вернуть деньги.
+func (billing SberBillingService) reportPaidAfterFailed(ctx context.Context, details *dto.PurchaseDetails) *courseError.CourseError {
+	now := time.Now()
+
+	report := dto.CreateNewReconciliationReport(now).
+		AddMismatch(*details, dto.ReconciliationPaidAfterFailed, InvoiceStatusPaid, "").
+		Finish(now)
+	report.Checked = 1
+
+	return billing.banker.SaveReconciliationReport(ctx, report)
+}
+
+func (billing SberBillingService) cancelOrder(ctx context.Context, invoiceId uint) *courseError.CourseError {
+	details, err := billing.banker.GetPurchaseDetails(ctx, fmt.Sprint(invoiceId))
+	if err != nil {
+		if err.Code == 15001 {
+			return nil
+		}
+		return err
+	}
+
+	if details.Status != dto.BillingStatusPending {
+		return nil
+	}
+
+	reminderEmail, err := billing.emailService.PreparePurchaseReminder(details)
+	if err != nil {
+		return err
+	}
+
+	return billing.banker.FailOrder(ctx, fmt.Sprint(invoiceId), reminderEmail)
+}
+
+// applyProviderRefund записывает возврат, начатый в банке. Если банк не передал сумму полного возврата,
+// возвращенной считается вся цена заказа.
+func (billing SberBillingService) applyProviderRefund(ctx context.Context, webhook entity.SberWebhook) *courseError.CourseError {
+	details, err := billing.banker.GetPurchaseDetails(ctx, fmt.Sprint(webhook.InvoiceId))
+	if err != nil {
+		return err
+	}
+
+	refundedTotal := uint(webhook.RefundedAmount)
+	if webhook.Status == InvoiceStatusRefunded && refundedTotal == 0 {
+		refundedTotal = details.Amount
+	}
+
+	return billing.recordRefund(ctx, details, refundedTotal, dto.CreateNewRefund(dto.RefundSourceProvider))
+}
@@ -51,6 +51,7 @@ type ContentManager interface {
 	DeleteModule(ctx context.Context, moduleId string) *courseError.CourseError
 	DeleteLesson(ctx context.Context, lessonId string) *courseError.CourseError
 	GetCourseByName(ctx context.Context, name string) (*dto.Course, *courseError.CourseError)
+	HasSubscriptionAccess(ctx context.Context, courseId uint) (bool, *courseError.CourseError)
 }
 
 // NewContentManagementServcie - это билдер для сервиса контента.
@@ -48,8 +48,9 @@ func (manager ContentManagementServcie) AddCourse(ctx context.Context,
 
 // GetCourseInfo используется для получения курса по фильтрам, в качестве параметров принимает название курса, описание
 // стоимость, скидка, они используются для поиска по фильтрам. В качестве обязательного параметра выступают страница и лимит.
-// Если был передан ID, то все остальные параметры игнорируются и происходит проверка на наличие курса у клиента. Если он не приобретен,
-// то возвращается только базовая информация без доступа к расширенному контенту. Метод возвращает массив курсов с пагинацией или ошибку.
+// Если был передан ID, то все остальные параметры игнорируются и происходит проверка на наличие курса у клиента. Если он не приобретен
+// и не открыт подпиской, то возвращается только базовая информация без доступа к расширенному контенту. Метод возвращает массив курсов
+// с пагинацией или ошибку.
 func (manager ContentManagementServcie) GetCourseInfo(ctx context.Context, params *CourseQueryParams) (*entity.CourseInfoWithPagination, *courseError.CourseError) {
 	var isCoursePurchased bool
 	if params.ID != "" {
@@ -71,6 +72,14 @@ func (manager ContentManagementServcie) GetCourseInfo(ctx context.Context, param
 				isCoursePurchased = true
 			}
 		}
+
+		if !isCoursePurchased && ctx.Value("UserId") != nil {
+			courseId, _ := strconv.Atoi(params.ID)
+			isCoursePurchased, err = manager.contentManager.HasSubscriptionAccess(ctx, uint(courseId))
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
 
 	if ctx.Value("adminId") != nil {
@@ -83,7 +83,8 @@ func (manager ContentManagementServcie) AddLesson(
 
 // GetLessonsInfo используется для получения уроков по фильтрам. В качестве параметров принимает название урока, описание
 // название модуля, название курса, страницу и лимит. Последние 2 параметра являются обязательными. Метод валидирует переданные данные
-// и если было передано название курса, то проверяет наличие этого курса у клиента. Если он был приобретен, то метод возвращает расширенный контент.
+// и если было передано название курса, то проверяет наличие этого курса у клиента. Если он был приобретен или открыт подпиской, то метод возвращает
+// расширенный контент.
 // Метод возвращает информацию об уроке или ошибку.
 func (manager ContentManagementServcie) GetLessonsInfo(ctx context.Context,
 	name, description, moduleName, courseName, page, limit string) (
@@ -116,6 +117,13 @@ func (manager ContentManagementServcie) GetLessonsInfo(ctx context.Context,
 					break
 				}
 			}
+
+			if !isPurchased {
+				isPurchased, err = manager.contentManager.HasSubscriptionAccess(ctx, course.ID)
+				if err != nil {
+					return nil, err
+				}
+			}
 		}
 	}
 
@@ -28,7 +28,7 @@ func (manager ContentManagementServcie) AddModule(ctx context.Context, module *e
 
 // GetModulesInfo используется для получения модулей. Принимает в качестве параметров название, описание, название курса, страницу и лимит.
 // Последние 2 параметра являются обязательными, остальные используются для поиска по фильтрам. Метод валидирует параметры и проверяет наличие
-// купленного курса, если было передано название курса. Если этот курс был куплен пользователем, то возвращается расширенный контент. Метод
+// купленного курса, если было передано название курса. Если этот курс был куплен пользователем или открыт подпиской, то возвращается расширенный контент. Метод
 // возвращает данные модуля вместе с пагинацией или ошибку.
 func (manager ContentManagementServcie) GetModulesInfo(ctx context.Context,
 	name, description, courseName, page, limit string) (*entity.ModuleInfoWithPagination, *courseError.CourseError) {
@@ -99,6 +99,7 @@ func (manager ContentManagementServcie) RemoveModule(ctx context.Context, module
 	return nil
 }
 
+// checkCoursePurchase проверяет, купил ли пользователь курс с названием courseName или открывает ли его подписка пользователя.
 func (manager ContentManagementServcie) checkCoursePurchase(ctx context.Context, courseName string) (bool, *courseError.CourseError) {
 	userCourses, err := manager.contentManager.GetUserCourses(ctx)
 	if err != nil {
@@ -116,6 +117,8 @@ func (manager ContentManagementServcie) checkCoursePurchase(ctx context.Context,
 				return true, nil
 			}
 		}
+
+		return manager.contentManager.HasSubscriptionAccess(ctx, course.ID)
 	}
 	return false, nil
 }
@@ -2,17 +2,15 @@
 package email
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"math/rand"
-	"net"
-	"net/smtp"
-	"strings"
 	"time"
 
 	"github.com/go-redis/redis"
-	"github.com/knstch/course/internal/app/config"
 	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
 )
 
 const (
@@ -20,47 +18,46 @@ const (
 	recover      = "recover"
 	ConfirmEmail = "confirmEmail"
 	confirm      = "confirm"
-
-	recoverPasswordTitle = "Код для восстановления пароля"
-	confirmEmailTitle    = "Код для подтверждения почты"
+	login        = "login"
 
 	emailSent = "sent"
 )
 
 var (
-	emailMessage          = "From: %v\r\nTo: %v\r\nSubject: %v\r\n\r\n%d"
 	errDoingAntispamCheck = errors.New("ошибка при проверке антиспам ключа")
 	ErrEmailIsAlreadySent = errors.New("письмо уже было отправлено, подождите 1 минуту перед отправкой нового")
 	errInvalidEmail       = errors.New("передана несуществующая почта")
 )
 
-// EmailService используется для отправки email.
+// outboxStorage используется для постановки писем в outbox, управления ими из админки и получения
+// почты и языка получателя.
+type outboxStorage interface {
+	EnqueueEmail(ctx context.Context, email *dto.OutboxEmail) *courseError.CourseError
+	GetOutboxEmails(ctx context.Context, status string, limit, offset int) ([]dto.OutboxEmail, int64, *courseError.CourseError)
+	ResendOutboxEmail(ctx context.Context, id uint) *courseError.CourseError
+	GetEmailRecipient(ctx context.Context, userId uint) (email, locale string, err *courseError.CourseError)
+	GetLocaleByEmail(ctx context.Context, email string) (string, *courseError.CourseError)
+}
+
+// EmailService используется для отправки email. Письма не отправляются внутри запроса, а пишутся в outbox,
+// откуда их отправляет Sender.
 type EmailService struct {
-	redis       *redis.Client
-	smtpHost    string
-	smptPort    string
-	auth        smtp.Auth
-	senderEmail string
-	isTest      bool
+	redis    *redis.Client
+	outbox   outboxStorage
+	verifier *Verifier
 }
 
 // NewEmailService - это билдер для EmailService.
-func NewEmailService(redis *redis.Client, config *config.Config) *EmailService {
-	auth := smtp.PlainAuth(me, config.ServiceEmail, config.ServiceEmailPassword, config.SmtpHost)
+func NewEmailService(redis *redis.Client, outbox outboxStorage, verifier *Verifier) *EmailService {
 	return &EmailService{
 		redis,
-		config.SmtpHost,
-		config.SmtpPort,
-		auth,
-		config.ServiceEmail,
-		config.IsTest,
+		outbox,
+		verifier,
 	}
 }
 
-// SendConfirmCode используется для отправки кода подтверждения. В качестве параметров принимает
-// ID пользователя и почту для отправки. Возвращает ошибку.
-func (email EmailService) SendConfirmCode(userId *uint, emailToSend *string, source string) *courseError.CourseError {
-	antispamKey := fmt.Sprintf("%v:%v", confirm, *emailToSend)
+// checkAntispam проверяет, что на почту не отправлялось такое же письмо в течение минуты, и запоминает отправку.
+func (email EmailService) checkAntispam(antispamKey string) *courseError.CourseError {
 	antispamValue, err := email.redis.Get(antispamKey).Result()
 	if err != nil {
 		if errors.Is(err, redis.Nil) {
@@ -76,16 +73,27 @@ func (email EmailService) SendConfirmCode(userId *uint, emailToSend *string, sou
 		return courseError.CreateError(ErrEmailIsAlreadySent, 17002)
 	}
 
-	confirmCode := email.generateEmailConfirmCode()
+	return nil
+}
 
-	if email.isTest {
-		if err := email.redis.Set(fmt.Sprint(*userId), 1111, 15*time.Minute).Err(); err != nil {
-			return courseError.CreateError(err, 10031)
-		}
-		return nil
+// SendConfirmCode используется для отправки кода подтверждения. В качестве параметров принимает
+// ID пользователя и почту для отправки. Возвращает ошибку.
+func (email EmailService) SendConfirmCode(ctx context.Context, userId *uint, emailToSend *string, source string) *courseError.CourseError {
+	if err := email.checkAntispam(fmt.Sprintf("%v:%v", confirm, *emailToSend)); err != nil {
+		return err
+	}
+
+	locale, err := email.GetUserLocale(ctx, *userId)
+	if err != nil {
+		return err
+	}
+
+	confirmEmail, confirmCode, err := email.PrepareConfirmCode(*emailToSend, source, locale)
+	if err != nil {
+		return err
 	}
 
-	if err := email.sendConfirmEmail(confirmCode, emailToSend, source); err != nil {
+	if err := email.outbox.EnqueueEmail(ctx, confirmEmail); err != nil {
 		return err
 	}
 
@@ -96,106 +104,128 @@ func (email EmailService) SendConfirmCode(userId *uint, emailToSend *string, sou
 	return nil
 }
 
-// generateEmailConfirmCode используется для генерации кода подтверждения.
-func (email EmailService) generateEmailConfirmCode() int {
-	return rand.Intn(9000) + 1000
-}
+// PrepareConfirmCode генерирует код подтверждения и письмо с ним на языке пользователя. Используется, когда письмо нужно
+// записать в outbox в одной транзакции с изменением в БД, после коммита код сохраняется через StoreConfirmCode.
+func (email EmailService) PrepareConfirmCode(emailToSend, source, locale string) (*dto.OutboxEmail, int, *courseError.CourseError) {
+	confirmCode := email.generateEmailConfirmCode()
 
-// sendConfirmEmail отправляет код подтверждения на почту, принимает в качестве параметров код и почту для отправки.
-// Возвращает ошибку.
-func (email EmailService) sendConfirmEmail(code int, userEmail *string, sourse string) *courseError.CourseError {
-	readyEmail := fmt.Sprintf(emailMessage, email.senderEmail, *userEmail, confirmEmailTitle, code)
-	if sourse == recover {
-		readyEmail = fmt.Sprintf(emailMessage, email.senderEmail, *userEmail, recoverPasswordTitle, code)
+	template := ConfirmCodeTemplate
+	if source == recover {
+		template = RecoverPasswordTemplate
 	}
 
-	if err := smtp.SendMail(fmt.Sprintf("%v:%v", email.smtpHost, email.smptPort), email.auth, email.senderEmail, []string{*userEmail}, []byte(readyEmail)); err != nil {
-		return courseError.CreateError(err, 17001)
+	confirmEmail, err := newOutboxEmail(emailToSend, template, locale, ConfirmCodeData{Code: confirmCode})
+	if err != nil {
+		return nil, 0, err
 	}
 
-	return nil
+	return confirmEmail, confirmCode, nil
 }
 
-// SendPasswordRecoverConfirmCode используется для отправки кода подтверждения на почту для изменения пароля.
-// Принимает в качестве параметра почту для отправки, возвращает ошибку.
-func (email EmailService) SendPasswordRecoverConfirmCode(emailToSend string) *courseError.CourseError {
-	antispamKey := fmt.Sprintf("%v:%v", recover, emailToSend)
-	antispamValue, err := email.redis.Get(antispamKey).Result()
+// GetUserLocale возвращает язык писем пользователя по его ID.
+func (email EmailService) GetUserLocale(ctx context.Context, userId uint) (string, *courseError.CourseError) {
+	_, locale, err := email.outbox.GetEmailRecipient(ctx, userId)
 	if err != nil {
-		if errors.Is(err, redis.Nil) {
-			if err := email.redis.Set(antispamKey, emailSent, time.Minute).Err(); err != nil {
-				return courseError.CreateError(err, 10031)
-			}
-		} else {
-			return courseError.CreateError(errDoingAntispamCheck, 11004)
-		}
-	}
-
-	if antispamValue != "" {
-		return courseError.CreateError(ErrEmailIsAlreadySent, 17002)
+		return "", err
 	}
 
-	confirmCode := email.generateEmailConfirmCode()
+	return locale, nil
+}
 
-	if email.isTest {
-		if err := email.redis.Set(emailToSend, 1111, 15*time.Minute).Err(); err != nil {
-			return courseError.CreateError(err, 10031)
-		}
-		return nil
+// newOutboxEmail рендерит шаблон письма и собирает из него письмо для outbox.
+func newOutboxEmail(recipient, template, locale string, data interface{}) (*dto.OutboxEmail, *courseError.CourseError) {
+	rendered, err := Render(template, locale, data)
+	if err != nil {
+		return nil, err
 	}
 
-	if err := email.sendConfirmEmail(confirmCode, &emailToSend, recover); err != nil {
-		return err
+	return dto.CreateNewOutboxEmail(recipient, rendered.Subject, rendered.Text).AddHtmlBody(rendered.Html), nil
+}
+
+// StoreConfirmCode сохраняет код подтверждения пользователя, письмо с которым уже записано в outbox,
+// и запоминает отправку для антиспама. Возвращает ошибку.
+func (email EmailService) StoreConfirmCode(userId uint, emailToSend string, confirmCode int) *courseError.CourseError {
+	if err := email.redis.Set(fmt.Sprintf("%v:%v", confirm, emailToSend), emailSent, time.Minute).Err(); err != nil {
+		return courseError.CreateError(err, 10031)
 	}
 
-	if err := email.redis.Set(emailToSend, confirmCode, 15*time.Minute).Err(); err != nil {
+	if err := email.redis.Set(fmt.Sprint(userId), confirmCode, 15*time.Minute).Err(); err != nil {
 		return courseError.CreateError(err, 10031)
 	}
 
 	return nil
 }
 
-func (email EmailService) ValidateEmail(emailToCheck string) *courseError.CourseError {
-	if email.isTest {
-		return nil
-	}
+// generateEmailConfirmCode используется для генерации кода подтверждения.
+func (email EmailService) generateEmailConfirmCode() int {
+	return rand.Intn(9000) + 1000
+}
 
-	parts := strings.Split(emailToCheck, "@")
+// SendPasswordRecoverConfirmCode используется для отправки кода подтверждения на почту для изменения пароля.
+// Принимает в качестве параметра почту для отправки, возвращает ошибку.
+func (email EmailService) SendPasswordRecoverConfirmCode(ctx context.Context, emailToSend string) *courseError.CourseError {
+	if err := email.checkAntispam(fmt.Sprintf("%v:%v", recover, emailToSend)); err != nil {
+		return err
+	}
 
-	mxRecords, err := net.LookupMX(parts[1])
+	locale, err := email.outbox.GetLocaleByEmail(ctx, emailToSend)
 	if err != nil {
-		return courseError.CreateError(err, 17003)
+		return err
 	}
 
-	client, err := email.smtpDialTimeout(mxRecords[0].Host, 3*time.Second)
+	recoverEmail, confirmCode, err := email.PrepareConfirmCode(emailToSend, recover, locale)
 	if err != nil {
-		return courseError.CreateError(err, 17003)
+		return err
+	}
+
+	if err := email.outbox.EnqueueEmail(ctx, recoverEmail); err != nil {
+		return err
 	}
-	defer client.Close()
 
-	if err := client.Hello(parts[1]); err != nil {
-		return courseError.CreateError(err, 17003)
+	if err := email.redis.Set(emailToSend, confirmCode, 15*time.Minute).Err(); err != nil {
+		return courseError.CreateError(err, 10031)
+	}
+
+	return nil
+}
+
+// SendLoginLink используется для отправки ссылки для входа без пароля. Принимает в качестве параметров
+// почту для отправки и ссылку, возвращает ошибку.
+func (email EmailService) SendLoginLink(ctx context.Context, emailToSend, link string) *courseError.CourseError {
+	if err := email.checkAntispam(fmt.Sprintf("%v:%v", login, emailToSend)); err != nil {
+		return err
 	}
 
-	err = client.Mail(emailToCheck)
+	locale, err := email.outbox.GetLocaleByEmail(ctx, emailToSend)
 	if err != nil {
-		return courseError.CreateError(err, 17003)
+		return err
 	}
 
-	err = client.Rcpt(emailToCheck)
+	loginEmail, err := newOutboxEmail(emailToSend, LoginLinkTemplate, locale, LoginLinkData{Link: link})
 	if err != nil {
-		return courseError.CreateError(errInvalidEmail, 17004)
+		return err
+	}
+
+	if err := email.outbox.EnqueueEmail(ctx, loginEmail); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-func (email EmailService) smtpDialTimeout(addr string, timeout time.Duration) (*smtp.Client, error) {
-	conn, err := net.DialTimeout("tcp", addr+":25", timeout)
+// PrepareWelcome собирает приветственное письмо для пользователя на его языке. Письмо записывается в outbox
+// в одной транзакции с подтверждением почты. Принимает ID пользователя, возвращает письмо или ошибку.
+func (email EmailService) PrepareWelcome(ctx context.Context, userId uint) (*dto.OutboxEmail, *courseError.CourseError) {
+	emailToSend, locale, err := email.outbox.GetEmailRecipient(ctx, userId)
 	if err != nil {
 		return nil, err
 	}
 
-	host, _, _ := net.SplitHostPort(addr)
-	return smtp.NewClient(conn, host)
+	return newOutboxEmail(emailToSend, WelcomeTemplate, locale, WelcomeData{Email: emailToSend})
+}
+
+// ValidateEmail используется для проверки почты при регистрации. Принимает почту, возвращает ошибку,
+// если почта не прошла проверку Verifier.
+func (email EmailService) ValidateEmail(ctx context.Context, emailToCheck string) *courseError.CourseError {
+	return email.verifier.Verify(ctx, emailToCheck)
 }
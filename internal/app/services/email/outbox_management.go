@@ -0,0 +1,53 @@
+package email
+
+import (
+	"context"
+	"strconv"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/validation"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+// RetreiveOutboxEmails используется для просмотра писем в outbox. Принимает статус письма (необязательно),
+// страницу и лимит, валидирует их и возвращает письма с пагинацией или ошибку.
+func (email EmailService) RetreiveOutboxEmails(ctx context.Context, status, page, limit string) (*entity.OutboxEmailsWithPagination, *courseError.CourseError) {
+	if err := validation.NewOutboxQueryToValidate(status, page, limit).Validate(ctx); err != nil {
+		return nil, err
+	}
+
+	pageInt, _ := strconv.Atoi(page)
+	limitInt, _ := strconv.Atoi(limit)
+
+	emails, totalCount, err := email.outbox.GetOutboxEmails(ctx, status, limitInt, pageInt*limitInt)
+	if err != nil {
+		return nil, err
+	}
+
+	pagesCount := int(totalCount) / limitInt
+	if int(totalCount)%limitInt != 0 {
+		pagesCount++
+	}
+
+	return &entity.OutboxEmailsWithPagination{
+		Pagination: entity.Pagination{
+			Page:       pageInt,
+			Limit:      limitInt,
+			TotalCount: int(totalCount),
+			PagesCount: pagesCount,
+		},
+		Emails: entity.CreateOutboxEmails(emails),
+	}, nil
+}
+
+// ResendOutboxEmail используется для повторной отправки письма, у которого закончились попытки.
+// Принимает ID письма, валидирует его и возвращает письмо в очередь. Возвращает ошибку.
+func (email EmailService) ResendOutboxEmail(ctx context.Context, id string) *courseError.CourseError {
+	if err := validation.NewStringIdToValidate(id).Validate(ctx); err != nil {
+		return err
+	}
+
+	emailId, _ := strconv.Atoi(id)
+
+	return email.outbox.ResendOutboxEmail(ctx, uint(emailId))
+}
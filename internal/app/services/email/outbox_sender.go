@@ -0,0 +1,216 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"time"
+
+	"github.com/knstch/course/internal/app/config"
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/logger"
+	"github.com/knstch/course/internal/domain/dto"
+)
+
+const (
+	// OutboxSent, OutboxRetry и OutboxDead - это результаты попытки отправки письма для метрик.
+	OutboxSent  = "sent"
+	OutboxRetry = "retry"
+	OutboxDead  = "dead"
+
+	smtpTimeout = 10 * time.Second
+	// outboxLease задает, через сколько письмо снова станет доступно для отправки, если отправщик упал.
+	outboxLease = 5 * time.Minute
+)
+
+var (
+	emailHeaders = "From: %v\r\nTo: %v\r\nSubject: %v\r\nMIME-Version: 1.0\r\nContent-Type: %v\r\n"
+)
+
+// outboxManager содержит методы для работы с outbox в БД.
+type outboxManager interface {
+	ClaimOutboxEmails(ctx context.Context, limit int, lease time.Duration) ([]dto.OutboxEmail, *courseError.CourseError)
+	UpdateOutboxEmail(ctx context.Context, email *dto.OutboxEmail) *courseError.CourseError
+}
+
+// outboxRecorder используется для подсчета результатов отправки писем.
+type outboxRecorder interface {
+	RecordOutboxEmail(result string)
+}
+
+// Sender - это фоновый отправщик писем из outbox. Неудачные попытки повторяются с экспоненциальной задержкой,
+// после EMAIL_OUTBOX_MAX_ATTEMPTS попыток письмо получает статус failed и может быть переотправлено админом.
+type Sender struct {
+	outbox       outboxManager
+	logger       logger.Logger
+	metrics      outboxRecorder
+	transport    MailTransport
+	senderEmail  string
+	pollInterval time.Duration
+	batchSize    int
+	maxAttempts  int
+	retryBase    time.Duration
+	retryMax     time.Duration
+}
+
+// NewSender - это билдер для Sender.
+func NewSender(outbox outboxManager, transport MailTransport, config *config.Config, logger logger.Logger, metrics outboxRecorder) *Sender {
+	return &Sender{
+		outbox:       outbox,
+		logger:       logger,
+		metrics:      metrics,
+		transport:    transport,
+		senderEmail:  config.ServiceEmail,
+		pollInterval: config.EmailOutboxPollInterval,
+		batchSize:    config.EmailOutboxBatchSize,
+		maxAttempts:  config.EmailOutboxMaxAttempts,
+		retryBase:    config.EmailOutboxRetryBase,
+		retryMax:     config.EmailOutboxRetryMax,
+	}
+}
+
+// Run отправляет письма из outbox раз в EMAIL_OUTBOX_POLL_INTERVAL, пока не будет отменен контекст.
+func (sender *Sender) Run(ctx context.Context) {
+	ticker := time.NewTicker(sender.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		sender.SendBatch(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// SendBatch забирает пачку писем, которые пора отправить, и сохраняет результат каждой попытки. Результат сохраняется
+// без контекста Run, чтобы отправленное при остановке сервиса письмо не ушло повторно. Возвращает количество
+// взятых писем, в тестах используется для отправки писем без ожидания Run.
+func (sender *Sender) SendBatch(ctx context.Context) int {
+	emails, err := sender.outbox.ClaimOutboxEmails(ctx, sender.batchSize, outboxLease)
+	if err != nil {
+		sender.logger.Error("не получилось получить письма из outbox", "Sender", err.Message, err.Code)
+		return 0
+	}
+
+	for i := range emails {
+		if ctx.Err() != nil {
+			return len(emails)
+		}
+
+		email := &emails[i]
+		email.Attempts++
+
+		now := time.Now()
+		if err := sender.send(email); err != nil {
+			if email.Attempts >= sender.maxAttempts {
+				email.SetStatusFailed(err.Error())
+				sender.metrics.RecordOutboxEmail(OutboxDead)
+				sender.logger.Error(fmt.Sprintf("письмо с ID: %d не отправлено после %d попыток", email.ID, email.Attempts), "Sender", err.Error(), 17001)
+			} else {
+				email.ScheduleRetry(now.Add(sender.retryDelay(email.Attempts)), err.Error())
+				sender.metrics.RecordOutboxEmail(OutboxRetry)
+			}
+		} else {
+			email.SetStatusSent(now)
+			sender.metrics.RecordOutboxEmail(OutboxSent)
+		}
+
+		if err := sender.outbox.UpdateOutboxEmail(context.Background(), email); err != nil {
+			sender.logger.Error(fmt.Sprintf("не получилось сохранить статус письма с ID: %d", email.ID), "Sender", err.Message, err.Code)
+		}
+	}
+
+	return len(emails)
+}
+
+// retryDelay возвращает задержку перед следующей попыткой: EMAIL_OUTBOX_RETRY_BASE, умноженный на 2 за каждую
+// неудачную попытку, но не больше EMAIL_OUTBOX_RETRY_MAX.
+func (sender *Sender) retryDelay(attempts int) time.Duration {
+	delay := sender.retryBase
+	for i := 1; i < attempts && delay < sender.retryMax; i++ {
+		delay *= 2
+	}
+
+	if delay > sender.retryMax {
+		return sender.retryMax
+	}
+
+	return delay
+}
+
+// send собирает письмо и передает его транспорту.
+func (sender *Sender) send(email *dto.OutboxEmail) error {
+	message, err := buildMessage(sender.senderEmail, email)
+	if err != nil {
+		return err
+	}
+
+	return sender.transport.Send(sender.senderEmail, email.Recipient, message)
+}
+
+// buildMessage собирает письмо в формате MIME. Если у письма есть HTML версия, письмо отправляется как
+// multipart/alternative из текстовой и HTML частей, иначе только текстом. Тема кодируется в UTF-8.
+func buildMessage(from string, email *dto.OutboxEmail) ([]byte, error) {
+	var message bytes.Buffer
+	subject := mime.QEncoding.Encode("utf-8", email.Subject)
+
+	if email.HtmlBody == "" {
+		fmt.Fprintf(&message, emailHeaders, from, email.Recipient, subject, "text/plain; charset=utf-8")
+		message.WriteString("Content-Transfer-Encoding: quoted-printable\r\n\r\n")
+		if err := writeQuotedPrintable(&message, email.Body); err != nil {
+			return nil, err
+		}
+		return message.Bytes(), nil
+	}
+
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+
+	for _, part := range []struct {
+		contentType string
+		content     string
+	}{
+		{"text/plain; charset=utf-8", email.Body},
+		{"text/html; charset=utf-8", email.HtmlBody},
+	} {
+		partWriter, err := parts.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if err := writeQuotedPrintable(partWriter, part.content); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(&message, emailHeaders, from, email.Recipient, subject,
+		fmt.Sprintf("multipart/alternative; boundary=%v", parts.Boundary()))
+	message.WriteString("\r\n")
+	message.Write(body.Bytes())
+
+	return message.Bytes(), nil
+}
+
+func writeQuotedPrintable(writer io.Writer, content string) error {
+	encoder := quotedprintable.NewWriter(writer)
+	if _, err := encoder.Write([]byte(content)); err != nil {
+		return err
+	}
+
+	return encoder.Close()
+}
@@ -0,0 +1,70 @@
+package email
+
+import (
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+)
+
+// PreparePurchaseReceipt собирает письмо с чеком о покупке курса на языке пользователя. Письмо записывается в outbox
+// в одной транзакции с подтверждением оплаты. Принимает данные заказа, возвращает письмо или ошибку.
+func (email EmailService) PreparePurchaseReceipt(details *dto.PurchaseDetails) (*dto.OutboxEmail, *courseError.CourseError) {
+	return newOutboxEmail(details.Email, PurchaseReceiptTemplate, details.Locale, PurchaseReceiptData{
+		CourseName:    details.CourseName,
+		Order:         details.Order,
+		InvoiceId:     details.InvoiceId,
+		PaymentMethod: details.PaymentMethod,
+		Currency:      purchaseCurrency(details),
+		Price:         dto.FromMinorUnits(details.Amount),
+		GiftCode:      details.GiftCode,
+		GiftRecipient: details.GiftRecipient,
+	})
+}
+
+// PrepareGiftNotification собирает письмо с кодом подарка для получателя на языке покупателя. Письмо записывается
+// в outbox вместе с чеком. Если курс куплен не в подарок или почта получателя не указана, письма нет. Принимает
+// данные заказа, возвращает письмо или ошибку.
+func (email EmailService) PrepareGiftNotification(details *dto.PurchaseDetails) (*dto.OutboxEmail, *courseError.CourseError) {
+	if details.GiftCode == "" || details.GiftRecipient == "" {
+		return nil, nil
+	}
+
+	return newOutboxEmail(details.GiftRecipient, GiftReceivedTemplate, details.Locale, GiftReceivedData{
+		CourseName: details.CourseName,
+		Code:       details.GiftCode,
+	})
+}
+
+// PreparePurchaseReminder собирает письмо с напоминанием о незавершенной покупке курса на языке пользователя.
+// Принимает данные заказа, возвращает письмо или ошибку.
+func (email EmailService) PreparePurchaseReminder(details *dto.PurchaseDetails) (*dto.OutboxEmail, *courseError.CourseError) {
+	return newOutboxEmail(details.Email, PurchaseReminderTemplate, details.Locale, PurchaseReminderData{
+		CourseName: details.CourseName,
+		Order:      details.Order,
+		Currency:   purchaseCurrency(details),
+		Price:      dto.FromMinorUnits(details.Amount),
+	})
+}
+
+// PreparePurchaseRefund собирает письмо о возврате денег за курс на языке пользователя. Принимает данные заказа
+// до возврата и общую сумму возврата в минимальных единицах валюты с учетом прошлых возвратов, возвращает письмо
+// или ошибку.
+func (email EmailService) PreparePurchaseRefund(details *dto.PurchaseDetails, refundedTotal uint) (*dto.OutboxEmail, *courseError.CourseError) {
+	return newOutboxEmail(details.Email, PurchaseRefundTemplate, details.Locale, PurchaseRefundData{
+		CourseName:    details.CourseName,
+		Order:         details.Order,
+		InvoiceId:     details.InvoiceId,
+		Currency:      purchaseCurrency(details),
+		Amount:        dto.FromMinorUnits(refundedTotal - details.RefundedAmount),
+		RefundedTotal: dto.FromMinorUnits(refundedTotal),
+		Price:         dto.FromMinorUnits(details.Amount),
+		AccessRevoked: refundedTotal >= details.Amount,
+	})
+}
+
+// purchaseCurrency возвращает валюту заказа. Заказы, оформленные до появления валют, оплачены в рублях.
+func purchaseCurrency(details *dto.PurchaseDetails) string {
+	if details.Currency == "" {
+		return dto.CurrencyRUB
+	}
+	return details.Currency
+}
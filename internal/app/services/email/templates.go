@@ -0,0 +1,260 @@
+package email
+
+import (
+	"bytes"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	htmlTemplate "html/template"
+	"strings"
+	textTemplate "text/template"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/validation"
+	"github.com/knstch/course/internal/domain/dto"
+)
+
+const (
+	// LocaleRu и LocaleEn - это поддерживаемые языки писем. Если язык пользователя неизвестен, письмо отправляется на русском.
+	LocaleRu = "ru"
+	LocaleEn = "en"
+
+	ConfirmCodeTemplate      = "confirm_code"
+	RecoverPasswordTemplate  = "recover_password"
+	LoginLinkTemplate        = "login_link"
+	WelcomeTemplate          = "welcome"
+	PurchaseReceiptTemplate  = "purchase_receipt"
+	PurchaseReminderTemplate = "purchase_reminder"
+	PurchaseRefundTemplate   = "purchase_refund"
+	GiftReceivedTemplate     = "gift_received"
+
+	defaultLocale = LocaleRu
+)
+
+var (
+	//go:embed templates
+	templatesFS embed.FS
+
+	Locales       = []string{LocaleRu, LocaleEn}
+	TemplateNames = []string{ConfirmCodeTemplate, RecoverPasswordTemplate, LoginLinkTemplate, WelcomeTemplate, PurchaseReceiptTemplate,
+		PurchaseReminderTemplate, PurchaseRefundTemplate, GiftReceivedTemplate}
+
+	// currencySigns содержит знаки валют для писем на русском, в письмах на английском выводится код валюты.
+	currencySigns = map[string]string{
+		dto.CurrencyRUB: "₽",
+		dto.CurrencyUSD: "$",
+		dto.CurrencyEUR: "€",
+		dto.CurrencyKZT: "₸",
+	}
+
+	templateFuncs = map[string]interface{}{
+		"currencySign": currencySign,
+	}
+
+	emailTemplates = mustParseTemplates()
+
+	errTemplateNotFound = errors.New("шаблон письма не найден")
+)
+
+// ConfirmCodeData - это данные для писем с кодом подтверждения почты и восстановления пароля.
+type ConfirmCodeData struct {
+	Code int
+}
+
+// LoginLinkData - это данные для письма со ссылкой для входа.
+type LoginLinkData struct {
+	Link string
+}
+
+// WelcomeData - это данные для приветственного письма.
+type WelcomeData struct {
+	Email string
+}
+
+// PurchaseReceiptData - это данные для письма о покупке курса. Если курс куплен в подарок, передается код
+// подарка и почта получателя, если она указана.
+type PurchaseReceiptData struct {
+	CourseName    string
+	Order         string
+	InvoiceId     uint
+	PaymentMethod string
+	Currency      string
+	Price         float64
+	GiftCode      string
+	GiftRecipient string
+}
+
+// PurchaseReminderData - это данные для напоминания о незавершенной покупке курса.
+type PurchaseReminderData struct {
+	CourseName string
+	Order      string
+	Currency   string
+	Price      float64
+}
+
+// PurchaseRefundData - это данные для письма о возврате денег за курс. AccessRevoked означает, что деньги
+// вернули полностью и курс больше не доступен.
+type PurchaseRefundData struct {
+	CourseName    string
+	Order         string
+	InvoiceId     uint
+	Currency      string
+	Amount        float64
+	RefundedTotal float64
+	Price         float64
+	AccessRevoked bool
+}
+
+// GiftReceivedData - это данные для письма получателю подарка.
+type GiftReceivedData struct {
+	CourseName string
+	Code       string
+}
+
+// sampleData содержит примеры данных для предпросмотра шаблонов из админки.
+var sampleData = map[string]interface{}{
+	ConfirmCodeTemplate:     ConfirmCodeData{Code: 4821},
+	RecoverPasswordTemplate: ConfirmCodeData{Code: 4821},
+	LoginLinkTemplate:       LoginLinkData{Link: "https://example.com/login?token=sample"},
+	WelcomeTemplate:         WelcomeData{Email: "student@example.com"},
+	PurchaseReceiptTemplate: PurchaseReceiptData{
+		CourseName:    "Go для начинающих",
+		Order:         "2f1c6a1e-3b8d-4c55-9a57-1d2f4e9b0c11",
+		InvoiceId:     100500,
+		PaymentMethod: "ru-card",
+		Currency:      dto.CurrencyRUB,
+		Price:         4990,
+	},
+	PurchaseReminderTemplate: PurchaseReminderData{
+		CourseName: "Go для начинающих",
+		Order:      "2f1c6a1e-3b8d-4c55-9a57-1d2f4e9b0c11",
+		Currency:   dto.CurrencyRUB,
+		Price:      4990,
+	},
+	PurchaseRefundTemplate: PurchaseRefundData{
+		CourseName:    "Go для начинающих",
+		Order:         "2f1c6a1e-3b8d-4c55-9a57-1d2f4e9b0c11",
+		InvoiceId:     100500,
+		Currency:      dto.CurrencyRUB,
+		Amount:        4990,
+		RefundedTotal: 4990,
+		Price:         4990,
+		AccessRevoked: true,
+	},
+	GiftReceivedTemplate: GiftReceivedData{
+		CourseName: "Go для начинающих",
+		Code:       "K7QM-2XWP-9RTA-H4NE",
+	},
+}
+
+// emailTemplate - это пара шаблонов письма: HTML версия и текстовая версия с темой письма.
+type emailTemplate struct {
+	html *htmlTemplate.Template
+	text *textTemplate.Template
+}
+
+// RenderedEmail - это готовое к отправке письмо.
+type RenderedEmail struct {
+	Subject string
+	Text    string
+	Html    string
+}
+
+// mustParseTemplates разбирает шаблоны всех писем на всех языках при старте сервиса. HTML шаблоны
+// встраиваются в общий layout.html, тема письма задается блоком subject в текстовом шаблоне.
+func mustParseTemplates() map[string]emailTemplate {
+	templates := make(map[string]emailTemplate, len(TemplateNames)*len(Locales))
+
+	for _, name := range TemplateNames {
+		for _, locale := range Locales {
+			textName := fmt.Sprintf("%v.%v.txt", name, locale)
+			templates[templateKey(name, locale)] = emailTemplate{
+				html: htmlTemplate.Must(htmlTemplate.New("layout.html").Funcs(templateFuncs).ParseFS(templatesFS,
+					"templates/layout.html", fmt.Sprintf("templates/%v.%v.html", name, locale))),
+				text: textTemplate.Must(textTemplate.New(textName).Funcs(templateFuncs).ParseFS(templatesFS,
+					"templates/"+textName)),
+			}
+		}
+	}
+
+	return templates
+}
+
+func templateKey(name, locale string) string {
+	return name + "." + locale
+}
+
+// currencySign возвращает знак валюты по ее коду. Если знак неизвестен, возвращается код.
+func currencySign(currency string) string {
+	if sign, ok := currencySigns[currency]; ok {
+		return sign
+	}
+	return currency
+}
+
+// Render используется для рендера письма. Принимает название шаблона, язык и данные для шаблона.
+// Если шаблона на нужном языке нет, используется русский. Возвращает письмо или ошибку.
+func Render(name, locale string, data interface{}) (*RenderedEmail, *courseError.CourseError) {
+	template, ok := emailTemplates[templateKey(name, locale)]
+	if !ok {
+		template, ok = emailTemplates[templateKey(name, defaultLocale)]
+		if !ok {
+			return nil, courseError.CreateError(errTemplateNotFound, 17006)
+		}
+	}
+
+	var subject, text, html bytes.Buffer
+
+	if err := template.text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return nil, courseError.CreateError(err, 17007)
+	}
+
+	if err := template.text.Execute(&text, data); err != nil {
+		return nil, courseError.CreateError(err, 17007)
+	}
+
+	if err := template.html.Execute(&html, data); err != nil {
+		return nil, courseError.CreateError(err, 17007)
+	}
+
+	return &RenderedEmail{
+		Subject: strings.TrimSpace(subject.String()),
+		Text:    text.String(),
+		Html:    html.String(),
+	}, nil
+}
+
+// RenderSample используется для предпросмотра шаблона с примером данных. Принимает название шаблона и язык,
+// возвращает письмо или ошибку.
+func RenderSample(name, locale string) (*RenderedEmail, *courseError.CourseError) {
+	data, ok := sampleData[name]
+	if !ok {
+		return nil, courseError.CreateError(errTemplateNotFound, 17006)
+	}
+
+	return Render(name, locale, data)
+}
+
+// PreviewTemplate используется для предпросмотра шаблона письма из админки без отправки. Принимает название шаблона,
+// язык и формат (html или text), валидирует их и рендерит шаблон с примером данных. Возвращает текст письма или ошибку.
+func (email EmailService) PreviewTemplate(ctx context.Context, name, locale, format string) (string, *courseError.CourseError) {
+	if err := validation.NewTemplatePreviewToValidate(locale, format).Validate(ctx); err != nil {
+		return "", err
+	}
+
+	if locale == "" {
+		locale = defaultLocale
+	}
+
+	rendered, err := RenderSample(name, locale)
+	if err != nil {
+		return "", err
+	}
+
+	if format == "text" {
+		return fmt.Sprintf("Subject: %v\n\n%v", rendered.Subject, rendered.Text), nil
+	}
+
+	return rendered.Html, nil
+}
@@ -0,0 +1,227 @@
+package email
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/knstch/course/internal/app/config"
+)
+
+const (
+	// MailTransportSmtp, MailTransportFile и MailTransportMemory - это допустимые значения MAIL_TRANSPORT.
+	MailTransportSmtp   = "smtp"
+	MailTransportFile   = "file"
+	MailTransportMemory = "memory"
+)
+
+var (
+	errUnknownMailTransport = errors.New("неизвестный способ отправки писем")
+)
+
+// MailTransport доставляет готовое письмо в формате MIME получателю.
+type MailTransport interface {
+	Send(from, to string, message []byte) error
+}
+
+// NewMailTransport собирает способ отправки писем по MAIL_TRANSPORT: smtp (по умолчанию), file или memory.
+func NewMailTransport(config *config.Config) (MailTransport, error) {
+	switch config.MailTransport {
+	case MailTransportSmtp, "":
+		return NewSmtpTransport(config), nil
+	case MailTransportFile:
+		return NewMaildirTransport(config.MailDir)
+	case MailTransportMemory:
+		return NewMemoryTransport(), nil
+	default:
+		return nil, fmt.Errorf("%w: %v", errUnknownMailTransport, config.MailTransport)
+	}
+}
+
+// SmtpTransport отправляет письма по SMTP. По умолчанию соединение поднимается через STARTTLS, если сервер
+// его поддерживает, при SMTP_IMPLICIT_TLS соединение сразу устанавливается по TLS, как на порту 465.
+type SmtpTransport struct {
+	host        string
+	port        string
+	auth        smtp.Auth
+	implicitTls bool
+}
+
+// NewSmtpTransport - это билдер для SmtpTransport.
+func NewSmtpTransport(config *config.Config) *SmtpTransport {
+	return &SmtpTransport{
+		host:        config.SmtpHost,
+		port:        config.SmtpPort,
+		auth:        smtp.PlainAuth(me, config.ServiceEmail, config.ServiceEmailPassword, config.SmtpHost),
+		implicitTls: config.SmtpImplicitTls,
+	}
+}
+
+// Send отправляет письмо по SMTP. В отличие от smtp.SendMail, соединение ограничено по времени,
+// чтобы зависший сервер не блокировал отправку остальных писем.
+func (transport *SmtpTransport) Send(from, to string, message []byte) error {
+	dialer := &net.Dialer{Timeout: smtpTimeout}
+	address := net.JoinHostPort(transport.host, transport.port)
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	if transport.implicitTls {
+		conn, err = tls.DialWithDialer(dialer, "tcp", address, &tls.Config{ServerName: transport.host})
+	} else {
+		conn, err = dialer.Dial("tcp", address)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := conn.SetDeadline(time.Now().Add(smtpTimeout)); err != nil {
+		conn.Close()
+		return err
+	}
+
+	client, err := smtp.NewClient(conn, transport.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer client.Close()
+
+	if !transport.implicitTls {
+		if ok, _ := client.Extension("STARTTLS"); ok {
+			if err := client.StartTLS(&tls.Config{ServerName: transport.host}); err != nil {
+				return err
+			}
+		}
+	}
+
+	if ok, _ := client.Extension("AUTH"); ok {
+		if err := client.Auth(transport.auth); err != nil {
+			return err
+		}
+	}
+
+	if err := client.Mail(from); err != nil {
+		return err
+	}
+
+	if err := client.Rcpt(to); err != nil {
+		return err
+	}
+
+	writer, err := client.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err := writer.Write(message); err != nil {
+		writer.Close()
+		return err
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
+	}
+
+	return client.Quit()
+}
+
+// MaildirTransport складывает письма в локальный Maildir вместо отправки. Используется для локальной разработки,
+// письма можно открыть любым почтовым клиентом с поддержкой Maildir.
+type MaildirTransport struct {
+	dir     string
+	counter uint64
+}
+
+// NewMaildirTransport - это билдер для MaildirTransport. Создает в MAIL_DIR каталоги tmp, new и cur.
+func NewMaildirTransport(dir string) (*MaildirTransport, error) {
+	for _, subdir := range []string{"tmp", "new", "cur"} {
+		if err := os.MkdirAll(filepath.Join(dir, subdir), 0700); err != nil {
+			return nil, err
+		}
+	}
+
+	return &MaildirTransport{
+		dir: dir,
+	}, nil
+}
+
+// Send записывает письмо в tmp и переносит его в new, чтобы клиент не увидел письмо недописанным.
+func (transport *MaildirTransport) Send(from, to string, message []byte) error {
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "localhost"
+	}
+
+	name := fmt.Sprintf("%d.P%dQ%d.%v", time.Now().UnixNano(), os.Getpid(), atomic.AddUint64(&transport.counter, 1), hostname)
+
+	tmpPath := filepath.Join(transport.dir, "tmp", name)
+	if err := os.WriteFile(tmpPath, message, 0600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, filepath.Join(transport.dir, "new", name))
+}
+
+// SentMail - это письмо, сохраненное в MemoryTransport.
+type SentMail struct {
+	From    string
+	To      string
+	Message []byte
+}
+
+// MemoryTransport хранит письма в памяти вместо отправки. Используется в тестах, чтобы проверять
+// реальные коды и тексты писем.
+type MemoryTransport struct {
+	mu    sync.Mutex
+	mails []SentMail
+}
+
+// NewMemoryTransport - это билдер для MemoryTransport.
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+func (transport *MemoryTransport) Send(from, to string, message []byte) error {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	transport.mails = append(transport.mails, SentMail{
+		From:    from,
+		To:      to,
+		Message: append([]byte(nil), message...),
+	})
+
+	return nil
+}
+
+// Mails возвращает все сохраненные письма в порядке отправки.
+func (transport *MemoryTransport) Mails() []SentMail {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	return append([]SentMail(nil), transport.mails...)
+}
+
+// LastMail возвращает последнее письмо, отправленное на почту, и false, если писем не было.
+func (transport *MemoryTransport) LastMail(to string) (*SentMail, bool) {
+	transport.mu.Lock()
+	defer transport.mu.Unlock()
+
+	for i := len(transport.mails) - 1; i >= 0; i-- {
+		if transport.mails[i].To == to {
+			mail := transport.mails[i]
+			return &mail, true
+		}
+	}
+
+	return nil, false
+}
@@ -0,0 +1,278 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	_ "embed"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/knstch/course/internal/app/config"
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/logger"
+)
+
+//go:embed disposable_domains.txt
+var defaultDisposableDomains string
+
+const (
+	mxCacheKey = "mx:%v"
+	// noMxHost кэшируется для доменов без MX записей, чтобы не спрашивать DNS повторно.
+	noMxHost = "-"
+	smtpPort = "25"
+)
+
+var (
+	errBadEmailSyntax  = errors.New("почта указана неверно")
+	errDisposableEmail = errors.New("временные почтовые ящики не поддерживаются, укажите постоянную почту")
+)
+
+// Resolver ищет MX записи домена. net.DefaultResolver подходит как есть, в тестах подменяется на резолвер без сети.
+type Resolver interface {
+	LookupMX(ctx context.Context, name string) ([]*net.MX, error)
+}
+
+// Dialer открывает соединение с почтовым сервером для SMTP проверки. net.Dialer подходит как есть.
+type Dialer interface {
+	DialContext(ctx context.Context, network, address string) (net.Conn, error)
+}
+
+// Verifier проверяет почту при регистрации по шагам: синтаксис, список временных почтовых ящиков, MX записи домена
+// с кэшем в Redis и SMTP проверка получателя. Каждый шаг включается в конфиге, сетевые шаги ограничены своим таймаутом.
+type Verifier struct {
+	redis             *redis.Client
+	logger            logger.Logger
+	resolver          Resolver
+	dialer            Dialer
+	disposableDomains map[string]struct{}
+	probeFrom         string
+	checkSyntax       bool
+	checkDisposable   bool
+	checkMx           bool
+	checkSmtp         bool
+	mxTimeout         time.Duration
+	mxCacheTTL        time.Duration
+	smtpTimeout       time.Duration
+}
+
+// NewVerifier - это билдер для Verifier. Загружает список временных почтовых ящиков из EMAIL_VERIFY_DISPOSABLE_LIST_PATH
+// в дополнение к встроенному. Если redis не передан, MX записи не кэшируются. Возвращает верификатор или ошибку.
+func NewVerifier(redis *redis.Client, resolver Resolver, dialer Dialer, config *config.Config, logger logger.Logger) (*Verifier, error) {
+	verifier := &Verifier{
+		redis:             redis,
+		logger:            logger,
+		resolver:          resolver,
+		dialer:            dialer,
+		disposableDomains: make(map[string]struct{}),
+		probeFrom:         config.ServiceEmail,
+		checkSyntax:       config.EmailVerifySyntax,
+		checkDisposable:   config.EmailVerifyDisposable,
+		checkMx:           config.EmailVerifyMx,
+		checkSmtp:         config.EmailVerifySmtp,
+		mxTimeout:         config.EmailVerifyMxTimeout,
+		mxCacheTTL:        config.EmailVerifyMxCacheTTL,
+		smtpTimeout:       config.EmailVerifySmtpTimeout,
+	}
+
+	for _, v := range strings.Split(defaultDisposableDomains, "\n") {
+		verifier.addDisposableDomain(v)
+	}
+
+	if config.EmailVerifyDisposableListPath != "" {
+		file, err := os.Open(config.EmailVerifyDisposableListPath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			verifier.addDisposableDomain(scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	return verifier, nil
+}
+
+func (verifier *Verifier) addDisposableDomain(domain string) {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if domain != "" && !strings.HasPrefix(domain, "#") {
+		verifier.disposableDomains[domain] = struct{}{}
+	}
+}
+
+// Verify проверяет почту по включенным шагам. Возвращает ошибку, если почта не прошла проверку.
+func (verifier *Verifier) Verify(ctx context.Context, email string) *courseError.CourseError {
+	at := strings.LastIndex(email, "@")
+	if at <= 0 || at == len(email)-1 {
+		return courseError.CreateError(errBadEmailSyntax, 17008)
+	}
+	domain := strings.ToLower(email[at+1:])
+
+	if verifier.checkSyntax && !isValidEmailSyntax(email, domain) {
+		return courseError.CreateError(errBadEmailSyntax, 17008)
+	}
+
+	if verifier.checkDisposable && verifier.isDisposable(domain) {
+		return courseError.CreateError(errDisposableEmail, 17009)
+	}
+
+	if !verifier.checkMx && !verifier.checkSmtp {
+		return nil
+	}
+
+	mxHost, err := verifier.lookupMx(ctx, domain)
+	if err != nil {
+		return err
+	}
+
+	if mxHost == "" {
+		return courseError.CreateError(errInvalidEmail, 17004)
+	}
+
+	if verifier.checkSmtp {
+		return verifier.probe(ctx, mxHost, email)
+	}
+
+	return nil
+}
+
+// isValidEmailSyntax проверяет, что почта - это один адрес без имени и комментариев, а домен состоит
+// хотя бы из двух частей.
+func isValidEmailSyntax(email, domain string) bool {
+	if len(email) > 254 || strings.LastIndex(email, "@") > 64 {
+		return false
+	}
+
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email || address.Name != "" {
+		return false
+	}
+
+	return strings.Contains(domain, ".") && !strings.HasPrefix(domain, ".") && !strings.HasSuffix(domain, ".")
+}
+
+// isDisposable проверяет домен и все его родительские домены по списку временных почтовых ящиков.
+func (verifier *Verifier) isDisposable(domain string) bool {
+	for {
+		if _, ok := verifier.disposableDomains[domain]; ok {
+			return true
+		}
+
+		dot := strings.Index(domain, ".")
+		if dot < 0 {
+			return false
+		}
+		domain = domain[dot+1:]
+	}
+}
+
+// lookupMx возвращает почтовый сервер домена с наивысшим приоритетом или пустую строку, если домен не принимает почту.
+// Результат кэшируется в Redis на EMAIL_VERIFY_MX_CACHE_TTL, в том числе отсутствие MX записей. Кэш не обязателен:
+// если Redis недоступен, ошибка записывается в лог, а MX записи запрашиваются у DNS.
+func (verifier *Verifier) lookupMx(ctx context.Context, domain string) (string, *courseError.CourseError) {
+	cacheKey := fmt.Sprintf(mxCacheKey, domain)
+
+	if verifier.redis != nil {
+		host, err := verifier.redis.Get(cacheKey).Result()
+		if err == nil {
+			if host == noMxHost {
+				return "", nil
+			}
+			return host, nil
+		}
+		if !errors.Is(err, redis.Nil) {
+			verifier.logger.Error(fmt.Sprintf("не получилось прочитать MX записи домена %v из кэша", domain), "lookupMx", err.Error(), 10030)
+		}
+	}
+
+	lookupCtx, cancel := context.WithTimeout(ctx, verifier.mxTimeout)
+	defer cancel()
+
+	host := ""
+	records, err := verifier.resolver.LookupMX(lookupCtx, domain)
+	if err != nil {
+		var dnsErr *net.DNSError
+		if !errors.As(err, &dnsErr) || !dnsErr.IsNotFound {
+			return "", courseError.CreateError(err, 17003)
+		}
+	} else if len(records) > 0 {
+		// Null MX из RFC 7505 записывается как ".", такой домен явно не принимает почту.
+		host = strings.TrimSuffix(records[0].Host, ".")
+	}
+
+	if verifier.redis != nil {
+		cached := host
+		if cached == "" {
+			cached = noMxHost
+		}
+		if err := verifier.redis.Set(cacheKey, cached, verifier.mxCacheTTL).Err(); err != nil {
+			verifier.logger.Error(fmt.Sprintf("не получилось записать MX записи домена %v в кэш", domain), "lookupMx", err.Error(), 10031)
+		}
+	}
+
+	return host, nil
+}
+
+// probe спрашивает почтовый сервер, существует ли получатель. Почтовые серверы часто блокируют такие проверки,
+// поэтому ошибкой считается только явный отказ сервера принять получателя (коды 5xx), а недоступный сервер,
+// таймаут и временные ошибки пропускаются.
+func (verifier *Verifier) probe(ctx context.Context, mxHost, email string) *courseError.CourseError {
+	probeCtx, cancel := context.WithTimeout(ctx, verifier.smtpTimeout)
+	defer cancel()
+
+	conn, err := verifier.dialer.DialContext(probeCtx, "tcp", net.JoinHostPort(mxHost, smtpPort))
+	if err != nil {
+		return nil
+	}
+
+	if deadline, ok := probeCtx.Deadline(); ok {
+		if err := conn.SetDeadline(deadline); err != nil {
+			conn.Close()
+			return nil
+		}
+	}
+
+	client, err := smtp.NewClient(conn, mxHost)
+	if err != nil {
+		conn.Close()
+		return nil
+	}
+	defer client.Close()
+
+	heloHost := "localhost"
+	if at := strings.LastIndex(verifier.probeFrom, "@"); at >= 0 {
+		heloHost = verifier.probeFrom[at+1:]
+	}
+
+	if err := client.Hello(heloHost); err != nil {
+		return nil
+	}
+
+	if err := client.Mail(verifier.probeFrom); err != nil {
+		return nil
+	}
+
+	if err := client.Rcpt(email); err != nil {
+		var protocolErr *textproto.Error
+		if errors.As(err, &protocolErr) && protocolErr.Code >= 500 {
+			return courseError.CreateError(errInvalidEmail, 17004)
+		}
+		return nil
+	}
+
+	client.Quit()
+
+	return nil
+}
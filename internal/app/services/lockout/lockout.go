@@ -0,0 +1,173 @@
+// lockout содержит защиту от перебора паролей и кодов подтверждения.
+package lockout
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis"
+	"github.com/knstch/course/internal/app/config"
+	courseError "github.com/knstch/course/internal/app/course_error"
+)
+
+const (
+	// ScopeLogin - логин пользователя, аккаунт идентифицируется почтой.
+	ScopeLogin = "login"
+	// ScopeEmailVerification - подтверждение почты, аккаунт идентифицируется ID пользователя.
+	ScopeEmailVerification = "email_verification"
+	// ScopePasswordRecovery - восстановление пароля, аккаунт идентифицируется почтой.
+	ScopePasswordRecovery = "password_recovery"
+	// ScopeTwoFactor - второй шаг логина кодом из аутентификатора или кодом восстановления, аккаунт идентифицируется
+	// ID пользователя.
+	ScopeTwoFactor = "two_factor"
+	// ScopeAdminLogin - логин администратора, аккаунт идентифицируется логином.
+	ScopeAdminLogin = "admin_login"
+
+	keyPrefix = "lockout:"
+)
+
+var (
+	ErrTooManyAttempts = errors.New("слишком много неудачных попыток")
+
+	userScopes = []string{ScopeLogin, ScopeEmailVerification, ScopePasswordRecovery, ScopeTwoFactor}
+)
+
+// Lockout считает неудачные попытки входа в Redis отдельно по аккаунту и по IP. После превышения
+// лимита аккаунт или IP блокируются, и каждая следующая неудачная попытка удваивает время блокировки.
+// Счетчики сбрасываются, если в течение окна не было неудачных попыток.
+type Lockout struct {
+	redis         *redis.Client
+	maxAttempts   int64
+	maxIpAttempts int64
+	window        time.Duration
+	baseDuration  time.Duration
+	maxDuration   time.Duration
+}
+
+// NewLockout - это билдер для Lockout.
+func NewLockout(client *redis.Client, config *config.Config) *Lockout {
+	return &Lockout{
+		redis:         client,
+		maxAttempts:   config.LockoutMaxAttempts,
+		maxIpAttempts: config.LockoutMaxIpAttempts,
+		window:        config.LockoutWindow,
+		baseDuration:  config.LockoutBaseDuration,
+		maxDuration:   config.LockoutMaxDuration,
+	}
+}
+
+// Check проверяет, не заблокированы ли аккаунт или IP в переданном сценарии. Если блокировка активна,
+// возвращает ошибку с временем, через которое можно повторить попытку.
+func (lockout Lockout) Check(scope, account, ip string) *courseError.CourseError {
+	var accountTTL, ipTTL *redis.DurationCmd
+	if _, err := lockout.redis.Pipelined(func(pipe redis.Pipeliner) error {
+		accountTTL = pipe.TTL(lockKey(scope, "account", account))
+		ipTTL = pipe.TTL(lockKey(scope, "ip", ip))
+		return nil
+	}); err != nil {
+		return courseError.CreateError(err, 10030)
+	}
+
+	retryAfter := accountTTL.Val()
+	if ipTTL.Val() > retryAfter {
+		retryAfter = ipTTL.Val()
+	}
+
+	if retryAfter > 0 {
+		return courseError.CreateError(fmt.Errorf("%w, повторите через %d сек.",
+			ErrTooManyAttempts, int(retryAfter.Round(time.Second).Seconds())), 11019)
+	}
+
+	return nil
+}
+
+// RegisterFailure увеличивает счетчики неудачных попыток по аккаунту и IP и блокирует их,
+// если лимит превышен. Возвращает ошибку.
+func (lockout Lockout) RegisterFailure(scope, account, ip string) *courseError.CourseError {
+	if err := lockout.registerFailure(scope, "account", account, lockout.maxAttempts); err != nil {
+		return err
+	}
+
+	if err := lockout.registerFailure(scope, "ip", ip, lockout.maxIpAttempts); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (lockout Lockout) registerFailure(scope, subject, value string, maxAttempts int64) *courseError.CourseError {
+	if value == "" {
+		return nil
+	}
+
+	key := counterKey(scope, subject, value)
+
+	var attempts *redis.IntCmd
+	if _, err := lockout.redis.TxPipelined(func(pipe redis.Pipeliner) error {
+		attempts = pipe.Incr(key)
+		pipe.Expire(key, lockout.window)
+		return nil
+	}); err != nil {
+		return courseError.CreateError(err, 10030)
+	}
+
+	if attempts.Val() < maxAttempts {
+		return nil
+	}
+
+	if err := lockout.redis.Set(lockKey(scope, subject, value), attempts.Val(),
+		lockout.backoff(attempts.Val()-maxAttempts)).Err(); err != nil {
+		return courseError.CreateError(err, 10031)
+	}
+
+	return nil
+}
+
+// backoff возвращает время блокировки, которое удваивается с каждой попыткой сверх лимита.
+func (lockout Lockout) backoff(overLimit int64) time.Duration {
+	duration := lockout.baseDuration
+	for i := int64(0); i < overLimit; i++ {
+		duration *= 2
+		if duration >= lockout.maxDuration {
+			return lockout.maxDuration
+		}
+	}
+
+	return duration
+}
+
+// Reset сбрасывает счетчик неудачных попыток аккаунта после успешного входа. Счетчик IP не сбрасывается,
+// чтобы перебор по разным аккаунтам с одного адреса не обнулялся удачным входом в свой аккаунт.
+func (lockout Lockout) Reset(scope, account string) *courseError.CourseError {
+	if err := lockout.redis.Del(counterKey(scope, "account", account)).Err(); err != nil {
+		return courseError.CreateError(err, 10033)
+	}
+
+	return nil
+}
+
+// UnlockUser снимает блокировки и сбрасывает счетчики пользователя во всех сценариях.
+// Принимает почту и ID пользователя. Возвращает ошибку.
+func (lockout Lockout) UnlockUser(email, userId string) *courseError.CourseError {
+	keys := make([]string, 0, len(userScopes)*4)
+	for _, scope := range userScopes {
+		for _, account := range []string{email, userId} {
+			keys = append(keys, counterKey(scope, "account", account), lockKey(scope, "account", account))
+		}
+	}
+
+	if err := lockout.redis.Del(keys...).Err(); err != nil {
+		return courseError.CreateError(err, 10033)
+	}
+
+	return nil
+}
+
+func counterKey(scope, subject, value string) string {
+	return keyPrefix + scope + ":" + subject + ":" + value
+}
+
+func lockKey(scope, subject, value string) string {
+	return keyPrefix + "lock:" + scope + ":" + subject + ":" + value
+}
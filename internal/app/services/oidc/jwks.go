@@ -0,0 +1,112 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+const jwksRefreshInterval = time.Hour
+
+var errUnknownKeyId = errors.New("ключ провайдера с таким kid не найден")
+
+// remoteKeySet хранит публичные ключи провайдера, загруженные по JWKS. Ключи перезапрашиваются
+// раз в час или когда приходит токен с неизвестным kid, так как провайдеры ротируют ключи.
+type remoteKeySet struct {
+	url    string
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+func newRemoteKeySet(url string, client *http.Client) *remoteKeySet {
+	return &remoteKeySet{
+		url:    url,
+		client: client,
+	}
+}
+
+// key возвращает публичный ключ для проверки подписи токена по kid из заголовка.
+func (keySet *remoteKeySet) key(ctx context.Context, t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	keySet.mu.Lock()
+	defer keySet.mu.Unlock()
+
+	key, ok := keySet.keys[kid]
+	if ok && time.Since(keySet.fetchedAt) < jwksRefreshInterval {
+		return key, nil
+	}
+
+	if err := keySet.fetch(ctx); err != nil {
+		return nil, err
+	}
+
+	key, ok = keySet.keys[kid]
+	if !ok {
+		return nil, errUnknownKeyId
+	}
+
+	return key, nil
+}
+
+func (keySet *remoteKeySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, keySet.url, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := keySet.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	jwks := entity.CreateJWKS()
+	if err := json.NewDecoder(resp.Body).Decode(jwks); err != nil {
+		return err
+	}
+
+	keys := make(map[string]crypto.PublicKey, len(jwks.Keys))
+	for _, v := range jwks.Keys {
+		switch v.Kty {
+		case "RSA":
+			n, err := base64.RawURLEncoding.DecodeString(v.N)
+			if err != nil {
+				continue
+			}
+			e, err := base64.RawURLEncoding.DecodeString(v.E)
+			if err != nil {
+				continue
+			}
+			keys[v.Kid] = &rsa.PublicKey{
+				N: new(big.Int).SetBytes(n),
+				E: int(new(big.Int).SetBytes(e).Int64()),
+			}
+		case "OKP":
+			x, err := base64.RawURLEncoding.DecodeString(v.X)
+			if err != nil || v.Crv != "Ed25519" || len(x) != ed25519.PublicKeySize {
+				continue
+			}
+			keys[v.Kid] = ed25519.PublicKey(x)
+		}
+	}
+
+	keySet.keys = keys
+	keySet.fetchedAt = time.Now()
+
+	return nil
+}
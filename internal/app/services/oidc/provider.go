@@ -0,0 +1,346 @@
+// oidc содержит провайдеров социального логина по протоколам OAuth2 и OpenID Connect.
+package oidc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/knstch/course/internal/app/config"
+	courseError "github.com/knstch/course/internal/app/course_error"
+)
+
+const (
+	Google = "google"
+	Yandex = "yandex"
+	Vk     = "vk"
+)
+
+var (
+	ErrProviderRejected  = errors.New("провайдер отклонил запрос авторизации")
+	ErrBadIdToken        = errors.New("ID токен провайдера невалиден")
+	ErrBadNonce          = errors.New("nonce в ID токене не совпадает")
+	ErrSubjectIsNotFound = errors.New("провайдер не вернул ID пользователя")
+)
+
+// Identity содержит данные пользователя, полученные от провайдера.
+type Identity struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// Provider - это провайдер социального логина. Если у провайдера задан JWKS и он вернул ID токен, то данные
+// пользователя берутся из проверенного ID токена, иначе запрашиваются у userinfo эндпоинта по токену доступа.
+type Provider struct {
+	clientId     string
+	clientSecret string
+	redirectUrl  string
+	authUrl      string
+	tokenUrl     string
+	userInfoUrl  string
+	issuer       string
+	scopes       []string
+
+	// subjectClaim, emailClaim и emailVerifiedClaim - пути к полям в ID токене или ответе userinfo,
+	// вложенные поля разделяются точкой. Если emailVerifiedClaim пустой, то провайдер отдает только подтвержденные почты.
+	subjectClaim       string
+	emailClaim         string
+	emailVerifiedClaim string
+	userInfoByPost     bool
+	authScheme         string
+
+	keys   *remoteKeySet
+	client *http.Client
+}
+
+// tokenResponse - ответ token эндпоинта провайдера.
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	IdToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// presets содержит адреса и маппинг полей известных провайдеров. Yandex ID и VK ID не выдают
+// ID токен с проверяемой подписью, поэтому данные пользователя берутся из userinfo.
+var presets = map[string]Provider{
+	Google: {
+		authUrl:            "https://accounts.google.com/o/oauth2/v2/auth",
+		tokenUrl:           "https://oauth2.googleapis.com/token",
+		userInfoUrl:        "https://openidconnect.googleapis.com/v1/userinfo",
+		issuer:             "https://accounts.google.com",
+		scopes:             []string{"openid", "email"},
+		subjectClaim:       "sub",
+		emailClaim:         "email",
+		emailVerifiedClaim: "email_verified",
+		authScheme:         "Bearer",
+	},
+	Yandex: {
+		authUrl:      "https://oauth.yandex.ru/authorize",
+		tokenUrl:     "https://oauth.yandex.ru/token",
+		userInfoUrl:  "https://login.yandex.ru/info?format=json",
+		scopes:       []string{"login:email"},
+		subjectClaim: "id",
+		emailClaim:   "default_email",
+		authScheme:   "OAuth",
+	},
+	Vk: {
+		authUrl:        "https://id.vk.com/authorize",
+		tokenUrl:       "https://id.vk.com/oauth2/auth",
+		userInfoUrl:    "https://id.vk.com/oauth2/user_info",
+		scopes:         []string{"email"},
+		subjectClaim:   "user.user_id",
+		emailClaim:     "user.email",
+		userInfoByPost: true,
+	},
+}
+
+var defaultJwksUrls = map[string]string{
+	Google: "https://www.googleapis.com/oauth2/v3/certs",
+}
+
+// NewProviders собирает включенных в конфиге провайдеров. Провайдер включен, если для него задан client id.
+// Адреса из конфига переопределяют адреса пресета, это используется для тестов с локальным провайдером.
+func NewProviders(config *config.Config, client *http.Client) map[string]*Provider {
+	providers := make(map[string]*Provider)
+
+	for name, providerConfig := range providerConfigs(config) {
+		if providerConfig.ClientId == "" {
+			continue
+		}
+
+		provider := presets[name]
+		provider.clientId = providerConfig.ClientId
+		provider.clientSecret = providerConfig.ClientSecret
+		provider.redirectUrl = fmt.Sprintf("%s/%s/callback", strings.TrimSuffix(config.OAuthRedirectUrl, "/"), name)
+		provider.client = client
+
+		provider.authUrl = override(provider.authUrl, providerConfig.AuthUrl)
+		provider.tokenUrl = override(provider.tokenUrl, providerConfig.TokenUrl)
+		provider.userInfoUrl = override(provider.userInfoUrl, providerConfig.UserInfoUrl)
+		provider.issuer = override(provider.issuer, providerConfig.Issuer)
+
+		if jwksUrl := override(defaultJwksUrls[name], providerConfig.JwksUrl); jwksUrl != "" {
+			provider.keys = newRemoteKeySet(jwksUrl, client)
+		}
+
+		providers[name] = &provider
+	}
+
+	return providers
+}
+
+func providerConfigs(cfg *config.Config) map[string]config.OAuthProviderConfig {
+	return map[string]config.OAuthProviderConfig{
+		Google: cfg.OAuthGoogle,
+		Yandex: cfg.OAuthYandex,
+		Vk:     cfg.OAuthVk,
+	}
+}
+
+func override(value, configValue string) string {
+	if configValue != "" {
+		return configValue
+	}
+	return value
+}
+
+// AuthCodeURL возвращает адрес страницы авторизации провайдера. Принимает state, nonce и PKCE challenge.
+func (provider Provider) AuthCodeURL(state, nonce, codeChallenge string) string {
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", provider.clientId)
+	params.Set("redirect_uri", provider.redirectUrl)
+	params.Set("scope", strings.Join(provider.scopes, " "))
+	params.Set("state", state)
+	params.Set("nonce", nonce)
+	params.Set("code_challenge", codeChallenge)
+	params.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(provider.authUrl, "?") {
+		separator = "&"
+	}
+
+	return provider.authUrl + separator + params.Encode()
+}
+
+// Exchange обменивает код авторизации на токены провайдера и возвращает данные пользователя.
+// Принимает код, PKCE verifier, device id (передается VK ID в колбэке) и nonce, который должен совпасть с nonce в ID токене.
+func (provider Provider) Exchange(ctx context.Context, code, codeVerifier, deviceId, nonce string) (*Identity, *courseError.CourseError) {
+	params := url.Values{}
+	params.Set("grant_type", "authorization_code")
+	params.Set("code", code)
+	params.Set("redirect_uri", provider.redirectUrl)
+	params.Set("client_id", provider.clientId)
+	params.Set("client_secret", provider.clientSecret)
+	params.Set("code_verifier", codeVerifier)
+	if deviceId != "" {
+		params.Set("device_id", deviceId)
+	}
+
+	var tokens tokenResponse
+	if err := provider.postForm(ctx, provider.tokenUrl, params, &tokens); err != nil {
+		return nil, err
+	}
+
+	if tokens.Error != "" {
+		return nil, courseError.CreateError(fmt.Errorf("%w: %s", ErrProviderRejected, strings.TrimSpace(tokens.Error+" "+tokens.ErrorDescription)), 11203)
+	}
+
+	var claims map[string]interface{}
+	if provider.keys != nil && tokens.IdToken != "" {
+		idTokenClaims, err := provider.verifyIdToken(ctx, tokens.IdToken, nonce)
+		if err != nil {
+			return nil, err
+		}
+		claims = idTokenClaims
+	} else {
+		userInfo, err := provider.userInfo(ctx, tokens.AccessToken)
+		if err != nil {
+			return nil, err
+		}
+		claims = userInfo
+	}
+
+	return provider.identity(claims)
+}
+
+// verifyIdToken проверяет подпись, издателя, аудиторию, срок жизни и nonce ID токена. Возвращает claims или ошибку.
+func (provider Provider) verifyIdToken(ctx context.Context, idToken, nonce string) (map[string]interface{}, *courseError.CourseError) {
+	options := []jwt.ParserOption{
+		jwt.WithAudience(provider.clientId),
+		jwt.WithExpirationRequired(),
+		jwt.WithValidMethods([]string{jwt.SigningMethodRS256.Alg(), jwt.SigningMethodEdDSA.Alg()}),
+	}
+	if provider.issuer != "" {
+		options = append(options, jwt.WithIssuer(provider.issuer))
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(idToken, claims, func(t *jwt.Token) (interface{}, error) {
+		return provider.keys.key(ctx, t)
+	}, options...); err != nil {
+		return nil, courseError.CreateError(fmt.Errorf("%w: %s", ErrBadIdToken, err.Error()), 11204)
+	}
+
+	if claimNonce, _ := claims["nonce"].(string); claimNonce != nonce {
+		return nil, courseError.CreateError(ErrBadNonce, 11204)
+	}
+
+	return claims, nil
+}
+
+// userInfo запрашивает данные пользователя у userinfo эндпоинта провайдера.
+func (provider Provider) userInfo(ctx context.Context, accessToken string) (map[string]interface{}, *courseError.CourseError) {
+	userInfo := make(map[string]interface{})
+
+	if provider.userInfoByPost {
+		params := url.Values{}
+		params.Set("client_id", provider.clientId)
+		params.Set("access_token", accessToken)
+		if err := provider.postForm(ctx, provider.userInfoUrl, params, &userInfo); err != nil {
+			return nil, err
+		}
+		return userInfo, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, provider.userInfoUrl, nil)
+	if err != nil {
+		return nil, courseError.CreateError(err, 11040)
+	}
+	req.Header.Set("Authorization", provider.authScheme+" "+accessToken)
+
+	if err := provider.do(req, &userInfo); err != nil {
+		return nil, err
+	}
+
+	return userInfo, nil
+}
+
+func (provider Provider) postForm(ctx context.Context, endpoint string, params url.Values, dest interface{}) *courseError.CourseError {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(params.Encode()))
+	if err != nil {
+		return courseError.CreateError(err, 11040)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	return provider.do(req, dest)
+}
+
+func (provider Provider) do(req *http.Request, dest interface{}) *courseError.CourseError {
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := provider.client.Do(req)
+	if err != nil {
+		return courseError.CreateError(err, 11041)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return courseError.CreateError(err, 11042)
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	if err := decoder.Decode(dest); err != nil {
+		return courseError.CreateError(fmt.Errorf("%w: статус %d", ErrProviderRejected, resp.StatusCode), 11203)
+	}
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		if tokens, ok := dest.(*tokenResponse); ok && tokens.Error != "" {
+			return nil
+		}
+		return courseError.CreateError(fmt.Errorf("%w: статус %d", ErrProviderRejected, resp.StatusCode), 11203)
+	}
+
+	return nil
+}
+
+// identity достает данные пользователя из claims по настроенным путям.
+func (provider Provider) identity(claims map[string]interface{}) (*Identity, *courseError.CourseError) {
+	subject := claimString(claims, provider.subjectClaim)
+	if subject == "" {
+		return nil, courseError.CreateError(ErrSubjectIsNotFound, 11203)
+	}
+
+	emailVerified := true
+	if provider.emailVerifiedClaim != "" {
+		emailVerified = claimString(claims, provider.emailVerifiedClaim) == "true"
+	}
+
+	return &Identity{
+		Subject:       subject,
+		Email:         strings.ToLower(claimString(claims, provider.emailClaim)),
+		EmailVerified: emailVerified,
+	}, nil
+}
+
+func claimString(claims map[string]interface{}, path string) string {
+	var value interface{} = claims
+	for _, key := range strings.Split(path, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return ""
+		}
+		value = object[key]
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	default:
+		return fmt.Sprint(v)
+	}
+}
@@ -0,0 +1,71 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const hashPrefixLength = 5
+
+// localCorpus - это корпус утекших паролей в формате Have I Been Pwned: в директории лежит файл <PREFIX>.txt
+// на каждый префикс SHA-1 хэша, в файле строки вида SUFFIX:COUNT. Такие файлы скачивает haveibeenpwned-downloader.
+type localCorpus struct {
+	dir string
+}
+
+func newLocalCorpus(dir string) *localCorpus {
+	return &localCorpus{
+		dir: dir,
+	}
+}
+
+// Range возвращает строки файла для префикса. Если файла нет, то утекших паролей с таким префиксом нет.
+func (corpus *localCorpus) Range(prefix string) ([]string, error) {
+	file, err := os.Open(filepath.Join(corpus.dir, prefix+".txt"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer file.Close()
+
+	var lines []string
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return lines, nil
+}
+
+// isBreached проверяет пароль по корпусу с k-анонимностью: в корпус передаются только первые 5 символов хэша,
+// а суффикс сравнивается локально. Записи с нулевым счетчиком - это паддинг, они пропускаются.
+func isBreached(corpus breachedPasswords, password string) (bool, error) {
+	hash := sha1.Sum([]byte(password))
+	hexHash := strings.ToUpper(hex.EncodeToString(hash[:]))
+	prefix, suffix := hexHash[:hashPrefixLength], hexHash[hashPrefixLength:]
+
+	lines, err := corpus.Range(prefix)
+	if err != nil {
+		return false, err
+	}
+
+	for _, v := range lines {
+		lineSuffix, count, _ := strings.Cut(strings.TrimSpace(v), ":")
+		if strings.EqualFold(lineSuffix, suffix) && strings.TrimLeft(count, "0") != "" {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,155 @@
+// password содержит парольную политику: проверку стойкости пароля, популярных и утекших паролей.
+package password
+
+import (
+	"bufio"
+	_ "embed"
+	"errors"
+	"math"
+	"os"
+	"strings"
+	"unicode"
+
+	"github.com/knstch/course/internal/app/config"
+	courseError "github.com/knstch/course/internal/app/course_error"
+)
+
+//go:embed common_passwords.txt
+var defaultCommonPasswords string
+
+var (
+	ErrPasswordIsWeak     = errors.New("пароль слишком простой, сделайте его длиннее или добавьте символы другого типа")
+	ErrPasswordIsCommon   = errors.New("пароль входит в список популярных паролей, придумайте другой")
+	ErrPasswordIsBreached = errors.New("пароль встречается в утечках данных, придумайте другой")
+)
+
+// Policy проверяет новые пароли пользователей и администраторов. Повторное использование старых паролей
+// проверяется в БД, так как для этого нужны хэши прошлых паролей.
+type Policy struct {
+	minEntropy      float64
+	commonPasswords map[string]struct{}
+	breached        breachedPasswords
+}
+
+// breachedPasswords возвращает суффиксы SHA-1 хэшей утекших паролей по первым 5 символам хэша. Так пароль
+// можно проверить без передачи его хэша целиком, если корпус будет вынесен во внешний сервис.
+type breachedPasswords interface {
+	Range(prefix string) ([]string, error)
+}
+
+// NewPolicy - это билдер для Policy. Загружает список популярных паролей из PASSWORD_COMMON_LIST_PATH в дополнение
+// к встроенному и подключает корпус утекших паролей, если задан PASSWORD_BREACHED_HASHES_DIR. Возвращает политику или ошибку.
+func NewPolicy(config *config.Config) (*Policy, error) {
+	policy := &Policy{
+		minEntropy:      config.PasswordMinEntropy,
+		commonPasswords: make(map[string]struct{}),
+	}
+
+	for _, v := range strings.Split(defaultCommonPasswords, "\n") {
+		policy.addCommonPassword(v)
+	}
+
+	if config.PasswordCommonListPath != "" {
+		file, err := os.Open(config.PasswordCommonListPath)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			policy.addCommonPassword(scanner.Text())
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.PasswordBreachedHashesDir != "" {
+		policy.breached = newLocalCorpus(config.PasswordBreachedHashesDir)
+	}
+
+	return policy, nil
+}
+
+func (policy *Policy) addCommonPassword(password string) {
+	password = strings.ToLower(strings.TrimSpace(password))
+	if password != "" {
+		policy.commonPasswords[password] = struct{}{}
+	}
+}
+
+// Check проверяет, что пароль достаточно стойкий, не входит в список популярных паролей и не встречается в утечках.
+// Возвращает ошибку с кодом нарушенного правила.
+func (policy *Policy) Check(password string) *courseError.CourseError {
+	if entropy(password) < policy.minEntropy {
+		return courseError.CreateError(ErrPasswordIsWeak, 11401)
+	}
+
+	if _, ok := policy.commonPasswords[strings.ToLower(password)]; ok {
+		return courseError.CreateError(ErrPasswordIsCommon, 11402)
+	}
+
+	if policy.breached != nil {
+		breached, err := isBreached(policy.breached, password)
+		if err != nil {
+			return courseError.CreateError(err, 11405)
+		}
+		if breached {
+			return courseError.CreateError(ErrPasswordIsBreached, 11404)
+		}
+	}
+
+	return nil
+}
+
+// entropy оценивает энтропию пароля в битах по размеру алфавита, из которого набраны символы.
+// Подряд идущие одинаковые символы считаются за один, чтобы "Aaaaaaaa1" не считался стойким.
+func entropy(password string) float64 {
+	var hasLower, hasUpper, hasNumber, hasSymbol, hasOther bool
+	var length int
+	var previous rune
+
+	for i, v := range password {
+		if i == 0 || v != previous {
+			length++
+		}
+		previous = v
+
+		switch {
+		case v > unicode.MaxASCII:
+			hasOther = true
+		case unicode.IsLower(v):
+			hasLower = true
+		case unicode.IsUpper(v):
+			hasUpper = true
+		case unicode.IsNumber(v):
+			hasNumber = true
+		default:
+			hasSymbol = true
+		}
+	}
+
+	var alphabet int
+	if hasLower {
+		alphabet += 26
+	}
+	if hasUpper {
+		alphabet += 26
+	}
+	if hasNumber {
+		alphabet += 10
+	}
+	if hasSymbol {
+		alphabet += 33
+	}
+	if hasOther {
+		alphabet += 66
+	}
+
+	if alphabet == 0 {
+		return 0
+	}
+
+	return float64(length) * math.Log2(float64(alphabet))
+}
@@ -0,0 +1,230 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/knstch/course/internal/app/config"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+const (
+	// UserAudience записывается в токены пользователей.
+	UserAudience = "course"
+	// AdminAudience записывается в токены администраторов.
+	AdminAudience = "course-admin"
+	// MagicLinkAudience записывается в токены ссылок для входа по почте.
+	MagicLinkAudience = "course-magic-link"
+)
+
+var (
+	errUnknownKeyId       = errors.New("ключ подписи с таким kid не найден")
+	errUnsupportedKey     = errors.New("поддерживаются только ключи RSA и Ed25519")
+	errSigningKeyNotFound = errors.New("приватный ключ для подписи не найден")
+	errKeysDirNotSet      = errors.New("JWT_KEYS_DIR не задан, временный ключ разрешен только в ENVIRONMENT=dev или test")
+)
+
+// verificationKey содержит публичный ключ и алгоритм, которым подписываются токены.
+// Если приватный ключ не задан, ключ используется только для проверки старых токенов.
+type verificationKey struct {
+	method  jwt.SigningMethod
+	private crypto.Signer
+	public  crypto.PublicKey
+}
+
+// KeySet хранит ключи для подписи и проверки JWT. Токены подписываются ключом с signingKid,
+// а проверяются любым ключом из набора, поэтому при ротации старые токены остаются валидными.
+type KeySet struct {
+	signingKid string
+	keys       map[string]verificationKey
+}
+
+// NewKeySet загружает ключи из директории JWT_KEYS_DIR. Имя файла без расширения .pem считается kid.
+// Файл с приватным ключом PKCS8 используется для подписи и проверки, файл с публичным ключом PKIX - только для проверки.
+// Если директория не задана, в ENVIRONMENT=dev или test генерируется временный Ed25519 ключ, который живет до
+// перезапуска сервиса, в остальных окружениях сервис не запускается.
+func NewKeySet(config *config.Config) (*KeySet, error) {
+	if config.JwtKeysDir == "" {
+		if config.Environment != "dev" && config.Environment != "test" {
+			return nil, errKeysDirNotSet
+		}
+		return newEphemeralKeySet()
+	}
+
+	paths, err := filepath.Glob(filepath.Join(config.JwtKeysDir, "*.pem"))
+	if err != nil {
+		return nil, err
+	}
+
+	keySet := &KeySet{
+		signingKid: config.JwtSigningKeyId,
+		keys:       make(map[string]verificationKey, len(paths)),
+	}
+
+	for _, path := range paths {
+		key, err := loadKey(path)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		keySet.keys[strings.TrimSuffix(filepath.Base(path), ".pem")] = *key
+	}
+
+	signingKey, ok := keySet.keys[keySet.signingKid]
+	if !ok || signingKey.private == nil {
+		return nil, errSigningKeyNotFound
+	}
+
+	return keySet, nil
+}
+
+func newEphemeralKeySet() (*KeySet, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	kidBytes := make([]byte, 8)
+	if _, err := rand.Read(kidBytes); err != nil {
+		return nil, err
+	}
+	kid := hex.EncodeToString(kidBytes)
+
+	log.Print("JWT_KEYS_DIR не задан, токены подписываются временным ключом")
+
+	return &KeySet{
+		signingKid: kid,
+		keys: map[string]verificationKey{
+			kid: {
+				method:  jwt.SigningMethodEdDSA,
+				private: private,
+				public:  public,
+			},
+		},
+	}, nil
+}
+
+func loadKey(path string) (*verificationKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errUnsupportedKey
+	}
+
+	if block.Type == "PUBLIC KEY" {
+		public, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return newVerificationKey(nil, public)
+	}
+
+	private, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := private.(crypto.Signer)
+	if !ok {
+		return nil, errUnsupportedKey
+	}
+
+	return newVerificationKey(signer, signer.Public())
+}
+
+func newVerificationKey(private crypto.Signer, public crypto.PublicKey) (*verificationKey, error) {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return &verificationKey{method: jwt.SigningMethodRS256, private: private, public: public}, nil
+	case ed25519.PublicKey:
+		return &verificationKey{method: jwt.SigningMethodEdDSA, private: private, public: public}, nil
+	default:
+		return nil, errUnsupportedKey
+	}
+}
+
+// Sign подписывает claims текущим ключом и добавляет kid в заголовок токена. Возвращает токен или ошибку.
+func (keySet *KeySet) Sign(claims jwt.Claims) (string, error) {
+	key := keySet.keys[keySet.signingKid]
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = keySet.signingKid
+
+	return token.SignedString(key.private)
+}
+
+// Verify проверяет подпись, аудиторию и срок жизни токена и записывает его поля в claims. Возвращает ошибку.
+func (keySet *KeySet) Verify(tokenString string, claims jwt.Claims, audience string) error {
+	_, err := jwt.ParseWithClaims(tokenString, claims, keySet.keyFunc, jwt.WithAudience(audience), jwt.WithExpirationRequired())
+	return err
+}
+
+// keyFunc находит ключ для проверки подписи по kid из заголовка токена.
+func (keySet *KeySet) keyFunc(t *jwt.Token) (interface{}, error) {
+	kid, _ := t.Header["kid"].(string)
+
+	key, ok := keySet.keys[kid]
+	if !ok {
+		return nil, errUnknownKeyId
+	}
+
+	if t.Method.Alg() != key.method.Alg() {
+		return nil, jwt.ErrTokenSignatureInvalid
+	}
+
+	return key.public, nil
+}
+
+// JWKS возвращает публичные ключи набора в формате JSON Web Key Set.
+func (keySet *KeySet) JWKS() *entity.JWKS {
+	kids := make([]string, 0, len(keySet.keys))
+	for kid := range keySet.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+
+	jwks := entity.CreateJWKS()
+	for _, kid := range kids {
+		key := keySet.keys[kid]
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwks.AddKey(entity.JWK{
+				Kty: "RSA",
+				Kid: kid,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				N:   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks.AddKey(entity.JWK{
+				Kty: "OKP",
+				Kid: kid,
+				Use: "sig",
+				Alg: key.method.Alg(),
+				Crv: "Ed25519",
+				X:   base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+
+	return jwks
+}
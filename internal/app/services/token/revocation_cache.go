@@ -0,0 +1,64 @@
+package token
+
+import (
+	"time"
+
+	"github.com/go-redis/redis"
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+)
+
+const (
+	revokedTokenKeyPrefix = "revoked:"
+	seenTokenKeyPrefix    = "seen:"
+
+	// lastSeenThrottle задает, как часто запрос с токеном обновляет время последней активности сессии в БД.
+	lastSeenThrottle = time.Minute
+)
+
+// RevocationCache хранит в Redis denylist отозванных токенов по их ID. Запись живет ровно
+// столько, сколько живет сам токен, после истечения срока токен отклоняется при декодировании.
+type RevocationCache struct {
+	redis *redis.Client
+}
+
+// NewRevocationCache - это билдер для RevocationCache.
+func NewRevocationCache(client *redis.Client) *RevocationCache {
+	return &RevocationCache{
+		redis: client,
+	}
+}
+
+// Revoke добавляет токены в denylist. Токены без ID или с истекшим сроком жизни пропускаются. Возвращает ошибку.
+func (cache RevocationCache) Revoke(tokens []dto.RevokedToken) *courseError.CourseError {
+	if _, err := cache.redis.Pipelined(func(pipe redis.Pipeliner) error {
+		for _, v := range tokens {
+			ttl := time.Until(v.ExpiresAt)
+			if v.TokenId == "" || ttl <= 0 {
+				continue
+			}
+			pipe.Set(revokedTokenKeyPrefix+v.TokenId, true, ttl)
+		}
+		return nil
+	}); err != nil {
+		return courseError.CreateError(err, 10031)
+	}
+
+	return nil
+}
+
+// IsRevoked проверяет наличие токена в denylist. Возвращает статус отзыва или ошибку Redis.
+func (cache RevocationCache) IsRevoked(tokenId string) (bool, error) {
+	count, err := cache.redis.Exists(revokedTokenKeyPrefix + tokenId).Result()
+	if err != nil {
+		return false, err
+	}
+
+	return count > 0, nil
+}
+
+// MarkSeen отмечает использование токена. Возвращает true, если токен не использовался последние
+// lastSeenThrottle и время активности его сессии нужно обновить, или ошибку Redis.
+func (cache RevocationCache) MarkSeen(tokenId string) (bool, error) {
+	return cache.redis.SetNX(seenTokenKeyPrefix+tokenId, true, lastSeenThrottle).Result()
+}
@@ -5,23 +5,29 @@ import (
 	"errors"
 
 	"github.com/golang-jwt/jwt/v5"
-	"github.com/knstch/course/internal/app/config"
 	courseError "github.com/knstch/course/internal/app/course_error"
 )
 
 type TokenService struct {
 	tokenManager tokenManager
-	userSecret   string
-	adminSecret  string
+	revocation   *RevocationCache
+	recorder     revocationRecorder
+	keys         *KeySet
+}
+
+// revocationRecorder используется для подсчета обращений к denylist токенов.
+type revocationRecorder interface {
+	RecordRevocationLookup(tokenType, result string)
 }
 
 // Claims содержит в себе поля, которые хранятся в JWT.
 type UserClaims struct {
 	jwt.RegisteredClaims
-	Iat      int
-	Exp      int
-	UserID   uint
-	Verified bool
+	Iat       int
+	Exp       int
+	UserID    uint
+	Verified  bool
+	SessionId string
 }
 
 // Claims содержит в себе типы данных, которые хранятся в JWT.
@@ -34,37 +40,34 @@ type AdminClaims struct {
 }
 
 type tokenManager interface {
-	DisableToken(ctx context.Context, token string) *courseError.CourseError
 	DisableAdminToken(ctx context.Context, token *string) *courseError.CourseError
-	CheckAdminAccessToken(ctx context.Context, token *string) *courseError.CourseError
-	CheckAccessToken(ctx context.Context, token string) *courseError.CourseError
+	CheckAdminAccessToken(ctx context.Context, tokenId string) *courseError.CourseError
+	CheckAccessToken(ctx context.Context, tokenId string) *courseError.CourseError
+	TouchAccessToken(ctx context.Context, tokenId string) *courseError.CourseError
 }
 
-func NewTokenService(tokenManager tokenManager, config *config.Config) *TokenService {
+var (
+	errTokenRevoked = errors.New("токен отозван")
+)
+
+func NewTokenService(tokenManager tokenManager, revocation *RevocationCache, recorder revocationRecorder, keys *KeySet) *TokenService {
 	return &TokenService{
 		tokenManager,
-		config.Secret,
-		config.AdminSecret,
+		revocation,
+		recorder,
+		keys,
 	}
 }
 
 // DecodeToken используется для декодирования токена, принимает в качестве параметра токен,
-// и возвращает данные из токена или ошибку. Если время жизни токена истекло, то меняет его
-// статус в БД на available = false.
+// проверяет подпись по kid из заголовка и возвращает данные из токена или ошибку. Токен пользователя короткоживущий, поэтому при истечении
+// его времени жизни сессия в БД не отключается, и пользователь может обновить токен через refresh токен.
 func (token TokenService) DecodeUserToken(ctx context.Context, tokenString string) (*UserClaims, *courseError.CourseError) {
 	claims := &UserClaims{}
 
-	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, nil
-		}
-		return []byte(token.userSecret), nil
-	})
+	_, err := jwt.ParseWithClaims(tokenString, claims, token.keys.keyFunc, jwt.WithAudience(UserAudience))
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
-			if err := token.tokenManager.DisableToken(ctx, tokenString); err != nil {
-				return nil, err
-			}
 			return nil, courseError.CreateError(err, 11007)
 		}
 		return nil, courseError.CreateError(err, 11011)
@@ -81,12 +84,7 @@ func (token TokenService) DecodeUserToken(ctx context.Context, tokenString strin
 func (token TokenService) DecodeAdminToken(ctx context.Context, tokenString string) (*AdminClaims, *courseError.CourseError) {
 	claims := &AdminClaims{}
 
-	_, err := jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, nil
-		}
-		return []byte(token.adminSecret), nil
-	})
+	_, err := jwt.ParseWithClaims(tokenString, claims, token.keys.keyFunc, jwt.WithAudience(AdminAudience))
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) {
 			if err := token.tokenManager.DisableAdminToken(ctx, &tokenString); err != nil {
@@ -100,22 +98,60 @@ func (token TokenService) DecodeAdminToken(ctx context.Context, tokenString stri
 	return claims, nil
 }
 
-// ValidateAdminAccessToken используется для валидации токена администратора. Используется в middleware.
-// Если токен не найден в БД или имеет статус available = false, возвращает ошибку.
-func (t TokenService) ValidateAdminAccessToken(ctx context.Context, token *string) *courseError.CourseError {
-	if err := t.tokenManager.CheckAdminAccessToken(ctx, token); err != nil {
+// ValidateAdminAccessToken используется для валидации токена администратора по его ID. Используется в middleware.
+// Если токен находится в denylist, возвращает ошибку. БД используется только при недоступности Redis.
+func (t TokenService) ValidateAdminAccessToken(ctx context.Context, tokenId string) *courseError.CourseError {
+	if err := t.checkRevocation(ctx, "admin", tokenId, t.tokenManager.CheckAdminAccessToken); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-// ValidateAdminAccessToken используется для валидации токена пользователя. Используется в middleware.
-// Если токен не найден в БД или имеет статус available = false, возвращает ошибку.
-func (t TokenService) ValidateAccessToken(ctx context.Context, token *string) *courseError.CourseError {
-	if err := t.tokenManager.CheckAccessToken(ctx, *token); err != nil {
+// ValidateAccessToken используется для валидации токена пользователя по его ID. Используется в middleware.
+// Если токен находится в denylist, возвращает ошибку. БД используется только при недоступности Redis
+// и не чаще раза в минуту на токен, чтобы обновить время последней активности сессии.
+func (t TokenService) ValidateAccessToken(ctx context.Context, tokenId string) *courseError.CourseError {
+	if err := t.checkRevocation(ctx, "user", tokenId, t.tokenManager.CheckAccessToken); err != nil {
 		return err
 	}
 
+	t.touchSession(ctx, tokenId)
+
+	return nil
+}
+
+// touchSession обновляет время последней активности сессии. Если Redis недоступен, время уже обновлено
+// при проверке токена в БД. Ошибка обновления не мешает запросу пользователя.
+func (t TokenService) touchSession(ctx context.Context, tokenId string) {
+	first, err := t.revocation.MarkSeen(tokenId)
+	if err != nil || !first {
+		return
+	}
+
+	t.tokenManager.TouchAccessToken(ctx, tokenId)
+}
+
+// checkRevocation проверяет, не отозван ли токен. Сначала проверяется denylist в Redis, если Redis
+// недоступен, то статус токена проверяется в БД через fallback. Результат каждой проверки записывается в метрики.
+func (t TokenService) checkRevocation(ctx context.Context, tokenType, tokenId string,
+	fallback func(ctx context.Context, tokenId string) *courseError.CourseError) *courseError.CourseError {
+	if tokenId == "" {
+		return courseError.CreateError(errTokenRevoked, 11006)
+	}
+
+	revoked, err := t.revocation.IsRevoked(tokenId)
+	if err != nil {
+		t.recorder.RecordRevocationLookup(tokenType, "fallback")
+		return fallback(ctx, tokenId)
+	}
+
+	if revoked {
+		t.recorder.RecordRevocationLookup(tokenType, "revoked")
+		return courseError.CreateError(errTokenRevoked, 11006)
+	}
+
+	t.recorder.RecordRevocationLookup(tokenType, "valid")
+
 	return nil
 }
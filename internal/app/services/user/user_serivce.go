@@ -18,40 +18,48 @@ import (
 	courseError "github.com/knstch/course/internal/app/course_error"
 	cdnerrors "github.com/knstch/course/internal/app/services/cdn_errors"
 	"github.com/knstch/course/internal/app/services/email"
+	"github.com/knstch/course/internal/app/services/password"
 	"github.com/knstch/course/internal/app/validation"
+	"github.com/knstch/course/internal/domain/dto"
 	"github.com/knstch/course/internal/domain/entity"
 )
 
 type Profiler interface {
 	FillUserProfile(ctx context.Context, firstName, surname string, phoneNumber int, userId string) *courseError.CourseError
 	ChangePasssword(ctx context.Context, oldPassword, newPassword string, userId uint) *courseError.CourseError
-	ChangeEmail(ctx context.Context, newEmail string, userId uint) *courseError.CourseError
-	VerifyEmail(ctx context.Context, userId uint, isEdit bool) *courseError.CourseError
+	ChangeEmail(ctx context.Context, newEmail string, userId uint, confirmEmail *dto.OutboxEmail) *courseError.CourseError
+	VerifyEmail(ctx context.Context, userId uint, isEdit bool, welcomeEmail *dto.OutboxEmail) *courseError.CourseError
 	SetPhoto(ctx context.Context, path string) *courseError.CourseError
 	RetreiveUserData(ctx context.Context) (*entity.UserData, *courseError.CourseError)
 	DeactivateProfile(ctx context.Context) *courseError.CourseError
 	SetWatchedStatus(ctx context.Context, lessonId uint) *courseError.CourseError
+	GetUserSessions(ctx context.Context, userId uint) ([]dto.AccessToken, *courseError.CourseError)
+	DisableSession(ctx context.Context, userId uint, sessionId string) *courseError.CourseError
+	DisableOtherSessions(ctx context.Context, userId uint, sessionId string) *courseError.CourseError
 }
 
 // UserService используется для менеджмента профиля пользователем.
 type UserService struct {
-	Profiler     Profiler
-	emailService *email.EmailService
-	redis        *redis.Client
-	client       *http.Client
-	CdnApiKey    string
-	CdnHost      string
+	Profiler       Profiler
+	emailService   *email.EmailService
+	redis          *redis.Client
+	client         *http.Client
+	passwordPolicy *password.Policy
+	CdnApiKey      string
+	CdnHost        string
 }
 
 // NewUserService - это билдер для UserService.
-func NewUserService(profiler Profiler, emailService *email.EmailService, redis *redis.Client, client *http.Client, apiKey, cdnHost string) UserService {
+func NewUserService(profiler Profiler, emailService *email.EmailService, redis *redis.Client, client *http.Client, apiKey, cdnHost string,
+	passwordPolicy *password.Policy) UserService {
 	return UserService{
-		Profiler:     profiler,
-		emailService: emailService,
-		redis:        redis,
-		client:       client,
-		CdnApiKey:    apiKey,
-		CdnHost:      cdnHost,
+		Profiler:       profiler,
+		emailService:   emailService,
+		redis:          redis,
+		client:         client,
+		passwordPolicy: passwordPolicy,
+		CdnApiKey:      apiKey,
+		CdnHost:        cdnHost,
 	}
 }
 
@@ -85,12 +93,16 @@ func (user UserService) FillProfile(ctx context.Context, userInfo *entity.UserIn
 }
 
 // EditPassword используется для изменения пароля пользователя, принимает в качестве параметра старый и новый пароль,
-// валидирует их и вносит изменения. Возвращает ошибку.
+// валидирует их, проверяет новый пароль по парольной политике и вносит изменения. Возвращает ошибку.
 func (user UserService) EditPassword(ctx context.Context, passwords *entity.Passwords) *courseError.CourseError {
 	if err := validation.NewPasswordToValidate(passwords.NewPassword).ValidatePassword(ctx); err != nil {
 		return err
 	}
 
+	if err := user.passwordPolicy.Check(passwords.NewPassword); err != nil {
+		return err
+	}
+
 	if err := user.Profiler.ChangePasssword(ctx, passwords.OldPassword, passwords.NewPassword, ctx.Value("UserId").(uint)); err != nil {
 		return err
 	}
@@ -105,11 +117,21 @@ func (user UserService) EditEmail(ctx context.Context, userEmail string, userId
 		return err
 	}
 
-	if err := user.Profiler.ChangeEmail(ctx, userEmail, userId); err != nil {
+	locale, err := user.emailService.GetUserLocale(ctx, userId)
+	if err != nil {
+		return err
+	}
+
+	confirmEmail, confirmCode, err := user.emailService.PrepareConfirmCode(userEmail, email.ConfirmEmail, locale)
+	if err != nil {
 		return err
 	}
 
-	if err := user.emailService.SendConfirmCode(&userId, &userEmail, email.ConfirmEmail); err != nil {
+	if err := user.Profiler.ChangeEmail(ctx, userEmail, userId, confirmEmail); err != nil {
+		return err
+	}
+
+	if err := user.emailService.StoreConfirmCode(userId, userEmail, confirmCode); err != nil {
 		return err
 	}
 
@@ -136,7 +158,7 @@ func (user UserService) ConfirmEditEmail(ctx context.Context, confirmCode string
 		return courseError.CreateError(err, 10033)
 	}
 
-	if err := user.Profiler.VerifyEmail(ctx, userId, true); err != nil {
+	if err := user.Profiler.VerifyEmail(ctx, userId, true, nil); err != nil {
 		return err
 	}
 
@@ -261,3 +283,46 @@ func (user UserService) MarkLessonAsWatched(ctx context.Context, lessonId string
 
 	return nil
 }
+
+// RetreiveSessions используется для получения активных сессий пользователя. Текущая сессия помечается
+// отдельным флагом. Возвращает список сессий или ошибку.
+func (user UserService) RetreiveSessions(ctx context.Context) ([]entity.Session, *courseError.CourseError) {
+	sessions, err := user.Profiler.GetUserSessions(ctx, ctx.Value("UserId").(uint))
+	if err != nil {
+		return nil, err
+	}
+
+	return entity.CreateSessions(sessions, ctx.Value("SessionId").(string)), nil
+}
+
+// TerminateSession используется для завершения сессии пользователя на другом устройстве. В качестве обязательного
+// параметра принимает ID сессии, валидирует его и отключает все токены этой сессии. Возвращает ошибку.
+func (user UserService) TerminateSession(ctx context.Context, sessionId string) *courseError.CourseError {
+	if err := validation.NewSessionIdToValidate(sessionId).Validate(ctx); err != nil {
+		return err
+	}
+
+	if err := user.Profiler.DisableSession(ctx, ctx.Value("UserId").(uint), sessionId); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TerminateOtherSessions используется для выхода со всех устройств, кроме текущего. Возвращает ошибку.
+func (user UserService) TerminateOtherSessions(ctx context.Context) *courseError.CourseError {
+	if err := user.Profiler.DisableOtherSessions(ctx, ctx.Value("UserId").(uint), ctx.Value("SessionId").(string)); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// LogOut используется для выхода из текущей сессии. Возвращает ошибку.
+func (user UserService) LogOut(ctx context.Context) *courseError.CourseError {
+	if err := user.Profiler.DisableSession(ctx, ctx.Value("UserId").(uint), ctx.Value("SessionId").(string)); err != nil {
+		return err
+	}
+
+	return nil
+}
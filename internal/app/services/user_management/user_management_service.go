@@ -5,6 +5,7 @@ import (
 	"context"
 
 	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/app/services/lockout"
 	"github.com/knstch/course/internal/app/validation"
 	"github.com/knstch/course/internal/domain/entity"
 )
@@ -12,6 +13,7 @@ import (
 // UserManagementService используется для администрирования пользователей.
 type UserManagementService struct {
 	manager UserManager
+	lockout *lockout.Lockout
 }
 
 type UserManager interface {
@@ -25,9 +27,10 @@ type UserManager interface {
 }
 
 // NewUserManagementService - это билдер для UserManagementService.
-func NewUserManagementService(userManager UserManager) UserManagementService {
+func NewUserManagementService(userManager UserManager, lockout *lockout.Lockout) UserManagementService {
 	return UserManagementService{
 		manager: userManager,
+		lockout: lockout,
 	}
 }
 
@@ -104,3 +107,22 @@ func (user UserManagementService) EraseUserProfilePhoto(ctx context.Context, id
 
 	return nil
 }
+
+// UnlockUser используется для снятия временной блокировки после неудачных попыток входа или ввода кодов.
+// В качестве обязательного параметра принимает ID пользователя, валидирует его, и возвращает ошибку.
+func (user UserManagementService) UnlockUser(ctx context.Context, id string) *courseError.CourseError {
+	if err := validation.NewStringIdToValidate(id).Validate(ctx); err != nil {
+		return err
+	}
+
+	userData, err := user.manager.GetAllUserDataById(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := user.lockout.UnlockUser(userData.Email, id); err != nil {
+		return err
+	}
+
+	return nil
+}
@@ -9,6 +9,7 @@ import (
 	"github.com/pquerna/otp/totp"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
@@ -124,15 +125,18 @@ func (storage Storage) Login(ctx context.Context, login, password, code string)
 	return &credentials.ID, &credentials.Role, nil
 }
 
-func (storage Storage) StoreAdminAccessToken(ctx context.Context, id *uint, token *string) *courseError.CourseError {
+func (storage Storage) StoreAdminAccessToken(ctx context.Context, accessToken *dto.AdminAccessToken) *courseError.CourseError {
 	tx := storage.db.WithContext(ctx).Begin()
 
-	if err := tx.Model(&dto.AdminAccessToken{}).Where("admin_id = ?", id).Update("available", false).Error; err != nil {
+	var oldTokens []dto.AdminAccessToken
+	if err := tx.Model(&oldTokens).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "token_id"}, {Name: "expires_at"}}}).
+		Where("admin_id = ? AND available = ?", accessToken.AdminId, true).
+		Update("available", false).Error; err != nil {
 		tx.Rollback()
 		return courseError.CreateError(err, 10003)
 	}
 
-	accessToken := dto.CreateNewAdminAccessToken(*id, *token)
 	if err := tx.Create(&accessToken).Error; err != nil {
 		tx.Rollback()
 		return courseError.CreateError(err, 10001)
@@ -143,14 +147,21 @@ func (storage Storage) StoreAdminAccessToken(ctx context.Context, id *uint, toke
 		return courseError.CreateError(err, 10010)
 	}
 
+	revoked := make([]dto.RevokedToken, 0, len(oldTokens))
+	for _, v := range oldTokens {
+		revoked = append(revoked, dto.NewRevokedToken(v.TokenId, v.ExpiresAt))
+	}
+
+	storage.revokeCached(revoked)
+
 	return nil
 }
 
-func (storage Storage) CheckAdminAccessToken(ctx context.Context, token *string) *courseError.CourseError {
+func (storage Storage) CheckAdminAccessToken(ctx context.Context, tokenId string) *courseError.CourseError {
 	tx := storage.db.WithContext(ctx).Begin()
 
 	var accessToken *dto.AdminAccessToken
-	if err := tx.Where("token = ? AND available = ?", token, true).First(&accessToken).Error; err != nil {
+	if err := tx.Where("token_id = ? AND available = ?", tokenId, true).First(&accessToken).Error; err != nil {
 		tx.Rollback()
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return courseError.CreateError(errTokenNotFound, 11006)
@@ -193,6 +204,11 @@ func (storage Storage) ResetAdminPassword(ctx context.Context, login, newPasswor
 		return courseError.CreateError(err, 10002)
 	}
 
+	if err := storage.checkPasswordReuse(tx, "admin_id = ?", admin.ID, admin.Password, newPassword); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword+storage.secret), bcrypt.DefaultCost)
 	if err != nil {
 		tx.Rollback()
@@ -204,6 +220,11 @@ func (storage Storage) ResetAdminPassword(ctx context.Context, login, newPasswor
 		return courseError.CreateError(err, 10003)
 	}
 
+	if err := storage.storePasswordHistory(tx, dto.CreateNewPasswordHistory(admin.Password).AddAdminId(admin.ID), "admin_id = ?", admin.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		tx.Rollback()
 		return courseError.CreateError(err, 10010)
@@ -16,10 +16,10 @@ func (storage Storage) GetSalesStats(ctx context.Context, from, due time.Time, c
 
 	due = due.AddDate(0, 0, 1)
 
-	baseQuery := "SELECT billings.* FROM billings"
+	paidStatuses := []string{dto.BillingStatusPaid, dto.BillingStatusPartiallyRefunded, dto.BillingStatusRefunded}
+
 	joins := make([]string, 0, 2)
-	whereClauses := make([]string, 0, 3)
-	whereClauses = append(whereClauses, "DATE(billings.created_at) = ? AND billings.paid = ?")
+	whereClauses := make([]string, 0, 2)
 	params := []interface{}{}
 
 	if courseName != "" {
@@ -35,8 +35,20 @@ func (storage Storage) GetSalesStats(ctx context.Context, from, due time.Time, c
 	}
 
 	joinClause := strings.Join(joins, " ")
-	whereClause := strings.Join(whereClauses, " AND ")
-	fullQuery := fmt.Sprintf("%s %s WHERE %s", baseQuery, joinClause, whereClause)
+	whereClause := strings.Join(append([]string{"DATE(billings.created_at) = ? AND billings.status IN (?)"}, whereClauses...), " AND ")
+	fullQuery := fmt.Sprintf("SELECT billings.* FROM billings %s WHERE %s", joinClause, whereClause)
+
+	refundsWhereClause := strings.Join(append([]string{"DATE(refunds.created_at) = ? AND refunds.deleted_at IS NULL"}, whereClauses...), " AND ")
+	refundsQuery := fmt.Sprintf("SELECT refunds.* FROM refunds JOIN billings ON billings.id = refunds.billing_id %s WHERE %s", joinClause, refundsWhereClause)
+
+	promoQuery := fmt.Sprintf(`SELECT promo_codes.id AS promo_code_id, promo_codes.code AS code, billings.currency AS currency,
+		COUNT(*) AS redemptions, SUM(promo_redemptions.amount) AS discount
+		FROM promo_redemptions
+		JOIN promo_codes ON promo_codes.id = promo_redemptions.promo_code_id
+		JOIN billings ON billings.order_id = promo_redemptions.order_id %s
+		WHERE %s AND promo_redemptions.deleted_at IS NULL
+		GROUP BY promo_codes.id, promo_codes.code, billings.currency
+		ORDER BY promo_codes.code, billings.currency`, joinClause, whereClause)
 
 	duration := due.Sub(from)
 	daysLeft := int(duration.Hours() / 24)
@@ -45,13 +57,27 @@ func (storage Storage) GetSalesStats(ctx context.Context, from, due time.Time, c
 	for date := from; date.Before(due); date = date.AddDate(0, 0, 1) {
 		var billings []dto.Billing
 
-		iterationParams := append([]interface{}{date.Format(time.DateOnly), true}, params...)
+		iterationParams := append([]interface{}{date.Format(time.DateOnly), paidStatuses}, params...)
 		if err := tx.Raw(fullQuery, iterationParams...).Scan(&billings).Error; err != nil {
 			tx.Rollback()
 			return nil, courseError.CreateError(err, 10002)
 		}
 
-		dayStat := entity.CreateNewPaymentStats(date, billings)
+		var refunds []dto.Refund
+
+		refundsParams := append([]interface{}{date.Format(time.DateOnly)}, params...)
+		if err := tx.Raw(refundsQuery, refundsParams...).Scan(&refunds).Error; err != nil {
+			tx.Rollback()
+			return nil, courseError.CreateError(err, 10002)
+		}
+
+		var promos []entity.PromoStats
+		if err := tx.Raw(promoQuery, iterationParams...).Scan(&promos).Error; err != nil {
+			tx.Rollback()
+			return nil, courseError.CreateError(err, 10002)
+		}
+
+		dayStat := entity.CreateNewPaymentStats(date, billings, refunds, promos)
 
 		stats = append(stats, *dayStat)
 	}
@@ -0,0 +1,139 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+	"github.com/knstch/course/internal/domain/entity"
+	"gorm.io/gorm"
+)
+
+// apiKeyLastUsedPrecision задает, как часто обновляется время последнего использования ключа,
+// чтобы не писать в БД на каждый запрос.
+const apiKeyLastUsedPrecision = time.Minute
+
+var (
+	errApiKeyNotFound = errors.New("API ключ не найден или отозван")
+)
+
+func (storage Storage) StoreApiKey(ctx context.Context, apiKey *dto.ApiKey) *courseError.CourseError {
+	if err := storage.db.WithContext(ctx).Create(apiKey).Error; err != nil {
+		return courseError.CreateError(err, 10001)
+	}
+
+	return nil
+}
+
+func (storage Storage) GetUserApiKeys(ctx context.Context, userId uint) ([]dto.ApiKey, *courseError.CourseError) {
+	return storage.getApiKeys(ctx, "user_id = ?", userId)
+}
+
+func (storage Storage) GetAdminApiKeys(ctx context.Context, adminId uint) ([]dto.ApiKey, *courseError.CourseError) {
+	return storage.getApiKeys(ctx, "admin_id = ?", adminId)
+}
+
+func (storage Storage) getApiKeys(ctx context.Context, query string, ownerId uint) ([]dto.ApiKey, *courseError.CourseError) {
+	apiKeys := dto.CreateNewApiKeys()
+
+	if err := storage.db.WithContext(ctx).
+		Where(query+" AND revoked = ?", ownerId, false).
+		Order("created_at DESC").
+		Find(&apiKeys).Error; err != nil {
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return apiKeys, nil
+}
+
+func (storage Storage) RevokeUserApiKey(ctx context.Context, userId, keyId uint) *courseError.CourseError {
+	return storage.revokeApiKey(ctx, "user_id = ?", userId, keyId)
+}
+
+func (storage Storage) RevokeAdminApiKey(ctx context.Context, adminId, keyId uint) *courseError.CourseError {
+	return storage.revokeApiKey(ctx, "admin_id = ?", adminId, keyId)
+}
+
+func (storage Storage) revokeApiKey(ctx context.Context, query string, ownerId, keyId uint) *courseError.CourseError {
+	result := storage.db.WithContext(ctx).
+		Model(&dto.ApiKey{}).
+		Where(query+" AND id = ? AND revoked = ?", ownerId, keyId, false).
+		Update("revoked", true)
+	if result.Error != nil {
+		return courseError.CreateError(result.Error, 10003)
+	}
+
+	if result.RowsAffected == 0 {
+		return courseError.CreateError(errApiKeyNotFound, 11304)
+	}
+
+	return nil
+}
+
+// UseApiKey используется для аутентификации по API ключу. Находит неотозванный ключ по хэшу, проверяет, что его владелец
+// может войти, и обновляет время последнего использования ключа. Возвращает владельца ключа или ошибку.
+func (storage Storage) UseApiKey(ctx context.Context, hashedKey string) (*entity.ApiKeyOwner, *courseError.CourseError) {
+	db := storage.db.WithContext(ctx)
+
+	var apiKey dto.ApiKey
+	if err := db.Where("hashed_key = ? AND revoked = ?", hashedKey, false).First(&apiKey).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, courseError.CreateError(errApiKeyNotFound, 11301)
+		}
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	owner := &entity.ApiKeyOwner{
+		KeyId:  apiKey.ID,
+		Scopes: strings.Split(apiKey.Scopes, ","),
+	}
+
+	switch {
+	case apiKey.UserId != nil:
+		user := dto.CreateNewUser()
+		if err := db.Where("id = ?", *apiKey.UserId).First(&user).Error; err != nil {
+			return nil, courseError.CreateError(err, 10002)
+		}
+
+		if user.Banned {
+			return nil, courseError.CreateError(errUserBanned, 11010)
+		}
+
+		if !user.Active {
+			return nil, courseError.CreateError(errUserInactive, 11011)
+		}
+
+		credentials := dto.CreateNewCredentials()
+		if err := db.Where("id = ?", user.CredentialsId).First(&credentials).Error; err != nil {
+			return nil, courseError.CreateError(err, 10002)
+		}
+
+		owner.UserId = user.ID
+		owner.Verified = credentials.Verified
+	case apiKey.AdminId != nil:
+		var admin dto.Admin
+		if err := db.Where("id = ?", *apiKey.AdminId).First(&admin).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, courseError.CreateError(errApiKeyNotFound, 11301)
+			}
+			return nil, courseError.CreateError(err, 10002)
+		}
+
+		owner.AdminId = admin.ID
+		owner.Role = admin.Role
+	default:
+		return nil, courseError.CreateError(errApiKeyNotFound, 11301)
+	}
+
+	now := time.Now()
+	if apiKey.LastUsedAt == nil || now.Sub(*apiKey.LastUsedAt) > apiKeyLastUsedPrecision {
+		if err := db.Model(&apiKey).Update("last_used_at", now).Error; err != nil {
+			return nil, courseError.CreateError(err, 10003)
+		}
+	}
+
+	return owner, nil
+}
@@ -4,19 +4,28 @@ import (
 	"context"
 	"errors"
 	"strings"
+	"time"
 
 	courseError "github.com/knstch/course/internal/app/course_error"
 	"github.com/knstch/course/internal/domain/dto"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 var (
-	errUserInactive = errors.New("пользователь неактивен, восстановите аккаунт")
-	errUserBanned   = errors.New("пользователь заблокирован, обратитесь к администратору")
+	errUserInactive        = errors.New("пользователь неактивен, восстановите аккаунт")
+	errUserBanned          = errors.New("пользователь заблокирован, обратитесь к администратору")
+	errRefreshTokenReused  = errors.New("refresh токен уже был использован, все сессии устройства завершены")
+	errRefreshTokenExpired = errors.New("refresh токен устарел")
+	errSessionNotFound     = errors.New("сессия не найдена")
 )
 
-func (storage Storage) RegisterUser(ctx context.Context, email, password string) (*uint, *courseError.CourseError) {
+// lastSeenUpdateInterval задает, как часто обновляется время последней активности сессии,
+// чтобы не писать в БД при каждом запросе пользователя.
+const lastSeenUpdateInterval = time.Minute
+
+func (storage Storage) RegisterUser(ctx context.Context, email, password, locale string, confirmEmail *dto.OutboxEmail) (*uint, *courseError.CourseError) {
 	tx := storage.db.WithContext(ctx).Begin()
 
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password+storage.secret), bcrypt.DefaultCost)
@@ -37,13 +46,19 @@ func (storage Storage) RegisterUser(ctx context.Context, email, password string)
 	}
 
 	user := dto.CreateNewUser().
-		AddCredentialsId(&credentials.ID)
+		AddCredentialsId(&credentials.ID).
+		AddLocale(locale)
 
 	if err := tx.Create(&user).Error; err != nil {
 		tx.Rollback()
 		return nil, courseError.CreateError(errRegistingUser, 10001)
 	}
 
+	if err := enqueueEmail(tx, confirmEmail); err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		tx.Rollback()
 		return nil, courseError.CreateError(err, 10010)
@@ -52,14 +67,9 @@ func (storage Storage) RegisterUser(ctx context.Context, email, password string)
 	return &user.ID, nil
 }
 
-func (storage Storage) StoreToken(ctx context.Context, token *string, id *uint) *courseError.CourseError {
+func (storage Storage) StoreToken(ctx context.Context, accessToken *dto.AccessToken) *courseError.CourseError {
 	tx := storage.db.WithContext(ctx).Begin()
 
-	accessToken := dto.CreateNewAccessToken().
-		AddToken(token).
-		AddUsedId(id).
-		SetStatusAvailable()
-
 	if err := tx.Create(&accessToken).Error; err != nil {
 		tx.Rollback()
 		return courseError.CreateError(err, 10001)
@@ -123,44 +133,204 @@ func (storage Storage) verifyPassword(hashedPassword, password string) bool {
 }
 
 func (storage Storage) DisableTokens(ctx context.Context, userId uint) *courseError.CourseError {
+	revoked, err := disableAccessTokens(storage.db.WithContext(ctx), "user_id = ?", userId)
+	if err != nil {
+		return courseError.CreateError(err, 10003)
+	}
+
+	storage.revokeCached(revoked)
+
+	return nil
+}
+
+// disableAccessTokens отключает доступные токены пользователей, подходящие под условие,
+// и возвращает их ID со сроком жизни для добавления в denylist.
+func disableAccessTokens(db *gorm.DB, query interface{}, args ...interface{}) ([]dto.RevokedToken, error) {
+	var tokens []dto.AccessToken
+	if err := db.Model(&tokens).
+		Clauses(clause.Returning{Columns: []clause.Column{{Name: "token_id"}, {Name: "expires_at"}}}).
+		Where("available = ?", true).
+		Where(query, args...).
+		Update("available", false).Error; err != nil {
+		return nil, err
+	}
+
+	revoked := make([]dto.RevokedToken, 0, len(tokens))
+	for _, v := range tokens {
+		revoked = append(revoked, dto.NewRevokedToken(v.TokenId, v.ExpiresAt))
+	}
+
+	return revoked, nil
+}
+
+func (storage Storage) CheckAccessToken(ctx context.Context, tokenId string) *courseError.CourseError {
+	accessToken := dto.CreateNewAccessToken()
+
+	if err := storage.db.WithContext(ctx).Where("token_id = ? AND available = ?", tokenId, true).First(&accessToken).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return courseError.CreateError(errTokenNotFound, 11006)
+		}
+		return courseError.CreateError(err, 10002)
+	}
+
+	if time.Since(accessToken.LastSeenAt) > lastSeenUpdateInterval {
+		if err := storage.db.WithContext(ctx).
+			Model(dto.AccessToken{}).
+			Where("id = ?", accessToken.ID).
+			Update("last_seen_at", time.Now()).Error; err != nil {
+			return courseError.CreateError(err, 10003)
+		}
+	}
+
+	return nil
+}
+
+// TouchAccessToken обновляет время последней активности сессии с токеном tokenId, если оно обновлялось
+// раньше, чем lastSeenUpdateInterval назад.
+func (storage Storage) TouchAccessToken(ctx context.Context, tokenId string) *courseError.CourseError {
 	if err := storage.db.WithContext(ctx).
 		Model(dto.AccessToken{}).
-		Where("user_id = ?", userId).
-		Update("available", false).Error; err != nil {
+		Where("token_id = ? AND available = ? AND last_seen_at < ?", tokenId, true, time.Now().Add(-lastSeenUpdateInterval)).
+		Update("last_seen_at", time.Now()).Error; err != nil {
 		return courseError.CreateError(err, 10003)
 	}
 
 	return nil
 }
 
-func (storage Storage) DisableToken(ctx context.Context, token string) *courseError.CourseError {
+func (storage Storage) GetUserSessions(ctx context.Context, userId uint) ([]dto.AccessToken, *courseError.CourseError) {
+	var sessions []dto.AccessToken
+
 	if err := storage.db.WithContext(ctx).
-		Model(dto.AccessToken{}).
-		Where("token = ?", token).Update("available", false).Error; err != nil {
+		Where("user_id = ? AND available = ? AND refresh_expires_at > ?", userId, true, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error; err != nil {
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return sessions, nil
+}
+
+func (storage Storage) DisableSession(ctx context.Context, userId uint, sessionId string) *courseError.CourseError {
+	revoked, err := disableAccessTokens(storage.db.WithContext(ctx), "user_id = ? AND session_id = ?", userId, sessionId)
+	if err != nil {
 		return courseError.CreateError(err, 10003)
 	}
 
+	if len(revoked) == 0 {
+		return courseError.CreateError(errSessionNotFound, 11013)
+	}
+
+	storage.revokeCached(revoked)
+
 	return nil
 }
 
-func (storage Storage) CheckAccessToken(ctx context.Context, token string) *courseError.CourseError {
-	accessToken := dto.CreateNewAccessToken()
+func (storage Storage) DisableOtherSessions(ctx context.Context, userId uint, sessionId string) *courseError.CourseError {
+	revoked, err := disableAccessTokens(storage.db.WithContext(ctx),
+		"user_id = ? AND (session_id <> ? OR session_id IS NULL)", userId, sessionId)
+	if err != nil {
+		return courseError.CreateError(err, 10003)
+	}
+
+	storage.revokeCached(revoked)
+
+	return nil
+}
+
+func (storage Storage) ConsumeRefreshToken(ctx context.Context, refreshToken string) (*dto.AccessToken, *bool, *courseError.CourseError) {
+	tx := storage.db.WithContext(ctx).Begin()
 
-	if err := storage.db.WithContext(ctx).Where("token = ? AND available = ?", token, true).First(&accessToken).Error; err != nil {
+	session := dto.CreateNewAccessToken()
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("refresh_token = ?", refreshToken).
+		First(&session).Error; err != nil {
+		tx.Rollback()
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return courseError.CreateError(errTokenNotFound, 11006)
+			return nil, nil, courseError.CreateError(errTokenNotFound, 11006)
 		}
-		return courseError.CreateError(err, 10002)
+		return nil, nil, courseError.CreateError(err, 10002)
 	}
 
-	return nil
+	if session.RefreshUsed {
+		revoked, err := disableAccessTokens(tx, "session_id = ?", session.SessionId)
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, courseError.CreateError(err, 10003)
+		}
+
+		if err := tx.Commit().Error; err != nil {
+			tx.Rollback()
+			return nil, nil, courseError.CreateError(err, 10010)
+		}
+
+		storage.revokeCached(revoked)
+
+		return nil, nil, courseError.CreateError(errRefreshTokenReused, 11012)
+	}
+
+	if !session.Available {
+		tx.Rollback()
+		return nil, nil, courseError.CreateError(errTokenNotFound, 11006)
+	}
+
+	if session.RefreshExpiresAt.Before(time.Now()) {
+		tx.Rollback()
+		return nil, nil, courseError.CreateError(errRefreshTokenExpired, 11007)
+	}
+
+	if err := tx.Model(&dto.AccessToken{}).Where("id = ?", session.ID).Updates(map[string]interface{}{
+		"refresh_used": true,
+		"available":    false,
+	}).Error; err != nil {
+		tx.Rollback()
+		return nil, nil, courseError.CreateError(err, 10003)
+	}
+
+	credentials := dto.CreateNewCredentials()
+	if err := tx.Joins("JOIN users ON users.id = ?", session.UserId).
+		Where("credentials.id = users.credentials_id").
+		First(&credentials).Error; err != nil {
+		tx.Rollback()
+		return nil, nil, courseError.CreateError(err, 10002)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return nil, nil, courseError.CreateError(err, 10010)
+	}
+
+	storage.revokeCached([]dto.RevokedToken{dto.NewRevokedToken(session.TokenId, session.ExpiresAt)})
+
+	return session, &credentials.Verified, nil
 }
 
 func (storage Storage) RecoverPassword(ctx context.Context, email, password string) *courseError.CourseError {
 	tx := storage.db.WithContext(ctx).Begin()
 
+	credentials := dto.CreateNewCredentials()
+	if err := tx.Where("email = ?", email).First(&credentials).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return courseError.CreateError(errUserNotFound, 11002)
+		}
+		return courseError.CreateError(err, 10002)
+	}
+
+	user := dto.CreateNewUser()
+	if err := tx.Where("credentials_id = ?", credentials.ID).First(&user).Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10002)
+	}
+
+	if err := storage.checkPasswordReuse(tx, "user_id = ?", user.ID, credentials.Password, password); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password+storage.secret), bcrypt.DefaultCost)
 	if err != nil {
+		tx.Rollback()
 		return courseError.CreateError(err, 11020)
 	}
 
@@ -169,6 +339,11 @@ func (storage Storage) RecoverPassword(ctx context.Context, email, password stri
 		return courseError.CreateError(err, 10003)
 	}
 
+	if err := storage.storePasswordHistory(tx, dto.CreateNewPasswordHistory(credentials.Password).AddUserId(user.ID), "user_id = ?", user.ID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err := tx.Exec(`UPDATE "users" SET active = true
  					   WHERE credentials_id = (SELECT id from "credentials" WHERE email = ?)`, email).Error; err != nil {
 		tx.Rollback()
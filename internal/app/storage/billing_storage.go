@@ -6,47 +6,114 @@ import (
 	"encoding/hex"
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	courseError "github.com/knstch/course/internal/app/course_error"
 	"github.com/knstch/course/internal/domain/dto"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
-func (storage Storage) CreateNewOrder(ctx context.Context, courseId, price uint, ruCard bool) (*dto.OrderEssentials, *courseError.CourseError) {
+var (
+	errPaymentNotRefundable = errors.New("платеж не оплачен или уже возвращен полностью")
+	errRefundTooLarge       = errors.New("сумма возврата больше оплаченной")
+)
+
+// sameOrderBillings - это условие на платежи за все курсы заказа, в который входит платеж с переданным ID.
+const sameOrderBillings = `billings.order_id IN (SELECT orders.id FROM orders WHERE orders."order" = (
+	SELECT o."order" FROM orders o JOIN billings b ON b.order_id = o.id WHERE b.id = ?))`
+
+// CreateNewOrder создает заказ на курсы items и ожидающий оплаты платеж за каждый курс в валюте currency. Курсы
+// получают один номер заказа и оплачиваются одним инвойсом. Если передан промокод, он применяется к первому курсу,
+// на который действует: цена курса уменьшается на скидку, а использование промокода записывается вместе с заказом
+// этого курса.
+func (storage Storage) CreateNewOrder(ctx context.Context, items []dto.CheckoutItem, ruCard bool, currency, promoCode string) (*dto.OrderEssentials, *courseError.CourseError) {
 	tx := storage.db.WithContext(ctx).Begin()
 
 	userId := ctx.Value("UserId").(uint)
 
+	var (
+		promo     *dto.PromoCode
+		discount  uint
+		promoItem = -1
+	)
+	if promoCode != "" {
+		for i := range items {
+			var err *courseError.CourseError
+			promo, discount, err = applyPromoCode(tx, promoCode, items[i].CourseId, userId, currency, items[i].Amount)
+			if err == nil {
+				promoItem = i
+				break
+			}
+			if err.Code != 15012 || i == len(items)-1 {
+				tx.Rollback()
+				return nil, err
+			}
+		}
+	}
+
 	orderHash := md5.New()
 
-	orderHash.Write([]byte(fmt.Sprintf("%d%d%d", userId, courseId, time.Now().Unix())))
+	orderHash.Write([]byte(fmt.Sprintf("%d%d%d", userId, items[0].CourseId, time.Now().UnixNano())))
 
 	orderNum := hex.EncodeToString(orderHash.Sum(nil))
 
-	order := dto.CreateNewOrder().AddCourseId(courseId).AddUserId(userId).AddOrder(orderNum)
+	var (
+		firstOrder *dto.Order
+		amount     uint
+	)
+	courseNames := make([]string, 0, len(items))
+	for i, item := range items {
+		course := dto.CreateNewCourse()
+		if err := tx.Where("id = ?", item.CourseId).First(&course).Error; err != nil {
+			tx.Rollback()
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, courseError.CreateError(errCourseNotExists, 13003)
+			}
+			return nil, courseError.CreateError(err, 10002)
+		}
 
-	if err := tx.Create(&order).Error; err != nil {
-		tx.Rollback()
-		return nil, courseError.CreateError(err, 10001)
-	}
-	invoice := dto.NewPayment().AddOrderId(order.ID).AddRusCard().AddPrice(float64(price))
-	if err := tx.Create(&invoice).Error; err != nil {
-		tx.Rollback()
-		return nil, courseError.CreateError(err, 10001)
-	}
+		price := item.Amount
+		if i == promoItem {
+			price -= discount
+		}
 
-	course := dto.CreateNewCourse()
-	if err := tx.Where("id = ?", courseId).First(&course).Error; err != nil {
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, courseError.CreateError(errCourseNotExists, 13003)
+		order := dto.CreateNewOrder().AddCourseId(item.CourseId).AddUserId(userId).AddBundleId(item.BundleId).AddOrder(orderNum)
+		if err := tx.Create(&order).Error; err != nil {
+			tx.Rollback()
+			return nil, courseError.CreateError(err, 10001)
 		}
-		return nil, courseError.CreateError(err, 10002)
+
+		invoice := dto.NewPayment().AddOrderId(order.ID).AddCurrency(currency).AddAmount(price)
+		if ruCard {
+			invoice.AddRusCard()
+		} else {
+			invoice.AddForeignCard()
+		}
+		if err := tx.Create(&invoice).Error; err != nil {
+			tx.Rollback()
+			return nil, courseError.CreateError(err, 10001)
+		}
+
+		if i == promoItem {
+			if err := tx.Create(dto.CreateNewPromoRedemption(promo.ID, order.ID, userId, discount)).Error; err != nil {
+				tx.Rollback()
+				return nil, courseError.CreateError(err, 10001)
+			}
+		}
+
+		if firstOrder == nil {
+			firstOrder = order
+		}
+		amount += price
+		courseNames = append(courseNames, course.Name)
 	}
 
 	credentials := dto.CreateNewCredentials()
 	if err := tx.Joins("JOIN users ON users.id = ?", userId).
 		Where("credentials.id = users.credentials_id").First(&credentials).Error; err != nil {
+		tx.Rollback()
 		return nil, courseError.CreateError(err, 10002)
 	}
 
@@ -56,28 +123,28 @@ func (storage Storage) CreateNewOrder(ctx context.Context, courseId, price uint,
 	}
 
 	placedOrder := dto.NewOrderEssentials().
-		AddOrderId(order.ID).
+		AddOrderId(firstOrder.ID).
 		AddOrder(orderNum).
-		AddOrderDate(uint(order.CreatedAt.Unix())).
-		AddExpDate(uint(order.CreatedAt.Add(15 * time.Minute).Unix())).
-		AddAmountToPay(price).
+		AddOrderDate(uint(firstOrder.CreatedAt.Unix())).
+		AddExpDate(uint(firstOrder.CreatedAt.Add(15 * time.Minute).Unix())).
+		AddAmountToPay(amount).
+		AddCurrency(currency).
 		AddRusLang().
-		AddPurpose(fmt.Sprintf("Покупка: %v", course.Name)).
+		AddPurpose(fmt.Sprintf("Покупка: %v", strings.Join(courseNames, ", "))).
 		AddDefaultTaxSystem().
 		AddEmail(credentials.Email).
 		AddContactEmail()
 
-	if ruCard {
-		placedOrder.AddCurrencyRub()
-	}
-
 	return placedOrder, nil
 }
 
+// SetInvoiceId привязывает инвойс банка к платежам за все курсы заказа с ID orderId.
 func (storage Storage) SetInvoiceId(ctx context.Context, invoiceId, orderId uint) *courseError.CourseError {
 	tx := storage.db.WithContext(ctx).Begin()
 
-	if err := tx.Model(&dto.Billing{}).Where("order_id = ?", orderId).Update("invoice_id", invoiceId).Error; err != nil {
+	if err := tx.Model(&dto.Billing{}).
+		Where(`order_id IN (SELECT id FROM orders WHERE "order" = (SELECT o."order" FROM orders o WHERE o.id = ?))`, orderId).
+		Update("invoice_id", invoiceId).Error; err != nil {
 		return courseError.CreateError(err, 10003)
 	}
 
@@ -89,76 +156,384 @@ func (storage Storage) SetInvoiceId(ctx context.Context, invoiceId, orderId uint
 	return nil
 }
 
-func (storage Storage) ApprovePayment(ctx context.Context, invoiceId, hashedUserData string) (*string, *courseError.CourseError) {
+// ApprovePayment отмечает инвойс оплаченным и ставит чек в outbox, а если курс куплен в подарок, то и письмо
+// получателю подарка. Письма записываются, только если инвойс еще ждал оплаты, поэтому повторное уведомление
+// от банка ничего не меняет. За оплату в рублях ставится в очередь фискальный чек.
+func (storage Storage) ApprovePayment(ctx context.Context, invoiceId uint, receiptEmail, giftEmail *dto.OutboxEmail) *courseError.CourseError {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	result := tx.Model(&dto.Billing{}).
+		Where("invoice_id = ? AND status = ?", invoiceId, dto.BillingStatusPending).
+		Updates(map[string]interface{}{
+			"status": dto.BillingStatusPaid,
+			"receipt_status": gorm.Expr("CASE WHEN currency = ? THEN ? ELSE ? END",
+				dto.CurrencyRUB, dto.ReceiptStatusPending, dto.ReceiptStatusNotRequired),
+		})
+	if err := result.Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10003)
+	}
+
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+
+		var count int64
+		if err := storage.db.WithContext(ctx).Model(&dto.Billing{}).Where("invoice_id = ?", invoiceId).Count(&count).Error; err != nil {
+			return courseError.CreateError(err, 10002)
+		}
+		if count == 0 {
+			return courseError.CreateError(errInvoiceNotFound, 15001)
+		}
+
+		return nil
+	}
+
+	if err := enqueueEmail(tx, receiptEmail); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := enqueueEmail(tx, giftEmail); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10010)
+	}
+
+	return nil
+}
+
+// FailOrder отмечает неоплаченный заказ неудавшимся и ставит в outbox напоминание о незавершенной покупке,
+// если оно еще не отправлялось. Заказ остается в истории платежей, оплаченный заказ не меняется. Платежи
+// за все курсы заказа меняются вместе.
+func (storage Storage) FailOrder(ctx context.Context, invoiceId string, reminderEmail *dto.OutboxEmail) *courseError.CourseError {
+	return storage.failPendingOrder(ctx, "invoice_id = ?", invoiceId, reminderEmail)
+}
+
+// ExpireOrder отмечает неоплаченный заказ неудавшимся по ID платежа так же, как FailOrder. Используется
+// для заказов, по которым банк так и не выставил инвойс.
+func (storage Storage) ExpireOrder(ctx context.Context, billingId uint, reminderEmail *dto.OutboxEmail) *courseError.CourseError {
+	return storage.failPendingOrder(ctx, "id = ?", billingId, reminderEmail)
+}
+
+// failPendingOrder отмечает заказ неудавшимся. Напоминание ставится в outbox так же, как в MarkOrderReminded:
+// только если условное обновление reminder_sent_at затронуло строки, поэтому параллельные проходы не отправят
+// его дважды.
+func (storage Storage) failPendingOrder(ctx context.Context, query string, arg interface{}, reminderEmail *dto.OutboxEmail) *courseError.CourseError {
 	tx := storage.db.WithContext(ctx).Begin()
 
 	bill := dto.NewPayment()
-	if err := tx.Where("invoice_id = ?", invoiceId).First(&bill).Error; err != nil {
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where(query, arg).First(&bill).Error; err != nil {
 		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return courseError.CreateError(errInvoiceNotFound, 15001)
+		}
+		return courseError.CreateError(err, 10002)
+	}
+
+	if !dto.CanChangeBillingStatus(bill.Status, dto.BillingStatusFailed) {
+		tx.Rollback()
+		return nil
+	}
+
+	reminded := tx.Model(&dto.Billing{}).
+		Where(sameOrderBillings, bill.ID).
+		Where("billings.reminder_sent_at IS NULL").
+		Update("reminder_sent_at", time.Now())
+	if err := reminded.Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10003)
+	}
+
+	if reminded.RowsAffected != 0 {
+		if err := enqueueEmail(tx, reminderEmail); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := tx.Model(&dto.Billing{}).Where(sameOrderBillings, bill.ID).Where("billings.status = ?", dto.BillingStatusPending).
+		Update("status", dto.BillingStatusFailed).Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10003)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10010)
+	}
+
+	return nil
+}
+
+// purchaseDetailsQuery собирает запрос данных заказа для писем о покупке. Платежи за курсы одного заказа
+// собираются в одну строку: цены и возвраты складываются, названия курсов перечисляются через запятую,
+// а ID платежа берется у первого курса. Статус заказа считается по статусам всех его платежей: заказ ждет оплаты,
+// пока ждет хотя бы один платеж, оплачен или возвращен, только если так у всех платежей, и возвращен частично,
+// если среди оплаченных платежей есть возвраты.
+func (storage Storage) purchaseDetailsQuery(ctx context.Context) *gorm.DB {
+	return storage.db.WithContext(ctx).Table("billings").
+		Select(`MIN(billings.id) AS billing_id, MAX(billings.invoice_id) AS invoice_id, MIN(billings.payment_method) AS payment_method,
+			MIN(billings.currency) AS currency, SUM(billings.amount) AS amount,
+			CASE
+				WHEN BOOL_OR(billings.status = @pending) THEN @pending
+				WHEN BOOL_AND(billings.status = @paid) THEN @paid
+				WHEN BOOL_AND(billings.status = @refunded) THEN @refunded
+				WHEN BOOL_AND(billings.status IN (@paid, @partiallyRefunded, @refunded)) THEN @partiallyRefunded
+				ELSE @failed
+			END AS status,
+			SUM(billings.refunded_amount) AS refunded_amount, MIN(billings.created_at) AS created_at, orders."order",
+			MIN(orders.user_id) AS user_id, STRING_AGG(courses.name, ', ' ORDER BY billings.id) AS course_name,
+			COUNT(*) AS course_count, MIN(credentials.email) AS email, MIN(users.locale) AS locale,
+			MIN(gifts.code) AS gift_code, MIN(gifts.recipient_email) AS gift_recipient`, map[string]interface{}{
+			"pending":           dto.BillingStatusPending,
+			"paid":              dto.BillingStatusPaid,
+			"partiallyRefunded": dto.BillingStatusPartiallyRefunded,
+			"refunded":          dto.BillingStatusRefunded,
+			"failed":            dto.BillingStatusFailed,
+		}).
+		Joins("JOIN orders ON orders.id = billings.order_id").
+		Joins("JOIN courses ON courses.id = orders.course_id").
+		Joins("JOIN users ON users.id = orders.user_id").
+		Joins("JOIN credentials ON credentials.id = users.credentials_id").
+		Joins("LEFT JOIN gifts ON gifts.order_id = orders.id").
+		Where("billings.deleted_at IS NULL").
+		Group(`orders."order"`)
+}
+
+// GetPurchaseDetails возвращает данные заказа по ID инвойса для писем о покупке.
+func (storage Storage) GetPurchaseDetails(ctx context.Context, invoiceId string) (*dto.PurchaseDetails, *courseError.CourseError) {
+	details := dto.NewPurchaseDetails()
+	if err := storage.purchaseDetailsQuery(ctx).
+		Where("billings.invoice_id = ?", invoiceId).
+		Take(details).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, courseError.CreateError(errInvoiceNotFound, 15001)
 		}
 		return nil, courseError.CreateError(err, 10002)
 	}
 
-	order := dto.CreateNewOrder()
-	if err := tx.Where("id = ?", bill.OrderId).First(&order).Error; err != nil {
-		tx.Rollback()
+	return details, nil
+}
+
+// GetBillingPurchaseDetails возвращает данные платежа за один курс заказа по его ID для возврата денег.
+func (storage Storage) GetBillingPurchaseDetails(ctx context.Context, billingId uint) (*dto.PurchaseDetails, *courseError.CourseError) {
+	details := dto.NewPurchaseDetails()
+	if err := storage.purchaseDetailsQuery(ctx).
+		Where("billings.id = ?", billingId).
+		Take(details).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return nil, courseError.CreateError(errOrderNotFound, 15002)
+			return nil, courseError.CreateError(errInvoiceNotFound, 15001)
 		}
 		return nil, courseError.CreateError(err, 10002)
 	}
 
-	userDataHash := md5.New()
+	return details, nil
+}
 
-	userDataHash.Write([]byte(fmt.Sprintf("%d%v", order.UserId, order.Order)))
+// RefundBilling записывает возврат amount по платежу за один курс и ставит письмо о возврате в outbox. Статус
+// меняется только у этого платежа, после полного возврата доступ закрывается только к его курсу. invoiceRefundedTotal -
+// общая сумма возврата по инвойсу в банке вместе с этим возвратом: если у нас по инвойсу уже записано столько же,
+// возврат успел прийти уведомлением от банка и повторно не записывается.
+func (storage Storage) RefundBilling(ctx context.Context, billingId, amount, invoiceRefundedTotal uint, refund *dto.Refund, refundEmail *dto.OutboxEmail) *courseError.CourseError {
+	tx := storage.db.WithContext(ctx).Begin()
 
-	checkHashedUserData := hex.EncodeToString(userDataHash.Sum(nil))
+	var bills []dto.Billing
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("invoice_id = (SELECT invoice_id FROM billings WHERE id = ?)", billingId).
+		Order("id").Find(&bills).Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10002)
+	}
 
-	if checkHashedUserData != hashedUserData {
+	var bill *dto.Billing
+	var invoiceRefunded uint
+	for i, v := range bills {
+		invoiceRefunded += v.RefundedAmount
+		if v.ID == billingId {
+			bill = &bills[i]
+		}
+	}
+
+	if bill == nil {
 		tx.Rollback()
-		return nil, courseError.CreateError(errBadUserCredentials, 15003)
+		return courseError.CreateError(errInvoiceNotFound, 15001)
 	}
 
-	if err := tx.Model(&dto.Billing{}).Where("id = ?", bill.ID).Update("paid", true).Error; err != nil {
+	if invoiceRefunded >= invoiceRefundedTotal {
 		tx.Rollback()
-		return nil, courseError.CreateError(errBadUserCredentials, 10003)
+		return nil
 	}
 
-	course := dto.CreateNewCourse()
-	if err := tx.Where("id = ?", order.CourseId).First(&course).Error; err != nil {
-		return nil, courseError.CreateError(err, 10002)
+	if amount > bill.Amount-bill.RefundedAmount {
+		tx.Rollback()
+		return courseError.CreateError(errRefundTooLarge, 15009)
+	}
+
+	if err := refundBill(tx, bill, bill.RefundedAmount+amount, refund); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := enqueueEmail(tx, refundEmail); err != nil {
+		tx.Rollback()
+		return err
 	}
 
 	if err := tx.Commit().Error; err != nil {
 		tx.Rollback()
-		return nil, courseError.CreateError(err, 10010)
+		return courseError.CreateError(err, 10010)
 	}
 
-	return &course.Name, nil
+	return nil
 }
 
-func (storage Storage) DeleteOrder(ctx context.Context, invoiceId string) *courseError.CourseError {
+// RefundPayment записывает возврат по инвойсу до общей суммы refundedTotal, о котором сообщил банк, и ставит письмо
+// о возврате в outbox. Если инвойс оплачивал несколько курсов, новая часть возврата делится между их платежами
+// пропорционально невозвращенному остатку, после полного возврата платежа доступ к его курсу закрывается.
+// Если такая сумма уже возвращена, ничего не меняется, поэтому повторное уведомление от банка не создает второй возврат.
+func (storage Storage) RefundPayment(ctx context.Context, invoiceId uint, refundedTotal uint, refund *dto.Refund, refundEmail *dto.OutboxEmail) *courseError.CourseError {
 	tx := storage.db.WithContext(ctx).Begin()
 
-	bill := dto.NewPayment()
-	if err := tx.Where("invoice_id = ?", invoiceId).First(&bill).Error; err != nil {
+	var bills []dto.Billing
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("invoice_id = ?", invoiceId).Order("id").Find(&bills).Error; err != nil {
 		tx.Rollback()
-		if errors.Is(err, gorm.ErrRecordNotFound) {
-			return courseError.CreateError(errInvoiceNotFound, 15001)
-		}
 		return courseError.CreateError(err, 10002)
 	}
 
-	if err := tx.Model(&dto.Billing{}).Where("order_id = ?", bill.OrderId).Delete(nil).Error; err != nil {
+	if len(bills) == 0 {
+		tx.Rollback()
+		return courseError.CreateError(errInvoiceNotFound, 15001)
+	}
+
+	var amount, refundedAmount uint
+	var lastRefundable int
+	for i, v := range bills {
+		amount += v.Amount
+		refundedAmount += v.RefundedAmount
+		if v.RefundedAmount < v.Amount {
+			lastRefundable = i
+		}
+	}
+
+	if refundedTotal <= refundedAmount {
+		tx.Rollback()
+		return nil
+	}
+
+	if refundedTotal > amount {
+		tx.Rollback()
+		return courseError.CreateError(errRefundTooLarge, 15009)
+	}
+
+	delta := refundedTotal - refundedAmount
+	remaining := amount - refundedAmount
+
+	var distributed uint
+	for i := range bills {
+		bill := &bills[i]
+		if bill.RefundedAmount >= bill.Amount {
+			continue
+		}
+
+		billDelta := delta - distributed
+		if i < lastRefundable {
+			billDelta = uint(uint64(bill.Amount-bill.RefundedAmount) * uint64(delta) / uint64(remaining))
+		}
+		distributed += billDelta
+
+		if billDelta == 0 {
+			continue
+		}
+
+		if err := refundBill(tx, bill, bill.RefundedAmount+billDelta, refund); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := enqueueEmail(tx, refundEmail); err != nil {
 		tx.Rollback()
-		return courseError.CreateError(err, 10004)
+		return err
 	}
 
-	if err := tx.Model(&dto.Order{}).Where("id = ?", bill.OrderId).Delete(nil).Error; err != nil {
+	if err := tx.Commit().Error; err != nil {
 		tx.Rollback()
-		return courseError.CreateError(err, 10004)
+		return courseError.CreateError(err, 10010)
+	}
+
+	return nil
+}
+
+// refundBill записывает возврат по платежу до суммы billRefunded и меняет его статус. За возврат в рублях
+// в очередь ставится чек "возврат прихода".
+func refundBill(tx *gorm.DB, bill *dto.Billing, billRefunded uint, refund *dto.Refund) *courseError.CourseError {
+	status := dto.RefundStatus(bill.Amount, billRefunded)
+	if !dto.CanChangeBillingStatus(bill.Status, status) {
+		return courseError.CreateError(errPaymentNotRefundable, 15008)
+	}
+
+	billRefund := *refund
+	billRefund.BillingId = bill.ID
+	billRefund.Currency = bill.Currency
+	billRefund.Amount = billRefunded - bill.RefundedAmount
+	billRefund.ReceiptStatus = dto.ReceiptStatusNotRequired
+	if bill.Currency == dto.CurrencyRUB {
+		billRefund.ReceiptStatus = dto.ReceiptStatusPending
+	}
+	if err := tx.Create(&billRefund).Error; err != nil {
+		return courseError.CreateError(err, 10001)
+	}
+
+	if err := tx.Model(&dto.Billing{}).Where("id = ?", bill.ID).Updates(map[string]interface{}{
+		"status":          status,
+		"refunded_amount": billRefunded,
+	}).Error; err != nil {
+		return courseError.CreateError(err, 10003)
+	}
+
+	return nil
+}
+
+// GetExpiredOrders возвращает ожидающие оплаты заказы, созданные после createdAfter и раньше createdBefore, по которым
+// еще не отправлялось напоминание о покупке. Нижняя граница не дает напомнить о давно брошенных заказах.
+func (storage Storage) GetExpiredOrders(ctx context.Context, createdAfter, createdBefore time.Time, limit int) ([]dto.PurchaseDetails, *courseError.CourseError) {
+	var orders []dto.PurchaseDetails
+	if err := storage.purchaseDetailsQuery(ctx).
+		Where("billings.status = ? AND billings.reminder_sent_at IS NULL AND billings.created_at > ? AND billings.created_at < ?",
+			dto.BillingStatusPending, createdAfter, createdBefore).
+		Order("billing_id").
+		Limit(limit).
+		Scan(&orders).Error; err != nil {
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return orders, nil
+}
+
+// MarkOrderReminded отмечает, что по заказу отправлено напоминание о покупке, и ставит письмо в outbox.
+// Письмо записывается, только если напоминание еще не отправлялось и заказ ждет оплаты, поэтому несколько
+// экземпляров сервиса не отправят его дважды.
+func (storage Storage) MarkOrderReminded(ctx context.Context, billingId uint, reminderEmail *dto.OutboxEmail) *courseError.CourseError {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	result := tx.Model(&dto.Billing{}).
+		Where(sameOrderBillings, billingId).
+		Where("billings.status = ? AND billings.reminder_sent_at IS NULL", dto.BillingStatusPending).
+		Update("reminder_sent_at", time.Now())
+	if err := result.Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10003)
+	}
+
+	if result.RowsAffected != 0 {
+		if err := enqueueEmail(tx, reminderEmail); err != nil {
+			tx.Rollback()
+			return err
+		}
 	}
 
 	if err := tx.Commit().Error; err != nil {
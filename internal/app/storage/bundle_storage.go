@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+	"gorm.io/gorm"
+)
+
+var (
+	errBundleNotFound = errors.New("набор курсов не найден")
+	errBundleExists   = errors.New("набор курсов с таким названием уже существует")
+)
+
+// StoreBundle сохраняет набор курсов вместе с его курсами.
+func (storage Storage) StoreBundle(ctx context.Context, bundle *dto.Bundle) *courseError.CourseError {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	if err := checkCoursesExist(tx, bundle.Courses); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Omit("Courses.*").Create(bundle).Error; err != nil {
+		tx.Rollback()
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			return courseError.CreateError(errBundleExists, 15021)
+		}
+		return courseError.CreateError(err, 10001)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10010)
+	}
+
+	return nil
+}
+
+// UpdateBundle перезаписывает условия и курсы набора с указанным ID. Уже оплаченные заказы не меняются.
+func (storage Storage) UpdateBundle(ctx context.Context, bundle *dto.Bundle) *courseError.CourseError {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	if err := checkCoursesExist(tx, bundle.Courses); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	result := tx.Model(&dto.Bundle{}).Where("id = ?", bundle.ID).
+		Select("name", "description", "price", "active").
+		Updates(bundle)
+	if err := result.Error; err != nil {
+		tx.Rollback()
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			return courseError.CreateError(errBundleExists, 15021)
+		}
+		return courseError.CreateError(err, 10003)
+	}
+
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return courseError.CreateError(errBundleNotFound, 15020)
+	}
+
+	if err := tx.Omit("Courses.*").Model(bundle).Association("Courses").Replace(bundle.Courses); err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10003)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10010)
+	}
+
+	return nil
+}
+
+// DeleteBundle удаляет набор курсов. Заказы, оформленные на набор, остаются в истории платежей.
+func (storage Storage) DeleteBundle(ctx context.Context, id uint) *courseError.CourseError {
+	result := storage.db.WithContext(ctx).Delete(&dto.Bundle{}, id)
+	if err := result.Error; err != nil {
+		return courseError.CreateError(err, 10004)
+	}
+
+	if result.RowsAffected == 0 {
+		return courseError.CreateError(errBundleNotFound, 15020)
+	}
+
+	return nil
+}
+
+// GetBundles возвращает наборы курсов вместе с их курсами, начиная с самого дешевого. С onlyActive
+// возвращаются только наборы, которые можно купить.
+func (storage Storage) GetBundles(ctx context.Context, onlyActive bool) ([]dto.Bundle, *courseError.CourseError) {
+	query := storage.db.WithContext(ctx).Preload("Courses", bundleCoursesOrder)
+	if onlyActive {
+		query = query.Where("active = ?", true)
+	}
+
+	bundles := dto.CreateNewBundles()
+	if err := query.Order("price, id").Find(&bundles).Error; err != nil {
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return bundles, nil
+}
+
+// GetBundle возвращает набор курсов, который можно купить, вместе с его курсами.
+func (storage Storage) GetBundle(ctx context.Context, id uint) (*dto.Bundle, *courseError.CourseError) {
+	bundle := &dto.Bundle{}
+	if err := storage.db.WithContext(ctx).Preload("Courses", bundleCoursesOrder).
+		Where("id = ? AND active = ?", id, true).First(bundle).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, courseError.CreateError(errBundleNotFound, 15020)
+		}
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return bundle, nil
+}
+
+// bundleCoursesOrder упорядочивает курсы набора, чтобы цена набора всегда делилась между ними одинаково.
+func bundleCoursesOrder(db *gorm.DB) *gorm.DB {
+	return db.Order("courses.id")
+}
@@ -0,0 +1,175 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errOutboxEmailNotFound = errors.New("письмо не найдено или не находится в статусе failed")
+)
+
+// EnqueueEmail добавляет письмо в outbox, откуда его отправит фоновый отправщик.
+func (storage Storage) EnqueueEmail(ctx context.Context, email *dto.OutboxEmail) *courseError.CourseError {
+	if err := storage.db.WithContext(ctx).Create(email).Error; err != nil {
+		return courseError.CreateError(err, 10001)
+	}
+
+	return nil
+}
+
+// enqueueEmail добавляет письмо в outbox в рамках транзакции бизнес-операции, чтобы письмо
+// появилось только вместе с изменениями, о которых оно сообщает.
+func enqueueEmail(tx *gorm.DB, email *dto.OutboxEmail) *courseError.CourseError {
+	if email == nil {
+		return nil
+	}
+
+	if err := tx.Create(email).Error; err != nil {
+		return courseError.CreateError(err, 10001)
+	}
+
+	return nil
+}
+
+// ClaimOutboxEmails забирает письма, которые пора отправить, и откладывает их следующую попытку на lease.
+// Строки блокируются с SKIP LOCKED, поэтому несколько экземпляров сервиса не возьмут одно и то же письмо.
+// Если отправщик упадет во время отправки, письмо снова станет доступно после lease.
+func (storage Storage) ClaimOutboxEmails(ctx context.Context, limit int, lease time.Duration) ([]dto.OutboxEmail, *courseError.CourseError) {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	now := time.Now()
+
+	emails := dto.CreateNewOutboxEmails()
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+		Where("status = ? AND next_attempt_at <= ?", dto.OutboxEmailPending, now).
+		Order("next_attempt_at").
+		Limit(limit).
+		Find(&emails).Error; err != nil {
+		tx.Rollback()
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	if len(emails) == 0 {
+		tx.Rollback()
+		return emails, nil
+	}
+
+	ids := make([]uint, 0, len(emails))
+	for _, v := range emails {
+		ids = append(ids, v.ID)
+	}
+
+	if err := tx.Model(&dto.OutboxEmail{}).Where("id IN ?", ids).Update("next_attempt_at", now.Add(lease)).Error; err != nil {
+		tx.Rollback()
+		return nil, courseError.CreateError(err, 10003)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return nil, courseError.CreateError(err, 10010)
+	}
+
+	return emails, nil
+}
+
+// UpdateOutboxEmail сохраняет результат попытки отправки письма. Текст отправленного письма сохраняется
+// пустым.
+func (storage Storage) UpdateOutboxEmail(ctx context.Context, email *dto.OutboxEmail) *courseError.CourseError {
+	if err := storage.db.WithContext(ctx).
+		Model(email).
+		Select("status", "attempts", "next_attempt_at", "last_error", "sent_at", "body", "html_body").
+		Updates(email).Error; err != nil {
+		return courseError.CreateError(err, 10003)
+	}
+
+	return nil
+}
+
+func (storage Storage) GetOutboxEmails(ctx context.Context, status string, limit, offset int) ([]dto.OutboxEmail, int64, *courseError.CourseError) {
+	query := storage.db.WithContext(ctx).Model(&dto.OutboxEmail{})
+
+	if status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var totalCount int64
+	if err := query.Count(&totalCount).Error; err != nil {
+		return nil, 0, courseError.CreateError(err, 10002)
+	}
+
+	emails := dto.CreateNewOutboxEmails()
+	if err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&emails).Error; err != nil {
+		return nil, 0, courseError.CreateError(err, 10002)
+	}
+
+	return emails, totalCount, nil
+}
+
+// ResendOutboxEmail возвращает письмо из dead letter в очередь со сброшенным счетчиком попыток.
+func (storage Storage) ResendOutboxEmail(ctx context.Context, id uint) *courseError.CourseError {
+	result := storage.db.WithContext(ctx).
+		Model(&dto.OutboxEmail{}).
+		Where("id = ? AND status = ?", id, dto.OutboxEmailFailed).
+		Updates(map[string]interface{}{
+			"status":          dto.OutboxEmailPending,
+			"attempts":        0,
+			"next_attempt_at": time.Now(),
+		})
+	if result.Error != nil {
+		return courseError.CreateError(result.Error, 10003)
+	}
+
+	if result.RowsAffected == 0 {
+		return courseError.CreateError(errOutboxEmailNotFound, 17005)
+	}
+
+	return nil
+}
+
+// GetEmailRecipient возвращает почту и язык писем пользователя по его ID.
+func (storage Storage) GetEmailRecipient(ctx context.Context, userId uint) (email, locale string, err *courseError.CourseError) {
+	var recipient struct {
+		Email  string
+		Locale string
+	}
+
+	if err := storage.db.WithContext(ctx).Table("users").
+		Select("credentials.email, users.locale").
+		Joins("JOIN credentials ON credentials.id = users.credentials_id").
+		Where("users.id = ? AND users.deleted_at IS NULL", userId).
+		Take(&recipient).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return "", "", courseError.CreateError(errUserNotFound, 11002)
+		}
+		return "", "", courseError.CreateError(err, 10002)
+	}
+
+	return recipient.Email, recipient.Locale, nil
+}
+
+// GetLocaleByEmail возвращает язык писем пользователя по почте. Если пользователь не найден, возвращается
+// пустая строка, чтобы по ответу нельзя было понять, зарегистрирована ли почта.
+func (storage Storage) GetLocaleByEmail(ctx context.Context, email string) (string, *courseError.CourseError) {
+	var locales []string
+
+	if err := storage.db.WithContext(ctx).Table("users").
+		Joins("JOIN credentials ON credentials.id = users.credentials_id").
+		Where("credentials.email = ? AND users.deleted_at IS NULL", email).
+		Limit(1).
+		Pluck("users.locale", &locales).Error; err != nil {
+		return "", courseError.CreateError(err, 10002)
+	}
+
+	if len(locales) == 0 {
+		return "", nil
+	}
+
+	return locales[0], nil
+}
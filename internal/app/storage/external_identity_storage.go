@@ -0,0 +1,207 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"strings"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+	"golang.org/x/crypto/bcrypt"
+	"gorm.io/gorm"
+)
+
+var (
+	errExternalEmailIsMissing    = errors.New("провайдер не передал почту пользователя")
+	errExternalEmailIsUnverified = errors.New("почта не подтверждена у провайдера, войдите по паролю")
+)
+
+// SignInWithExternalIdentity используется для входа через социальный логин. Если внешний аккаунт уже привязан,
+// то возвращает его пользователя. Иначе привязывает внешний аккаунт к пользователю с той же почтой или создает
+// нового пользователя, это возможно только если провайдер подтвердил почту. Возвращает ID пользователя, статус верификации или ошибку.
+func (storage Storage) SignInWithExternalIdentity(ctx context.Context, provider, subject, email string, emailVerified bool) (
+	userId *uint, verified *bool, err *courseError.CourseError) {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	var revoked []dto.RevokedToken
+
+	user := dto.CreateNewUser()
+	credentials := dto.CreateNewCredentials()
+
+	identity := dto.CreateNewExternalIdentity(0, provider, subject, email)
+	identityErr := tx.Where("provider = ? AND subject = ?", provider, subject).First(&identity).Error
+	if identityErr != nil && !errors.Is(identityErr, gorm.ErrRecordNotFound) {
+		tx.Rollback()
+		return nil, nil, courseError.CreateError(identityErr, 10002)
+	}
+
+	if identityErr == nil {
+		if err := tx.Where("id = ?", identity.UserId).First(&user).Error; err != nil {
+			tx.Rollback()
+			return nil, nil, courseError.CreateError(err, 10002)
+		}
+
+		if err := tx.Where("id = ?", user.CredentialsId).First(&credentials).Error; err != nil {
+			tx.Rollback()
+			return nil, nil, courseError.CreateError(err, 10002)
+		}
+	} else {
+		if email == "" {
+			tx.Rollback()
+			return nil, nil, courseError.CreateError(errExternalEmailIsMissing, 11205)
+		}
+
+		if !emailVerified {
+			tx.Rollback()
+			return nil, nil, courseError.CreateError(errExternalEmailIsUnverified, 11205)
+		}
+
+		verifiedUser, verifiedCredentials, disabledTokens, err := storage.findOrCreateVerifiedUser(tx, email)
+		if err != nil {
+			tx.Rollback()
+			return nil, nil, err
+		}
+		user, credentials, revoked = verifiedUser, verifiedCredentials, disabledTokens
+
+		identity.UserId = user.ID
+		if err := tx.Create(&identity).Error; err != nil {
+			tx.Rollback()
+			return nil, nil, courseError.CreateError(err, 10001)
+		}
+	}
+
+	if user.Banned {
+		tx.Rollback()
+		return nil, nil, courseError.CreateError(errUserBanned, 11010)
+	}
+
+	if !user.Active {
+		tx.Rollback()
+		return nil, nil, courseError.CreateError(errUserInactive, 11011)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return nil, nil, courseError.CreateError(err, 10010)
+	}
+
+	storage.revokeCached(revoked)
+
+	return &user.ID, &credentials.Verified, nil
+}
+
+// SignInByEmail используется для входа по ссылке из письма. Так как пользователь перешел по ссылке, владение почтой
+// подтверждено, поэтому находит пользователя по почте или создает нового. Возвращает ID пользователя, статус верификации или ошибку.
+func (storage Storage) SignInByEmail(ctx context.Context, email string) (userId *uint, verified *bool, err *courseError.CourseError) {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	user, credentials, revoked, err := storage.findOrCreateVerifiedUser(tx, email)
+	if err != nil {
+		tx.Rollback()
+		return nil, nil, err
+	}
+
+	if user.Banned {
+		tx.Rollback()
+		return nil, nil, courseError.CreateError(errUserBanned, 11010)
+	}
+
+	if !user.Active {
+		tx.Rollback()
+		return nil, nil, courseError.CreateError(errUserInactive, 11011)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return nil, nil, courseError.CreateError(err, 10010)
+	}
+
+	storage.revokeCached(revoked)
+
+	return &user.ID, &credentials.Verified, nil
+}
+
+// findOrCreateVerifiedUser находит пользователя по почте, владение которой уже подтверждено, или создает нового
+// пользователя с подтвержденной почтой и случайным паролем, который можно сменить через восстановление пароля.
+// Если почта существующего пользователя не была подтверждена, то его пароль заменяется случайным, а сессии отключаются,
+// так как аккаунт мог быть создан не владельцем почты. Отключенные токены нужно отозвать после коммита транзакции.
+func (storage Storage) findOrCreateVerifiedUser(tx *gorm.DB, email string) (*dto.User, *dto.Credentials, []dto.RevokedToken, *courseError.CourseError) {
+	user := dto.CreateNewUser()
+	credentials := dto.CreateNewCredentials()
+
+	credentialsErr := tx.Where("email = ?", email).First(&credentials).Error
+	if credentialsErr != nil && !errors.Is(credentialsErr, gorm.ErrRecordNotFound) {
+		return nil, nil, nil, courseError.CreateError(credentialsErr, 10002)
+	}
+
+	if credentialsErr == nil {
+		if err := tx.Where("credentials_id = ?", credentials.ID).First(&user).Error; err != nil {
+			return nil, nil, nil, courseError.CreateError(err, 10002)
+		}
+
+		if credentials.Verified {
+			return user, credentials, nil, nil
+		}
+
+		hashedPassword, err := storage.randomPasswordHash()
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		if err := tx.Model(&credentials).Updates(map[string]interface{}{
+			"verified": true,
+			"password": *hashedPassword,
+		}).Error; err != nil {
+			return nil, nil, nil, courseError.CreateError(err, 10003)
+		}
+		credentials.SetStatusVerified()
+
+		revoked, disableErr := disableAccessTokens(tx, "user_id = ?", user.ID)
+		if disableErr != nil {
+			return nil, nil, nil, courseError.CreateError(disableErr, 10003)
+		}
+
+		return user, credentials, revoked, nil
+	}
+
+	hashedPassword, err := storage.randomPasswordHash()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	credentials.AddEmail(email).
+		AddPassword(*hashedPassword).
+		SetStatusVerified()
+
+	if err := tx.Create(&credentials).Error; err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			return nil, nil, nil, courseError.CreateError(errEmailIsBusy, 11001)
+		}
+		return nil, nil, nil, courseError.CreateError(errRegistingUser, 10001)
+	}
+
+	user.AddCredentialsId(&credentials.ID)
+	if err := tx.Create(&user).Error; err != nil {
+		return nil, nil, nil, courseError.CreateError(errRegistingUser, 10001)
+	}
+
+	return user, credentials, nil, nil
+}
+
+// randomPasswordHash возвращает хэш случайного пароля для пользователей, созданных без пароля.
+func (storage Storage) randomPasswordHash() (*string, *courseError.CourseError) {
+	password := make([]byte, 32)
+	if _, err := rand.Read(password); err != nil {
+		return nil, courseError.CreateError(err, 11020)
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(hex.EncodeToString(password)+storage.secret), bcrypt.DefaultCost)
+	if err != nil {
+		return nil, courseError.CreateError(err, 11020)
+	}
+
+	hash := string(hashedPassword)
+	return &hash, nil
+}
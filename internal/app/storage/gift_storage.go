@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errGiftNotFound        = errors.New("подарок не найден")
+	errGiftVoided          = errors.New("подарок аннулирован")
+	errGiftAlreadyRedeemed = errors.New("подарок уже активирован")
+	errGiftNotPaid         = errors.New("подарок еще не оплачен")
+	errGiftForOtherUser    = errors.New("подарок предназначен другому пользователю")
+	errGiftCourseOwned     = errors.New("этот курс уже куплен")
+)
+
+// CreateGift отмечает заказ с ID orderId покупкой в подарок с кодом code. Если передана почта получателя,
+// подарок сможет активировать только пользователь с этой почтой.
+func (storage Storage) CreateGift(ctx context.Context, orderId uint, code, recipientEmail string) *courseError.CourseError {
+	gift := dto.CreateNewGift(orderId, ctx.Value("UserId").(uint), code, recipientEmail)
+	if err := storage.db.WithContext(ctx).Create(gift).Error; err != nil {
+		return courseError.CreateError(err, 10001)
+	}
+
+	return nil
+}
+
+// RedeemGift активирует подарок с кодом code для текущего пользователя, после чего курс из заказа подарка
+// становится доступен ему. Подарок можно активировать один раз и только после оплаты. Возвращает подарок или ошибку.
+func (storage Storage) RedeemGift(ctx context.Context, code string) (*dto.GiftDetails, *courseError.CourseError) {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	userId := ctx.Value("UserId").(uint)
+
+	gift := &dto.Gift{}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code = ?", code).First(gift).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, courseError.CreateError(errGiftNotFound, 15022)
+		}
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	details := &dto.GiftDetails{}
+	if err := giftDetailsQuery(tx).Where("gifts.id = ?", gift.ID).First(details).Error; err != nil {
+		tx.Rollback()
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	switch details.Status() {
+	case dto.GiftStatusRedeemed:
+		tx.Rollback()
+		return nil, courseError.CreateError(errGiftAlreadyRedeemed, 15024)
+	case dto.GiftStatusVoided:
+		tx.Rollback()
+		return nil, courseError.CreateError(errGiftVoided, 15023)
+	case dto.GiftStatusPending:
+		tx.Rollback()
+		return nil, courseError.CreateError(errGiftNotPaid, 15025)
+	}
+
+	if gift.RecipientEmail != "" {
+		credentials := dto.CreateNewCredentials()
+		if err := tx.Joins("JOIN users ON users.id = ?", userId).
+			Where("credentials.id = users.credentials_id").First(&credentials).Error; err != nil {
+			tx.Rollback()
+			return nil, courseError.CreateError(err, 10002)
+		}
+
+		if !strings.EqualFold(credentials.Email, gift.RecipientEmail) {
+			tx.Rollback()
+			return nil, courseError.CreateError(errGiftForOtherUser, 15026)
+		}
+	}
+
+	var owned int64
+	if err := tx.Table("orders").
+		Joins("JOIN billings ON billings.order_id = orders.id").
+		Joins("LEFT JOIN gifts ON gifts.order_id = orders.id").
+		Where("orders.course_id = ? AND billings.status IN (?)", details.CourseId, dto.BillingAccessStatuses).
+		Where("(gifts.id IS NULL AND orders.user_id = ?) OR gifts.recipient_id = ?", userId, userId).
+		Count(&owned).Error; err != nil {
+		tx.Rollback()
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	if owned != 0 {
+		tx.Rollback()
+		return nil, courseError.CreateError(errGiftCourseOwned, 15004)
+	}
+
+	now := time.Now()
+	if err := tx.Model(&dto.Gift{}).Where("id = ?", gift.ID).
+		Updates(map[string]interface{}{"recipient_id": userId, "redeemed_at": now}).Error; err != nil {
+		tx.Rollback()
+		return nil, courseError.CreateError(err, 10003)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return nil, courseError.CreateError(err, 10010)
+	}
+
+	details.RecipientId = &userId
+	details.RedeemedAt = &now
+
+	return details, nil
+}
+
+// VoidGift аннулирует неактивированный подарок с указанным ID, после чего его код больше не активируется.
+// Деньги за подарок при этом не возвращаются.
+func (storage Storage) VoidGift(ctx context.Context, id uint) *courseError.CourseError {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	gift := &dto.Gift{}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id).First(gift).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return courseError.CreateError(errGiftNotFound, 15022)
+		}
+		return courseError.CreateError(err, 10002)
+	}
+
+	if gift.RedeemedAt != nil {
+		tx.Rollback()
+		return courseError.CreateError(errGiftAlreadyRedeemed, 15024)
+	}
+
+	if gift.VoidedAt != nil {
+		tx.Rollback()
+		return courseError.CreateError(errGiftVoided, 15023)
+	}
+
+	if err := tx.Model(&dto.Gift{}).Where("id = ?", gift.ID).Update("voided_at", time.Now()).Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10003)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10010)
+	}
+
+	return nil
+}
+
+// GetUserGifts возвращает подарки, купленные текущим пользователем, начиная с последнего.
+func (storage Storage) GetUserGifts(ctx context.Context) ([]dto.GiftDetails, *courseError.CourseError) {
+	gifts := dto.CreateNewGiftsDetails()
+	if err := giftDetailsQuery(storage.db.WithContext(ctx)).
+		Where("gifts.buyer_id = ?", ctx.Value("UserId").(uint)).
+		Order("gifts.id DESC").Find(&gifts).Error; err != nil {
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return gifts, nil
+}
+
+// GetGifts возвращает подарки, начиная с последнего, и их общее количество. С onlyUnredeemed возвращаются только
+// неактивированные и неаннулированные подарки.
+func (storage Storage) GetGifts(ctx context.Context, onlyUnredeemed bool, limit, offset int) ([]dto.GiftDetails, int64, *courseError.CourseError) {
+	query := giftDetailsQuery(storage.db.WithContext(ctx))
+	if onlyUnredeemed {
+		query = query.Where("gifts.redeemed_at IS NULL AND gifts.voided_at IS NULL")
+	}
+
+	var totalCount int64
+	if err := query.Count(&totalCount).Error; err != nil {
+		return nil, 0, courseError.CreateError(err, 10002)
+	}
+
+	gifts := dto.CreateNewGiftsDetails()
+	if err := query.Order("gifts.id DESC").Offset(offset).Limit(limit).Find(&gifts).Error; err != nil {
+		return nil, 0, courseError.CreateError(err, 10002)
+	}
+
+	return gifts, totalCount, nil
+}
+
+// giftDetailsQuery собирает запрос подарков вместе с курсом, почтой покупателя и статусом оплаты заказа.
+func giftDetailsQuery(tx *gorm.DB) *gorm.DB {
+	return tx.Table("gifts").
+		Select(`gifts.id AS gift_id, gifts.code, orders.course_id, courses.name AS course_name, gifts.buyer_id,
+			credentials.email AS buyer_email, gifts.recipient_email, gifts.recipient_id, billings.status AS billing_status,
+			gifts.redeemed_at, gifts.voided_at, gifts.created_at`).
+		Joins("JOIN orders ON orders.id = gifts.order_id").
+		Joins("JOIN billings ON billings.order_id = orders.id").
+		Joins("JOIN courses ON courses.id = orders.course_id").
+		Joins("JOIN users ON users.id = gifts.buyer_id").
+		Joins("JOIN credentials ON credentials.id = users.credentials_id").
+		Where("gifts.deleted_at IS NULL")
+}
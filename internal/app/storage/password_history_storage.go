@@ -0,0 +1,65 @@
+package storage
+
+import (
+	"errors"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+	"gorm.io/gorm"
+)
+
+var (
+	errPasswordReused = errors.New("этот пароль уже использовался недавно, придумайте другой")
+)
+
+// checkPasswordReuse проверяет, что новый пароль не совпадает с текущим и с последними паролями владельца.
+// В истории хранятся прошлые хэши, поэтому вместе с текущим проверяется passwordHistorySize паролей.
+func (storage Storage) checkPasswordReuse(tx *gorm.DB, query string, ownerId uint, currentPassword, newPassword string) *courseError.CourseError {
+	if storage.passwordHistorySize <= 0 {
+		return nil
+	}
+
+	if storage.verifyPassword(currentPassword, newPassword) {
+		return courseError.CreateError(errPasswordReused, 11403)
+	}
+
+	history := dto.CreateNewPasswordHistories()
+	if err := tx.Where(query, ownerId).
+		Order("id DESC").
+		Limit(storage.passwordHistorySize - 1).
+		Find(&history).Error; err != nil {
+		return courseError.CreateError(err, 10002)
+	}
+
+	for _, v := range history {
+		if storage.verifyPassword(v.Password, newPassword) {
+			return courseError.CreateError(errPasswordReused, 11403)
+		}
+	}
+
+	return nil
+}
+
+// storePasswordHistory сохраняет прошлый хэш пароля в историю и удаляет записи, которые больше не участвуют в проверке.
+func (storage Storage) storePasswordHistory(tx *gorm.DB, history *dto.PasswordHistory, query string, ownerId uint) *courseError.CourseError {
+	if storage.passwordHistorySize <= 1 {
+		return nil
+	}
+
+	if err := tx.Create(history).Error; err != nil {
+		return courseError.CreateError(err, 10001)
+	}
+
+	if err := tx.Unscoped().
+		Where(query, ownerId).
+		Where("id NOT IN (?)", tx.Model(&dto.PasswordHistory{}).
+			Select("id").
+			Where(query, ownerId).
+			Order("id DESC").
+			Limit(storage.passwordHistorySize-1)).
+		Delete(&dto.PasswordHistory{}).Error; err != nil {
+		return courseError.CreateError(err, 10003)
+	}
+
+	return nil
+}
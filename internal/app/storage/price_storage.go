@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"errors"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+	"gorm.io/gorm"
+)
+
+var (
+	errPriceNotSet = errors.New("курс не продается в этой валюте")
+)
+
+// StoreCoursePrices перезаписывает прайс-лист курса в валютах, отличных от рубля. Уже оформленные заказы не меняются.
+func (storage Storage) StoreCoursePrices(ctx context.Context, courseId uint, prices []dto.CoursePrice) *courseError.CourseError {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	course := dto.Course{}
+	course.ID = courseId
+	if err := checkCoursesExist(tx, []dto.Course{course}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Unscoped().Where("course_id = ?", courseId).Delete(&dto.CoursePrice{}).Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10004)
+	}
+
+	if len(prices) != 0 {
+		if err := tx.Create(&prices).Error; err != nil {
+			tx.Rollback()
+			return courseError.CreateError(err, 10001)
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10010)
+	}
+
+	return nil
+}
+
+// GetCoursePrices возвращает цены курса во всех валютах, в которых он продается. Цена в рублях берется из курса
+// с учетом скидки и идет первой.
+func (storage Storage) GetCoursePrices(ctx context.Context, courseId uint) ([]dto.CoursePrice, *courseError.CourseError) {
+	course := dto.CreateNewCourse()
+	if err := storage.db.WithContext(ctx).Where("id = ?", courseId).First(&course).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, courseError.CreateError(errCourseNotExists, 13003)
+		}
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	prices := dto.CreateNewCoursePrices()
+	if err := storage.db.WithContext(ctx).Where("course_id = ?", courseId).Order("currency").Find(&prices).Error; err != nil {
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return append([]dto.CoursePrice{*dto.CreateNewCoursePrice(courseId, dto.CurrencyRUB, dto.ToMinorUnits(course.FinalCost()))}, prices...), nil
+}
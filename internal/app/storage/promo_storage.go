@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+	"github.com/knstch/course/internal/domain/entity"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errPromoCodeNotFound      = errors.New("промокод не найден")
+	errPromoCodeExpired       = errors.New("промокод не активен или истек")
+	errPromoCodeNotApplicable = errors.New("промокод не действует на этот курс или валюту")
+	errPromoCodeExhausted     = errors.New("лимит использований промокода исчерпан")
+	errPromoCodeFirstPurchase = errors.New("промокод действует только на первую покупку")
+	errPromoCodeExists        = errors.New("такой промокод уже существует")
+)
+
+// applyPromoCode проверяет промокод для заказа пользователя в валюте currency и возвращает его вместе со скидкой. Промокод
+// блокируется до конца транзакции, чтобы параллельные заказы не превысили лимиты. Использования в неудавшихся
+// заказах не учитываются. Промокод на первую покупку не действует, если у пользователя уже есть оплаченный
+// или ожидающий оплаты заказ, иначе скидку можно было бы получить на несколько неоплаченных заказов сразу.
+func applyPromoCode(tx *gorm.DB, code string, courseId, userId uint, currency string, price uint) (*dto.PromoCode, uint, *courseError.CourseError) {
+	promo := dto.PromoCode{}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("code = ?", code).First(&promo).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, 0, courseError.CreateError(errPromoCodeNotFound, 15010)
+		}
+		return nil, 0, courseError.CreateError(err, 10002)
+	}
+
+	if !promo.IsValidAt(time.Now()) {
+		return nil, 0, courseError.CreateError(errPromoCodeExpired, 15011)
+	}
+
+	if !promo.AppliesTo(courseId) || !promo.AppliesToCurrency(currency) {
+		return nil, 0, courseError.CreateError(errPromoCodeNotApplicable, 15012)
+	}
+
+	if promo.MaxRedemptions != 0 || promo.MaxPerUser != 0 {
+		var total, byUser int64
+		if err := redemptionsQuery(tx, promo.ID).Count(&total).Error; err != nil {
+			return nil, 0, courseError.CreateError(err, 10002)
+		}
+		if err := redemptionsQuery(tx, promo.ID).Where("promo_redemptions.user_id = ?", userId).Count(&byUser).Error; err != nil {
+			return nil, 0, courseError.CreateError(err, 10002)
+		}
+
+		if (promo.MaxRedemptions != 0 && uint(total) >= promo.MaxRedemptions) ||
+			(promo.MaxPerUser != 0 && uint(byUser) >= promo.MaxPerUser) {
+			return nil, 0, courseError.CreateError(errPromoCodeExhausted, 15013)
+		}
+	}
+
+	if promo.FirstPurchaseOnly {
+		var purchases int64
+		if err := tx.Model(&dto.Billing{}).
+			Joins("JOIN orders ON orders.id = billings.order_id").
+			Where("orders.user_id = ? AND billings.status <> ?", userId, dto.BillingStatusFailed).
+			Count(&purchases).Error; err != nil {
+			return nil, 0, courseError.CreateError(err, 10002)
+		}
+
+		if purchases != 0 {
+			return nil, 0, courseError.CreateError(errPromoCodeFirstPurchase, 15014)
+		}
+	}
+
+	return &promo, promo.Discount(price), nil
+}
+
+// redemptionsQuery возвращает запрос по использованиям промокода в заказах, которые не завершились неудачей.
+func redemptionsQuery(tx *gorm.DB, promoCodeId uint) *gorm.DB {
+	return tx.Model(&dto.PromoRedemption{}).
+		Joins("JOIN billings ON billings.order_id = promo_redemptions.order_id").
+		Where("promo_redemptions.promo_code_id = ? AND billings.status <> ?", promoCodeId, dto.BillingStatusFailed)
+}
+
+// StorePromoCode сохраняет новый промокод.
+func (storage Storage) StorePromoCode(ctx context.Context, promo *dto.PromoCode) *courseError.CourseError {
+	if err := storage.db.WithContext(ctx).Create(promo).Error; err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			return courseError.CreateError(errPromoCodeExists, 15015)
+		}
+		return courseError.CreateError(err, 10001)
+	}
+
+	return nil
+}
+
+// UpdatePromoCode перезаписывает условия промокода с указанным ID.
+func (storage Storage) UpdatePromoCode(ctx context.Context, promo *dto.PromoCode) *courseError.CourseError {
+	result := storage.db.WithContext(ctx).Model(&dto.PromoCode{}).Where("id = ?", promo.ID).
+		Select("code", "discount_type", "value", "course_id", "max_redemptions", "max_per_user",
+			"valid_from", "valid_until", "first_purchase_only", "active").
+		Updates(promo)
+	if err := result.Error; err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			return courseError.CreateError(errPromoCodeExists, 15015)
+		}
+		return courseError.CreateError(err, 10003)
+	}
+
+	if result.RowsAffected == 0 {
+		return courseError.CreateError(errPromoCodeNotFound, 15010)
+	}
+
+	return nil
+}
+
+// DeletePromoCode удаляет промокод. История его использований остается в статистике. Уникальный индекс кода
+// действует только на неудаленные промокоды, поэтому такой же код можно создать заново.
+func (storage Storage) DeletePromoCode(ctx context.Context, id uint) *courseError.CourseError {
+	result := storage.db.WithContext(ctx).Delete(&dto.PromoCode{}, id)
+	if err := result.Error; err != nil {
+		return courseError.CreateError(err, 10004)
+	}
+
+	if result.RowsAffected == 0 {
+		return courseError.CreateError(errPromoCodeNotFound, 15010)
+	}
+
+	return nil
+}
+
+// GetPromoCodes возвращает промокоды, начиная с последнего, статистику их использований и общее количество промокодов.
+func (storage Storage) GetPromoCodes(ctx context.Context, limit, offset int) ([]dto.PromoCode, []entity.PromoStats, int64, *courseError.CourseError) {
+	query := storage.db.WithContext(ctx).Model(&dto.PromoCode{})
+
+	var totalCount int64
+	if err := query.Count(&totalCount).Error; err != nil {
+		return nil, nil, 0, courseError.CreateError(err, 10002)
+	}
+
+	promos := dto.CreateNewPromoCodes()
+	if err := query.Order("id DESC").Offset(offset).Limit(limit).Find(&promos).Error; err != nil {
+		return nil, nil, 0, courseError.CreateError(err, 10002)
+	}
+
+	ids := make([]uint, 0, len(promos))
+	for _, v := range promos {
+		ids = append(ids, v.ID)
+	}
+
+	var stats []entity.PromoStats
+	if err := storage.db.WithContext(ctx).Model(&dto.PromoRedemption{}).
+		Select("promo_redemptions.promo_code_id AS promo_code_id, COUNT(*) AS redemptions, SUM(promo_redemptions.amount) AS discount").
+		Joins("JOIN billings ON billings.order_id = promo_redemptions.order_id").
+		Where("promo_redemptions.promo_code_id IN (?) AND billings.status <> ?", ids, dto.BillingStatusFailed).
+		Group("promo_redemptions.promo_code_id").
+		Scan(&stats).Error; err != nil {
+		return nil, nil, 0, courseError.CreateError(err, 10002)
+	}
+
+	return promos, stats, totalCount, nil
+}
@@ -0,0 +1,220 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+	"gorm.io/gorm"
+)
+
+var (
+	errReceiptNotFound      = errors.New("чек не найден или не был отклонен онлайн-кассой")
+	errUnknownReceiptSource = errors.New("неизвестный источник чека")
+)
+
+// receiptSource - это таблица с чеками одного источника. key - это колонка, по которой позиции собираются в один
+// чек, а lines - запрос позиций ожидающих отправки чеков с ключами из подзапроса keys.
+type receiptSource struct {
+	model interface{}
+	key   string
+	lines func(db *gorm.DB, keys *gorm.DB) *gorm.DB
+}
+
+// receiptSources сопоставляет источник чека с его таблицей. Чек за оплату курсов собирается из платежей
+// одного инвойса, а чеки за оплату подписки и за возврат - из одной строки.
+var receiptSources = map[string]receiptSource{
+	dto.ReceiptSourceBilling: {
+		model: &dto.Billing{},
+		key:   "invoice_id",
+		lines: func(db *gorm.DB, keys *gorm.DB) *gorm.DB {
+			return db.Table("billings").
+				Select(`billings.invoice_id AS source_id, billings.id AS billing_id, billings.invoice_id, orders."order",
+					courses.name AS course_name, billings.amount, credentials.email`).
+				Joins("JOIN orders ON orders.id = billings.order_id").
+				Joins("JOIN courses ON courses.id = orders.course_id").
+				Joins("JOIN users ON users.id = orders.user_id").
+				Joins("JOIN credentials ON credentials.id = users.credentials_id").
+				Where("billings.deleted_at IS NULL AND billings.receipt_status = ? AND billings.invoice_id IN (?)",
+					dto.ReceiptStatusPending, keys).
+				Order("billings.invoice_id, billings.id")
+		},
+	},
+	dto.ReceiptSourceSubscription: {
+		model: &dto.SubscriptionPayment{},
+		key:   "id",
+		lines: func(db *gorm.DB, keys *gorm.DB) *gorm.DB {
+			return db.Table("subscription_payments").
+				Select(`subscription_payments.id AS source_id, subscription_payments.invoice_id,
+					CONCAT('subscription-', subscription_payments.id) AS "order", subscription_plans.name AS course_name,
+					subscription_payments.amount, credentials.email`).
+				Joins("JOIN subscriptions ON subscriptions.id = subscription_payments.subscription_id").
+				Joins("JOIN subscription_plans ON subscription_plans.id = subscriptions.plan_id").
+				Joins("JOIN users ON users.id = subscriptions.user_id").
+				Joins("JOIN credentials ON credentials.id = users.credentials_id").
+				Where("subscription_payments.deleted_at IS NULL AND subscription_payments.receipt_status = ? AND subscription_payments.id IN (?)",
+					dto.ReceiptStatusPending, keys).
+				Order("subscription_payments.id")
+		},
+	},
+	dto.ReceiptSourceRefund: {
+		model: &dto.Refund{},
+		key:   "id",
+		lines: func(db *gorm.DB, keys *gorm.DB) *gorm.DB {
+			return db.Table("refunds").
+				Select(`refunds.id AS source_id, refunds.billing_id, billings.invoice_id,
+					CONCAT(orders."order", '-refund-', refunds.id) AS "order", courses.name AS course_name,
+					refunds.amount, credentials.email`).
+				Joins("JOIN billings ON billings.id = refunds.billing_id").
+				Joins("JOIN orders ON orders.id = billings.order_id").
+				Joins("JOIN courses ON courses.id = orders.course_id").
+				Joins("JOIN users ON users.id = orders.user_id").
+				Joins("JOIN credentials ON credentials.id = users.credentials_id").
+				Where("refunds.deleted_at IS NULL AND refunds.receipt_status = ? AND refunds.id IN (?)",
+					dto.ReceiptStatusPending, keys).
+				Order("refunds.id")
+		},
+	},
+}
+
+// getReceiptSource возвращает таблицу источника чека или ошибку, если источник неизвестен.
+func getReceiptSource(source string) (*receiptSource, *courseError.CourseError) {
+	receiptSource, ok := receiptSources[source]
+	if !ok {
+		return nil, courseError.CreateError(fmt.Errorf("%w: %v", errUnknownReceiptSource, source), 10002)
+	}
+
+	return &receiptSource, nil
+}
+
+// GetPendingReceipts возвращает позиции ожидающих отправки чеков источника source за limit чеков с ключом
+// больше afterId. Позиции упорядочены по ключу чека, чтобы позиции одного чека шли подряд.
+func (storage Storage) GetPendingReceipts(ctx context.Context, source string, afterId uint, limit int) ([]dto.ReceiptLine, *courseError.CourseError) {
+	receiptSource, err := getReceiptSource(source)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := storage.db.WithContext(ctx).Model(receiptSource.model).
+		Distinct(receiptSource.key).
+		Where(fmt.Sprintf("receipt_status = ? AND %s > ?", receiptSource.key), dto.ReceiptStatusPending, afterId).
+		Order(receiptSource.key).
+		Limit(limit)
+
+	var lines []dto.ReceiptLine
+	if err := receiptSource.lines(storage.db.WithContext(ctx), keys).Scan(&lines).Error; err != nil {
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return lines, nil
+}
+
+// SetReceiptSent отмечает чек источника source с ключом id отправленным в онлайн-кассу под ID receiptId.
+func (storage Storage) SetReceiptSent(ctx context.Context, source string, id uint, receiptId string) *courseError.CourseError {
+	return storage.updateReceipt(ctx, source, id, []string{dto.ReceiptStatusPending}, map[string]interface{}{
+		"receipt_status": dto.ReceiptStatusSent,
+		"receipt_id":     receiptId,
+		"receipt_error":  "",
+	})
+}
+
+// GetSentReceipts возвращает отправленные в онлайн-кассу чеки источника source за limit чеков с ключом больше afterId.
+func (storage Storage) GetSentReceipts(ctx context.Context, source string, afterId uint, limit int) ([]dto.SentReceipt, *courseError.CourseError) {
+	receiptSource, err := getReceiptSource(source)
+	if err != nil {
+		return nil, err
+	}
+
+	var receipts []dto.SentReceipt
+	if err := storage.db.WithContext(ctx).Model(receiptSource.model).
+		Select(fmt.Sprintf("%s AS source_id, MIN(receipt_id) AS receipt_id", receiptSource.key)).
+		Where(fmt.Sprintf("receipt_status = ? AND %s > ?", receiptSource.key), dto.ReceiptStatusSent, afterId).
+		Group(receiptSource.key).
+		Order(receiptSource.key).
+		Limit(limit).
+		Scan(&receipts).Error; err != nil {
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return receipts, nil
+}
+
+// SaveFiscalData отмечает чек источника source с ключом id зарегистрированным и сохраняет его фискальные данные.
+func (storage Storage) SaveFiscalData(ctx context.Context, source string, id uint, fiscalData *dto.FiscalData) *courseError.CourseError {
+	return storage.updateReceipt(ctx, source, id, []string{dto.ReceiptStatusSent}, map[string]interface{}{
+		"receipt_status":         dto.ReceiptStatusDone,
+		"fiscal_drive_number":    fiscalData.FiscalDriveNumber,
+		"fiscal_document_number": fiscalData.FiscalDocumentNumber,
+		"fiscal_sign":            fiscalData.FiscalSign,
+		"register_number":        fiscalData.RegisterNumber,
+		"fiscalized_at":          fiscalData.FiscalizedAt,
+		"receipt_url":            fiscalData.ReceiptUrl,
+	})
+}
+
+// FailReceipt отмечает чек источника source с ключом id отклоненным онлайн-кассой и сохраняет причину отказа.
+func (storage Storage) FailReceipt(ctx context.Context, source string, id uint, reason string) *courseError.CourseError {
+	return storage.updateReceipt(ctx, source, id, []string{dto.ReceiptStatusPending, dto.ReceiptStatusSent}, map[string]interface{}{
+		"receipt_status": dto.ReceiptStatusFailed,
+		"receipt_error":  reason,
+	})
+}
+
+// updateReceipt меняет чек источника source с ключом id, если он в одном из статусов statuses.
+func (storage Storage) updateReceipt(ctx context.Context, source string, id uint, statuses []string, updates map[string]interface{}) *courseError.CourseError {
+	receiptSource, err := getReceiptSource(source)
+	if err != nil {
+		return err
+	}
+
+	if err := storage.db.WithContext(ctx).Model(receiptSource.model).
+		Where(fmt.Sprintf("%s = ? AND receipt_status IN (?)", receiptSource.key), id, statuses).
+		Updates(updates).Error; err != nil {
+		return courseError.CreateError(err, 10003)
+	}
+
+	return nil
+}
+
+// ResendReceipt возвращает в очередь на отправку отклоненные чеки за заказ, в который входит платеж с ID billingId:
+// чек за оплату и чеки за возвраты по платежам заказа.
+func (storage Storage) ResendReceipt(ctx context.Context, billingId uint) *courseError.CourseError {
+	resend := map[string]interface{}{
+		"receipt_status": dto.ReceiptStatusPending,
+		"receipt_id":     "",
+	}
+
+	tx := storage.db.WithContext(ctx).Begin()
+
+	billings := tx.Model(&dto.Billing{}).
+		Where(sameOrderBillings, billingId).
+		Where("billings.receipt_status = ?", dto.ReceiptStatusFailed).
+		Updates(resend)
+	if billings.Error != nil {
+		tx.Rollback()
+		return courseError.CreateError(billings.Error, 10003)
+	}
+
+	refunds := tx.Model(&dto.Refund{}).
+		Where("billing_id IN (?)", tx.Model(&dto.Billing{}).Select("billings.id").Where(sameOrderBillings, billingId)).
+		Where("receipt_status = ?", dto.ReceiptStatusFailed).
+		Updates(resend)
+	if refunds.Error != nil {
+		tx.Rollback()
+		return courseError.CreateError(refunds.Error, 10003)
+	}
+
+	if billings.RowsAffected+refunds.RowsAffected == 0 {
+		tx.Rollback()
+		return courseError.CreateError(errReceiptNotFound, 15030)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10010)
+	}
+
+	return nil
+}
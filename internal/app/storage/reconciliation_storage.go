@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"time"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+)
+
+// GetUnpaidOrders возвращает ожидающие оплаты заказы с ID платежа больше afterId для сверки с банком.
+func (storage Storage) GetUnpaidOrders(ctx context.Context, afterId uint, limit int) ([]dto.PurchaseDetails, *courseError.CourseError) {
+	var orders []dto.PurchaseDetails
+	if err := storage.purchaseDetailsQuery(ctx).
+		Where("billings.status = ?", dto.BillingStatusPending).
+		Having("MIN(billings.id) > ?", afterId).
+		Order("billing_id").
+		Limit(limit).
+		Scan(&orders).Error; err != nil {
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return orders, nil
+}
+
+// GetPaidOrders возвращает оплаченные и возвращенные заказы, измененные после paidAfter, с ID платежа больше afterId
+// для сверки с банком.
+func (storage Storage) GetPaidOrders(ctx context.Context, paidAfter time.Time, afterId uint, limit int) ([]dto.PurchaseDetails, *courseError.CourseError) {
+	var orders []dto.PurchaseDetails
+	if err := storage.purchaseDetailsQuery(ctx).
+		Where("billings.status IN (?) AND billings.updated_at > ?",
+			[]string{dto.BillingStatusPaid, dto.BillingStatusPartiallyRefunded, dto.BillingStatusRefunded}, paidAfter).
+		Having("MIN(billings.id) > ?", afterId).
+		Order("billing_id").
+		Limit(limit).
+		Scan(&orders).Error; err != nil {
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return orders, nil
+}
+
+// GetFailedOrders возвращает неудавшиеся заказы с выставленным инвойсом, измененные после failedAfter, с ID платежа
+// больше afterId, чтобы найти заказы, оплаченные в банке после того, как они были отмечены неудавшимися.
+func (storage Storage) GetFailedOrders(ctx context.Context, failedAfter time.Time, afterId uint, limit int) ([]dto.PurchaseDetails, *courseError.CourseError) {
+	var orders []dto.PurchaseDetails
+	if err := storage.purchaseDetailsQuery(ctx).
+		Where("billings.status = ? AND billings.invoice_id <> ? AND billings.updated_at > ?", dto.BillingStatusFailed, 0, failedAfter).
+		Having("MIN(billings.id) > ?", afterId).
+		Order("billing_id").
+		Limit(limit).
+		Scan(&orders).Error; err != nil {
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return orders, nil
+}
+
+// SaveReconciliationReport сохраняет отчет о сверке вместе с найденными расхождениями.
+func (storage Storage) SaveReconciliationReport(ctx context.Context, report *dto.ReconciliationReport) *courseError.CourseError {
+	if err := storage.db.WithContext(ctx).Create(report).Error; err != nil {
+		return courseError.CreateError(err, 10001)
+	}
+
+	return nil
+}
+
+// GetReconciliationReports возвращает отчеты о сверке с расхождениями, начиная с последнего, и общее количество отчетов.
+func (storage Storage) GetReconciliationReports(ctx context.Context, limit, offset int) ([]dto.ReconciliationReport, int64, *courseError.CourseError) {
+	query := storage.db.WithContext(ctx).Model(&dto.ReconciliationReport{})
+
+	var totalCount int64
+	if err := query.Count(&totalCount).Error; err != nil {
+		return nil, 0, courseError.CreateError(err, 10002)
+	}
+
+	reports := dto.CreateNewReconciliationReports()
+	if err := query.Preload("Mismatches").Order("id DESC").Offset(offset).Limit(limit).Find(&reports).Error; err != nil {
+		return nil, 0, courseError.CreateError(err, 10002)
+	}
+
+	return reports, totalCount, nil
+}
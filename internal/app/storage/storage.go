@@ -3,6 +3,8 @@ package storage
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log"
 	"time"
 
 	"github.com/knstch/course/internal/app/config"
@@ -22,11 +24,70 @@ var (
 )
 
 type Storage struct {
-	db     *gorm.DB
-	secret string
+	db                  *gorm.DB
+	secret              string
+	passwordHistorySize int
+	revocation          revocationStore
+	totpSteps           totpStepStore
 }
 
-func NewStorage(dsn, secret string) (*Storage, error) {
+// revocationStore используется для добавления отключенных токенов в denylist.
+type revocationStore interface {
+	Revoke(tokens []dto.RevokedToken) *courseError.CourseError
+}
+
+// totpStepStore используется, чтобы один и тот же код из аутентификатора нельзя было принять дважды.
+type totpStepStore interface {
+	UseStep(userId uint, step uint64) (bool, *courseError.CourseError)
+}
+
+const (
+	revokeRetryBase = time.Second
+	revokeRetryMax  = time.Minute
+)
+
+// revokeCached добавляет отключенные токены в denylist после коммита. Отзыв уже сохранен в БД, которая остается
+// источником истины, поэтому ошибка Redis не возвращается пользователю: она логируется, а запись в denylist
+// повторяется в фоне, пока токены не истекут.
+func (storage Storage) revokeCached(revoked []dto.RevokedToken) {
+	if len(revoked) == 0 {
+		return
+	}
+
+	if err := storage.revocation.Revoke(revoked); err != nil {
+		log.Printf("не удалось добавить токены в denylist, запись будет повторена: %v", err.Error)
+		go storage.retryRevoke(revoked)
+	}
+}
+
+// retryRevoke повторяет запись токенов в denylist с растущей задержкой, пока она не пройдет
+// или пока не истечет срок жизни всех токенов.
+func (storage Storage) retryRevoke(revoked []dto.RevokedToken) {
+	var expiresAt time.Time
+	for _, v := range revoked {
+		if v.ExpiresAt.After(expiresAt) {
+			expiresAt = v.ExpiresAt
+		}
+	}
+
+	delay := revokeRetryBase
+	for time.Now().Add(delay).Before(expiresAt) {
+		time.Sleep(delay)
+
+		if err := storage.revocation.Revoke(revoked); err == nil {
+			return
+		}
+
+		delay *= 2
+		if delay > revokeRetryMax {
+			delay = revokeRetryMax
+		}
+	}
+
+	log.Printf("токены не добавлены в denylist до истечения срока жизни")
+}
+
+func NewStorage(dsn, secret string, passwordHistorySize int, revocation revocationStore, totpSteps totpStepStore) (*Storage, error) {
 	db, err := gorm.Open(postgres.Open(dsn), &gorm.Config{})
 	if err != nil {
 		return nil, err
@@ -41,12 +102,42 @@ func NewStorage(dsn, secret string) (*Storage, error) {
 	sqlDB.SetConnMaxIdleTime(time.Minute * 30)
 
 	return &Storage{
-		db:     db,
-		secret: secret,
+		db:                  db,
+		secret:              secret,
+		passwordHistorySize: passwordHistorySize,
+		revocation:          revocation,
+		totpSteps:           totpSteps,
 	}, nil
 }
 
+// floatAmountColumns - это колонки, которые хранили суммы в основных единицах валюты как float. До AutoMigrate
+// они переводятся в целые минимальные единицы, иначе AutoMigrate отбросил бы дробную часть.
+var floatAmountColumns = []struct {
+	table  string
+	column string
+}{
+	{"billings", "refunded_amount"},
+	{"refunds", "amount"},
+}
+
+// majorAmountColumns - это колонки с суммами в основных единицах валюты, которые заменены колонками to
+// с суммами в минимальных единицах.
+var majorAmountColumns = []struct {
+	model interface{}
+	from  string
+	to    string
+}{
+	{&dto.Billing{}, "price", "amount"},
+	{&dto.SubscriptionPayment{}, "price", "amount"},
+	{&dto.CoursePrice{}, "price", "amount"},
+	{&dto.PromoRedemption{}, "discount", "amount"},
+}
+
 func (storage Storage) Automigrate(config *config.Config) error {
+	if err := storage.convertFloatAmounts(); err != nil {
+		return err
+	}
+
 	if err := storage.db.AutoMigrate(
 		&dto.User{},
 		&dto.Credentials{},
@@ -55,15 +146,49 @@ func (storage Storage) Automigrate(config *config.Config) error {
 		&dto.Photo{},
 		&dto.Order{},
 		&dto.Course{},
+		&dto.CoursePrice{},
 		&dto.Lesson{},
 		&dto.Billing{},
 		&dto.Admin{},
 		&dto.AdminAccessToken{},
 		&dto.WatchHistory{},
+		&dto.RecoveryCode{},
+		&dto.ExternalIdentity{},
+		&dto.ApiKey{},
+		&dto.PasswordHistory{},
+		&dto.OutboxEmail{},
+		&dto.ReconciliationReport{},
+		&dto.ReconciliationMismatch{},
+		&dto.Refund{},
+		&dto.PromoCode{},
+		&dto.PromoRedemption{},
+		&dto.Bundle{},
+		&dto.Gift{},
+		&dto.SubscriptionPlan{},
+		&dto.Subscription{},
+		&dto.SubscriptionPayment{},
 	); err != nil {
 		return err
 	}
 
+	if err := storage.dropBillingPaidColumn(); err != nil {
+		return err
+	}
+
+	if err := storage.rebuildPromoCodeIndex(); err != nil {
+		return err
+	}
+
+	if err := storage.moveMajorAmounts(); err != nil {
+		return err
+	}
+
+	if err := storage.db.Model(&dto.OutboxEmail{}).
+		Where("status = ? AND (body <> '' OR html_body <> '')", dto.OutboxEmailSent).
+		Updates(map[string]interface{}{"body": "", "html_body": ""}).Error; err != nil {
+		return err
+	}
+
 	if config.SuperAdminLogin != "" && config.SuperAdminPassword != "" {
 		hashedPassword, err := bcrypt.GenerateFromPassword([]byte(config.SuperAdminPassword+storage.secret), bcrypt.DefaultCost)
 		if err != nil {
@@ -87,7 +212,96 @@ func (storage Storage) Automigrate(config *config.Config) error {
 	return nil
 }
 
-func (storage Storage) VerifyEmail(ctx context.Context, userId uint, isEdit bool) *courseError.CourseError {
+// convertFloatAmounts переводит суммы из колонок floatAmountColumns в минимальные единицы валюты и меняет тип
+// колонок на целый. Уже переведенные колонки и отсутствующие таблицы пропускаются.
+func (storage Storage) convertFloatAmounts() error {
+	for _, v := range floatAmountColumns {
+		var dataType string
+		if err := storage.db.Raw(`SELECT data_type FROM information_schema.columns
+			WHERE table_schema = CURRENT_SCHEMA() AND table_name = ? AND column_name = ?`, v.table, v.column).
+			Scan(&dataType).Error; err != nil {
+			return err
+		}
+
+		if dataType != "double precision" {
+			continue
+		}
+
+		if err := storage.db.Exec(fmt.Sprintf("ALTER TABLE %s ALTER COLUMN %s TYPE bigint USING ROUND(%s * %d)",
+			v.table, v.column, v.column, dto.CurrencyMinorUnits)).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// dropBillingPaidColumn удаляет колонку billings.paid, которая дублировала статус платежа. Перед удалением
+// оплаченные платежи со статусом pending, оставшиеся с времен до статусов, переводятся в статус paid.
+func (storage Storage) dropBillingPaidColumn() error {
+	if !storage.db.Migrator().HasColumn(&dto.Billing{}, "paid") {
+		return nil
+	}
+
+	return storage.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&dto.Billing{}).Unscoped().
+			Where("paid = ? AND status = ?", true, dto.BillingStatusPending).
+			Update("status", dto.BillingStatusPaid).Error; err != nil {
+			return err
+		}
+
+		return tx.Migrator().DropColumn(&dto.Billing{}, "paid")
+	})
+}
+
+// rebuildPromoCodeIndex пересоздает уникальный индекс кода промокода как частичный, только по неудаленным промокодам,
+// если в БД он остался без условия. AutoMigrate не меняет существующий индекс, а без условия код удаленного
+// промокода нельзя создать заново.
+func (storage Storage) rebuildPromoCodeIndex() error {
+	var plain int64
+	if err := storage.db.Raw(`SELECT COUNT(*) FROM pg_indexes WHERE tablename = ? AND indexname = ? AND indexdef NOT ILIKE ?`,
+		"promo_codes", "idx_promo_codes_code", "%WHERE%").Scan(&plain).Error; err != nil {
+		return err
+	}
+
+	if plain == 0 {
+		return nil
+	}
+
+	return storage.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Migrator().DropIndex(&dto.PromoCode{}, "idx_promo_codes_code"); err != nil {
+			return err
+		}
+
+		return tx.Migrator().CreateIndex(&dto.PromoCode{}, "idx_promo_codes_code")
+	})
+}
+
+// moveMajorAmounts переносит суммы из колонок majorAmountColumns в колонки с минимальными единицами валюты
+// и удаляет старые колонки.
+func (storage Storage) moveMajorAmounts() error {
+	for _, v := range majorAmountColumns {
+		if !storage.db.Migrator().HasColumn(v.model, v.from) {
+			continue
+		}
+
+		if err := storage.db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Model(v.model).Unscoped().
+				Where(fmt.Sprintf("%s IS NOT NULL", v.from)).
+				Update(v.to, gorm.Expr(fmt.Sprintf("ROUND(%s * ?)", v.from), dto.CurrencyMinorUnits)).Error; err != nil {
+				return err
+			}
+
+			return tx.Migrator().DropColumn(v.model, v.from)
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (storage Storage) VerifyEmail(ctx context.Context, userId uint, isEdit bool, welcomeEmail *dto.OutboxEmail) *courseError.CourseError {
 	tx := storage.db.WithContext(ctx).Begin()
 
 	if isEdit {
@@ -113,13 +327,21 @@ func (storage Storage) VerifyEmail(ctx context.Context, userId uint, isEdit bool
 		}
 	}
 
-	if err := tx.Exec(`UPDATE "credentials" SET "verified" = ?
+	result := tx.Exec(`UPDATE "credentials" SET "verified" = ?
 		WHERE credentials.id = (SELECT credentials_id 
-		FROM "users" WHERE id = ?) AND verified = ?`, true, userId, false).Error; err != nil {
+		FROM "users" WHERE id = ?) AND verified = ?`, true, userId, false)
+	if err := result.Error; err != nil {
 		tx.Rollback()
 		return courseError.CreateError(err, 11002)
 	}
 
+	if result.RowsAffected != 0 {
+		if err := enqueueEmail(tx, welcomeEmail); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		tx.Rollback()
 		return courseError.CreateError(err, 10010)
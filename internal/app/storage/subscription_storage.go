@@ -0,0 +1,489 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	errSubscriptionExists       = errors.New("подписка уже оформлена")
+	errSubscriptionPlanNotFound = errors.New("план подписки не найден")
+	errSubscriptionNotFound     = errors.New("нет активной подписки")
+	errSubscriptionPlanExists   = errors.New("план подписки с таким названием уже существует")
+)
+
+// StoreSubscriptionPlan сохраняет план подписки вместе с его курсами.
+func (storage Storage) StoreSubscriptionPlan(ctx context.Context, plan *dto.SubscriptionPlan) *courseError.CourseError {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	if err := checkCoursesExist(tx, plan.Courses); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := tx.Omit("Courses.*").Create(plan).Error; err != nil {
+		tx.Rollback()
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			return courseError.CreateError(errSubscriptionPlanExists, 15019)
+		}
+		return courseError.CreateError(err, 10001)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10010)
+	}
+
+	return nil
+}
+
+// UpdateSubscriptionPlan перезаписывает условия и курсы плана подписки с указанным ID. Оформленные подписки
+// продлеваются по новым условиям.
+func (storage Storage) UpdateSubscriptionPlan(ctx context.Context, plan *dto.SubscriptionPlan) *courseError.CourseError {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	if err := checkCoursesExist(tx, plan.Courses); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	result := tx.Model(&dto.SubscriptionPlan{}).Where("id = ?", plan.ID).
+		Select("name", "period", "price", "all_courses", "active").
+		Updates(plan)
+	if err := result.Error; err != nil {
+		tx.Rollback()
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			return courseError.CreateError(errSubscriptionPlanExists, 15019)
+		}
+		return courseError.CreateError(err, 10003)
+	}
+
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return courseError.CreateError(errSubscriptionPlanNotFound, 15017)
+	}
+
+	if err := tx.Omit("Courses.*").Model(plan).Association("Courses").Replace(plan.Courses); err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10003)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10010)
+	}
+
+	return nil
+}
+
+// checkCoursesExist проверяет, что все курсы плана существуют.
+func checkCoursesExist(tx *gorm.DB, courses []dto.Course) *courseError.CourseError {
+	if len(courses) == 0 {
+		return nil
+	}
+
+	ids := make([]uint, 0, len(courses))
+	for _, v := range courses {
+		ids = append(ids, v.ID)
+	}
+
+	var count int64
+	if err := tx.Model(&dto.Course{}).Where("id IN (?)", ids).Count(&count).Error; err != nil {
+		return courseError.CreateError(err, 10002)
+	}
+
+	if int(count) != len(ids) {
+		return courseError.CreateError(errCourseNotExists, 13003)
+	}
+
+	return nil
+}
+
+// GetSubscriptionPlans возвращает планы подписки вместе с их курсами, начиная с самого дешевого. С onlyActive
+// возвращаются только планы, на которые можно подписаться.
+func (storage Storage) GetSubscriptionPlans(ctx context.Context, onlyActive bool) ([]dto.SubscriptionPlan, *courseError.CourseError) {
+	query := storage.db.WithContext(ctx).Preload("Courses")
+	if onlyActive {
+		query = query.Where("active = ?", true)
+	}
+
+	plans := dto.CreateNewSubscriptionPlans()
+	if err := query.Order("price, id").Find(&plans).Error; err != nil {
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return plans, nil
+}
+
+// CreateSubscription оформляет пользователю подписку на план, ожидающую первой оплаты, и создает платеж за первый
+// период. Прошлые неоплаченные подписки пользователя закрываются. Возвращает данные для инвойса или ошибку.
+func (storage Storage) CreateSubscription(ctx context.Context, planId uint, ruCard bool) (*dto.SubscriptionCharge, *courseError.CourseError) {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	userId := ctx.Value("UserId").(uint)
+
+	plan := dto.SubscriptionPlan{}
+	if err := tx.Where("id = ? AND active = ?", planId, true).First(&plan).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, courseError.CreateError(errSubscriptionPlanNotFound, 15017)
+		}
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	var existing int64
+	if err := tx.Model(&dto.Subscription{}).
+		Where("user_id = ? AND status IN (?)", userId, []string{dto.SubscriptionStatusActive, dto.SubscriptionStatusPastDue}).
+		Count(&existing).Error; err != nil {
+		tx.Rollback()
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	if existing != 0 {
+		tx.Rollback()
+		return nil, courseError.CreateError(errSubscriptionExists, 15016)
+	}
+
+	if err := tx.Model(&dto.Subscription{}).
+		Where("user_id = ? AND status = ?", userId, dto.SubscriptionStatusPending).
+		Update("status", dto.SubscriptionStatusExpired).Error; err != nil {
+		tx.Rollback()
+		return nil, courseError.CreateError(err, 10003)
+	}
+
+	subscription := dto.CreateNewSubscription(userId, plan.ID, ruCard)
+	if err := tx.Create(subscription).Error; err != nil {
+		tx.Rollback()
+		return nil, courseError.CreateError(err, 10001)
+	}
+
+	payment := dto.CreateNewSubscriptionPayment(subscription.ID, dto.ToMinorUnits(plan.Price))
+	if err := tx.Create(payment).Error; err != nil {
+		tx.Rollback()
+		return nil, courseError.CreateError(err, 10001)
+	}
+
+	credentials := dto.CreateNewCredentials()
+	if err := tx.Joins("JOIN users ON users.id = ?", userId).
+		Where("credentials.id = users.credentials_id").First(&credentials).Error; err != nil {
+		tx.Rollback()
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return nil, courseError.CreateError(err, 10010)
+	}
+
+	return &dto.SubscriptionCharge{
+		SubscriptionId: subscription.ID,
+		PaymentId:      payment.ID,
+		UserId:         userId,
+		PlanId:         plan.ID,
+		PlanName:       plan.Name,
+		Amount:         dto.ToMinorUnits(plan.Price),
+		RusCard:        ruCard,
+		Email:          credentials.Email,
+	}, nil
+}
+
+// SetSubscriptionInvoiceId привязывает инвойс банка к платежу за подписку.
+func (storage Storage) SetSubscriptionInvoiceId(ctx context.Context, invoiceId, paymentId uint) *courseError.CourseError {
+	if err := storage.db.WithContext(ctx).Model(&dto.SubscriptionPayment{}).
+		Where("id = ?", paymentId).Update("invoice_id", invoiceId).Error; err != nil {
+		return courseError.CreateError(err, 10003)
+	}
+
+	return nil
+}
+
+// GetSubscriptionPayment возвращает платеж за подписку вместе с подпиской по ID инвойса или ошибку 15001,
+// если такого платежа нет.
+func (storage Storage) GetSubscriptionPayment(ctx context.Context, invoiceId uint) (*dto.SubscriptionPayment, *courseError.CourseError) {
+	payment := dto.SubscriptionPayment{}
+	if err := storage.db.WithContext(ctx).Preload("Subscription").Where("invoice_id = ?", invoiceId).First(&payment).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, courseError.CreateError(errInvoiceNotFound, 15001)
+		}
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return &payment, nil
+}
+
+// PaySubscription отмечает платеж за подписку оплаченным и продлевает подписку на период плана. Продленный
+// период начинается с конца прошлого, а у новой или закрытой подписки - с момента оплаты. Если банк передал
+// привязку карты, она сохраняется для следующих списаний. За оплату в очередь ставится чек "приход". Повторная
+// оплата того же инвойса ничего не меняет.
+func (storage Storage) PaySubscription(ctx context.Context, invoiceId uint, bindingId string) *courseError.CourseError {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	payment := dto.SubscriptionPayment{}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("invoice_id = ?", invoiceId).First(&payment).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return courseError.CreateError(errInvoiceNotFound, 15001)
+		}
+		return courseError.CreateError(err, 10002)
+	}
+
+	if payment.Status != dto.BillingStatusPending {
+		tx.Rollback()
+		return nil
+	}
+
+	subscription := dto.Subscription{}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Plan").
+		Where("id = ?", payment.SubscriptionId).First(&subscription).Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10002)
+	}
+
+	start := time.Now()
+	if subscription.CurrentPeriodEnd != nil &&
+		(subscription.Status == dto.SubscriptionStatusActive || subscription.Status == dto.SubscriptionStatusPastDue) {
+		start = *subscription.CurrentPeriodEnd
+	}
+	end := subscription.Plan.PeriodEnd(start)
+
+	if err := tx.Model(&payment).Updates(map[string]interface{}{
+		"status":         dto.BillingStatusPaid,
+		"period_start":   start,
+		"period_end":     end,
+		"receipt_status": dto.ReceiptStatusPending,
+	}).Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10003)
+	}
+
+	updates := map[string]interface{}{
+		"status":               dto.SubscriptionStatusActive,
+		"current_period_start": start,
+		"current_period_end":   end,
+		"grace_until":          nil,
+	}
+	if bindingId != "" {
+		updates["binding_id"] = bindingId
+	}
+
+	if err := tx.Model(&subscription).Updates(updates).Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10003)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10010)
+	}
+
+	return nil
+}
+
+// FailSubscriptionPayment отмечает платеж за подписку неудавшимся. Если не прошла первая оплата, подписка
+// закрывается. Если не прошло продление, подписка переходит в past_due и открывает курсы еще gracePeriod после
+// конца оплаченного периода. Повторный отказ по тому же инвойсу ничего не меняет.
+func (storage Storage) FailSubscriptionPayment(ctx context.Context, invoiceId uint, gracePeriod time.Duration) *courseError.CourseError {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	payment := dto.SubscriptionPayment{}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("invoice_id = ?", invoiceId).First(&payment).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return courseError.CreateError(errInvoiceNotFound, 15001)
+		}
+		return courseError.CreateError(err, 10002)
+	}
+
+	if payment.Status != dto.BillingStatusPending {
+		tx.Rollback()
+		return nil
+	}
+
+	if err := tx.Model(&payment).Update("status", dto.BillingStatusFailed).Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10003)
+	}
+
+	subscription := dto.Subscription{}
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", payment.SubscriptionId).First(&subscription).Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10002)
+	}
+
+	var updates map[string]interface{}
+	switch subscription.Status {
+	case dto.SubscriptionStatusPending:
+		updates = map[string]interface{}{"status": dto.SubscriptionStatusExpired}
+	case dto.SubscriptionStatusActive:
+		updates = map[string]interface{}{
+			"status":      dto.SubscriptionStatusPastDue,
+			"grace_until": subscription.CurrentPeriodEnd.Add(gracePeriod),
+		}
+	}
+
+	if updates != nil {
+		if err := tx.Model(&subscription).Updates(updates).Error; err != nil {
+			tx.Rollback()
+			return courseError.CreateError(err, 10003)
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10010)
+	}
+
+	return nil
+}
+
+// GetUserSubscription возвращает последнюю оплаченную когда-либо подписку пользователя вместе с планом
+// или ошибку 15018, если подписок нет.
+func (storage Storage) GetUserSubscription(ctx context.Context) (*dto.Subscription, *courseError.CourseError) {
+	userId := ctx.Value("UserId").(uint)
+
+	subscription := dto.Subscription{}
+	if err := storage.db.WithContext(ctx).Preload("Plan").Preload("Plan.Courses").
+		Where("user_id = ? AND current_period_end IS NOT NULL", userId).
+		Order("id DESC").First(&subscription).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, courseError.CreateError(errSubscriptionNotFound, 15018)
+		}
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return &subscription, nil
+}
+
+// CancelSubscription отменяет продление подписки пользователя. Курсы остаются открыты до конца оплаченного периода.
+func (storage Storage) CancelSubscription(ctx context.Context) *courseError.CourseError {
+	userId := ctx.Value("UserId").(uint)
+
+	result := storage.db.WithContext(ctx).Model(&dto.Subscription{}).
+		Where("user_id = ? AND status IN (?)", userId, []string{dto.SubscriptionStatusActive, dto.SubscriptionStatusPastDue}).
+		Update("cancel_at_period_end", true)
+	if err := result.Error; err != nil {
+		return courseError.CreateError(err, 10003)
+	}
+
+	if result.RowsAffected == 0 {
+		return courseError.CreateError(errSubscriptionNotFound, 15018)
+	}
+
+	return nil
+}
+
+// HasSubscriptionAccess проверяет, открывает ли подписка пользователя курс с ID courseId.
+func (storage Storage) HasSubscriptionAccess(ctx context.Context, courseId uint) (bool, *courseError.CourseError) {
+	userId := ctx.Value("UserId").(uint)
+	now := time.Now()
+
+	var count int64
+	if err := storage.db.WithContext(ctx).Model(&dto.Subscription{}).
+		Joins("JOIN subscription_plans ON subscription_plans.id = subscriptions.plan_id").
+		Where(`subscriptions.user_id = ? AND (
+			(subscriptions.status = ? AND subscriptions.current_period_end > ?) OR
+			(subscriptions.status = ? AND subscriptions.grace_until > ?))`,
+			userId, dto.SubscriptionStatusActive, now, dto.SubscriptionStatusPastDue, now).
+		Where(`(subscription_plans.all_courses AND EXISTS (
+				SELECT 1 FROM courses WHERE courses.id = ? AND courses.hidden = false AND courses.deleted_at IS NULL)) OR
+			EXISTS (SELECT 1 FROM subscription_plan_courses
+				WHERE subscription_plan_courses.subscription_plan_id = subscription_plans.id AND subscription_plan_courses.course_id = ?)`,
+			courseId, courseId).
+		Count(&count).Error; err != nil {
+		return false, courseError.CreateError(err, 10002)
+	}
+
+	return count != 0, nil
+}
+
+// ClaimSubscriptionsToRenew забирает до limit подписок, оплаченный период которых заканчивается до renewBefore,
+// с привязанной картой и без отмены продления, и создает по каждой ожидающий платеж за следующий период.
+// Подписки блокируются с SKIP LOCKED, а платеж создается в той же транзакции, поэтому несколько экземпляров
+// сервиса не спишут деньги за одну подписку дважды. Подписки, по которым уже пытались списать деньги после
+// retryAfter или есть платеж, ожидающий ответа банка, пропускаются.
+func (storage Storage) ClaimSubscriptionsToRenew(ctx context.Context, renewBefore, retryAfter time.Time, limit int) ([]dto.SubscriptionCharge, *courseError.CourseError) {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	var charges []dto.SubscriptionCharge
+	if err := tx.Table("subscriptions").
+		Clauses(clause.Locking{Strength: "UPDATE", Table: clause.Table{Name: "subscriptions"}, Options: "SKIP LOCKED"}).
+		Select(`subscriptions.id AS subscription_id, subscriptions.user_id, subscriptions.plan_id,
+			subscription_plans.name AS plan_name, subscription_plans.price * ? AS amount, subscriptions.binding_id, subscriptions.rus_card,
+			credentials.email, subscriptions.current_period_end`, dto.CurrencyMinorUnits).
+		Joins("JOIN subscription_plans ON subscription_plans.id = subscriptions.plan_id").
+		Joins("JOIN users ON users.id = subscriptions.user_id").
+		Joins("JOIN credentials ON credentials.id = users.credentials_id").
+		Where(`subscriptions.deleted_at IS NULL AND subscriptions.status IN (?) AND subscriptions.cancel_at_period_end = false
+			AND subscriptions.binding_id <> '' AND subscriptions.current_period_end <= ?`,
+			[]string{dto.SubscriptionStatusActive, dto.SubscriptionStatusPastDue}, renewBefore).
+		Where(`NOT EXISTS (SELECT 1 FROM subscription_payments
+			WHERE subscription_payments.subscription_id = subscriptions.id AND subscription_payments.deleted_at IS NULL
+			AND (subscription_payments.created_at > ? OR (subscription_payments.status = ? AND subscription_payments.invoice_id <> 0)))`,
+			retryAfter, dto.BillingStatusPending).
+		Order("subscriptions.id").
+		Limit(limit).
+		Scan(&charges).Error; err != nil {
+		tx.Rollback()
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	for i := range charges {
+		payment := dto.CreateNewSubscriptionPayment(charges[i].SubscriptionId, charges[i].Amount)
+		if err := tx.Create(payment).Error; err != nil {
+			tx.Rollback()
+			return nil, courseError.CreateError(err, 10001)
+		}
+		charges[i].PaymentId = payment.ID
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return nil, courseError.CreateError(err, 10010)
+	}
+
+	return charges, nil
+}
+
+// GetPendingRenewals возвращает платежи за продление подписок с ID больше afterId, по которым выставлен инвойс,
+// но банк еще не ответил на списание.
+func (storage Storage) GetPendingRenewals(ctx context.Context, afterId uint, limit int) ([]dto.SubscriptionPayment, *courseError.CourseError) {
+	var payments []dto.SubscriptionPayment
+	if err := storage.db.WithContext(ctx).
+		Joins("JOIN subscriptions ON subscriptions.id = subscription_payments.subscription_id").
+		Where("subscription_payments.status = ? AND subscription_payments.invoice_id <> 0 AND subscriptions.status <> ? AND subscription_payments.id > ?",
+			dto.BillingStatusPending, dto.SubscriptionStatusPending, afterId).
+		Order("subscription_payments.id").
+		Limit(limit).
+		Find(&payments).Error; err != nil {
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return payments, nil
+}
+
+// ExpireSubscriptions закрывает подписки, которые больше не продлеваются: отмененные или без привязанной карты после
+// конца оплаченного периода, неоплаченные после конца льготного периода и подписки, первая оплата которых не пришла
+// до pendingBefore.
+// Возвращает количество закрытых подписок.
+func (storage Storage) ExpireSubscriptions(ctx context.Context, now, pendingBefore time.Time) (int64, *courseError.CourseError) {
+	result := storage.db.WithContext(ctx).Model(&dto.Subscription{}).
+		Where(`(status = ? AND (cancel_at_period_end OR binding_id = '') AND current_period_end <= ?) OR
+			(status = ? AND (grace_until <= ? OR (cancel_at_period_end AND current_period_end <= ?))) OR
+			(status = ? AND created_at < ?)`,
+			dto.SubscriptionStatusActive, now,
+			dto.SubscriptionStatusPastDue, now, now,
+			dto.SubscriptionStatusPending, pendingBefore).
+		Update("status", dto.SubscriptionStatusExpired)
+	if err := result.Error; err != nil {
+		return 0, courseError.CreateError(err, 10003)
+	}
+
+	return result.RowsAffected, nil
+}
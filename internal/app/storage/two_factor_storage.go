@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+	"github.com/pquerna/otp"
+	"github.com/pquerna/otp/totp"
+	"gorm.io/gorm"
+)
+
+// totpPeriod и totpSkew повторяют настройки totp.Validate: код меняется раз в 30 секунд
+// и принимается еще на один шаг раньше и позже текущего.
+const (
+	totpPeriod = 30
+	totpSkew   = 1
+)
+
+var (
+	errTwoStepAuthEnabled    = errors.New("двухфакторная аутентификация уже включена")
+	errTwoStepAuthNotEnabled = errors.New("аутентификатор не привязан")
+	errBadTwoStepAuthCode    = errors.New("неверный код двухфакторной аутентификации")
+)
+
+func (storage Storage) SetUserTotpKey(ctx context.Context, userId uint, key string) *courseError.CourseError {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	user := dto.CreateNewUser()
+	if err := tx.Where("id = ?", userId).First(&user).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return courseError.CreateError(errUserNotFound, 11101)
+		}
+		return courseError.CreateError(err, 10002)
+	}
+
+	if user.TwoStepsAuthEnabled {
+		tx.Rollback()
+		return courseError.CreateError(errTwoStepAuthEnabled, 11014)
+	}
+
+	if err := tx.Model(&dto.User{}).Where("id = ?", userId).Update("totp_key", key).Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10003)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10010)
+	}
+
+	return nil
+}
+
+func (storage Storage) EnableUserTwoStepAuth(ctx context.Context, userId uint, code string, recoveryCodes []string) *courseError.CourseError {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	user := dto.CreateNewUser()
+	if err := tx.Where("id = ?", userId).First(&user).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return courseError.CreateError(errUserNotFound, 11101)
+		}
+		return courseError.CreateError(err, 10002)
+	}
+
+	if user.TwoStepsAuthEnabled {
+		tx.Rollback()
+		return courseError.CreateError(errTwoStepAuthEnabled, 11014)
+	}
+
+	if user.TotpKey == "" {
+		tx.Rollback()
+		return courseError.CreateError(errTwoStepAuthNotEnabled, 11015)
+	}
+
+	valid, err := storage.useTotpCode(userId, code, user.TotpKey)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if !valid {
+		tx.Rollback()
+		return courseError.CreateError(errBadTwoStepAuthCode, 11016)
+	}
+
+	if err := tx.Model(&dto.User{}).Where("id = ?", userId).Update("two_steps_auth_enabled", true).Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10003)
+	}
+
+	if err := tx.Where("user_id = ?", userId).Delete(&dto.RecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10004)
+	}
+
+	codes := make([]dto.RecoveryCode, 0, len(recoveryCodes))
+	for _, v := range recoveryCodes {
+		codes = append(codes, *dto.CreateNewRecoveryCode(userId, v))
+	}
+
+	if err := tx.Create(&codes).Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10001)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10010)
+	}
+
+	return nil
+}
+
+func (storage Storage) IsTwoStepAuthEnabled(ctx context.Context, userId uint) (*bool, *courseError.CourseError) {
+	user := dto.CreateNewUser()
+	if err := storage.db.WithContext(ctx).Where("id = ?", userId).First(&user).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, courseError.CreateError(errUserNotFound, 11101)
+		}
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	return &user.TwoStepsAuthEnabled, nil
+}
+
+// CheckTwoStepAuthCode проверяет код из аутентификатора, а если он не подошел или уже был использован, то ищет
+// неиспользованный код восстановления по хэшу и помечает его использованным.
+func (storage Storage) CheckTwoStepAuthCode(ctx context.Context, userId uint, code, hashedCode string) *courseError.CourseError {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	user := dto.CreateNewUser()
+	if err := tx.Where("id = ?", userId).First(&user).Error; err != nil {
+		tx.Rollback()
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return courseError.CreateError(errUserNotFound, 11101)
+		}
+		return courseError.CreateError(err, 10002)
+	}
+
+	if !user.TwoStepsAuthEnabled {
+		tx.Rollback()
+		return courseError.CreateError(errTwoStepAuthNotEnabled, 11015)
+	}
+
+	valid, err := storage.useTotpCode(userId, code, user.TotpKey)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if valid {
+		tx.Rollback()
+		return nil
+	}
+
+	result := tx.Model(&dto.RecoveryCode{}).
+		Where("user_id = ? AND code = ? AND used = ?", userId, hashedCode, false).
+		Update("used", true)
+	if result.Error != nil {
+		tx.Rollback()
+		return courseError.CreateError(result.Error, 10003)
+	}
+
+	if result.RowsAffected == 0 {
+		tx.Rollback()
+		return courseError.CreateError(errBadTwoStepAuthCode, 11016)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10010)
+	}
+
+	return nil
+}
+
+func (storage Storage) DisableUserTwoStepAuth(ctx context.Context, userId uint) *courseError.CourseError {
+	tx := storage.db.WithContext(ctx).Begin()
+
+	if err := tx.Model(&dto.User{}).Where("id = ?", userId).Updates(map[string]interface{}{
+		"two_steps_auth_enabled": false,
+		"totp_key":               "",
+	}).Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10003)
+	}
+
+	if err := tx.Where("user_id = ?", userId).Delete(&dto.RecoveryCode{}).Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10004)
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		tx.Rollback()
+		return courseError.CreateError(err, 10010)
+	}
+
+	return nil
+}
+
+// useTotpCode проверяет код из аутентификатора и запоминает его шаг времени. Код, шаг которого не позже уже
+// принятого, считается неверным, поэтому перехваченный код нельзя использовать повторно. Возвращает результат
+// проверки или ошибку Redis.
+func (storage Storage) useTotpCode(userId uint, code, key string) (bool, *courseError.CourseError) {
+	now := time.Now()
+	for _, skew := range []int{0, -totpSkew, totpSkew} {
+		at := now.Add(time.Duration(skew*totpPeriod) * time.Second)
+
+		valid, _ := totp.ValidateCustom(code, key, at, totp.ValidateOpts{
+			Period:    totpPeriod,
+			Digits:    otp.DigitsSix,
+			Algorithm: otp.AlgorithmSHA1,
+		})
+		if valid {
+			return storage.totpSteps.UseStep(userId, uint64(at.Unix())/totpPeriod)
+		}
+	}
+
+	return false, nil
+}
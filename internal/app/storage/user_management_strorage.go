@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"strconv"
 	"strings"
+	"time"
 
 	courseError "github.com/knstch/course/internal/app/course_error"
 	"github.com/knstch/course/internal/domain/dto"
@@ -13,6 +14,15 @@ import (
 	"gorm.io/gorm"
 )
 
+// userCourseIds собирает подзапрос ID курсов пользователя по тому же правилу, что и GetUserCourses: курс из
+// подарочного заказа принадлежит получателю подарка, а не покупателю.
+func userCourseIds(tx *gorm.DB, userId interface{}) *gorm.DB {
+	return tx.Table("orders").
+		Select("orders.course_id").
+		Joins("LEFT JOIN gifts ON gifts.order_id = orders.id").
+		Where("(gifts.id IS NULL AND orders.user_id = ?) OR gifts.recipient_id = ?", userId, userId)
+}
+
 func (storage Storage) GetAllUsersData(ctx context.Context,
 	firstName, surname, phoneNumber, email, active, isVerified, courseName, banned, page,
 	limit string) (*entity.UserDataWithPagination, *courseError.CourseError) {
@@ -71,9 +81,12 @@ func (storage Storage) GetAllUsersData(ctx context.Context,
 	}
 
 	if courseName != "" {
-		query.Joins("JOIN courses ON LOWER(courses.name) LIKE ?", fmt.Sprint("%"+strings.ToLower(courseName)+"%")).
-			Joins("JOIN orders ON orders.course_id = courses.id").
-			Where("users.id = orders.user_id")
+		query.Where("EXISTS (?)", tx.Table("orders").
+			Select("1").
+			Joins("JOIN courses ON courses.id = orders.course_id").
+			Joins("LEFT JOIN gifts ON gifts.order_id = orders.id").
+			Where("LOWER(courses.name) LIKE ?", fmt.Sprint("%"+strings.ToLower(courseName)+"%")).
+			Where("(gifts.id IS NULL AND orders.user_id = users.id) OR gifts.recipient_id = users.id"))
 	}
 
 	limitInt, _ := strconv.Atoi(limit)
@@ -107,8 +120,7 @@ func (storage Storage) GetAllUsersData(ctx context.Context,
 		}
 
 		userCourses := dto.CreateNewCourses()
-		if err := tx.Joins("JOIN orders ON courses.id = orders.course_id").
-			Where("orders.user_id = ?", v.ID).Find(&userCourses).Error; err != nil {
+		if err := tx.Where("courses.id IN (?)", userCourseIds(tx, v.ID)).Find(&userCourses).Error; err != nil {
 			tx.Rollback()
 			return nil, courseError.CreateError(err, 10002)
 		}
@@ -158,7 +170,8 @@ func (storage Storage) DisableUser(ctx context.Context, userId string) *courseEr
 		return courseError.CreateError(err, 10003)
 	}
 
-	if err := tx.Model(&dto.AccessToken{}).Where("user_id = ?", userId).Update("available", false).Error; err != nil {
+	revoked, err := disableAccessTokens(tx, "user_id = ?", userId)
+	if err != nil {
 		tx.Rollback()
 		return courseError.CreateError(err, 10003)
 	}
@@ -168,6 +181,8 @@ func (storage Storage) DisableUser(ctx context.Context, userId string) *courseEr
 		return courseError.CreateError(err, 10010)
 	}
 
+	storage.revokeCached(revoked)
+
 	return nil
 }
 
@@ -219,8 +234,7 @@ func (storage Storage) GetAllUserDataById(ctx context.Context, id string) (*enti
 	}
 
 	userCourses := dto.CreateNewCourses()
-	if err := tx.Joins("JOIN orders ON courses.id = orders.course_id").
-		Where("orders.user_id = ?", id).Distinct("courses.id").Find(&userCourses).Error; err != nil {
+	if err := tx.Where("courses.id IN (?)", userCourseIds(tx, id)).Find(&userCourses).Error; err != nil {
 		tx.Rollback()
 		return nil, courseError.CreateError(err, 10002)
 	}
@@ -243,6 +257,16 @@ func (storage Storage) GetAllUserDataById(ctx context.Context, id string) (*enti
 
 	userEntity.AddCourses(userCourses, userOrders, userBilling)
 
+	var sessions []dto.AccessToken
+	if err := tx.Where("user_id = ? AND available = ? AND refresh_expires_at > ?", id, true, time.Now()).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error; err != nil {
+		tx.Rollback()
+		return nil, courseError.CreateError(err, 10002)
+	}
+
+	userEntity.AddSessions(sessions)
+
 	if err := tx.Commit().Error; err != nil {
 		tx.Rollback()
 		return nil, courseError.CreateError(err, 10010)
@@ -16,9 +16,7 @@ var (
 	errBadPassword             = errors.New("старый пароль передан неверно")
 	errOldAndNewPasswordsEqual = errors.New("новый и старый пароль не могут совпадать")
 
-	errInvoiceNotFound    = errors.New("инвойс не найден")
-	errOrderNotFound      = errors.New("заказ не найден")
-	errBadUserCredentials = errors.New("данные не совпадают с инвойсом")
+	errInvoiceNotFound = errors.New("инвойс не найден")
 )
 
 func (storage Storage) newUserProfileUpdate(firstName, surname string, phoneNumber int) map[string]interface{} {
@@ -84,6 +82,11 @@ func (storage Storage) ChangePasssword(ctx context.Context, oldPassword, newPass
 		return courseError.CreateError(errBadPassword, 11104)
 	}
 
+	if err := storage.checkPasswordReuse(tx, "user_id = ?", userId, credentials.Password, newPassword); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(newPassword+storage.secret), bcrypt.DefaultCost)
 	if err != nil {
 		tx.Rollback()
@@ -95,6 +98,11 @@ func (storage Storage) ChangePasssword(ctx context.Context, oldPassword, newPass
 		return courseError.CreateError(err, 10003)
 	}
 
+	if err := storage.storePasswordHistory(tx, dto.CreateNewPasswordHistory(credentials.Password).AddUserId(userId), "user_id = ?", userId); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		tx.Rollback()
 		return courseError.CreateError(err, 10010)
@@ -103,7 +111,7 @@ func (storage Storage) ChangePasssword(ctx context.Context, oldPassword, newPass
 	return nil
 }
 
-func (storage Storage) ChangeEmail(ctx context.Context, newEmail string, userId uint) *courseError.CourseError {
+func (storage Storage) ChangeEmail(ctx context.Context, newEmail string, userId uint, confirmEmail *dto.OutboxEmail) *courseError.CourseError {
 	tx := storage.db.WithContext(ctx).Begin()
 
 	oldCredentials := dto.CreateNewCredentials()
@@ -127,6 +135,11 @@ func (storage Storage) ChangeEmail(ctx context.Context, newEmail string, userId
 		return courseError.CreateError(err, 10001)
 	}
 
+	if err := enqueueEmail(tx, confirmEmail); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		tx.Rollback()
 		return courseError.CreateError(err, 10010)
@@ -196,7 +209,9 @@ func (storage Storage) RetreiveUserData(ctx context.Context) (*entity.UserData,
 	courses := dto.CreateNewCourses()
 	if err := tx.Joins("JOIN orders ON courses.id = orders.course_id").
 		Joins("JOIN billings ON billings.order_id = orders.id").
-		Where("orders.user_id = ? AND billings.paid = ?", userId, true).Find(&courses).Error; err != nil {
+		Joins("LEFT JOIN gifts ON gifts.order_id = orders.id").
+		Where("billings.status IN (?) AND ((gifts.id IS NULL AND orders.user_id = ?) OR gifts.recipient_id = ?)", dto.BillingAccessStatuses, userId, userId).
+		Find(&courses).Error; err != nil {
 		tx.Rollback()
 		return nil, courseError.CreateError(err, 10002)
 	}
@@ -227,6 +242,8 @@ func (storage Storage) RetreiveUserData(ctx context.Context) (*entity.UserData,
 	return userData, nil
 }
 
+// GetUserCourses возвращает оплаченные заказы курсов пользователя вместе с активированными им подарками. Курсы,
+// купленные пользователем в подарок, не возвращаются.
 func (storage Storage) GetUserCourses(ctx context.Context) ([]dto.Order, *courseError.CourseError) {
 	tx := storage.db.WithContext(ctx).Begin()
 
@@ -237,7 +254,8 @@ func (storage Storage) GetUserCourses(ctx context.Context) ([]dto.Order, *course
 	if err := tx.Joins("JOIN billings b ON b.order_id = orders.id").
 		Joins("JOIN orders o ON o.id = b.order_id").
 		Joins("JOIN courses c ON c.id = o.course_id").
-		Where("o.user_id = ? AND b.paid = ?", userId, true).
+		Joins("LEFT JOIN gifts g ON g.order_id = o.id").
+		Where("b.status IN (?) AND ((g.id IS NULL AND o.user_id = ?) OR g.recipient_id = ?)", dto.BillingAccessStatuses, userId, userId).
 		Find(&courses).Error; err != nil {
 		return nil, courseError.CreateError(err, 10002)
 	}
@@ -250,7 +268,9 @@ func (storage Storage) GetUserCourses(ctx context.Context) ([]dto.Order, *course
 	return courses, nil
 }
 
-func (storage Storage) GetCourseCost(ctx context.Context, courseId uint) (*uint, *courseError.CourseError) {
+// GetCourseCost возвращает цену курса в валюте currency в минимальных единицах валюты. Цена в рублях берется
+// из курса с учетом скидки, в остальных валютах - из прайс-листа курса.
+func (storage Storage) GetCourseCost(ctx context.Context, courseId uint, currency string) (*uint, *courseError.CourseError) {
 	tx := storage.db.WithContext(ctx).Begin()
 
 	course := dto.CreateNewCourse()
@@ -259,16 +279,25 @@ func (storage Storage) GetCourseCost(ctx context.Context, courseId uint) (*uint,
 		return nil, courseError.CreateError(err, 10002)
 	}
 
+	cost := dto.ToMinorUnits(course.FinalCost())
+	if currency != dto.CurrencyRUB {
+		price := dto.CoursePrice{}
+		if err := tx.Where("course_id = ? AND currency = ?", courseId, currency).First(&price).Error; err != nil {
+			tx.Rollback()
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return nil, courseError.CreateError(errPriceNotSet, 15027)
+			}
+			return nil, courseError.CreateError(err, 10002)
+		}
+		cost = price.Amount
+	}
+
 	if err := tx.Commit().Error; err != nil {
 		tx.Rollback()
 		return nil, courseError.CreateError(err, 10010)
 	}
 
-	if course.Discount != nil {
-		costWithDiscount := course.Cost - *course.Discount
-		return &costWithDiscount, nil
-	}
-	return &course.Cost, nil
+	return &cost, nil
 }
 
 func (storage Storage) DeactivateProfile(ctx context.Context) *courseError.CourseError {
@@ -281,7 +310,8 @@ func (storage Storage) DeactivateProfile(ctx context.Context) *courseError.Cours
 		return courseError.CreateError(err, 10003)
 	}
 
-	if err := tx.Table("access_tokens").Where("user_id = ?", userId).Update("available", false).Error; err != nil {
+	revoked, err := disableAccessTokens(tx, "user_id = ?", userId)
+	if err != nil {
 		tx.Rollback()
 		return courseError.CreateError(err, 10003)
 	}
@@ -291,6 +321,8 @@ func (storage Storage) DeactivateProfile(ctx context.Context) *courseError.Cours
 		return courseError.CreateError(err, 10010)
 	}
 
+	storage.revokeCached(revoked)
+
 	return nil
 }
 
@@ -0,0 +1,32 @@
+package validation
+
+import (
+	"context"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	courseerror "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+type ApiKeyToValidate entity.ApiKeyToCreate
+
+func NewApiKeyToValidate(apiKey *entity.ApiKeyToCreate) *ApiKeyToValidate {
+	return (*ApiKeyToValidate)(apiKey)
+}
+
+func (apiKey *ApiKeyToValidate) Validate(ctx context.Context) *courseerror.CourseError {
+	if err := validation.ValidateStructWithContext(ctx, apiKey,
+		validation.Field(&apiKey.Name,
+			validation.Required.Error(errApiKeyNameIsNil),
+			validation.RuneLength(1, 64).Error(errBadLength),
+		),
+		validation.Field(&apiKey.Scopes,
+			validation.Required.Error(errApiKeyScopesIsNil),
+			validation.Each(validation.In(apiKeyScopesInterfaces...).Error(errBadApiKeyScope)),
+		),
+	); err != nil {
+		return courseerror.CreateError(err, 400)
+	}
+
+	return nil
+}
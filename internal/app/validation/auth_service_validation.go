@@ -27,6 +27,9 @@ func (cr *CredentialsToValidate) Validate(ctx context.Context) *courseerror.Cour
 			validation.Match(passwordRegex).Error(errPasswordContainsBadSymbols),
 			validation.By(validatePassword(cr.Password)),
 		),
+		validation.Field(&cr.Locale,
+			validation.In(localesInterfaces...).Error(errBadLocale),
+		),
 	); err != nil {
 		return courseerror.CreateError(err, 400)
 	}
@@ -141,3 +144,51 @@ func (credentials *PasswordRecoverCredentials) Validate(ctx context.Context) *co
 
 	return nil
 }
+
+type TwoStepAuthCodeToValidate struct {
+	code string
+}
+
+func NewTwoStepAuthCodeToValidate(code string) *TwoStepAuthCodeToValidate {
+	return &TwoStepAuthCodeToValidate{
+		code: code,
+	}
+}
+
+func (code *TwoStepAuthCodeToValidate) Validate(ctx context.Context) *courseerror.CourseError {
+	if err := validation.ValidateStructWithContext(ctx, code,
+		validation.Field(&code.code,
+			validation.Required.Error(errTwoStepAuthCodeIsNil),
+			validation.RuneLength(6, 10).Error(errBadTwoStepAuthCode),
+			is.Alphanumeric.Error(errBadTwoStepAuthCode),
+		),
+	); err != nil {
+		return courseerror.CreateError(err, 400)
+	}
+
+	return nil
+}
+
+type TwoStepAuthChallengeToValidate entity.TwoStepAuthChallenge
+
+func NewTwoStepAuthChallengeToValidate(challenge *entity.TwoStepAuthChallenge) *TwoStepAuthChallengeToValidate {
+	return (*TwoStepAuthChallengeToValidate)(challenge)
+}
+
+func (challenge *TwoStepAuthChallengeToValidate) Validate(ctx context.Context) *courseerror.CourseError {
+	if err := validation.ValidateStructWithContext(ctx, challenge,
+		validation.Field(&challenge.Challenge,
+			validation.Required.Error(errChallengeIsNil),
+			is.Hexadecimal.Error(errBadChallenge),
+		),
+		validation.Field(&challenge.Code,
+			validation.Required.Error(errTwoStepAuthCodeIsNil),
+			validation.RuneLength(6, 10).Error(errBadTwoStepAuthCode),
+			is.Alphanumeric.Error(errBadTwoStepAuthCode),
+		),
+	); err != nil {
+		return courseerror.CreateError(err, 400)
+	}
+
+	return nil
+}
@@ -2,10 +2,13 @@ package validation
 
 import (
 	"context"
+	"fmt"
+	"regexp"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	"github.com/go-ozzo/ozzo-validation/v4/is"
 	courseerror "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
 	"github.com/knstch/course/internal/domain/entity"
 )
 
@@ -20,8 +23,11 @@ func (credentials *PaymentCredentialsToValidate) Validate(ctx context.Context) *
 		validation.Field(&credentials.CourseId,
 			validation.Required.Error(errFieldIsNil),
 		),
-		validation.Field(&credentials.IsRusCard,
-			validation.Required.Error(errFieldIsNil),
+		validation.Field(&credentials.Currency,
+			validation.In(currenciesInterfaces...).Error(errBadCurrency),
+		),
+		validation.Field(&credentials.PromoCode,
+			validation.Match(regexp.MustCompile(promoCodePattern)).Error(errBadPromoCode),
 		),
 	); err != nil {
 		return courseerror.CreateError(err, 400)
@@ -60,3 +66,271 @@ func ValidateToken(ctx context.Context, token string) *courseerror.CourseError {
 
 	return nil
 }
+
+type ReportsQueryToValidate struct {
+	page  string
+	limit string
+}
+
+func NewReportsQueryToValidate(page, limit string) *ReportsQueryToValidate {
+	return &ReportsQueryToValidate{
+		page:  page,
+		limit: limit,
+	}
+}
+
+func (reports *ReportsQueryToValidate) Validate(ctx context.Context) *courseerror.CourseError {
+	if err := validation.ValidateStructWithContext(ctx, reports,
+		validation.Field(&reports.page,
+			validation.By(validatePage(reports.page)),
+		),
+		validation.Field(&reports.limit,
+			validation.By(validateLimit(reports.limit)),
+		),
+	); err != nil {
+		return courseerror.CreateError(err, 400)
+	}
+
+	return nil
+}
+
+type RefundRequestToValidate entity.RefundRequest
+
+func NewRefundRequestToValidate(request *entity.RefundRequest) *RefundRequestToValidate {
+	return (*RefundRequestToValidate)(request)
+}
+
+func (request *RefundRequestToValidate) Validate(ctx context.Context) *courseerror.CourseError {
+	if err := validation.ValidateStructWithContext(ctx, request,
+		validation.Field(&request.BillingId,
+			validation.Required.Error(errFieldIsNil),
+		),
+		validation.Field(&request.Reason,
+			validation.RuneLength(0, 500).Error(errBadLength),
+		),
+	); err != nil {
+		return courseerror.CreateError(err, 400)
+	}
+
+	return nil
+}
+
+type PromoCodeToValidate entity.PromoCodeToSave
+
+func NewPromoCodeToValidate(promo *entity.PromoCodeToSave) *PromoCodeToValidate {
+	return (*PromoCodeToValidate)(promo)
+}
+
+func (promo *PromoCodeToValidate) Validate(ctx context.Context) *courseerror.CourseError {
+	if err := validation.ValidateStructWithContext(ctx, promo,
+		validation.Field(&promo.Code,
+			validation.Required.Error(errFieldIsNil),
+			validation.Match(regexp.MustCompile(promoCodePattern)).Error(errBadPromoCode),
+		),
+		validation.Field(&promo.DiscountType,
+			validation.Required.Error(errFieldIsNil),
+			validation.In(dto.PromoDiscountPercent, dto.PromoDiscountFixed).Error(errBadDiscountType),
+		),
+		validation.Field(&promo.Value,
+			validation.Required.Error(errValueTooSmall),
+			validation.When(promo.DiscountType == dto.PromoDiscountPercent,
+				validation.Max(uint(99)).Error(errBadPercent),
+			),
+		),
+		validation.Field(&promo.CourseId,
+			validation.NilOrNotEmpty.Error(errIdIsNil),
+		),
+		validation.Field(&promo.ValidUntil,
+			validation.By(promo.validateUntil()),
+		),
+	); err != nil {
+		return courseerror.CreateError(err, 400)
+	}
+
+	return nil
+}
+
+func (promo *PromoCodeToValidate) validateUntil() validation.RuleFunc {
+	return func(value interface{}) error {
+		if promo.ValidFrom != nil && promo.ValidUntil != nil && !promo.ValidUntil.After(*promo.ValidFrom) {
+			return fmt.Errorf(errValidUntilBeforeFrom)
+		}
+
+		return nil
+	}
+}
+
+type SubscribeDetailsToValidate entity.SubscribeDetails
+
+func NewSubscribeDetailsToValidate(details *entity.SubscribeDetails) *SubscribeDetailsToValidate {
+	return (*SubscribeDetailsToValidate)(details)
+}
+
+func (details *SubscribeDetailsToValidate) Validate(ctx context.Context) *courseerror.CourseError {
+	if err := validation.ValidateStructWithContext(ctx, details,
+		validation.Field(&details.PlanId,
+			validation.Required.Error(errIdIsNil),
+		),
+	); err != nil {
+		return courseerror.CreateError(err, 400)
+	}
+
+	return nil
+}
+
+type SubscriptionPlanToValidate entity.SubscriptionPlanToSave
+
+func NewSubscriptionPlanToValidate(plan *entity.SubscriptionPlanToSave) *SubscriptionPlanToValidate {
+	return (*SubscriptionPlanToValidate)(plan)
+}
+
+func (plan *SubscriptionPlanToValidate) Validate(ctx context.Context) *courseerror.CourseError {
+	if err := validation.ValidateStructWithContext(ctx, plan,
+		validation.Field(&plan.Name,
+			validation.Required.Error(errFieldIsNil),
+			validation.RuneLength(1, 100).Error(errBadLength),
+		),
+		validation.Field(&plan.Period,
+			validation.Required.Error(errFieldIsNil),
+			validation.In(dto.SubscriptionPeriodMonth, dto.SubscriptionPeriodYear).Error(errBadSubscriptionPeriod),
+		),
+		validation.Field(&plan.Price,
+			validation.Required.Error(errValueTooSmall),
+		),
+		validation.Field(&plan.CourseIds,
+			validation.When(!plan.AllCourses, validation.Required.Error(errPlanCoursesIsNil)),
+			validation.Each(validation.Required.Error(errIdIsNil)),
+		),
+	); err != nil {
+		return courseerror.CreateError(err, 400)
+	}
+
+	return nil
+}
+
+type CartDetailsToValidate entity.CartDetails
+
+func NewCartDetailsToValidate(cart *entity.CartDetails) *CartDetailsToValidate {
+	return (*CartDetailsToValidate)(cart)
+}
+
+func (cart *CartDetailsToValidate) Validate(ctx context.Context) *courseerror.CourseError {
+	if err := validation.ValidateStructWithContext(ctx, cart,
+		validation.Field(&cart.CourseIds,
+			validation.When(len(cart.BundleIds) == 0, validation.Required.Error(errCartIsEmpty)),
+			validation.Each(validation.Required.Error(errIdIsNil)),
+		),
+		validation.Field(&cart.BundleIds,
+			validation.Each(validation.Required.Error(errIdIsNil)),
+		),
+		validation.Field(&cart.Currency,
+			validation.In(currenciesInterfaces...).Error(errBadCurrency),
+		),
+		validation.Field(&cart.PromoCode,
+			validation.Match(regexp.MustCompile(promoCodePattern)).Error(errBadPromoCode),
+		),
+	); err != nil {
+		return courseerror.CreateError(err, 400)
+	}
+
+	return nil
+}
+
+type BundleToValidate entity.BundleToSave
+
+func NewBundleToValidate(bundle *entity.BundleToSave) *BundleToValidate {
+	return (*BundleToValidate)(bundle)
+}
+
+func (bundle *BundleToValidate) Validate(ctx context.Context) *courseerror.CourseError {
+	if err := validation.ValidateStructWithContext(ctx, bundle,
+		validation.Field(&bundle.Name,
+			validation.Required.Error(errFieldIsNil),
+			validation.RuneLength(1, 100).Error(errBadLength),
+		),
+		validation.Field(&bundle.Description,
+			validation.RuneLength(0, 500).Error(errBadLength),
+		),
+		validation.Field(&bundle.Price,
+			validation.Required.Error(errValueTooSmall),
+		),
+		validation.Field(&bundle.CourseIds,
+			validation.Length(2, 0).Error(errBundleTooFewCourses),
+			validation.Required.Error(errBundleTooFewCourses),
+			validation.Each(validation.Required.Error(errIdIsNil)),
+		),
+	); err != nil {
+		return courseerror.CreateError(err, 400)
+	}
+
+	return nil
+}
+
+type GiftOrderToValidate entity.GiftOrderDetails
+
+func NewGiftOrderToValidate(giftOrder *entity.GiftOrderDetails) *GiftOrderToValidate {
+	return (*GiftOrderToValidate)(giftOrder)
+}
+
+func (giftOrder *GiftOrderToValidate) Validate(ctx context.Context) *courseerror.CourseError {
+	if err := validation.ValidateStructWithContext(ctx, giftOrder,
+		validation.Field(&giftOrder.CourseId,
+			validation.Required.Error(errFieldIsNil),
+		),
+		validation.Field(&giftOrder.Currency,
+			validation.In(currenciesInterfaces...).Error(errBadCurrency),
+		),
+		validation.Field(&giftOrder.PromoCode,
+			validation.Match(regexp.MustCompile(promoCodePattern)).Error(errBadPromoCode),
+		),
+		validation.Field(&giftOrder.RecipientEmail,
+			is.EmailFormat.Error(errBadEmail),
+		),
+	); err != nil {
+		return courseerror.CreateError(err, 400)
+	}
+
+	return nil
+}
+
+func ValidateGiftCode(ctx context.Context, code string) *courseerror.CourseError {
+	if err := validation.Validate(code,
+		validation.Required.Error(errFieldIsNil),
+		validation.Match(regexp.MustCompile(giftCodePattern)).Error(errBadGiftCode),
+	); err != nil {
+		return courseerror.CreateError(err, 400)
+	}
+
+	return nil
+}
+
+type CoursePricesToValidate entity.CoursePricesToSave
+
+func NewCoursePricesToValidate(prices *entity.CoursePricesToSave) *CoursePricesToValidate {
+	return (*CoursePricesToValidate)(prices)
+}
+
+func (prices *CoursePricesToValidate) Validate(ctx context.Context) *courseerror.CourseError {
+	currencies := make(map[string]bool, len(prices.Prices))
+	for i := range prices.Prices {
+		price := &prices.Prices[i]
+		if err := validation.ValidateStructWithContext(ctx, price,
+			validation.Field(&price.Currency,
+				validation.Required.Error(errFieldIsNil),
+				validation.In(foreignCurrenciesInterfaces...).Error(errBadForeignCurrency),
+			),
+			validation.Field(&price.Amount,
+				validation.Required.Error(errValueTooSmall),
+			),
+		); err != nil {
+			return courseerror.CreateError(err, 400)
+		}
+
+		if currencies[price.Currency] {
+			return courseerror.CreateError(fmt.Errorf(errDuplicateCurrency), 400)
+		}
+		currencies[price.Currency] = true
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package validation
+
+import (
+	"context"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	courseerror "github.com/knstch/course/internal/app/course_error"
+)
+
+type OutboxQueryToValidate struct {
+	status string
+	page   string
+	limit  string
+}
+
+func NewOutboxQueryToValidate(status, page, limit string) *OutboxQueryToValidate {
+	return &OutboxQueryToValidate{
+		status: status,
+		page:   page,
+		limit:  limit,
+	}
+}
+
+func (outbox *OutboxQueryToValidate) Validate(ctx context.Context) *courseerror.CourseError {
+	if err := validation.ValidateStructWithContext(ctx, outbox,
+		validation.Field(&outbox.status,
+			validation.In(outboxStatusesInterfaces...).Error(errBadOutboxEmailStatus),
+		),
+		validation.Field(&outbox.page,
+			validation.By(validatePage(outbox.page)),
+		),
+		validation.Field(&outbox.limit,
+			validation.By(validateLimit(outbox.limit)),
+		),
+	); err != nil {
+		return courseerror.CreateError(err, 400)
+	}
+
+	return nil
+}
+
+type TemplatePreviewToValidate struct {
+	locale string
+	format string
+}
+
+func NewTemplatePreviewToValidate(locale, format string) *TemplatePreviewToValidate {
+	return &TemplatePreviewToValidate{
+		locale: locale,
+		format: format,
+	}
+}
+
+func (preview *TemplatePreviewToValidate) Validate(ctx context.Context) *courseerror.CourseError {
+	if err := validation.ValidateStructWithContext(ctx, preview,
+		validation.Field(&preview.locale,
+			validation.In(localesInterfaces...).Error(errBadLocale),
+		),
+		validation.Field(&preview.format,
+			validation.In(templateFormatInterfaces...).Error(errBadTemplateFormat),
+		),
+	); err != nil {
+		return courseerror.CreateError(err, 400)
+	}
+
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/go-ozzo/ozzo-validation/v4/is"
 	courseerror "github.com/knstch/course/internal/app/course_error"
 	"github.com/knstch/course/internal/domain/entity"
 )
@@ -107,3 +108,27 @@ func (img *ImgExtToValidate) Validate(ctx context.Context) *courseerror.CourseEr
 
 	return nil
 }
+
+type SessionIdToValidate struct {
+	sessionId string
+}
+
+func NewSessionIdToValidate(sessionId string) *SessionIdToValidate {
+	return &SessionIdToValidate{
+		sessionId: sessionId,
+	}
+}
+
+func (session *SessionIdToValidate) Validate(ctx context.Context) *courseerror.CourseError {
+	if err := validation.ValidateStructWithContext(ctx, session,
+		validation.Field(&session.sessionId,
+			validation.Required.Error("ID сессии не может быть пустым"),
+			validation.Length(32, 32).Error("ID сессии передан в неверном формате"),
+			is.Hexadecimal.Error("ID сессии передан в неверном формате"),
+		),
+	); err != nil {
+		return courseerror.CreateError(err, 400)
+	}
+
+	return nil
+}
@@ -10,6 +10,7 @@ import (
 
 	validation "github.com/go-ozzo/ozzo-validation/v4"
 	courseerror "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
 	"github.com/knstch/course/internal/domain/entity"
 )
 
@@ -27,6 +28,10 @@ const (
 	errPasswordContainsBadSymbols = "пароль может содержать только латинские буквы, спец. символы и цифры"
 	errFieldAcceptsOnlyLetters    = "допустимы только буквы"
 	errBadBool                    = "допустимы значения только true/fasle"
+	errTwoStepAuthCodeIsNil       = "код аутентификатора обязателен"
+	errBadTwoStepAuthCode         = "код аутентификатора передан неверно"
+	errChallengeIsNil             = "challenge обязателен"
+	errBadChallenge               = "challenge передан неверно"
 
 	errPageIsBad     = "номер страницы не может быть меньше 0"
 	errPageIsNil     = "номер страницы обязателен"
@@ -65,6 +70,35 @@ const (
 
 	errDueEarlierThenFrom = "период задан некорректно"
 	errBadUrl             = "ссылка передана неверно"
+
+	errApiKeyNameIsNil   = "название ключа обязательно"
+	errApiKeyScopesIsNil = "нужно передать хотя бы одно право ключа"
+	errBadApiKeyScope    = `допустимы значения только "read" и "write"`
+
+	errBadOutboxEmailStatus = `допустимы значения только "pending", "sent" и "failed"`
+	errBadLocale            = `допустимы значения только "ru" и "en"`
+	errBadTemplateFormat    = `допустимы значения только "html" и "text"`
+
+	promoCodePattern = `^[A-Z0-9_\-]{3,32}$`
+
+	errBadPromoCode         = "промокод может содержать только латинские буквы, цифры, _ и -, от 3 до 32 символов"
+	errBadDiscountType      = `допустимы значения только "percent" и "fixed"`
+	errBadPercent           = "скидка в процентах должна быть от 1 до 99"
+	errValidUntilBeforeFrom = "дата окончания действия должна быть позже даты начала"
+
+	errBadSubscriptionPeriod = `допустимы значения только "month" и "year"`
+	errPlanCoursesIsNil      = "нужно передать хотя бы один курс или открыть все курсы"
+
+	errCartIsEmpty         = "нужно передать хотя бы один курс или набор"
+	errBundleTooFewCourses = "в наборе должно быть хотя бы два курса"
+
+	giftCodePattern = `^[A-Z0-9]{4}(-[A-Z0-9]{4}){3}$`
+
+	errBadGiftCode = "код подарка должен быть в формате XXXX-XXXX-XXXX-XXXX"
+
+	errBadCurrency        = "допустимы валюты только RUB, USD, EUR и KZT"
+	errBadForeignCurrency = "цена в рублях задается в курсе, допустимы валюты только USD, EUR и KZT"
+	errDuplicateCurrency  = "цена в каждой валюте передается один раз"
 )
 
 var (
@@ -104,9 +138,36 @@ var (
 		"foreign-card",
 	}
 
-	boolsInterfaces          = stringSliceTOInterfaceSlice(bools)
-	rolesInterfaces          = stringSliceTOInterfaceSlice(allowedRoles)
-	paymentMethodsInterfaces = stringSliceTOInterfaceSlice(allowrdPaymentMethods)
+	allowedApiKeyScopes = []string{
+		"read",
+		"write",
+	}
+
+	allowedOutboxEmailStatuses = []string{
+		"pending",
+		"sent",
+		"failed",
+	}
+
+	allowedLocales = []string{
+		"ru",
+		"en",
+	}
+
+	allowedTemplateFormats = []string{
+		"html",
+		"text",
+	}
+
+	boolsInterfaces             = stringSliceTOInterfaceSlice(bools)
+	rolesInterfaces             = stringSliceTOInterfaceSlice(allowedRoles)
+	paymentMethodsInterfaces    = stringSliceTOInterfaceSlice(allowrdPaymentMethods)
+	apiKeyScopesInterfaces      = stringSliceTOInterfaceSlice(allowedApiKeyScopes)
+	outboxStatusesInterfaces    = stringSliceTOInterfaceSlice(allowedOutboxEmailStatuses)
+	localesInterfaces           = stringSliceTOInterfaceSlice(allowedLocales)
+	templateFormatInterfaces    = stringSliceTOInterfaceSlice(allowedTemplateFormats)
+	currenciesInterfaces        = stringSliceTOInterfaceSlice(dto.Currencies)
+	foreignCurrenciesInterfaces = stringSliceTOInterfaceSlice(dto.ForeignCurrencies)
 
 	errValueNotInt = errors.New("значение передано не как число")
 	errBadFile     = errors.New("загруженный файл имеет неверный формат")
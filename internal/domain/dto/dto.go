@@ -3,21 +3,25 @@ package dto
 import (
 	"reflect"
 	"strconv"
+	"time"
 
 	"gorm.io/gorm"
 )
 
 type User struct {
 	gorm.Model
-	FirstName     string
-	Surname       string
-	Credentials   Credentials
-	CredentialsId *uint `gorm:"not null"`
-	PhoneNumber   *uint
-	Active        bool `gorm:"not null;default:true"`
-	PhotoId       *uint
-	Photo         Photo
-	Banned        bool `gorm:"not null;default:false"`
+	FirstName           string
+	Surname             string
+	Credentials         Credentials
+	CredentialsId       *uint `gorm:"not null"`
+	PhoneNumber         *uint
+	Active              bool `gorm:"not null;default:true"`
+	PhotoId             *uint
+	Photo               Photo
+	Banned              bool `gorm:"not null;default:false"`
+	TotpKey             string
+	TwoStepsAuthEnabled bool   `gorm:"not null;default:false"`
+	Locale              string `gorm:"not null;default:ru"`
 }
 
 func CreateNewUser() *User {
@@ -28,6 +32,39 @@ func CreateNewUsers() []User {
 	return []User{}
 }
 
+type RecoveryCode struct {
+	gorm.Model
+	User   User
+	UserId uint   `gorm:"not null;index"`
+	Code   string `gorm:"not null"`
+	Used   bool   `gorm:"not null;default:false"`
+}
+
+func CreateNewRecoveryCode(userId uint, hashedCode string) *RecoveryCode {
+	return &RecoveryCode{
+		UserId: userId,
+		Code:   hashedCode,
+	}
+}
+
+type ExternalIdentity struct {
+	gorm.Model
+	User     User
+	UserId   uint   `gorm:"not null;index"`
+	Provider string `gorm:"not null;uniqueIndex:idx_external_identity"`
+	Subject  string `gorm:"not null;uniqueIndex:idx_external_identity"`
+	Email    string
+}
+
+func CreateNewExternalIdentity(userId uint, provider, subject, email string) *ExternalIdentity {
+	return &ExternalIdentity{
+		UserId:   userId,
+		Provider: provider,
+		Subject:  subject,
+		Email:    email,
+	}
+}
+
 type Photo struct {
 	gorm.Model
 	Path string
@@ -47,6 +84,11 @@ func (user *User) AddCredentialsId(id *uint) *User {
 	return user
 }
 
+func (user *User) AddLocale(locale string) *User {
+	user.Locale = locale
+	return user
+}
+
 type Credentials struct {
 	gorm.Model
 	Email    string `gorm:"not null;unique"`
@@ -63,6 +105,11 @@ func (cr *Credentials) SetStatusUnverified() *Credentials {
 	return cr
 }
 
+func (cr *Credentials) SetStatusVerified() *Credentials {
+	cr.Verified = true
+	return cr
+}
+
 func (cr *Credentials) AddEmail(email string) *Credentials {
 	cr.Email = email
 	return cr
@@ -75,10 +122,20 @@ func (cr *Credentials) AddPassword(password string) *Credentials {
 
 type AccessToken struct {
 	gorm.Model
-	User      User
-	UserId    uint   `gorm:"not null"`
-	Token     string `gorm:"not null"`
-	Available bool   `gorm:"not null"`
+	User             User
+	UserId           uint   `gorm:"not null"`
+	Token            string `gorm:"not null"`
+	TokenId          string `gorm:"index"`
+	ExpiresAt        time.Time
+	Available        bool   `gorm:"not null"`
+	RefreshToken     string `gorm:"index"`
+	RefreshExpiresAt time.Time
+	RefreshUsed      bool   `gorm:"not null;default:false"`
+	SessionId        string `gorm:"index"`
+	SessionStartedAt time.Time
+	LastSeenAt       time.Time
+	UserAgent        string
+	Ip               string
 }
 
 func CreateNewAccessToken() *AccessToken {
@@ -95,17 +152,62 @@ func (accessToken *AccessToken) AddToken(token *string) *AccessToken {
 	return accessToken
 }
 
+func (accessToken *AccessToken) AddTokenId(tokenId string) *AccessToken {
+	accessToken.TokenId = tokenId
+	return accessToken
+}
+
+func (accessToken *AccessToken) AddExpiration(expiresAt time.Time) *AccessToken {
+	accessToken.ExpiresAt = expiresAt
+	return accessToken
+}
+
 func (accessToken *AccessToken) SetStatusAvailable() *AccessToken {
 	accessToken.Available = true
 	return accessToken
 }
 
+func (accessToken *AccessToken) AddRefreshToken(hashedToken string) *AccessToken {
+	accessToken.RefreshToken = hashedToken
+	return accessToken
+}
+
+func (accessToken *AccessToken) AddRefreshExpiration(expiresAt time.Time) *AccessToken {
+	accessToken.RefreshExpiresAt = expiresAt
+	return accessToken
+}
+
+func (accessToken *AccessToken) AddSessionId(sessionId string) *AccessToken {
+	accessToken.SessionId = sessionId
+	return accessToken
+}
+
+func (accessToken *AccessToken) AddSessionStart(startedAt time.Time) *AccessToken {
+	accessToken.SessionStartedAt = startedAt
+	return accessToken
+}
+
+func (accessToken *AccessToken) AddLastSeen(lastSeenAt time.Time) *AccessToken {
+	accessToken.LastSeenAt = lastSeenAt
+	return accessToken
+}
+
+func (accessToken *AccessToken) AddDevice(userAgent, ip string) *AccessToken {
+	accessToken.UserAgent = userAgent
+	accessToken.Ip = ip
+	return accessToken
+}
+
+// Order - это заказ курса пользователем. Курсы, купленные вместе из корзины или набором, имеют один номер
+// заказа Order и оплачиваются одним инвойсом, но у каждого курса свой заказ и платеж. Если курс куплен в подарок,
+// к заказу привязан Gift, и курс получает не покупатель, а пользователь, который активировал подарок.
 type Order struct {
 	gorm.Model
 	UserId   uint
 	User     User
 	CourseId uint
 	Course   Course
+	BundleId *uint `gorm:"index"`
 	Order    string
 }
 
@@ -123,6 +225,11 @@ func (order *Order) AddCourseId(id uint) *Order {
 	return order
 }
 
+func (order *Order) AddBundleId(id *uint) *Order {
+	order.BundleId = id
+	return order
+}
+
 func (order *Order) AddOrder(orderHash string) *Order {
 	order.Order = orderHash
 	return order
@@ -146,6 +253,14 @@ func CreateNewCourse() *Course {
 	return &Course{}
 }
 
+// FinalCost возвращает цену курса с учетом скидки.
+func (course *Course) FinalCost() uint {
+	if course.Discount != nil {
+		return course.Cost - *course.Discount
+	}
+	return course.Cost
+}
+
 func (course *Course) AddName(name string) *Course {
 	course.Name = name
 	return course
@@ -180,6 +295,55 @@ func CreateNewCourses() []Course {
 	return []Course{}
 }
 
+const (
+	// CurrencyRUB, CurrencyUSD, CurrencyEUR и CurrencyKZT - это валюты, в которых продаются курсы. Цена курса
+	// в рублях задается в самом курсе, в остальных валютах - прайс-листом CoursePrice.
+	CurrencyRUB = "RUB"
+	CurrencyUSD = "USD"
+	CurrencyEUR = "EUR"
+	CurrencyKZT = "KZT"
+
+	// CurrencyMinorUnits - это число минимальных единиц в единице валюты. У всех поддерживаемых валют
+	// две цифры после запятой: копейки, центы и тиыны.
+	CurrencyMinorUnits = 100
+)
+
+var (
+	Currencies        = []string{CurrencyRUB, CurrencyUSD, CurrencyEUR, CurrencyKZT}
+	ForeignCurrencies = []string{CurrencyUSD, CurrencyEUR, CurrencyKZT}
+)
+
+// ToMinorUnits переводит целую сумму в основных единицах валюты, например цену курса в рублях, в минимальные единицы.
+func ToMinorUnits(amount uint) uint {
+	return amount * CurrencyMinorUnits
+}
+
+// FromMinorUnits переводит сумму в минимальных единицах валюты в основные единицы для показа пользователю.
+func FromMinorUnits(amount uint) float64 {
+	return float64(amount) / CurrencyMinorUnits
+}
+
+// CoursePrice - это цена курса в валюте, отличной от рубля, в минимальных единицах валюты. Если цены в валюте
+// нет, курс в ней не продается.
+type CoursePrice struct {
+	gorm.Model
+	CourseId uint   `gorm:"not null;uniqueIndex:idx_course_prices_currency"`
+	Currency string `gorm:"not null;uniqueIndex:idx_course_prices_currency"`
+	Amount   uint   `gorm:"not null;default:0"`
+}
+
+func CreateNewCoursePrice(courseId uint, currency string, amount uint) *CoursePrice {
+	return &CoursePrice{
+		CourseId: courseId,
+		Currency: currency,
+		Amount:   amount,
+	}
+}
+
+func CreateNewCoursePrices() []CoursePrice {
+	return []CoursePrice{}
+}
+
 type Lesson struct {
 	gorm.Model
 	ModuleId      uint    `gorm:"not null"`
@@ -312,14 +476,74 @@ func (m *Module) AddPosition(pos uint) *Module {
 	return m
 }
 
+const (
+	BillingStatusPending           = "pending"
+	BillingStatusPaid              = "paid"
+	BillingStatusPartiallyRefunded = "partially_refunded"
+	BillingStatusRefunded          = "refunded"
+	BillingStatusFailed            = "failed"
+)
+
+// billingTransitions содержит допустимые переходы между статусами платежа. Из refunded и failed перейти никуда нельзя.
+var billingTransitions = map[string][]string{
+	BillingStatusPending:           {BillingStatusPaid, BillingStatusFailed},
+	BillingStatusPaid:              {BillingStatusPartiallyRefunded, BillingStatusRefunded},
+	BillingStatusPartiallyRefunded: {BillingStatusPartiallyRefunded, BillingStatusRefunded},
+}
+
+// BillingAccessStatuses содержит статусы оплаченного платежа, при которых курс доступен пользователю.
+var BillingAccessStatuses = []string{BillingStatusPaid, BillingStatusPartiallyRefunded}
+
+// IsPaidBillingStatus проверяет, что платеж в статусе status оплачен и не возвращен полностью.
+func IsPaidBillingStatus(status string) bool {
+	for _, v := range BillingAccessStatuses {
+		if v == status {
+			return true
+		}
+	}
+
+	return false
+}
+
+// CanChangeBillingStatus проверяет, можно ли перевести платеж из статуса from в статус to.
+func CanChangeBillingStatus(from, to string) bool {
+	for _, v := range billingTransitions[from] {
+		if v == to {
+			return true
+		}
+	}
+	return false
+}
+
+// RefundStatus возвращает статус платежа после возврата refundedTotal из amount.
+func RefundStatus(amount, refundedTotal uint) string {
+	if refundedTotal >= amount {
+		return BillingStatusRefunded
+	}
+	return BillingStatusPartiallyRefunded
+}
+
+// Billing - это платеж за курс. Status хранит этап оплаты, от него же зависит доступ к курсу: курс открыт
+// при статусах из BillingAccessStatuses, то есть у оплаченного и частично возвращенного платежа. Цена Amount
+// и сумма возвратов RefundedAmount хранятся в минимальных единицах валюты Currency, в них же с банком считаются
+// инвойсы и возвраты.
+// ReceiptStatus хранит этап регистрации фискального чека за инвойс, ReceiptId - ID чека в онлайн-кассе,
+// а FiscalData - фискальные данные зарегистрированного чека.
 type Billing struct {
 	gorm.Model
-	PaymentMethod string
-	Price         float64
-	OrderId       uint
-	Order         Order
-	InvoiceId     uint
-	Paid          bool `gorm:"default:false"`
+	PaymentMethod  string
+	Currency       string `gorm:"not null;default:RUB"`
+	Amount         uint   `gorm:"not null;default:0"`
+	OrderId        uint
+	Order          Order
+	InvoiceId      uint
+	Status         string `gorm:"not null;default:pending;index"`
+	RefundedAmount uint   `gorm:"not null;default:0"`
+	ReminderSentAt *time.Time
+	ReceiptStatus  string `gorm:"not null;default:not_required;index"`
+	ReceiptId      string
+	ReceiptError   string
+	FiscalData     FiscalData `gorm:"embedded"`
 }
 
 func NewPayment() *Billing {
@@ -341,16 +565,92 @@ func (billing *Billing) AddOrderId(id uint) *Billing {
 	return billing
 }
 
-func (billing *Billing) AddPrice(price float64) *Billing {
-	billing.Price = price
+func (billing *Billing) AddCurrency(currency string) *Billing {
+	billing.Currency = currency
+	return billing
+}
+
+func (billing *Billing) AddAmount(amount uint) *Billing {
+	billing.Amount = amount
 	return billing
 }
 
 func (billing *Billing) SetPaidStatus() *Billing {
-	billing.Paid = true
+	billing.Status = BillingStatusPaid
 	return billing
 }
 
+// IsPaid проверяет по статусу, что платеж оплачен и не возвращен полностью.
+func (billing Billing) IsPaid() bool {
+	return IsPaidBillingStatus(billing.Status)
+}
+
+const (
+	RefundSourceAdmin    = "admin"
+	RefundSourceProvider = "provider"
+)
+
+// Refund - это возврат денег по платежу в валюте платежа, Amount хранится в минимальных единицах валюты. Source
+// показывает, кто начал возврат: админ или банк. За возврат в рублях регистрируется чек "возврат прихода",
+// ReceiptStatus хранит этап его регистрации.
+type Refund struct {
+	gorm.Model
+	BillingId     uint   `gorm:"not null;index"`
+	Amount        uint   `gorm:"not null"`
+	Currency      string `gorm:"not null;default:RUB"`
+	Source        string `gorm:"not null"`
+	AdminId       uint
+	Reason        string
+	ReceiptStatus string `gorm:"not null;default:not_required;index"`
+	ReceiptId     string
+	ReceiptError  string
+	FiscalData    FiscalData `gorm:"embedded"`
+}
+
+func CreateNewRefund(source string) *Refund {
+	return &Refund{
+		Source: source,
+	}
+}
+
+func (refund *Refund) AddAdminId(adminId uint) *Refund {
+	refund.AdminId = adminId
+	return refund
+}
+
+func (refund *Refund) AddReason(reason string) *Refund {
+	refund.Reason = reason
+	return refund
+}
+
+type PurchaseDetails struct {
+	BillingId      uint
+	InvoiceId      uint
+	PaymentMethod  string
+	Currency       string
+	Amount         uint
+	Status         string
+	RefundedAmount uint
+	Order          string
+	UserId         uint
+	CourseName     string
+	CourseCount    int
+	Email          string
+	Locale         string
+	CreatedAt      time.Time
+	GiftCode       string
+	GiftRecipient  string
+}
+
+func NewPurchaseDetails() *PurchaseDetails {
+	return &PurchaseDetails{}
+}
+
+// IsPaid проверяет по статусу, что заказ оплачен и не возвращен полностью.
+func (details PurchaseDetails) IsPaid() bool {
+	return IsPaidBillingStatus(details.Status)
+}
+
 type OrderEssentials struct {
 	OrderId        uint
 	Order          string
@@ -388,13 +688,19 @@ func (order *OrderEssentials) AddOrderDate(date uint) *OrderEssentials {
 	return order
 }
 
-func (order *OrderEssentials) AddAmountToPay(price uint) *OrderEssentials {
-	order.Amount = price
+// AddAmountToPay задает сумму инвойса в минимальных единицах валюты заказа.
+func (order *OrderEssentials) AddAmountToPay(amount uint) *OrderEssentials {
+	order.Amount = amount
 	return order
 }
 
 func (order *OrderEssentials) AddCurrencyRub() *OrderEssentials {
-	order.Currency = "RUB"
+	order.Currency = CurrencyRUB
+	return order
+}
+
+func (order *OrderEssentials) AddCurrency(currency string) *OrderEssentials {
+	order.Currency = currency
 	return order
 }
 
@@ -428,6 +734,202 @@ func (order *OrderEssentials) AddContactEmail() *OrderEssentials {
 	return order
 }
 
+const (
+	// ReceiptStatusNotRequired, ReceiptStatusPending, ReceiptStatusSent, ReceiptStatusDone и ReceiptStatusFailed -
+	// это этапы регистрации фискального чека: чек не нужен, ждет отправки в онлайн-кассу, отправлен и ждет
+	// фискализации, зарегистрирован в ОФД и отклонен онлайн-кассой.
+	ReceiptStatusNotRequired = "not_required"
+	ReceiptStatusPending     = "pending"
+	ReceiptStatusSent        = "sent"
+	ReceiptStatusDone        = "done"
+	ReceiptStatusFailed      = "failed"
+
+	// ReceiptOperationSell и ReceiptOperationSellRefund - это признаки расчета "приход" и "возврат прихода".
+	ReceiptOperationSell       = "sell"
+	ReceiptOperationSellRefund = "sell_refund"
+
+	// ReceiptSourceBilling, ReceiptSourceSubscription и ReceiptSourceRefund - это источники чеков: оплата курсов
+	// по инвойсу, оплата периода подписки и возврат по платежу за курс.
+	ReceiptSourceBilling      = "billing"
+	ReceiptSourceSubscription = "subscription"
+	ReceiptSourceRefund       = "refund"
+
+	// VatNone, Vat0, Vat5, Vat7, Vat10, Vat20 и Vat22 - это ставки НДС в чеке: без НДС, 0%, 5%, 7%, 10%, 20% и 22%.
+	VatNone = "none"
+	Vat0    = "vat0"
+	Vat5    = "vat5"
+	Vat7    = "vat7"
+	Vat10   = "vat10"
+	Vat20   = "vat20"
+	Vat22   = "vat22"
+
+	// PaymentSubjectService - это признак предмета расчета "услуга", PaymentMethodFullPayment - признак
+	// способа расчета "полный расчет".
+	PaymentSubjectService    = "service"
+	PaymentMethodFullPayment = "full_payment"
+)
+
+// vatPercents сопоставляет ставку НДС с ее размером в процентах.
+var vatPercents = map[string]uint{
+	VatNone: 0,
+	Vat0:    0,
+	Vat5:    5,
+	Vat7:    7,
+	Vat10:   10,
+	Vat20:   20,
+	Vat22:   22,
+}
+
+// IsVatRate проверяет, что vat - это допустимая ставка НДС.
+func IsVatRate(vat string) bool {
+	_, ok := vatPercents[vat]
+	return ok
+}
+
+// VatAmount возвращает НДС, включенный в сумму amount по ставке vat, с округлением до минимальной единицы.
+func VatAmount(amount uint, vat string) uint {
+	percent := vatPercents[vat]
+	if percent == 0 {
+		return 0
+	}
+	return (amount*percent + (100+percent)/2) / (100 + percent)
+}
+
+// Receipt - это фискальный чек по 54-ФЗ за оплату или возврат. SourceId - это ID инвойса для оплаты курсов,
+// ID платежа за подписку или ID возврата, в зависимости от источника Source. Чек отправляется на почту
+// покупателя из Purchaser, суммы хранятся в копейках.
+type Receipt struct {
+	Source    string
+	SourceId  uint
+	InvoiceId uint
+	Order     string
+	Operation string
+	TaxSystem uint
+	Purchaser Purchaser
+	Items     []ReceiptItem
+	Total     uint
+}
+
+// ReceiptItem - это позиция чека с признаками предмета и способа расчета и ставкой НДС.
+type ReceiptItem struct {
+	Name           string
+	Price          uint
+	Quantity       uint
+	Amount         uint
+	Vat            string
+	VatAmount      uint
+	PaymentSubject string
+	PaymentMethod  string
+}
+
+func NewReceipt() *Receipt {
+	return &Receipt{}
+}
+
+func (receipt *Receipt) AddSource(source string, id uint) *Receipt {
+	receipt.Source = source
+	receipt.SourceId = id
+	return receipt
+}
+
+func (receipt *Receipt) AddInvoiceId(id uint) *Receipt {
+	receipt.InvoiceId = id
+	return receipt
+}
+
+func (receipt *Receipt) AddOrder(orderHash string) *Receipt {
+	receipt.Order = orderHash
+	return receipt
+}
+
+func (receipt *Receipt) AddSellOperation() *Receipt {
+	receipt.Operation = ReceiptOperationSell
+	return receipt
+}
+
+func (receipt *Receipt) AddSellRefundOperation() *Receipt {
+	receipt.Operation = ReceiptOperationSellRefund
+	return receipt
+}
+
+func (receipt *Receipt) AddTaxSystem(taxSystem uint) *Receipt {
+	receipt.TaxSystem = taxSystem
+	return receipt
+}
+
+func (receipt *Receipt) AddEmail(email string) *Receipt {
+	receipt.Purchaser.Email = email
+	return receipt
+}
+
+func (receipt *Receipt) AddContactEmail() *Receipt {
+	receipt.Purchaser.Contact = "email"
+	return receipt
+}
+
+// AddItem добавляет позицию в чек и увеличивает итог чека на ее сумму.
+func (receipt *Receipt) AddItem(item *ReceiptItem) *Receipt {
+	receipt.Items = append(receipt.Items, *item)
+	receipt.Total += item.Amount
+	return receipt
+}
+
+// NewReceiptItem собирает позицию чека в одном экземпляре по цене price в копейках.
+func NewReceiptItem(name string, price uint) *ReceiptItem {
+	return &ReceiptItem{
+		Name:     name,
+		Price:    price,
+		Quantity: 1,
+		Amount:   price,
+	}
+}
+
+// AddVat задает ставку НДС позиции и считает НДС, включенный в ее сумму.
+func (item *ReceiptItem) AddVat(vat string) *ReceiptItem {
+	item.Vat = vat
+	item.VatAmount = VatAmount(item.Amount, vat)
+	return item
+}
+
+func (item *ReceiptItem) AddServiceSubject() *ReceiptItem {
+	item.PaymentSubject = PaymentSubjectService
+	return item
+}
+
+func (item *ReceiptItem) AddFullPaymentMethod() *ReceiptItem {
+	item.PaymentMethod = PaymentMethodFullPayment
+	return item
+}
+
+// ReceiptLine - это позиция ожидающего отправки чека: платеж за курс, оплата подписки или возврат. Позиции
+// с одинаковым SourceId становятся одним чеком, так платежи за курсы одного инвойса попадают в один чек.
+type ReceiptLine struct {
+	SourceId   uint
+	BillingId  uint
+	InvoiceId  uint
+	Order      string
+	CourseName string
+	Amount     uint
+	Email      string
+}
+
+// SentReceipt - это отправленный в онлайн-кассу чек, который ждет фискализации.
+type SentReceipt struct {
+	SourceId  uint
+	ReceiptId string
+}
+
+// FiscalData - это фискальные данные чека, зарегистрированного в ОФД: номер фискального накопителя,
+// номер и фискальный признак документа, регистрационный номер кассы, время регистрации и ссылка на чек.
+type FiscalData struct {
+	FiscalDriveNumber    string
+	FiscalDocumentNumber uint
+	FiscalSign           string
+	RegisterNumber       string
+	FiscalizedAt         *time.Time
+	ReceiptUrl           string
+}
+
 type Admin struct {
 	gorm.Model
 	Login               string `gorm:"unique"`
@@ -452,17 +954,634 @@ type AdminAccessToken struct {
 	Admin     Admin
 	AdminId   uint   `gorm:"not null"`
 	Token     string `gorm:"not null"`
-	Available bool   `gorm:"not null"`
+	TokenId   string `gorm:"index"`
+	ExpiresAt time.Time
+	Available bool `gorm:"not null"`
 }
 
-func CreateNewAdminAccessToken(id uint, token string) *AdminAccessToken {
+func CreateNewAdminAccessToken(id uint, token, tokenId string, expiresAt time.Time) *AdminAccessToken {
 	return &AdminAccessToken{
 		AdminId:   id,
 		Token:     token,
+		TokenId:   tokenId,
+		ExpiresAt: expiresAt,
 		Available: true,
 	}
 }
 
+type ApiKey struct {
+	gorm.Model
+	Name       string `gorm:"not null"`
+	Prefix     string `gorm:"not null"`
+	HashedKey  string `gorm:"not null;uniqueIndex"`
+	Scopes     string `gorm:"not null"`
+	User       *User
+	UserId     *uint `gorm:"index"`
+	Admin      *Admin
+	AdminId    *uint `gorm:"index"`
+	LastUsedAt *time.Time
+	Revoked    bool `gorm:"not null;default:false"`
+}
+
+func CreateNewApiKey(name, prefix, hashedKey, scopes string) *ApiKey {
+	return &ApiKey{
+		Name:      name,
+		Prefix:    prefix,
+		HashedKey: hashedKey,
+		Scopes:    scopes,
+	}
+}
+
+func (apiKey *ApiKey) AddUserId(id uint) *ApiKey {
+	apiKey.UserId = &id
+	return apiKey
+}
+
+func (apiKey *ApiKey) AddAdminId(id uint) *ApiKey {
+	apiKey.AdminId = &id
+	return apiKey
+}
+
+func CreateNewApiKeys() []ApiKey {
+	return []ApiKey{}
+}
+
+type PasswordHistory struct {
+	gorm.Model
+	User     *User
+	UserId   *uint `gorm:"index"`
+	Admin    *Admin
+	AdminId  *uint  `gorm:"index"`
+	Password string `gorm:"not null"`
+}
+
+func CreateNewPasswordHistory(hashedPassword string) *PasswordHistory {
+	return &PasswordHistory{
+		Password: hashedPassword,
+	}
+}
+
+func (history *PasswordHistory) AddUserId(id uint) *PasswordHistory {
+	history.UserId = &id
+	return history
+}
+
+func (history *PasswordHistory) AddAdminId(id uint) *PasswordHistory {
+	history.AdminId = &id
+	return history
+}
+
+func CreateNewPasswordHistories() []PasswordHistory {
+	return []PasswordHistory{}
+}
+
+const (
+	OutboxEmailPending = "pending"
+	OutboxEmailSent    = "sent"
+	OutboxEmailFailed  = "failed"
+)
+
+type OutboxEmail struct {
+	gorm.Model
+	Recipient     string `gorm:"not null"`
+	Subject       string `gorm:"not null"`
+	Body          string `gorm:"not null"`
+	HtmlBody      string
+	Status        string    `gorm:"not null;index"`
+	Attempts      int       `gorm:"not null;default:0"`
+	NextAttemptAt time.Time `gorm:"not null;index"`
+	LastError     string
+	SentAt        *time.Time
+}
+
+func CreateNewOutboxEmail(recipient, subject, body string) *OutboxEmail {
+	return &OutboxEmail{
+		Recipient:     recipient,
+		Subject:       subject,
+		Body:          body,
+		Status:        OutboxEmailPending,
+		NextAttemptAt: time.Now(),
+	}
+}
+
+func (email *OutboxEmail) AddHtmlBody(htmlBody string) *OutboxEmail {
+	email.HtmlBody = htmlBody
+	return email
+}
+
+// SetStatusSent отмечает письмо отправленным и стирает его текст, чтобы коды подтверждения, ссылки для входа
+// и коды подарков не хранились в БД после доставки.
+func (email *OutboxEmail) SetStatusSent(sentAt time.Time) *OutboxEmail {
+	email.Status = OutboxEmailSent
+	email.SentAt = &sentAt
+	email.LastError = ""
+	email.Body = ""
+	email.HtmlBody = ""
+	return email
+}
+
+func (email *OutboxEmail) SetStatusFailed(lastError string) *OutboxEmail {
+	email.Status = OutboxEmailFailed
+	email.LastError = lastError
+	return email
+}
+
+func (email *OutboxEmail) ScheduleRetry(nextAttemptAt time.Time, lastError string) *OutboxEmail {
+	email.NextAttemptAt = nextAttemptAt
+	email.LastError = lastError
+	return email
+}
+
+func CreateNewOutboxEmails() []OutboxEmail {
+	return []OutboxEmail{}
+}
+
+const (
+	ReconciliationPaidNotConfirmed  = "paid_not_confirmed"
+	ReconciliationPaidNotAtProvider = "paid_not_at_provider"
+	ReconciliationMissingAtProvider = "missing_at_provider"
+	ReconciliationStatusMismatch    = "status_mismatch"
+	ReconciliationPaidAfterFailed   = "paid_after_failed"
+
+	ReconciliationConfirmed = "confirmed"
+	ReconciliationExpired   = "expired"
+)
+
+type ReconciliationReport struct {
+	gorm.Model
+	StartedAt  time.Time                `gorm:"not null"`
+	FinishedAt time.Time                `gorm:"not null"`
+	Checked    int                      `gorm:"not null;default:0"`
+	Confirmed  int                      `gorm:"not null;default:0"`
+	Expired    int                      `gorm:"not null;default:0"`
+	Errors     int                      `gorm:"not null;default:0"`
+	Mismatches []ReconciliationMismatch `gorm:"foreignKey:ReportId"`
+}
+
+func CreateNewReconciliationReport(startedAt time.Time) *ReconciliationReport {
+	return &ReconciliationReport{
+		StartedAt: startedAt,
+	}
+}
+
+func (report *ReconciliationReport) AddMismatch(details PurchaseDetails, kind, providerStatus, resolution string) *ReconciliationReport {
+	report.Mismatches = append(report.Mismatches, ReconciliationMismatch{
+		BillingId:      details.BillingId,
+		InvoiceId:      details.InvoiceId,
+		Kind:           kind,
+		Paid:           details.IsPaid(),
+		Status:         details.Status,
+		ProviderStatus: providerStatus,
+		Resolution:     resolution,
+	})
+	return report
+}
+
+func (report *ReconciliationReport) Finish(finishedAt time.Time) *ReconciliationReport {
+	report.FinishedAt = finishedAt
+	return report
+}
+
+type ReconciliationMismatch struct {
+	gorm.Model
+	ReportId       uint   `gorm:"not null;index"`
+	BillingId      uint   `gorm:"not null"`
+	InvoiceId      uint   `gorm:"not null"`
+	Kind           string `gorm:"not null"`
+	Paid           bool
+	Status         string
+	ProviderStatus string
+	Resolution     string
+}
+
+func CreateNewReconciliationReports() []ReconciliationReport {
+	return []ReconciliationReport{}
+}
+
+const (
+	PromoDiscountPercent = "percent"
+	PromoDiscountFixed   = "fixed"
+)
+
+// PromoCode - это промокод на скидку в процентах или рублях. Без CourseId промокод действует на все курсы,
+// нулевые лимиты и пустые даты ничего не ограничивают.
+type PromoCode struct {
+	gorm.Model
+	Code              string `gorm:"not null;uniqueIndex:idx_promo_codes_code,where:deleted_at IS NULL"`
+	DiscountType      string `gorm:"not null"`
+	Value             uint   `gorm:"not null"`
+	CourseId          *uint
+	MaxRedemptions    uint `gorm:"not null;default:0"`
+	MaxPerUser        uint `gorm:"not null;default:0"`
+	ValidFrom         *time.Time
+	ValidUntil        *time.Time
+	FirstPurchaseOnly bool `gorm:"not null;default:false"`
+	Active            bool `gorm:"not null;default:true"`
+}
+
+func CreateNewPromoCode(code, discountType string, value uint) *PromoCode {
+	return &PromoCode{
+		Code:         code,
+		DiscountType: discountType,
+		Value:        value,
+	}
+}
+
+func (promo *PromoCode) AddCourseId(courseId *uint) *PromoCode {
+	promo.CourseId = courseId
+	return promo
+}
+
+func (promo *PromoCode) AddLimits(maxRedemptions, maxPerUser uint) *PromoCode {
+	promo.MaxRedemptions = maxRedemptions
+	promo.MaxPerUser = maxPerUser
+	return promo
+}
+
+func (promo *PromoCode) AddValidity(validFrom, validUntil *time.Time) *PromoCode {
+	promo.ValidFrom = validFrom
+	promo.ValidUntil = validUntil
+	return promo
+}
+
+func (promo *PromoCode) SetFirstPurchaseOnly(firstPurchaseOnly bool) *PromoCode {
+	promo.FirstPurchaseOnly = firstPurchaseOnly
+	return promo
+}
+
+func (promo *PromoCode) SetActive(active bool) *PromoCode {
+	promo.Active = active
+	return promo
+}
+
+// IsValidAt проверяет, что промокод включен и действует в момент t.
+func (promo *PromoCode) IsValidAt(t time.Time) bool {
+	if !promo.Active {
+		return false
+	}
+	if promo.ValidFrom != nil && t.Before(*promo.ValidFrom) {
+		return false
+	}
+	if promo.ValidUntil != nil && !t.Before(*promo.ValidUntil) {
+		return false
+	}
+	return true
+}
+
+// AppliesTo проверяет, что промокод действует на курс.
+func (promo *PromoCode) AppliesTo(courseId uint) bool {
+	return promo.CourseId == nil || *promo.CourseId == courseId
+}
+
+// AppliesToCurrency проверяет, что промокод действует на покупку в валюте currency. Скидка в рублях
+// действует только на покупки в рублях.
+func (promo *PromoCode) AppliesToCurrency(currency string) bool {
+	return promo.DiscountType == PromoDiscountPercent || currency == CurrencyRUB
+}
+
+// Discount возвращает скидку с цены amount в минимальных единицах валюты. Скидка в рублях задается целыми
+// рублями. Скидка не опускает цену ниже одной единицы валюты, потому что банк не выставляет инвойсы на 0 рублей.
+func (promo *PromoCode) Discount(amount uint) uint {
+	if amount <= CurrencyMinorUnits {
+		return 0
+	}
+
+	discount := ToMinorUnits(promo.Value)
+	if promo.DiscountType == PromoDiscountPercent {
+		discount = amount * promo.Value / 100
+	}
+
+	if discount > amount-CurrencyMinorUnits {
+		discount = amount - CurrencyMinorUnits
+	}
+	return discount
+}
+
+func CreateNewPromoCodes() []PromoCode {
+	return []PromoCode{}
+}
+
+// PromoRedemption - это использование промокода в заказе. Amount - скидка в минимальных единицах валюты заказа.
+type PromoRedemption struct {
+	gorm.Model
+	PromoCodeId uint `gorm:"not null;index"`
+	OrderId     uint `gorm:"not null;uniqueIndex"`
+	UserId      uint `gorm:"not null;index"`
+	Amount      uint `gorm:"not null;default:0"`
+}
+
+func CreateNewPromoRedemption(promoCodeId, orderId, userId, amount uint) *PromoRedemption {
+	return &PromoRedemption{
+		PromoCodeId: promoCodeId,
+		OrderId:     orderId,
+		UserId:      userId,
+		Amount:      amount,
+	}
+}
+
+const (
+	SubscriptionPeriodMonth = "month"
+	SubscriptionPeriodYear  = "year"
+
+	SubscriptionStatusPending = "pending"
+	SubscriptionStatusActive  = "active"
+	SubscriptionStatusPastDue = "past_due"
+	SubscriptionStatusExpired = "expired"
+)
+
+// Bundle - это набор курсов, который продается дешевле, чем курсы по отдельности.
+type Bundle struct {
+	gorm.Model
+	Name        string   `gorm:"not null;uniqueIndex:idx_bundles_name,where:deleted_at IS NULL"`
+	Description string   `gorm:"not null"`
+	Price       uint     `gorm:"not null"`
+	Courses     []Course `gorm:"many2many:bundle_courses"`
+	Active      bool     `gorm:"not null;default:true"`
+}
+
+func CreateNewBundle(name, description string, price uint) *Bundle {
+	return &Bundle{
+		Name:        name,
+		Description: description,
+		Price:       price,
+	}
+}
+
+func (bundle *Bundle) AddCourses(courseIds []uint) *Bundle {
+	bundle.Courses = make([]Course, 0, len(courseIds))
+	for _, v := range courseIds {
+		course := Course{}
+		course.ID = v
+		bundle.Courses = append(bundle.Courses, course)
+	}
+	return bundle
+}
+
+func (bundle *Bundle) SetActive(active bool) *Bundle {
+	bundle.Active = active
+	return bundle
+}
+
+// CoursesCost возвращает стоимость курсов набора по отдельности.
+func (bundle *Bundle) CoursesCost() uint {
+	var cost uint
+	for i := range bundle.Courses {
+		cost += bundle.Courses[i].FinalCost()
+	}
+	return cost
+}
+
+// CoursePrices распределяет цену набора между его курсами пропорционально их цене по отдельности, остаток
+// от округления достается последнему курсу. Если все курсы бесплатные, цена делится поровну. Возвращает цены
+// в минимальных единицах рубля в порядке Courses.
+func (bundle *Bundle) CoursePrices() []uint {
+	prices := make([]uint, len(bundle.Courses))
+	if len(prices) == 0 {
+		return prices
+	}
+
+	amount := ToMinorUnits(bundle.Price)
+	total := uint64(bundle.CoursesCost())
+
+	var distributed uint
+	for i := range bundle.Courses[:len(prices)-1] {
+		if total == 0 {
+			prices[i] = amount / uint(len(prices))
+		} else {
+			prices[i] = uint(uint64(amount) * uint64(bundle.Courses[i].FinalCost()) / total)
+		}
+		distributed += prices[i]
+	}
+	prices[len(prices)-1] = amount - distributed
+
+	return prices
+}
+
+func CreateNewBundles() []Bundle {
+	return []Bundle{}
+}
+
+// CheckoutItem - это курс в оформляемом заказе с ценой Amount в минимальных единицах валюты заказа, по которой
+// он покупается. Если курс покупается в наборе, BundleId указывает на набор, а цена - на долю курса в цене набора.
+type CheckoutItem struct {
+	CourseId uint
+	BundleId *uint
+	Amount   uint
+}
+
+const (
+	// GiftStatusPending, GiftStatusActive, GiftStatusRedeemed и GiftStatusVoided - это статусы подарка: ждет оплаты,
+	// оплачен и ждет активации, активирован и аннулирован админом.
+	GiftStatusPending  = "pending"
+	GiftStatusActive   = "active"
+	GiftStatusRedeemed = "redeemed"
+	GiftStatusVoided   = "voided"
+)
+
+// Gift - это курс, купленный в подарок. Покупатель оплачивает заказ OrderId и получает чек, а курс получает
+// пользователь, который активировал код подарка. Если указан RecipientEmail, подарок может активировать только
+// пользователь с этой почтой, и код отправляется ему письмом после оплаты.
+type Gift struct {
+	gorm.Model
+	OrderId        uint   `gorm:"not null;uniqueIndex"`
+	BuyerId        uint   `gorm:"not null;index"`
+	Code           string `gorm:"not null;uniqueIndex"`
+	RecipientEmail string
+	RecipientId    *uint `gorm:"index"`
+	RedeemedAt     *time.Time
+	VoidedAt       *time.Time
+}
+
+func CreateNewGift(orderId, buyerId uint, code, recipientEmail string) *Gift {
+	return &Gift{
+		OrderId:        orderId,
+		BuyerId:        buyerId,
+		Code:           code,
+		RecipientEmail: recipientEmail,
+	}
+}
+
+// GiftDetails - это подарок вместе с курсом и статусом оплаты заказа.
+type GiftDetails struct {
+	GiftId         uint
+	Code           string
+	CourseId       uint
+	CourseName     string
+	BuyerId        uint
+	BuyerEmail     string
+	RecipientEmail string
+	RecipientId    *uint
+	BillingStatus  string
+	RedeemedAt     *time.Time
+	VoidedAt       *time.Time
+	CreatedAt      time.Time
+}
+
+// Status возвращает статус подарка по статусу оплаты заказа. Подарок, деньги за который вернули полностью,
+// считается аннулированным.
+func (gift *GiftDetails) Status() string {
+	switch {
+	case gift.RedeemedAt != nil:
+		return GiftStatusRedeemed
+	case gift.VoidedAt != nil, gift.BillingStatus == BillingStatusRefunded, gift.BillingStatus == BillingStatusFailed:
+		return GiftStatusVoided
+	case gift.BillingStatus == BillingStatusPaid, gift.BillingStatus == BillingStatusPartiallyRefunded:
+		return GiftStatusActive
+	default:
+		return GiftStatusPending
+	}
+}
+
+func CreateNewGiftsDetails() []GiftDetails {
+	return []GiftDetails{}
+}
+
+// SubscriptionPlan - это план подписки. План с AllCourses открывает все не скрытые курсы, иначе только курсы из Courses.
+type SubscriptionPlan struct {
+	gorm.Model
+	Name       string   `gorm:"not null;uniqueIndex:idx_subscription_plans_name,where:deleted_at IS NULL"`
+	Period     string   `gorm:"not null"`
+	Price      uint     `gorm:"not null"`
+	AllCourses bool     `gorm:"not null;default:false"`
+	Courses    []Course `gorm:"many2many:subscription_plan_courses"`
+	Active     bool     `gorm:"not null;default:true"`
+}
+
+func CreateNewSubscriptionPlan(name, period string, price uint) *SubscriptionPlan {
+	return &SubscriptionPlan{
+		Name:   name,
+		Period: period,
+		Price:  price,
+	}
+}
+
+func (plan *SubscriptionPlan) SetAllCourses(allCourses bool) *SubscriptionPlan {
+	plan.AllCourses = allCourses
+	return plan
+}
+
+func (plan *SubscriptionPlan) AddCourses(courseIds []uint) *SubscriptionPlan {
+	plan.Courses = make([]Course, 0, len(courseIds))
+	for _, v := range courseIds {
+		course := Course{}
+		course.ID = v
+		plan.Courses = append(plan.Courses, course)
+	}
+	return plan
+}
+
+func (plan *SubscriptionPlan) SetActive(active bool) *SubscriptionPlan {
+	plan.Active = active
+	return plan
+}
+
+// PeriodEnd возвращает конец оплаченного периода, начавшегося в start.
+func (plan *SubscriptionPlan) PeriodEnd(start time.Time) time.Time {
+	return SubscriptionPeriodEnd(plan.Period, start)
+}
+
+// SubscriptionPeriodEnd возвращает конец периода подписки period, начавшегося в start.
+func SubscriptionPeriodEnd(period string, start time.Time) time.Time {
+	if period == SubscriptionPeriodYear {
+		return start.AddDate(1, 0, 0)
+	}
+	return start.AddDate(0, 1, 0)
+}
+
+func CreateNewSubscriptionPlans() []SubscriptionPlan {
+	return []SubscriptionPlan{}
+}
+
+// Subscription - это подписка пользователя на план. Подписка дает доступ, пока она активна и не закончился
+// оплаченный период, или пока после неудачного продления не закончился льготный период GraceUntil.
+type Subscription struct {
+	gorm.Model
+	UserId             uint `gorm:"not null;index"`
+	PlanId             uint `gorm:"not null"`
+	Plan               SubscriptionPlan
+	Status             string `gorm:"not null;default:pending;index"`
+	CurrentPeriodStart *time.Time
+	CurrentPeriodEnd   *time.Time
+	GraceUntil         *time.Time
+	CancelAtPeriodEnd  bool `gorm:"not null;default:false"`
+	BindingId          string
+	RusCard            bool `gorm:"not null;default:false"`
+}
+
+func CreateNewSubscription(userId, planId uint, ruCard bool) *Subscription {
+	return &Subscription{
+		UserId:  userId,
+		PlanId:  planId,
+		Status:  SubscriptionStatusPending,
+		RusCard: ruCard,
+	}
+}
+
+// HasAccessAt проверяет, что подписка открывает курсы в момент t.
+func (subscription *Subscription) HasAccessAt(t time.Time) bool {
+	switch subscription.Status {
+	case SubscriptionStatusActive:
+		return subscription.CurrentPeriodEnd != nil && t.Before(*subscription.CurrentPeriodEnd)
+	case SubscriptionStatusPastDue:
+		return subscription.GraceUntil != nil && t.Before(*subscription.GraceUntil)
+	default:
+		return false
+	}
+}
+
+// SubscriptionPayment - это оплата периода подписки. Первая оплата проходит через форму банка, следующие
+// списываются с привязанной карты. Amount - сумма оплаты в минимальных единицах рубля. За каждую оплату
+// регистрируется чек "приход", ReceiptStatus хранит этап его регистрации.
+type SubscriptionPayment struct {
+	gorm.Model
+	SubscriptionId uint `gorm:"not null;index"`
+	Subscription   Subscription
+	InvoiceId      uint   `gorm:"index"`
+	Amount         uint   `gorm:"not null;default:0"`
+	Status         string `gorm:"not null;default:pending;index"`
+	PeriodStart    *time.Time
+	PeriodEnd      *time.Time
+	ReceiptStatus  string `gorm:"not null;default:not_required;index"`
+	ReceiptId      string
+	ReceiptError   string
+	FiscalData     FiscalData `gorm:"embedded"`
+}
+
+func CreateNewSubscriptionPayment(subscriptionId uint, amount uint) *SubscriptionPayment {
+	return &SubscriptionPayment{
+		SubscriptionId: subscriptionId,
+		Amount:         amount,
+		Status:         BillingStatusPending,
+	}
+}
+
+// SubscriptionCharge - это данные подписки, нужные для выставления инвойса за ее период. Amount - цена плана
+// в минимальных единицах рубля.
+type SubscriptionCharge struct {
+	SubscriptionId   uint
+	PaymentId        uint
+	UserId           uint
+	PlanId           uint
+	PlanName         string
+	Amount           uint
+	BindingId        string
+	RusCard          bool
+	Email            string
+	CurrentPeriodEnd *time.Time
+}
+
+type RevokedToken struct {
+	TokenId   string
+	ExpiresAt time.Time
+}
+
+func NewRevokedToken(tokenId string, expiresAt time.Time) RevokedToken {
+	return RevokedToken{
+		TokenId:   tokenId,
+		ExpiresAt: expiresAt,
+	}
+}
+
 type WatchHistory struct {
 	gorm.Model
 	Lesson   Lesson
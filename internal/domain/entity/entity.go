@@ -1,6 +1,7 @@
 package entity
 
 import (
+	"strings"
 	"time"
 
 	"github.com/knstch/course/internal/domain/dto"
@@ -9,12 +10,86 @@ import (
 type Credentials struct {
 	Email    string `json:"email"`
 	Password string `json:"password"`
+	Locale   string `json:"locale,omitempty"`
 }
 
 func NewCredentials() *Credentials {
 	return &Credentials{}
 }
 
+type TwoStepAuthCode struct {
+	Code string `json:"code"`
+}
+
+func NewTwoStepAuthCode() *TwoStepAuthCode {
+	return &TwoStepAuthCode{}
+}
+
+type TwoStepAuthChallenge struct {
+	Challenge string `json:"challenge"`
+	Code      string `json:"code"`
+}
+
+func NewTwoStepAuthChallenge() *TwoStepAuthChallenge {
+	return &TwoStepAuthChallenge{}
+}
+
+type TwoStepAuthRequired struct {
+	TwoStepAuthRequired bool   `json:"twoStepAuthRequired"`
+	Challenge           string `json:"challenge"`
+}
+
+func CreateTwoStepAuthRequired(challenge string) *TwoStepAuthRequired {
+	return &TwoStepAuthRequired{
+		TwoStepAuthRequired: true,
+		Challenge:           challenge,
+	}
+}
+
+type RecoveryCodes struct {
+	RecoveryCodes []string `json:"recoveryCodes"`
+}
+
+func CreateRecoveryCodes(codes []string) *RecoveryCodes {
+	return &RecoveryCodes{
+		RecoveryCodes: codes,
+	}
+}
+
+type MagicLinkToken struct {
+	Token string `json:"token"`
+}
+
+func NewMagicLinkToken() *MagicLinkToken {
+	return &MagicLinkToken{}
+}
+
+type SocialLoginCallback struct {
+	Code     string
+	State    string
+	DeviceId string
+}
+
+func NewSocialLoginCallback(code, state, deviceId string) *SocialLoginCallback {
+	return &SocialLoginCallback{
+		Code:     code,
+		State:    state,
+		DeviceId: deviceId,
+	}
+}
+
+type Device struct {
+	UserAgent string
+	Ip        string
+}
+
+func NewDevice(userAgent, ip string) *Device {
+	return &Device{
+		UserAgent: userAgent,
+		Ip:        ip,
+	}
+}
+
 type UserInfo struct {
 	FirstName   string `json:"firstName"`
 	Surname     string `json:"surname"`
@@ -97,28 +172,64 @@ type UserCourses struct {
 	Billing    []UserBilling `json:"billingInfo"`
 }
 
-func (courses *UserCourses) AddBilling(id uint, order string, paidStatus bool, paid float64, paymentMethod string, invoiceId uint, time time.Time) *UserCourses {
+func (courses *UserCourses) AddBilling(id uint, order string, paidStatus bool, status string, paid, refunded float64, paymentMethod string, invoiceId uint, time time.Time, receipt *BillingReceipt) *UserCourses {
 	courses.Billing = append(courses.Billing, UserBilling{
 		Id:            id,
 		Order:         order,
 		PaidStatus:    paidStatus,
+		Status:        status,
 		Paid:          paid,
+		Refunded:      refunded,
 		PaymentMethod: paymentMethod,
 		InvoiceId:     invoiceId,
 		Timestamp:     time,
+		Receipt:       receipt,
 	})
 
 	return courses
 }
 
 type UserBilling struct {
-	Id            uint      `json:"id"`
-	Order         string    `json:"order"`
-	PaidStatus    bool      `json:"paidStatus"`
-	Paid          float64   `json:"paid"`
-	PaymentMethod string    `json:"paymentMethod"`
-	InvoiceId     uint      `json:"invoice"`
-	Timestamp     time.Time `json:"timestamp"`
+	Id            uint            `json:"id"`
+	Order         string          `json:"order"`
+	PaidStatus    bool            `json:"paidStatus"`
+	Status        string          `json:"status"`
+	Paid          float64         `json:"paid"`
+	Refunded      float64         `json:"refunded"`
+	PaymentMethod string          `json:"paymentMethod"`
+	InvoiceId     uint            `json:"invoice"`
+	Timestamp     time.Time       `json:"timestamp"`
+	Receipt       *BillingReceipt `json:"receipt,omitempty"`
+}
+
+// BillingReceipt - это фискальный чек за платеж. Фискальные данные есть только у зарегистрированного чека.
+type BillingReceipt struct {
+	Status               string     `json:"status"`
+	Error                string     `json:"error,omitempty"`
+	FiscalDriveNumber    string     `json:"fiscalDriveNumber,omitempty"`
+	FiscalDocumentNumber uint       `json:"fiscalDocumentNumber,omitempty"`
+	FiscalSign           string     `json:"fiscalSign,omitempty"`
+	RegisterNumber       string     `json:"registerNumber,omitempty"`
+	FiscalizedAt         *time.Time `json:"fiscalizedAt,omitempty"`
+	Url                  string     `json:"url,omitempty"`
+}
+
+// CreateBillingReceipt собирает чек за платеж или возвращает nil, если чек за платеж не нужен.
+func CreateBillingReceipt(billing dto.Billing) *BillingReceipt {
+	if billing.ReceiptStatus == "" || billing.ReceiptStatus == dto.ReceiptStatusNotRequired {
+		return nil
+	}
+
+	return &BillingReceipt{
+		Status:               billing.ReceiptStatus,
+		Error:                billing.ReceiptError,
+		FiscalDriveNumber:    billing.FiscalData.FiscalDriveNumber,
+		FiscalDocumentNumber: billing.FiscalData.FiscalDocumentNumber,
+		FiscalSign:           billing.FiscalData.FiscalSign,
+		RegisterNumber:       billing.FiscalData.RegisterNumber,
+		FiscalizedAt:         billing.FiscalData.FiscalizedAt,
+		Url:                  billing.FiscalData.ReceiptUrl,
+	}
 }
 
 type UserData struct {
@@ -180,7 +291,7 @@ func (user *UserData) AddCourses(courses []dto.Course, orders []dto.Order, billi
 			if v.ID == j.CourseId {
 				for _, k := range billing {
 					if j.ID == k.OrderId {
-						course.AddBilling(k.ID, j.Order, k.Paid, k.Price, k.PaymentMethod, k.InvoiceId, k.CreatedAt)
+						course.AddBilling(k.ID, j.Order, k.IsPaid(), k.Status, dto.FromMinorUnits(k.Amount), dto.FromMinorUnits(k.RefundedAmount), k.PaymentMethod, k.InvoiceId, k.CreatedAt, CreateBillingReceipt(k))
 					}
 				}
 			}
@@ -214,6 +325,7 @@ type UserDataAdmin struct {
 	Photo       *string       `json:"photoPath,omitempty"`
 	Courses     []UserCourses `json:"courses"`
 	Banned      bool          `json:"banned"`
+	Sessions    []Session     `json:"sessions"`
 }
 
 func CreateUserDataAdmin(user dto.User) *UserDataAdmin {
@@ -245,7 +357,7 @@ func (user *UserDataAdmin) AddCourses(courses []dto.Course, orders []dto.Order,
 			if v.ID == j.CourseId {
 				for _, k := range billing {
 					if j.ID == k.OrderId {
-						course.AddBilling(k.ID, j.Order, k.Paid, k.Price, k.PaymentMethod, k.InvoiceId, k.CreatedAt)
+						course.AddBilling(k.ID, j.Order, k.IsPaid(), k.Status, dto.FromMinorUnits(k.Amount), dto.FromMinorUnits(k.RefundedAmount), k.PaymentMethod, k.InvoiceId, k.CreatedAt, CreateBillingReceipt(k))
 					}
 				}
 			}
@@ -270,6 +382,191 @@ func (user *UserDataAdmin) AddPhoto(photo *dto.Photo) *UserDataAdmin {
 	return user
 }
 
+func (user *UserDataAdmin) AddSessions(sessions []dto.AccessToken) *UserDataAdmin {
+	user.Sessions = CreateSessions(sessions, "")
+
+	return user
+}
+
+type Session struct {
+	SessionId  string    `json:"sessionId"`
+	UserAgent  string    `json:"userAgent"`
+	Ip         string    `json:"ip"`
+	CreatedAt  time.Time `json:"createdAt"`
+	LastSeenAt time.Time `json:"lastSeenAt"`
+	Current    bool      `json:"current"`
+}
+
+func CreateSessions(sessions []dto.AccessToken, currentSessionId string) []Session {
+	result := make([]Session, 0, len(sessions))
+	for _, v := range sessions {
+		result = append(result, Session{
+			SessionId:  v.SessionId,
+			UserAgent:  v.UserAgent,
+			Ip:         v.Ip,
+			CreatedAt:  v.SessionStartedAt,
+			LastSeenAt: v.LastSeenAt,
+			Current:    currentSessionId != "" && v.SessionId == currentSessionId,
+		})
+	}
+
+	return result
+}
+
+type ApiKeyToCreate struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes"`
+}
+
+func NewApiKeyToCreate() *ApiKeyToCreate {
+	return &ApiKeyToCreate{}
+}
+
+type CreatedApiKey struct {
+	Id     uint     `json:"id"`
+	Name   string   `json:"name"`
+	Key    string   `json:"key"`
+	Scopes []string `json:"scopes"`
+}
+
+func CreateCreatedApiKey(id uint, name, key string, scopes []string) *CreatedApiKey {
+	return &CreatedApiKey{
+		Id:     id,
+		Name:   name,
+		Key:    key,
+		Scopes: scopes,
+	}
+}
+
+type ApiKey struct {
+	Id         uint       `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt"`
+}
+
+func CreateApiKeys(apiKeys []dto.ApiKey) []ApiKey {
+	result := make([]ApiKey, 0, len(apiKeys))
+	for _, v := range apiKeys {
+		result = append(result, ApiKey{
+			Id:         v.ID,
+			Name:       v.Name,
+			Prefix:     v.Prefix,
+			Scopes:     strings.Split(v.Scopes, ","),
+			CreatedAt:  v.CreatedAt,
+			LastUsedAt: v.LastUsedAt,
+		})
+	}
+
+	return result
+}
+
+type ApiKeyOwner struct {
+	KeyId    uint
+	UserId   uint
+	Verified bool
+	AdminId  uint
+	Role     string
+	Scopes   []string
+}
+
+type OutboxEmail struct {
+	Id            uint       `json:"id"`
+	Recipient     string     `json:"recipient"`
+	Subject       string     `json:"subject"`
+	Status        string     `json:"status"`
+	Attempts      int        `json:"attempts"`
+	NextAttemptAt time.Time  `json:"nextAttemptAt"`
+	LastError     string     `json:"lastError,omitempty"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	SentAt        *time.Time `json:"sentAt,omitempty"`
+}
+
+type OutboxEmailsWithPagination struct {
+	Pagination Pagination    `json:"pagination"`
+	Emails     []OutboxEmail `json:"emails"`
+}
+
+// CreateOutboxEmails собирает письма из outbox для админки. Текст письма не возвращается,
+// так как в нем лежат коды подтверждения и ссылки для входа.
+func CreateOutboxEmails(emails []dto.OutboxEmail) []OutboxEmail {
+	result := make([]OutboxEmail, 0, len(emails))
+	for _, v := range emails {
+		result = append(result, OutboxEmail{
+			Id:            v.ID,
+			Recipient:     v.Recipient,
+			Subject:       v.Subject,
+			Status:        v.Status,
+			Attempts:      v.Attempts,
+			NextAttemptAt: v.NextAttemptAt,
+			LastError:     v.LastError,
+			CreatedAt:     v.CreatedAt,
+			SentAt:        v.SentAt,
+		})
+	}
+
+	return result
+}
+
+type ReconciliationMismatch struct {
+	BillingId      uint   `json:"billingId"`
+	InvoiceId      uint   `json:"invoiceId"`
+	Kind           string `json:"kind"`
+	Paid           bool   `json:"paid"`
+	Status         string `json:"status,omitempty"`
+	ProviderStatus string `json:"providerStatus,omitempty"`
+	Resolution     string `json:"resolution,omitempty"`
+}
+
+type ReconciliationReport struct {
+	Id         uint                     `json:"id"`
+	StartedAt  time.Time                `json:"startedAt"`
+	FinishedAt time.Time                `json:"finishedAt"`
+	Checked    int                      `json:"checked"`
+	Confirmed  int                      `json:"confirmed"`
+	Expired    int                      `json:"expired"`
+	Errors     int                      `json:"errors"`
+	Mismatches []ReconciliationMismatch `json:"mismatches"`
+}
+
+type ReconciliationReportsWithPagination struct {
+	Pagination Pagination             `json:"pagination"`
+	Reports    []ReconciliationReport `json:"reports"`
+}
+
+func CreateReconciliationReports(reports []dto.ReconciliationReport) []ReconciliationReport {
+	result := make([]ReconciliationReport, 0, len(reports))
+	for _, v := range reports {
+		mismatches := make([]ReconciliationMismatch, 0, len(v.Mismatches))
+		for _, mismatch := range v.Mismatches {
+			mismatches = append(mismatches, ReconciliationMismatch{
+				BillingId:      mismatch.BillingId,
+				InvoiceId:      mismatch.InvoiceId,
+				Kind:           mismatch.Kind,
+				Paid:           mismatch.Paid,
+				Status:         mismatch.Status,
+				ProviderStatus: mismatch.ProviderStatus,
+				Resolution:     mismatch.Resolution,
+			})
+		}
+
+		result = append(result, ReconciliationReport{
+			Id:         v.ID,
+			StartedAt:  v.StartedAt,
+			FinishedAt: v.FinishedAt,
+			Checked:    v.Checked,
+			Confirmed:  v.Confirmed,
+			Expired:    v.Expired,
+			Errors:     v.Errors,
+			Mismatches: mismatches,
+		})
+	}
+
+	return result
+}
+
 type Id struct {
 	Id uint `json:"id"`
 }
@@ -436,9 +733,10 @@ type UserID struct {
 }
 
 type InvoiceData struct {
-	UserID  UserID  `json:"user_id"`
-	PType   int     `json:"ptype"`
-	Invoice Invoice `json:"invoice"`
+	UserID    UserID  `json:"user_id"`
+	PType     int     `json:"ptype"`
+	Invoice   Invoice `json:"invoice"`
+	Recurrent bool    `json:"recurrent,omitempty"`
 }
 
 func CreateOrder(essentials dto.OrderEssentials, serviceID int, partnerClientID string, ptype int) InvoiceData {
@@ -472,9 +770,204 @@ func CreateOrder(essentials dto.OrderEssentials, serviceID int, partnerClientID
 	return data
 }
 
+type SberInvoice struct {
+	InvoiceId      uint   `json:"invoice_id"`
+	Status         string `json:"status"`
+	RefundedAmount int    `json:"refunded_amount,omitempty"`
+	BindingId      string `json:"binding_id,omitempty"`
+}
+
+type SberRecurrentRequest struct {
+	BindingId string `json:"binding_id"`
+}
+
+type SberRefundRequest struct {
+	Amount int `json:"amount"`
+}
+
+type SberPaymentRequest struct {
+	SuccessUrl string `json:"success_url"`
+	FailUrl    string `json:"fail_url"`
+}
+
+type SberPaymentForm struct {
+	FormUrl string `json:"form_url"`
+}
+
+type SberWebhook struct {
+	InvoiceId      uint   `json:"invoice_id"`
+	Status         string `json:"status"`
+	RefundedAmount int    `json:"refunded_amount,omitempty"`
+	BindingId      string `json:"binding_id,omitempty"`
+}
+
+type PaymentStatus struct {
+	Status     string `json:"status"`
+	CourseName string `json:"courseName,omitempty"`
+}
+
+func CreatePaymentStatus(status, courseName string) *PaymentStatus {
+	return &PaymentStatus{
+		Status:     status,
+		CourseName: courseName,
+	}
+}
+
+type SberError struct {
+	Code        string `json:"code"`
+	Description string `json:"description"`
+}
+
+// taxSystems сопоставляет код системы налогообложения из инвойса с ее обозначением в API онлайн-кассы.
+var taxSystems = map[uint]string{
+	0: "osn",
+	1: "usn_income",
+	2: "usn_income_outcome",
+	3: "envd",
+	4: "esn",
+	5: "patent",
+}
+
+type FiscalClient struct {
+	Email string `json:"email,omitempty"`
+	Phone string `json:"phone,omitempty"`
+}
+
+type FiscalCompany struct {
+	Email          string `json:"email"`
+	Sno            string `json:"sno"`
+	Inn            string `json:"inn"`
+	PaymentAddress string `json:"payment_address"`
+}
+
+type FiscalVat struct {
+	Type string  `json:"type"`
+	Sum  float64 `json:"sum"`
+}
+
+type FiscalItem struct {
+	Name          string    `json:"name"`
+	Price         float64   `json:"price"`
+	Quantity      float64   `json:"quantity"`
+	Sum           float64   `json:"sum"`
+	PaymentMethod string    `json:"payment_method"`
+	PaymentObject string    `json:"payment_object"`
+	Vat           FiscalVat `json:"vat"`
+}
+
+type FiscalPayment struct {
+	Type int     `json:"type"`
+	Sum  float64 `json:"sum"`
+}
+
+type FiscalReceiptBody struct {
+	Client   FiscalClient    `json:"client"`
+	Company  FiscalCompany   `json:"company"`
+	Items    []FiscalItem    `json:"items"`
+	Payments []FiscalPayment `json:"payments"`
+	Total    float64         `json:"total"`
+}
+
+// FiscalReceipt - это запрос на регистрацию чека в онлайн-кассе. ExternalId - номер заказа, по которому
+// онлайн-касса не регистрирует один чек дважды.
+type FiscalReceipt struct {
+	ExternalId string            `json:"external_id"`
+	Operation  string            `json:"operation"`
+	Receipt    FiscalReceiptBody `json:"receipt"`
+}
+
+// fiscalPaymentElectronic - это тип оплаты "безналичными" в чеке.
+const fiscalPaymentElectronic = 1
+
+// CreateFiscalReceipt собирает запрос на регистрацию чека receipt от имени компании company. Суммы в чеке
+// передаются в рублях.
+func CreateFiscalReceipt(receipt dto.Receipt, company FiscalCompany) FiscalReceipt {
+	company.Sno = taxSystems[receipt.TaxSystem]
+
+	body := FiscalReceiptBody{
+		Company: company,
+		Items:   make([]FiscalItem, 0, len(receipt.Items)),
+		Payments: []FiscalPayment{{
+			Type: fiscalPaymentElectronic,
+			Sum:  dto.FromMinorUnits(receipt.Total),
+		}},
+		Total: dto.FromMinorUnits(receipt.Total),
+	}
+	if receipt.Purchaser.Contact == "email" {
+		body.Client.Email = receipt.Purchaser.Email
+	}
+
+	for _, item := range receipt.Items {
+		body.Items = append(body.Items, FiscalItem{
+			Name:          item.Name,
+			Price:         dto.FromMinorUnits(item.Price),
+			Quantity:      float64(item.Quantity),
+			Sum:           dto.FromMinorUnits(item.Amount),
+			PaymentMethod: item.PaymentMethod,
+			PaymentObject: item.PaymentSubject,
+			Vat: FiscalVat{
+				Type: item.Vat,
+				Sum:  dto.FromMinorUnits(item.VatAmount),
+			},
+		})
+	}
+
+	return FiscalReceipt{
+		ExternalId: receipt.Order,
+		Operation:  receipt.Operation,
+		Receipt:    body,
+	}
+}
+
+type FiscalError struct {
+	Code int    `json:"code"`
+	Text string `json:"text"`
+}
+
+type FiscalPayload struct {
+	FnNumber                string `json:"fn_number"`
+	FiscalDocumentNumber    uint   `json:"fiscal_document_number"`
+	FiscalDocumentAttribute string `json:"fiscal_document_attribute"`
+	EcrRegistrationNumber   string `json:"ecr_registration_number"`
+	ReceiptDatetime         string `json:"receipt_datetime"`
+	OfdReceiptUrl           string `json:"ofd_receipt_url"`
+}
+
+// FiscalReport - это ответ онлайн-кассы о чеке: статус регистрации, ошибка и фискальные данные.
+type FiscalReport struct {
+	Uuid    string         `json:"uuid"`
+	Status  string         `json:"status"`
+	Error   *FiscalError   `json:"error,omitempty"`
+	Payload *FiscalPayload `json:"payload,omitempty"`
+}
+
+// fiscalDatetimeLayout - это формат времени регистрации чека в ответе онлайн-кассы. Время передается по Москве.
+const fiscalDatetimeLayout = "02.01.2006 15:04:05"
+
+var fiscalLocation = time.FixedZone("MSK", 3*60*60)
+
+// CreateFiscalData собирает фискальные данные из ответа онлайн-кассы о зарегистрированном чеке.
+func CreateFiscalData(payload FiscalPayload) *dto.FiscalData {
+	fiscalData := &dto.FiscalData{
+		FiscalDriveNumber:    payload.FnNumber,
+		FiscalDocumentNumber: payload.FiscalDocumentNumber,
+		FiscalSign:           payload.FiscalDocumentAttribute,
+		RegisterNumber:       payload.EcrRegistrationNumber,
+		ReceiptUrl:           payload.OfdReceiptUrl,
+	}
+
+	if fiscalizedAt, err := time.ParseInLocation(fiscalDatetimeLayout, payload.ReceiptDatetime, fiscalLocation); err == nil {
+		fiscalData.FiscalizedAt = &fiscalizedAt
+	}
+
+	return fiscalData
+}
+
 type BuyDetails struct {
-	CourseId  uint `json:"courseId"`
-	IsRusCard bool `json:"isRusCard"`
+	CourseId  uint   `json:"courseId"`
+	IsRusCard bool   `json:"isRusCard"`
+	Currency  string `json:"currency,omitempty"`
+	PromoCode string `json:"promoCode,omitempty"`
 }
 
 func CreateNewBuyDetails() *BuyDetails {
@@ -503,6 +996,356 @@ func CreateNewFailedPayment() *FailedPayment {
 	}
 }
 
+type SubscribeDetails struct {
+	PlanId    uint `json:"planId"`
+	IsRusCard bool `json:"isRusCard"`
+}
+
+func CreateNewSubscribeDetails() *SubscribeDetails {
+	return &SubscribeDetails{}
+}
+
+type SubscriptionPlanToSave struct {
+	Name       string `json:"name"`
+	Period     string `json:"period"`
+	Price      uint   `json:"price"`
+	AllCourses bool   `json:"allCourses"`
+	CourseIds  []uint `json:"courseIds"`
+	Active     *bool  `json:"active"`
+}
+
+func CreateNewSubscriptionPlanToSave() *SubscriptionPlanToSave {
+	return &SubscriptionPlanToSave{}
+}
+
+type SubscriptionPlan struct {
+	Id         uint   `json:"id"`
+	Name       string `json:"name"`
+	Period     string `json:"period"`
+	Price      uint   `json:"price"`
+	AllCourses bool   `json:"allCourses"`
+	CourseIds  []uint `json:"courseIds"`
+	Active     bool   `json:"active"`
+}
+
+func CreateSubscriptionPlan(plan dto.SubscriptionPlan) *SubscriptionPlan {
+	courseIds := make([]uint, 0, len(plan.Courses))
+	for _, v := range plan.Courses {
+		courseIds = append(courseIds, v.ID)
+	}
+
+	return &SubscriptionPlan{
+		Id:         plan.ID,
+		Name:       plan.Name,
+		Period:     plan.Period,
+		Price:      plan.Price,
+		AllCourses: plan.AllCourses,
+		CourseIds:  courseIds,
+		Active:     plan.Active,
+	}
+}
+
+func CreateSubscriptionPlans(plans []dto.SubscriptionPlan) []SubscriptionPlan {
+	result := make([]SubscriptionPlan, 0, len(plans))
+	for _, v := range plans {
+		result = append(result, *CreateSubscriptionPlan(v))
+	}
+
+	return result
+}
+
+type Subscription struct {
+	Id                 uint             `json:"id"`
+	Plan               SubscriptionPlan `json:"plan"`
+	Status             string           `json:"status"`
+	CurrentPeriodStart *time.Time       `json:"currentPeriodStart"`
+	CurrentPeriodEnd   *time.Time       `json:"currentPeriodEnd"`
+	GraceUntil         *time.Time       `json:"graceUntil,omitempty"`
+	CancelAtPeriodEnd  bool             `json:"cancelAtPeriodEnd"`
+	HasAccess          bool             `json:"hasAccess"`
+}
+
+func CreateSubscription(subscription dto.Subscription, now time.Time) *Subscription {
+	return &Subscription{
+		Id:                 subscription.ID,
+		Plan:               *CreateSubscriptionPlan(subscription.Plan),
+		Status:             subscription.Status,
+		CurrentPeriodStart: subscription.CurrentPeriodStart,
+		CurrentPeriodEnd:   subscription.CurrentPeriodEnd,
+		GraceUntil:         subscription.GraceUntil,
+		CancelAtPeriodEnd:  subscription.CancelAtPeriodEnd,
+		HasAccess:          subscription.HasAccessAt(now),
+	}
+}
+
+type CartDetails struct {
+	CourseIds []uint `json:"courseIds"`
+	BundleIds []uint `json:"bundleIds"`
+	IsRusCard bool   `json:"isRusCard"`
+	Currency  string `json:"currency,omitempty"`
+	PromoCode string `json:"promoCode,omitempty"`
+}
+
+func CreateNewCartDetails() *CartDetails {
+	return &CartDetails{}
+}
+
+type BundleToSave struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Price       uint   `json:"price"`
+	CourseIds   []uint `json:"courseIds"`
+	Active      *bool  `json:"active"`
+}
+
+func CreateNewBundleToSave() *BundleToSave {
+	return &BundleToSave{}
+}
+
+type Bundle struct {
+	Id           uint   `json:"id"`
+	Name         string `json:"name"`
+	Description  string `json:"description"`
+	Price        uint   `json:"price"`
+	CoursesPrice uint   `json:"coursesPrice"`
+	CourseIds    []uint `json:"courseIds"`
+	Active       bool   `json:"active"`
+}
+
+func CreateBundle(bundle dto.Bundle) *Bundle {
+	courseIds := make([]uint, 0, len(bundle.Courses))
+	for _, v := range bundle.Courses {
+		courseIds = append(courseIds, v.ID)
+	}
+
+	return &Bundle{
+		Id:           bundle.ID,
+		Name:         bundle.Name,
+		Description:  bundle.Description,
+		Price:        bundle.Price,
+		CoursesPrice: bundle.CoursesCost(),
+		CourseIds:    courseIds,
+		Active:       bundle.Active,
+	}
+}
+
+func CreateBundles(bundles []dto.Bundle) []Bundle {
+	result := make([]Bundle, 0, len(bundles))
+	for _, v := range bundles {
+		result = append(result, *CreateBundle(v))
+	}
+
+	return result
+}
+
+// CoursePriceToSave - это цена курса в валюте, Amount передается в минимальных единицах валюты.
+type CoursePriceToSave struct {
+	Currency string `json:"currency"`
+	Amount   uint   `json:"amount"`
+}
+
+type CoursePricesToSave struct {
+	Prices []CoursePriceToSave `json:"prices"`
+}
+
+func CreateNewCoursePricesToSave() *CoursePricesToSave {
+	return &CoursePricesToSave{}
+}
+
+// CoursePrice - это цена курса в валюте, Amount передается в минимальных единицах валюты.
+type CoursePrice struct {
+	Currency string `json:"currency"`
+	Amount   uint   `json:"amount"`
+}
+
+func CreateCoursePrices(prices []dto.CoursePrice) []CoursePrice {
+	result := make([]CoursePrice, 0, len(prices))
+	for _, v := range prices {
+		result = append(result, CoursePrice{
+			Currency: v.Currency,
+			Amount:   v.Amount,
+		})
+	}
+
+	return result
+}
+
+type GiftOrderDetails struct {
+	CourseId       uint   `json:"courseId"`
+	IsRusCard      bool   `json:"isRusCard"`
+	Currency       string `json:"currency,omitempty"`
+	PromoCode      string `json:"promoCode,omitempty"`
+	RecipientEmail string `json:"recipientEmail,omitempty"`
+}
+
+func CreateNewGiftOrderDetails() *GiftOrderDetails {
+	return &GiftOrderDetails{}
+}
+
+type GiftCode struct {
+	Code string `json:"code"`
+}
+
+func CreateNewGiftCode() *GiftCode {
+	return &GiftCode{}
+}
+
+type Gift struct {
+	Id             uint       `json:"id"`
+	Code           string     `json:"code"`
+	CourseId       uint       `json:"courseId"`
+	CourseName     string     `json:"courseName"`
+	BuyerEmail     string     `json:"buyerEmail"`
+	RecipientEmail string     `json:"recipientEmail,omitempty"`
+	RecipientId    *uint      `json:"recipientId,omitempty"`
+	Status         string     `json:"status"`
+	RedeemedAt     *time.Time `json:"redeemedAt,omitempty"`
+	VoidedAt       *time.Time `json:"voidedAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
+
+func CreateGift(gift dto.GiftDetails) *Gift {
+	return &Gift{
+		Id:             gift.GiftId,
+		Code:           gift.Code,
+		CourseId:       gift.CourseId,
+		CourseName:     gift.CourseName,
+		BuyerEmail:     gift.BuyerEmail,
+		RecipientEmail: gift.RecipientEmail,
+		RecipientId:    gift.RecipientId,
+		Status:         gift.Status(),
+		RedeemedAt:     gift.RedeemedAt,
+		VoidedAt:       gift.VoidedAt,
+		CreatedAt:      gift.CreatedAt,
+	}
+}
+
+func CreateGifts(gifts []dto.GiftDetails) []Gift {
+	result := make([]Gift, 0, len(gifts))
+	for _, v := range gifts {
+		result = append(result, *CreateGift(v))
+	}
+
+	return result
+}
+
+type GiftsWithPagination struct {
+	Pagination Pagination `json:"pagination"`
+	Gifts      []Gift     `json:"gifts"`
+}
+
+type PromoCodeToSave struct {
+	Code              string     `json:"code"`
+	DiscountType      string     `json:"discountType"`
+	Value             uint       `json:"value"`
+	CourseId          *uint      `json:"courseId"`
+	MaxRedemptions    uint       `json:"maxRedemptions"`
+	MaxPerUser        uint       `json:"maxPerUser"`
+	ValidFrom         *time.Time `json:"validFrom"`
+	ValidUntil        *time.Time `json:"validUntil"`
+	FirstPurchaseOnly bool       `json:"firstPurchaseOnly"`
+	Active            *bool      `json:"active"`
+}
+
+func CreateNewPromoCodeToSave() *PromoCodeToSave {
+	return &PromoCodeToSave{}
+}
+
+// PromoStats - это использования промокода, Discount - сумма скидок в минимальных единицах валюты.
+type PromoStats struct {
+	PromoCodeId uint   `json:"-"`
+	Code        string `json:"code"`
+	Currency    string `json:"currency,omitempty"`
+	Redemptions int    `json:"redemptions"`
+	Discount    uint   `json:"discount"`
+}
+
+type PromoCode struct {
+	Id                uint       `json:"id"`
+	Code              string     `json:"code"`
+	DiscountType      string     `json:"discountType"`
+	Value             uint       `json:"value"`
+	CourseId          *uint      `json:"courseId"`
+	MaxRedemptions    uint       `json:"maxRedemptions"`
+	MaxPerUser        uint       `json:"maxPerUser"`
+	ValidFrom         *time.Time `json:"validFrom"`
+	ValidUntil        *time.Time `json:"validUntil"`
+	FirstPurchaseOnly bool       `json:"firstPurchaseOnly"`
+	Active            bool       `json:"active"`
+	Redemptions       int        `json:"redemptions"`
+	TotalDiscount     uint       `json:"totalDiscount"`
+	CreatedAt         time.Time  `json:"createdAt"`
+}
+
+func CreatePromoCode(promo dto.PromoCode) *PromoCode {
+	return &PromoCode{
+		Id:                promo.ID,
+		Code:              promo.Code,
+		DiscountType:      promo.DiscountType,
+		Value:             promo.Value,
+		CourseId:          promo.CourseId,
+		MaxRedemptions:    promo.MaxRedemptions,
+		MaxPerUser:        promo.MaxPerUser,
+		ValidFrom:         promo.ValidFrom,
+		ValidUntil:        promo.ValidUntil,
+		FirstPurchaseOnly: promo.FirstPurchaseOnly,
+		Active:            promo.Active,
+		CreatedAt:         promo.CreatedAt,
+	}
+}
+
+func CreatePromoCodes(promos []dto.PromoCode, stats []PromoStats) []PromoCode {
+	statsById := make(map[uint]PromoStats, len(stats))
+	for _, v := range stats {
+		statsById[v.PromoCodeId] = v
+	}
+
+	result := make([]PromoCode, 0, len(promos))
+	for _, v := range promos {
+		promo := CreatePromoCode(v)
+		promo.Redemptions = statsById[v.ID].Redemptions
+		promo.TotalDiscount = statsById[v.ID].Discount
+		result = append(result, *promo)
+	}
+
+	return result
+}
+
+type PromoCodesWithPagination struct {
+	Pagination Pagination  `json:"pagination"`
+	PromoCodes []PromoCode `json:"promoCodes"`
+}
+
+// RefundRequest - это запрос админа на возврат, Amount передается в минимальных единицах валюты платежа.
+type RefundRequest struct {
+	BillingId uint   `json:"billingId"`
+	Amount    uint   `json:"amount"`
+	Reason    string `json:"reason"`
+}
+
+func CreateNewRefundRequest() *RefundRequest {
+	return &RefundRequest{}
+}
+
+// RefundResult - это платеж после возврата. Суммы передаются в минимальных единицах валюты платежа.
+type RefundResult struct {
+	BillingId     uint   `json:"billingId"`
+	InvoiceId     uint   `json:"invoiceId"`
+	Status        string `json:"status"`
+	Amount        uint   `json:"amount"`
+	RefundedTotal uint   `json:"refundedTotal"`
+}
+
+func CreateRefundResult(billingId, invoiceId uint, status string, amount, refundedTotal uint) *RefundResult {
+	return &RefundResult{
+		BillingId:     billingId,
+		InvoiceId:     invoiceId,
+		Status:        status,
+		Amount:        amount,
+		RefundedTotal: refundedTotal,
+	}
+}
+
 type BillingHost struct {
 	Url string `json:"url"`
 }
@@ -568,23 +1411,70 @@ type AdminsInfoWithPagination struct {
 	AdminInfo  []Admin    `json:"adminInfo"`
 }
 
+// PaymentStats - это статистика платежей за день. Суммы считаются отдельно по каждой валюте в Revenue,
+// валюты без платежей и возвратов за день не выводятся.
 type PaymentStats struct {
-	Date           time.Time `json:"date"`
-	TotalPaid      uint      `json:"totalPaid"`
-	TotalPurchased int       `json:"totalPurchased"`
-}
+	Date           time.Time         `json:"date"`
+	TotalPurchased int               `json:"totalPurchased"`
+	TotalRefunds   int               `json:"totalRefunds"`
+	PromoPurchases int               `json:"promoPurchases"`
+	Revenue        []CurrencyRevenue `json:"revenue"`
+	PromoCodes     []PromoStats      `json:"promoCodes"`
+}
+
+// CurrencyRevenue - это выручка за день в одной валюте. Суммы передаются в минимальных единицах валюты.
+type CurrencyRevenue struct {
+	Currency       string `json:"currency"`
+	TotalPaid      uint   `json:"totalPaid"`
+	TotalPurchased int    `json:"totalPurchased"`
+	TotalRefunded  uint   `json:"totalRefunded"`
+	TotalRefunds   int    `json:"totalRefunds"`
+	NetRevenue     int    `json:"netRevenue"`
+	PromoDiscount  uint   `json:"promoDiscount"`
+}
+
+func CreateNewPaymentStats(date time.Time, billing []dto.Billing, refunds []dto.Refund, promos []PromoStats) *PaymentStats {
+	revenue := make(map[string]*CurrencyRevenue, len(dto.Currencies))
+	currencyRevenue := func(currency string) *CurrencyRevenue {
+		if _, ok := revenue[currency]; !ok {
+			revenue[currency] = &CurrencyRevenue{Currency: currency}
+		}
+		return revenue[currency]
+	}
 
-func CreateNewPaymentStats(date time.Time, billing []dto.Billing) *PaymentStats {
-	var paid uint
+	var promoPurchases int
+	for _, v := range promos {
+		currencyRevenue(v.Currency).PromoDiscount += v.Discount
+		promoPurchases += v.Redemptions
+	}
 
 	for _, v := range billing {
-		paid += uint(v.Price)
+		stats := currencyRevenue(v.Currency)
+		stats.TotalPaid += v.Amount
+		stats.TotalPurchased++
+	}
+
+	for _, v := range refunds {
+		stats := currencyRevenue(v.Currency)
+		stats.TotalRefunded += v.Amount
+		stats.TotalRefunds++
+	}
+
+	currencies := make([]CurrencyRevenue, 0, len(revenue))
+	for _, currency := range dto.Currencies {
+		if stats, ok := revenue[currency]; ok {
+			stats.NetRevenue = int(stats.TotalPaid) - int(stats.TotalRefunded)
+			currencies = append(currencies, *stats)
+		}
 	}
 
 	return &PaymentStats{
 		Date:           date,
-		TotalPaid:      paid,
 		TotalPurchased: len(billing),
+		TotalRefunds:   len(refunds),
+		PromoPurchases: promoPurchases,
+		Revenue:        currencies,
+		PromoCodes:     promos,
 	}
 }
 
@@ -599,3 +1489,29 @@ func CreateNewUsersStats(date time.Time, users int) *UsersStats {
 		RegistredUsers: users,
 	}
 }
+
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	Alg string `json:"alg"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+type JWKS struct {
+	Keys []JWK `json:"keys"`
+}
+
+func CreateJWKS() *JWKS {
+	return &JWKS{
+		Keys: make([]JWK, 0),
+	}
+}
+
+func (jwks *JWKS) AddKey(key JWK) *JWKS {
+	jwks.Keys = append(jwks.Keys, key)
+	return jwks
+}
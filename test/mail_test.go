@@ -0,0 +1,131 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+
+	"github.com/knstch/course/internal/app"
+	"github.com/knstch/course/internal/app/services/email"
+)
+
+var (
+	confirmCodeRegexp = regexp.MustCompile(`: (\d{4})`)
+)
+
+// receivedMail - это письмо из MemoryTransport, разобранное на тему, текстовую и HTML версии.
+type receivedMail struct {
+	subject string
+	text    string
+	html    string
+}
+
+// lastMail отправляет все письма из outbox через транспорт контейнера и возвращает последнее письмо,
+// отправленное на почту. Контейнер должен быть собран с MAIL_TRANSPORT=memory.
+func lastMail(container *app.Container, recipient string) (*receivedMail, error) {
+	transport, ok := container.MailTransport.(*email.MemoryTransport)
+	if !ok {
+		return nil, errors.New("контейнер собран не с MAIL_TRANSPORT=memory")
+	}
+
+	for container.EmailSender.SendBatch(context.Background()) != 0 {
+	}
+
+	sentMail, ok := transport.LastMail(recipient)
+	if !ok {
+		return nil, fmt.Errorf("на почту %v не было писем", recipient)
+	}
+
+	return parseMail(sentMail.Message)
+}
+
+// mailCode возвращает код подтверждения из последнего письма на почту.
+func mailCode(container *app.Container, recipient string) (string, error) {
+	received, err := lastMail(container, recipient)
+	if err != nil {
+		return "", err
+	}
+
+	match := confirmCodeRegexp.FindStringSubmatch(received.text)
+	if match == nil {
+		return "", fmt.Errorf("в письме %q нет кода подтверждения", received.subject)
+	}
+
+	return match[1], nil
+}
+
+// wrongCode возвращает код подтверждения, который точно не совпадает с настоящим.
+func wrongCode(code string) string {
+	if code == "2222" {
+		return "3333"
+	}
+
+	return "2222"
+}
+
+func parseMail(message []byte) (*receivedMail, error) {
+	parsed, err := mail.ReadMessage(strings.NewReader(string(message)))
+	if err != nil {
+		return nil, err
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil {
+		return nil, err
+	}
+
+	received := &receivedMail{
+		subject: subject,
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		text, err := io.ReadAll(quotedprintable.NewReader(parsed.Body))
+		if err != nil {
+			return nil, err
+		}
+		received.text = normalizeLineBreaks(text)
+		return received, nil
+	}
+
+	reader := multipart.NewReader(parsed.Body, params["boundary"])
+	for {
+		part, err := reader.NextPart()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		content, err := io.ReadAll(part)
+		if err != nil {
+			return nil, err
+		}
+
+		switch {
+		case strings.HasPrefix(part.Header.Get("Content-Type"), "text/plain"):
+			received.text = normalizeLineBreaks(content)
+		case strings.HasPrefix(part.Header.Get("Content-Type"), "text/html"):
+			received.html = normalizeLineBreaks(content)
+		}
+	}
+
+	return received, nil
+}
+
+// normalizeLineBreaks заменяет переводы строк CRLF, в которые quoted-printable переводит текст письма, на LF.
+func normalizeLineBreaks(content []byte) string {
+	return strings.ReplaceAll(string(content), "\r\n", "\n")
+}
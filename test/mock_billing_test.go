@@ -0,0 +1,1096 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+// mockReminderStorage - это хранилище просроченных заказов в памяти для тестов напоминаний о покупке.
+// Заказ отдается, пока по нему не отмечено напоминание.
+type mockReminderStorage struct {
+	mu            sync.Mutex
+	orders        []dto.PurchaseDetails
+	createdAfter  time.Time
+	createdBefore time.Time
+	reminders     map[uint]dto.OutboxEmail
+}
+
+func (storage *mockReminderStorage) GetExpiredOrders(ctx context.Context, createdAfter, createdBefore time.Time, limit int) ([]dto.PurchaseDetails, *courseError.CourseError) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	storage.createdAfter = createdAfter
+	storage.createdBefore = createdBefore
+
+	var orders []dto.PurchaseDetails
+	for _, v := range storage.orders {
+		if _, ok := storage.reminders[v.BillingId]; !ok && len(orders) < limit {
+			orders = append(orders, v)
+		}
+	}
+
+	return orders, nil
+}
+
+func (storage *mockReminderStorage) MarkOrderReminded(ctx context.Context, billingId uint, reminderEmail *dto.OutboxEmail) *courseError.CourseError {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	storage.reminders[billingId] = *reminderEmail
+
+	return nil
+}
+
+// mockBanker - это биллинг в памяти для тестов уведомлений от банка, возвратов и промокодов. Запоминает чеки,
+// напоминания и письма о возврате, поставленные в outbox, статус заказа меняется в details. Заказы не создаются,
+// запоминаются только переданные в заказ курсы, валюта и промокод. Купленными считаются курсы из ownedCourses. Письма
+// получателям подарков запоминаются в giftEmails, подарки хранятся в gifts. Все курсы стоят 1000 рублей, цены
+// в остальных валютах берутся из coursePrices. Фискальные чеки переотправляются в receiptStorage. Платежи за
+// отдельные курсы корзины хранятся в billings по ID платежа, возвраты по ним запоминаются под ID инвойса.
+type mockBanker struct {
+	mu              sync.Mutex
+	details         map[uint]*dto.PurchaseDetails
+	billings        map[uint]*dto.PurchaseDetails
+	receipts        map[uint][]dto.OutboxEmail
+	reminders       map[uint][]dto.OutboxEmail
+	refunds         map[uint][]dto.Refund
+	refundEmails    map[uint][]dto.OutboxEmail
+	promos          map[uint]*dto.PromoCode
+	promoStats      []entity.PromoStats
+	orderPromoCodes []string
+	orderItems      [][]dto.CheckoutItem
+	orderCurrencies []string
+	ownedCourses    []uint
+	lastPromoCodeId uint
+	plans           map[uint]*dto.SubscriptionPlan
+	subscriptions   map[uint]*dto.Subscription
+	subPayments     map[uint]*dto.SubscriptionPayment
+	bundles         map[uint]*dto.Bundle
+	lastBundleId    uint
+	giftEmails      map[uint][]dto.OutboxEmail
+	gifts           []*dto.GiftDetails
+	coursePrices    map[uint][]dto.CoursePrice
+	receiptStorage  *mockReceiptStorage
+	reports         []dto.ReconciliationReport
+}
+
+func (banker *mockBanker) CreateNewOrder(ctx context.Context, items []dto.CheckoutItem, ruCard bool, currency, promoCode string) (*dto.OrderEssentials, *courseError.CourseError) {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	banker.orderPromoCodes = append(banker.orderPromoCodes, promoCode)
+	banker.orderItems = append(banker.orderItems, items)
+	banker.orderCurrencies = append(banker.orderCurrencies, currency)
+
+	return nil, courseError.CreateError(errors.New("не поддерживается"), 500)
+}
+
+func (banker *mockBanker) GetCourseCost(ctx context.Context, courseId uint, currency string) (*uint, *courseError.CourseError) {
+	prices, err := banker.GetCoursePrices(ctx, courseId)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range prices {
+		if v.Currency == currency {
+			return &v.Amount, nil
+		}
+	}
+
+	return nil, courseError.CreateError(errors.New("курс не продается в этой валюте"), 15027)
+}
+
+func (banker *mockBanker) StoreCoursePrices(ctx context.Context, courseId uint, prices []dto.CoursePrice) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	if banker.coursePrices == nil {
+		banker.coursePrices = make(map[uint][]dto.CoursePrice)
+	}
+	banker.coursePrices[courseId] = prices
+
+	return nil
+}
+
+func (banker *mockBanker) GetCoursePrices(ctx context.Context, courseId uint) ([]dto.CoursePrice, *courseError.CourseError) {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	return append([]dto.CoursePrice{*dto.CreateNewCoursePrice(courseId, dto.CurrencyRUB, 100000)}, banker.coursePrices[courseId]...), nil
+}
+
+func (banker *mockBanker) SetInvoiceId(ctx context.Context, invoiceId, orderId uint) *courseError.CourseError {
+	return nil
+}
+
+func (banker *mockBanker) ApprovePayment(ctx context.Context, invoiceId uint, receiptEmail, giftEmail *dto.OutboxEmail) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	details, ok := banker.details[invoiceId]
+	if !ok {
+		return courseError.CreateError(errors.New("инвойс не найден"), 15001)
+	}
+
+	if details.Status == dto.BillingStatusPending {
+		details.Status = dto.BillingStatusPaid
+		banker.receipts[invoiceId] = append(banker.receipts[invoiceId], *receiptEmail)
+		if giftEmail != nil {
+			banker.giftEmails[invoiceId] = append(banker.giftEmails[invoiceId], *giftEmail)
+		}
+	}
+
+	return nil
+}
+
+func (banker *mockBanker) FailOrder(ctx context.Context, invoiceId string, reminderEmail *dto.OutboxEmail) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	id, _ := strconv.ParseUint(invoiceId, 10, 64)
+	details, ok := banker.details[uint(id)]
+	if !ok {
+		return courseError.CreateError(errors.New("инвойс не найден"), 15001)
+	}
+
+	if details.Status == dto.BillingStatusPending {
+		details.Status = dto.BillingStatusFailed
+		banker.reminders[uint(id)] = append(banker.reminders[uint(id)], *reminderEmail)
+	}
+
+	return nil
+}
+
+func (banker *mockBanker) GetUserCourses(ctx context.Context) ([]dto.Order, *courseError.CourseError) {
+	orders := make([]dto.Order, 0, len(banker.ownedCourses))
+	for _, v := range banker.ownedCourses {
+		orders = append(orders, dto.Order{CourseId: v})
+	}
+
+	return orders, nil
+}
+
+func (banker *mockBanker) GetPurchaseDetails(ctx context.Context, invoiceId string) (*dto.PurchaseDetails, *courseError.CourseError) {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	id, _ := strconv.ParseUint(invoiceId, 10, 64)
+	details, ok := banker.details[uint(id)]
+	if !ok {
+		return nil, courseError.CreateError(errors.New("инвойс не найден"), 15001)
+	}
+
+	copied := *details
+	return &copied, nil
+}
+
+func (banker *mockBanker) GetBillingPurchaseDetails(ctx context.Context, billingId uint) (*dto.PurchaseDetails, *courseError.CourseError) {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	bill := banker.findBilling(billingId)
+	if bill == nil {
+		return nil, courseError.CreateError(errors.New("инвойс не найден"), 15001)
+	}
+
+	copied := *bill
+	return &copied, nil
+}
+
+func (banker *mockBanker) findBilling(billingId uint) *dto.PurchaseDetails {
+	if bill, ok := banker.billings[billingId]; ok {
+		return bill
+	}
+
+	for _, v := range banker.details {
+		if v.BillingId == billingId {
+			return v
+		}
+	}
+
+	return nil
+}
+
+func (banker *mockBanker) RefundBilling(ctx context.Context, billingId, amount, invoiceRefundedTotal uint, refund *dto.Refund, refundEmail *dto.OutboxEmail) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	bill := banker.findBilling(billingId)
+	if bill == nil {
+		return courseError.CreateError(errors.New("инвойс не найден"), 15001)
+	}
+
+	var invoiceRefunded uint
+	for _, v := range banker.billings {
+		if v.InvoiceId == bill.InvoiceId {
+			invoiceRefunded += v.RefundedAmount
+		}
+	}
+	if _, ok := banker.billings[billingId]; !ok {
+		invoiceRefunded = bill.RefundedAmount
+	}
+
+	if invoiceRefunded >= invoiceRefundedTotal {
+		return nil
+	}
+
+	if amount > bill.Amount-bill.RefundedAmount {
+		return courseError.CreateError(errors.New("сумма возврата больше оплаченной"), 15009)
+	}
+
+	status := dto.RefundStatus(bill.Amount, bill.RefundedAmount+amount)
+	if !dto.CanChangeBillingStatus(bill.Status, status) {
+		return courseError.CreateError(errors.New("платеж нельзя вернуть"), 15008)
+	}
+
+	refund.BillingId = billingId
+	refund.Amount = amount
+	banker.refunds[bill.InvoiceId] = append(banker.refunds[bill.InvoiceId], *refund)
+	banker.refundEmails[bill.InvoiceId] = append(banker.refundEmails[bill.InvoiceId], *refundEmail)
+
+	bill.Status = status
+	bill.RefundedAmount += amount
+
+	return nil
+}
+
+func (banker *mockBanker) RefundPayment(ctx context.Context, invoiceId uint, refundedTotal uint, refund *dto.Refund, refundEmail *dto.OutboxEmail) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	details, ok := banker.details[invoiceId]
+	if !ok {
+		return courseError.CreateError(errors.New("инвойс не найден"), 15001)
+	}
+
+	if refundedTotal <= details.RefundedAmount {
+		return nil
+	}
+
+	status := dto.RefundStatus(details.Amount, refundedTotal)
+	if !dto.CanChangeBillingStatus(details.Status, status) {
+		return courseError.CreateError(errors.New("платеж нельзя вернуть"), 15008)
+	}
+
+	refund.BillingId = details.BillingId
+	refund.Amount = refundedTotal - details.RefundedAmount
+	banker.refunds[invoiceId] = append(banker.refunds[invoiceId], *refund)
+	banker.refundEmails[invoiceId] = append(banker.refundEmails[invoiceId], *refundEmail)
+
+	details.Status = status
+	details.RefundedAmount = refundedTotal
+
+	return nil
+}
+
+func (banker *mockBanker) GetReconciliationReports(ctx context.Context, limit, offset int) ([]dto.ReconciliationReport, int64, *courseError.CourseError) {
+	return nil, 0, nil
+}
+
+func (banker *mockBanker) SaveReconciliationReport(ctx context.Context, report *dto.ReconciliationReport) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	banker.reports = append(banker.reports, *report)
+
+	return nil
+}
+
+func (banker *mockBanker) StorePromoCode(ctx context.Context, promo *dto.PromoCode) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	for _, v := range banker.promos {
+		if v.Code == promo.Code {
+			return courseError.CreateError(errors.New("такой промокод уже существует"), 15015)
+		}
+	}
+
+	banker.lastPromoCodeId++
+	promo.ID = banker.lastPromoCodeId
+	copied := *promo
+	banker.promos[promo.ID] = &copied
+
+	return nil
+}
+
+func (banker *mockBanker) UpdatePromoCode(ctx context.Context, promo *dto.PromoCode) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	if _, ok := banker.promos[promo.ID]; !ok {
+		return courseError.CreateError(errors.New("промокод не найден"), 15010)
+	}
+
+	for _, v := range banker.promos {
+		if v.Code == promo.Code && v.ID != promo.ID {
+			return courseError.CreateError(errors.New("такой промокод уже существует"), 15015)
+		}
+	}
+
+	copied := *promo
+	banker.promos[promo.ID] = &copied
+
+	return nil
+}
+
+func (banker *mockBanker) DeletePromoCode(ctx context.Context, id uint) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	if _, ok := banker.promos[id]; !ok {
+		return courseError.CreateError(errors.New("промокод не найден"), 15010)
+	}
+
+	delete(banker.promos, id)
+
+	return nil
+}
+
+func (banker *mockBanker) GetPromoCodes(ctx context.Context, limit, offset int) ([]dto.PromoCode, []entity.PromoStats, int64, *courseError.CourseError) {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	promos := dto.CreateNewPromoCodes()
+	for id := banker.lastPromoCodeId; id > 0; id-- {
+		if v, ok := banker.promos[id]; ok {
+			promos = append(promos, *v)
+		}
+	}
+
+	total := int64(len(promos))
+	if offset > len(promos) {
+		offset = len(promos)
+	}
+	promos = promos[offset:]
+	if limit < len(promos) {
+		promos = promos[:limit]
+	}
+
+	return promos, banker.promoStats, total, nil
+}
+
+func (banker *mockBanker) StoreSubscriptionPlan(ctx context.Context, plan *dto.SubscriptionPlan) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	for _, v := range banker.plans {
+		if v.Name == plan.Name {
+			return courseError.CreateError(errors.New("план с таким названием уже существует"), 15019)
+		}
+	}
+
+	plan.ID = uint(len(banker.plans) + 1)
+	copied := *plan
+	banker.plans[plan.ID] = &copied
+
+	return nil
+}
+
+func (banker *mockBanker) UpdateSubscriptionPlan(ctx context.Context, plan *dto.SubscriptionPlan) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	if _, ok := banker.plans[plan.ID]; !ok {
+		return courseError.CreateError(errors.New("план подписки не найден"), 15017)
+	}
+
+	copied := *plan
+	banker.plans[plan.ID] = &copied
+
+	return nil
+}
+
+func (banker *mockBanker) GetSubscriptionPlans(ctx context.Context, onlyActive bool) ([]dto.SubscriptionPlan, *courseError.CourseError) {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	plans := dto.CreateNewSubscriptionPlans()
+	for id := uint(1); id <= uint(len(banker.plans)); id++ {
+		if v := banker.plans[id]; !onlyActive || v.Active {
+			plans = append(plans, *v)
+		}
+	}
+
+	return plans, nil
+}
+
+func (banker *mockBanker) CreateSubscription(ctx context.Context, planId uint, ruCard bool) (*dto.SubscriptionCharge, *courseError.CourseError) {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	userId := ctx.Value("UserId").(uint)
+
+	plan, ok := banker.plans[planId]
+	if !ok || !plan.Active {
+		return nil, courseError.CreateError(errors.New("план подписки не найден"), 15017)
+	}
+
+	for _, v := range banker.subscriptions {
+		if v.UserId == userId && (v.Status == dto.SubscriptionStatusActive || v.Status == dto.SubscriptionStatusPastDue) {
+			return nil, courseError.CreateError(errors.New("подписка уже оформлена"), 15016)
+		}
+	}
+
+	subscription := dto.CreateNewSubscription(userId, planId, ruCard)
+	subscription.ID = uint(len(banker.subscriptions) + 1)
+	subscription.CreatedAt = time.Now()
+	subscription.Plan = *plan
+	banker.subscriptions[subscription.ID] = subscription
+
+	return &dto.SubscriptionCharge{
+		SubscriptionId: subscription.ID,
+		PaymentId:      banker.addSubscriptionPayment(subscription.ID, dto.ToMinorUnits(plan.Price)),
+		UserId:         userId,
+		PlanId:         planId,
+		PlanName:       plan.Name,
+		Amount:         dto.ToMinorUnits(plan.Price),
+		RusCard:        ruCard,
+		Email:          fmt.Sprintf("user-%d@gmail.com", userId),
+	}, nil
+}
+
+func (banker *mockBanker) addSubscriptionPayment(subscriptionId, amount uint) uint {
+	payment := dto.CreateNewSubscriptionPayment(subscriptionId, amount)
+	payment.ID = uint(len(banker.subPayments) + 1)
+	payment.CreatedAt = time.Now()
+	banker.subPayments[payment.ID] = payment
+
+	return payment.ID
+}
+
+func (banker *mockBanker) SetSubscriptionInvoiceId(ctx context.Context, invoiceId, paymentId uint) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	banker.subPayments[paymentId].InvoiceId = invoiceId
+
+	return nil
+}
+
+func (banker *mockBanker) findSubscriptionPayment(invoiceId uint) (*dto.SubscriptionPayment, *courseError.CourseError) {
+	for _, v := range banker.subPayments {
+		if v.InvoiceId == invoiceId {
+			return v, nil
+		}
+	}
+
+	return nil, courseError.CreateError(errors.New("инвойс не найден"), 15001)
+}
+
+func (banker *mockBanker) GetSubscriptionPayment(ctx context.Context, invoiceId uint) (*dto.SubscriptionPayment, *courseError.CourseError) {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	payment, err := banker.findSubscriptionPayment(invoiceId)
+	if err != nil {
+		return nil, err
+	}
+
+	copied := *payment
+	copied.Subscription = *banker.subscriptions[payment.SubscriptionId]
+
+	return &copied, nil
+}
+
+func (banker *mockBanker) PaySubscription(ctx context.Context, invoiceId uint, bindingId string) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	payment, err := banker.findSubscriptionPayment(invoiceId)
+	if err != nil {
+		return err
+	}
+
+	if payment.Status != dto.BillingStatusPending {
+		return nil
+	}
+
+	subscription := banker.subscriptions[payment.SubscriptionId]
+
+	start := time.Now()
+	if subscription.CurrentPeriodEnd != nil &&
+		(subscription.Status == dto.SubscriptionStatusActive || subscription.Status == dto.SubscriptionStatusPastDue) {
+		start = *subscription.CurrentPeriodEnd
+	}
+	end := subscription.Plan.PeriodEnd(start)
+
+	payment.Status = dto.BillingStatusPaid
+	payment.PeriodStart, payment.PeriodEnd = &start, &end
+
+	subscription.Status = dto.SubscriptionStatusActive
+	subscription.CurrentPeriodStart, subscription.CurrentPeriodEnd = &start, &end
+	subscription.GraceUntil = nil
+	if bindingId != "" {
+		subscription.BindingId = bindingId
+	}
+
+	return nil
+}
+
+func (banker *mockBanker) FailSubscriptionPayment(ctx context.Context, invoiceId uint, gracePeriod time.Duration) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	payment, err := banker.findSubscriptionPayment(invoiceId)
+	if err != nil {
+		return err
+	}
+
+	if payment.Status != dto.BillingStatusPending {
+		return nil
+	}
+	payment.Status = dto.BillingStatusFailed
+
+	subscription := banker.subscriptions[payment.SubscriptionId]
+	switch subscription.Status {
+	case dto.SubscriptionStatusPending:
+		subscription.Status = dto.SubscriptionStatusExpired
+	case dto.SubscriptionStatusActive:
+		graceUntil := subscription.CurrentPeriodEnd.Add(gracePeriod)
+		subscription.Status = dto.SubscriptionStatusPastDue
+		subscription.GraceUntil = &graceUntil
+	}
+
+	return nil
+}
+
+func (banker *mockBanker) GetUserSubscription(ctx context.Context) (*dto.Subscription, *courseError.CourseError) {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	userId := ctx.Value("UserId").(uint)
+	for id := uint(len(banker.subscriptions)); id > 0; id-- {
+		if v := banker.subscriptions[id]; v.UserId == userId && v.CurrentPeriodEnd != nil {
+			copied := *v
+			return &copied, nil
+		}
+	}
+
+	return nil, courseError.CreateError(errors.New("подписка не найдена"), 15018)
+}
+
+func (banker *mockBanker) CancelSubscription(ctx context.Context) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	userId := ctx.Value("UserId").(uint)
+	for _, v := range banker.subscriptions {
+		if v.UserId == userId && (v.Status == dto.SubscriptionStatusActive || v.Status == dto.SubscriptionStatusPastDue) {
+			v.CancelAtPeriodEnd = true
+			return nil
+		}
+	}
+
+	return courseError.CreateError(errors.New("подписка не найдена"), 15018)
+}
+
+func (banker *mockBanker) ClaimSubscriptionsToRenew(ctx context.Context, renewBefore, retryAfter time.Time, limit int) ([]dto.SubscriptionCharge, *courseError.CourseError) {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	var charges []dto.SubscriptionCharge
+	for id := uint(1); id <= uint(len(banker.subscriptions)) && len(charges) < limit; id++ {
+		v := banker.subscriptions[id]
+		if (v.Status != dto.SubscriptionStatusActive && v.Status != dto.SubscriptionStatusPastDue) ||
+			v.CancelAtPeriodEnd || v.BindingId == "" || v.CurrentPeriodEnd.After(renewBefore) {
+			continue
+		}
+
+		retried := false
+		for _, payment := range banker.subPayments {
+			if payment.SubscriptionId == id && (payment.CreatedAt.After(retryAfter) ||
+				(payment.Status == dto.BillingStatusPending && payment.InvoiceId != 0)) {
+				retried = true
+			}
+		}
+		if retried {
+			continue
+		}
+
+		charges = append(charges, dto.SubscriptionCharge{
+			SubscriptionId:   id,
+			PaymentId:        banker.addSubscriptionPayment(id, dto.ToMinorUnits(v.Plan.Price)),
+			UserId:           v.UserId,
+			PlanId:           v.PlanId,
+			PlanName:         v.Plan.Name,
+			Amount:           dto.ToMinorUnits(v.Plan.Price),
+			BindingId:        v.BindingId,
+			RusCard:          v.RusCard,
+			Email:            fmt.Sprintf("user-%d@gmail.com", v.UserId),
+			CurrentPeriodEnd: v.CurrentPeriodEnd,
+		})
+	}
+
+	return charges, nil
+}
+
+func (banker *mockBanker) GetPendingRenewals(ctx context.Context, afterId uint, limit int) ([]dto.SubscriptionPayment, *courseError.CourseError) {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	var payments []dto.SubscriptionPayment
+	for id := afterId + 1; id <= uint(len(banker.subPayments)) && len(payments) < limit; id++ {
+		v := banker.subPayments[id]
+		if v.Status == dto.BillingStatusPending && v.InvoiceId != 0 &&
+			banker.subscriptions[v.SubscriptionId].Status != dto.SubscriptionStatusPending {
+			payments = append(payments, *v)
+		}
+	}
+
+	return payments, nil
+}
+
+func (banker *mockBanker) ExpireSubscriptions(ctx context.Context, now, pendingBefore time.Time) (int64, *courseError.CourseError) {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	var expired int64
+	for _, v := range banker.subscriptions {
+		ended := v.CurrentPeriodEnd != nil && !v.CurrentPeriodEnd.After(now) && (v.CancelAtPeriodEnd || v.BindingId == "")
+		if (v.Status == dto.SubscriptionStatusActive && ended) ||
+			(v.Status == dto.SubscriptionStatusPastDue && (ended || !v.GraceUntil.After(now))) ||
+			(v.Status == dto.SubscriptionStatusPending && v.CreatedAt.Before(pendingBefore)) {
+			v.Status = dto.SubscriptionStatusExpired
+			expired++
+		}
+	}
+
+	return expired, nil
+}
+
+// mockReconciliationStorage - это заказы в памяти для тестов сверки с банком. Статус оплаченного
+// и просроченного заказа меняется в orders, сохраненные отчеты запоминаются.
+type mockReconciliationStorage struct {
+	mu        sync.Mutex
+	orders    []dto.PurchaseDetails
+	receipts  map[uint]dto.OutboxEmail
+	reminders map[uint]dto.OutboxEmail
+	reports   []dto.ReconciliationReport
+}
+
+func (storage *mockReconciliationStorage) GetUnpaidOrders(ctx context.Context, afterId uint, limit int) ([]dto.PurchaseDetails, *courseError.CourseError) {
+	return storage.find(func(order dto.PurchaseDetails) bool {
+		return order.Status == dto.BillingStatusPending
+	}, afterId, limit), nil
+}
+
+func (storage *mockReconciliationStorage) GetPaidOrders(ctx context.Context, paidAfter time.Time, afterId uint, limit int) ([]dto.PurchaseDetails, *courseError.CourseError) {
+	return storage.find(func(order dto.PurchaseDetails) bool {
+		return order.Status != dto.BillingStatusPending && order.Status != dto.BillingStatusFailed
+	}, afterId, limit), nil
+}
+
+func (storage *mockReconciliationStorage) GetFailedOrders(ctx context.Context, failedAfter time.Time, afterId uint, limit int) ([]dto.PurchaseDetails, *courseError.CourseError) {
+	return storage.find(func(order dto.PurchaseDetails) bool {
+		return order.Status == dto.BillingStatusFailed && order.InvoiceId != 0
+	}, afterId, limit), nil
+}
+
+func (storage *mockReconciliationStorage) find(match func(order dto.PurchaseDetails) bool, afterId uint, limit int) []dto.PurchaseDetails {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	var orders []dto.PurchaseDetails
+	for _, v := range storage.orders {
+		if v.BillingId > afterId && match(v) && len(orders) < limit {
+			orders = append(orders, v)
+		}
+	}
+
+	return orders
+}
+
+func (storage *mockReconciliationStorage) ApprovePayment(ctx context.Context, invoiceId uint, receiptEmail, giftEmail *dto.OutboxEmail) *courseError.CourseError {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	for i := range storage.orders {
+		if storage.orders[i].InvoiceId == invoiceId && storage.orders[i].Status == dto.BillingStatusPending {
+			storage.orders[i].Status = dto.BillingStatusPaid
+			storage.receipts[storage.orders[i].BillingId] = *receiptEmail
+		}
+	}
+
+	return nil
+}
+
+func (storage *mockReconciliationStorage) ExpireOrder(ctx context.Context, billingId uint, reminderEmail *dto.OutboxEmail) *courseError.CourseError {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	for i := range storage.orders {
+		if storage.orders[i].BillingId == billingId {
+			if storage.orders[i].Status == dto.BillingStatusPending {
+				storage.orders[i].Status = dto.BillingStatusFailed
+				storage.reminders[billingId] = *reminderEmail
+			}
+			return nil
+		}
+	}
+
+	return courseError.CreateError(errors.New("инвойс не найден"), 15001)
+}
+
+func (storage *mockReconciliationStorage) SaveReconciliationReport(ctx context.Context, report *dto.ReconciliationReport) *courseError.CourseError {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	storage.reports = append(storage.reports, *report)
+
+	return nil
+}
+
+func (banker *mockBanker) StoreBundle(ctx context.Context, bundle *dto.Bundle) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	for _, v := range banker.bundles {
+		if v.Name == bundle.Name {
+			return courseError.CreateError(errors.New("набор курсов с таким названием уже существует"), 15021)
+		}
+	}
+
+	banker.lastBundleId++
+	bundle.ID = banker.lastBundleId
+	banker.bundles[bundle.ID] = bundle
+
+	return nil
+}
+
+func (banker *mockBanker) UpdateBundle(ctx context.Context, bundle *dto.Bundle) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	if _, ok := banker.bundles[bundle.ID]; !ok {
+		return courseError.CreateError(errors.New("набор курсов не найден"), 15020)
+	}
+
+	banker.bundles[bundle.ID] = bundle
+
+	return nil
+}
+
+func (banker *mockBanker) DeleteBundle(ctx context.Context, id uint) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	if _, ok := banker.bundles[id]; !ok {
+		return courseError.CreateError(errors.New("набор курсов не найден"), 15020)
+	}
+
+	delete(banker.bundles, id)
+
+	return nil
+}
+
+func (banker *mockBanker) GetBundles(ctx context.Context, onlyActive bool) ([]dto.Bundle, *courseError.CourseError) {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	bundles := make([]dto.Bundle, 0, len(banker.bundles))
+	for _, v := range banker.bundles {
+		if v.Active || !onlyActive {
+			bundles = append(bundles, *v)
+		}
+	}
+	sort.Slice(bundles, func(i, j int) bool { return bundles[i].ID < bundles[j].ID })
+
+	return bundles, nil
+}
+
+func (banker *mockBanker) GetBundle(ctx context.Context, id uint) (*dto.Bundle, *courseError.CourseError) {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	bundle, ok := banker.bundles[id]
+	if !ok || !bundle.Active {
+		return nil, courseError.CreateError(errors.New("набор курсов не найден"), 15020)
+	}
+
+	return bundle, nil
+}
+
+func (banker *mockBanker) CreateGift(ctx context.Context, orderId uint, code, recipientEmail string) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	banker.gifts = append(banker.gifts, &dto.GiftDetails{
+		GiftId:         uint(len(banker.gifts) + 1),
+		Code:           code,
+		RecipientEmail: recipientEmail,
+		BillingStatus:  dto.BillingStatusPending,
+	})
+
+	return nil
+}
+
+func (banker *mockBanker) RedeemGift(ctx context.Context, code string) (*dto.GiftDetails, *courseError.CourseError) {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	for _, v := range banker.gifts {
+		if v.Code != code {
+			continue
+		}
+
+		if v.Status() != dto.GiftStatusActive {
+			return nil, courseError.CreateError(errors.New("подарок нельзя активировать"), 15024)
+		}
+
+		userId := ctx.Value("UserId").(uint)
+		now := time.Now()
+		v.RecipientId = &userId
+		v.RedeemedAt = &now
+
+		gift := *v
+		return &gift, nil
+	}
+
+	return nil, courseError.CreateError(errors.New("подарок не найден"), 15022)
+}
+
+func (banker *mockBanker) VoidGift(ctx context.Context, id uint) *courseError.CourseError {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	for _, v := range banker.gifts {
+		if v.GiftId != id {
+			continue
+		}
+
+		if v.RedeemedAt != nil {
+			return courseError.CreateError(errors.New("подарок уже активирован"), 15024)
+		}
+
+		now := time.Now()
+		v.VoidedAt = &now
+
+		return nil
+	}
+
+	return courseError.CreateError(errors.New("подарок не найден"), 15022)
+}
+
+func (banker *mockBanker) GetUserGifts(ctx context.Context) ([]dto.GiftDetails, *courseError.CourseError) {
+	gifts, _, err := banker.GetGifts(ctx, false, len(banker.gifts), 0)
+	return gifts, err
+}
+
+func (banker *mockBanker) GetGifts(ctx context.Context, onlyUnredeemed bool, limit, offset int) ([]dto.GiftDetails, int64, *courseError.CourseError) {
+	banker.mu.Lock()
+	defer banker.mu.Unlock()
+
+	var matched []dto.GiftDetails
+	for i := len(banker.gifts) - 1; i >= 0; i-- {
+		v := banker.gifts[i]
+		if !onlyUnredeemed || (v.RedeemedAt == nil && v.VoidedAt == nil) {
+			matched = append(matched, *v)
+		}
+	}
+
+	gifts := dto.CreateNewGiftsDetails()
+	for i := offset; i < len(matched) && i < offset+limit; i++ {
+		gifts = append(gifts, matched[i])
+	}
+
+	return gifts, int64(len(matched)), nil
+}
+
+func (banker *mockBanker) ResendReceipt(ctx context.Context, billingId uint) *courseError.CourseError {
+	if banker.receiptStorage == nil {
+		return courseError.CreateError(errors.New("чек не найден или не был отклонен онлайн-кассой"), 15030)
+	}
+	return banker.receiptStorage.ResendReceipt(ctx, billingId)
+}
+
+// mockReceiptLine - это позиция чека источника source вместе с состоянием чека. Состояние хранится в платеже,
+// потому что у платежа, оплаты подписки и возврата одинаковые поля чека.
+type mockReceiptLine struct {
+	source string
+	line   dto.ReceiptLine
+	state  *dto.Billing
+}
+
+// mockReceiptStorage - это чеки в памяти для тестов отправки чеков. lines - это позиции чеков в порядке
+// ключа чека, статус и фискальные данные меняются у каждой позиции.
+type mockReceiptStorage struct {
+	mu    sync.Mutex
+	lines []mockReceiptLine
+}
+
+// newMockReceiptStorage собирает хранилище с позициями чеков за оплату курсов.
+func newMockReceiptStorage(lines ...dto.ReceiptLine) *mockReceiptStorage {
+	storage := &mockReceiptStorage{}
+	storage.add(dto.ReceiptSourceBilling, lines...)
+	return storage
+}
+
+// add добавляет позиции ожидающих отправки чеков источника source.
+func (storage *mockReceiptStorage) add(source string, lines ...dto.ReceiptLine) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	for _, v := range lines {
+		state := dto.NewPayment().AddCurrency(dto.CurrencyRUB)
+		state.ID = v.BillingId
+		state.InvoiceId = v.InvoiceId
+		state.Amount = v.Amount
+		state.ReceiptStatus = dto.ReceiptStatusPending
+		storage.lines = append(storage.lines, mockReceiptLine{source: source, line: v, state: state})
+	}
+}
+
+func (storage *mockReceiptStorage) GetPendingReceipts(ctx context.Context, source string, afterId uint, limit int) ([]dto.ReceiptLine, *courseError.CourseError) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	var (
+		lines    []dto.ReceiptLine
+		receipts int
+	)
+	for _, v := range storage.lines {
+		if v.source != source || v.line.SourceId <= afterId || v.state.ReceiptStatus != dto.ReceiptStatusPending {
+			continue
+		}
+		if len(lines) == 0 || lines[len(lines)-1].SourceId != v.line.SourceId {
+			if receipts == limit {
+				break
+			}
+			receipts++
+		}
+		lines = append(lines, v.line)
+	}
+
+	return lines, nil
+}
+
+func (storage *mockReceiptStorage) SetReceiptSent(ctx context.Context, source string, id uint, receiptId string) *courseError.CourseError {
+	storage.update(source, id, []string{dto.ReceiptStatusPending}, func(state *dto.Billing) {
+		state.ReceiptStatus = dto.ReceiptStatusSent
+		state.ReceiptId = receiptId
+		state.ReceiptError = ""
+	})
+	return nil
+}
+
+func (storage *mockReceiptStorage) GetSentReceipts(ctx context.Context, source string, afterId uint, limit int) ([]dto.SentReceipt, *courseError.CourseError) {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	var receipts []dto.SentReceipt
+	for _, v := range storage.lines {
+		if v.source != source || v.line.SourceId <= afterId || v.state.ReceiptStatus != dto.ReceiptStatusSent ||
+			(len(receipts) != 0 && receipts[len(receipts)-1].SourceId == v.line.SourceId) {
+			continue
+		}
+		if len(receipts) == limit {
+			break
+		}
+		receipts = append(receipts, dto.SentReceipt{SourceId: v.line.SourceId, ReceiptId: v.state.ReceiptId})
+	}
+
+	return receipts, nil
+}
+
+func (storage *mockReceiptStorage) SaveFiscalData(ctx context.Context, source string, id uint, fiscalData *dto.FiscalData) *courseError.CourseError {
+	storage.update(source, id, []string{dto.ReceiptStatusSent}, func(state *dto.Billing) {
+		state.ReceiptStatus = dto.ReceiptStatusDone
+		state.FiscalData = *fiscalData
+	})
+	return nil
+}
+
+func (storage *mockReceiptStorage) FailReceipt(ctx context.Context, source string, id uint, reason string) *courseError.CourseError {
+	storage.update(source, id, []string{dto.ReceiptStatusPending, dto.ReceiptStatusSent}, func(state *dto.Billing) {
+		state.ReceiptStatus = dto.ReceiptStatusFailed
+		state.ReceiptError = reason
+	})
+	return nil
+}
+
+func (storage *mockReceiptStorage) ResendReceipt(ctx context.Context, billingId uint) *courseError.CourseError {
+	storage.mu.Lock()
+	var invoiceId uint
+	for _, v := range storage.lines {
+		if v.line.BillingId == billingId {
+			invoiceId = v.line.InvoiceId
+		}
+	}
+	storage.mu.Unlock()
+
+	resend := func(state *dto.Billing) {
+		state.ReceiptStatus = dto.ReceiptStatusPending
+		state.ReceiptId = ""
+	}
+
+	var updated int
+	for _, source := range []string{dto.ReceiptSourceBilling, dto.ReceiptSourceRefund} {
+		updated += storage.updateInvoice(source, invoiceId, []string{dto.ReceiptStatusFailed}, resend)
+	}
+	if invoiceId == 0 || updated == 0 {
+		return courseError.CreateError(errors.New("чек не найден или не был отклонен онлайн-кассой"), 15030)
+	}
+
+	return nil
+}
+
+// update меняет позиции чека источника source с ключом id в одном из статусов statuses. Возвращает число
+// измененных позиций.
+func (storage *mockReceiptStorage) update(source string, id uint, statuses []string, change func(state *dto.Billing)) int {
+	return storage.change(func(v mockReceiptLine) bool { return v.source == source && v.line.SourceId == id }, statuses, change)
+}
+
+// updateInvoice меняет позиции чеков источника source за инвойс invoiceId в одном из статусов statuses.
+func (storage *mockReceiptStorage) updateInvoice(source string, invoiceId uint, statuses []string, change func(state *dto.Billing)) int {
+	return storage.change(func(v mockReceiptLine) bool { return v.source == source && v.line.InvoiceId == invoiceId }, statuses, change)
+}
+
+func (storage *mockReceiptStorage) change(match func(v mockReceiptLine) bool, statuses []string, change func(state *dto.Billing)) int {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	var updated int
+	for _, v := range storage.lines {
+		if !match(v) {
+			continue
+		}
+		for _, status := range statuses {
+			if v.state.ReceiptStatus == status {
+				change(v.state)
+				updated++
+				break
+			}
+		}
+	}
+
+	return updated
+}
+
+// billing возвращает состояние чека за оплату платежа с ID id.
+func (storage *mockReceiptStorage) billing(id uint) dto.Billing {
+	return storage.receipt(dto.ReceiptSourceBilling, func(line dto.ReceiptLine) bool { return line.BillingId == id })
+}
+
+// receipt возвращает состояние первой позиции чека источника source, подходящей под match.
+func (storage *mockReceiptStorage) receipt(source string, match func(line dto.ReceiptLine) bool) dto.Billing {
+	storage.mu.Lock()
+	defer storage.mu.Unlock()
+
+	for _, v := range storage.lines {
+		if v.source == source && match(v.line) {
+			return *v.state
+		}
+	}
+
+	return dto.Billing{}
+}
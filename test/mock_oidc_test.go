@@ -0,0 +1,118 @@
+package tests
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/knstch/course/internal/domain/entity"
+)
+
+const (
+	mockOIDCClientId = "course"
+	mockOIDCCode     = "mock-code"
+	mockOIDCKeyId    = "mock"
+)
+
+// mockOIDCProvider - это локальный OIDC провайдер для тестов социального логина. Выдает ID токены,
+// подписанные RS256, для пользователя, заданного в identity, и проверяет PKCE verifier.
+type mockOIDCProvider struct {
+	server *httptest.Server
+	key    *rsa.PrivateKey
+
+	mu            sync.Mutex
+	nonce         string
+	codeChallenge string
+	subject       string
+	email         string
+	emailVerified bool
+}
+
+func newMockOIDCProvider() (*mockOIDCProvider, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	provider := &mockOIDCProvider{key: key}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/jwks", provider.jwks)
+	mux.HandleFunc("/token", provider.token)
+	provider.server = httptest.NewServer(mux)
+
+	return provider, nil
+}
+
+// authorize запоминает параметры, которые браузер передал бы на страницу авторизации провайдера.
+func (provider *mockOIDCProvider) authorize(nonce, codeChallenge, subject, email string, emailVerified bool) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	provider.nonce = nonce
+	provider.codeChallenge = codeChallenge
+	provider.subject = subject
+	provider.email = email
+	provider.emailVerified = emailVerified
+}
+
+func (provider *mockOIDCProvider) jwks(w http.ResponseWriter, r *http.Request) {
+	jwks := entity.CreateJWKS()
+	jwks.AddKey(entity.JWK{
+		Kty: "RSA",
+		Kid: mockOIDCKeyId,
+		Use: "sig",
+		Alg: jwt.SigningMethodRS256.Alg(),
+		N:   base64.RawURLEncoding.EncodeToString(provider.key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(provider.key.E)).Bytes()),
+	})
+
+	json.NewEncoder(w).Encode(jwks)
+}
+
+func (provider *mockOIDCProvider) token(w http.ResponseWriter, r *http.Request) {
+	provider.mu.Lock()
+	defer provider.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	verifierHash := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if r.PostFormValue("code") != mockOIDCCode ||
+		base64.RawURLEncoding.EncodeToString(verifierHash[:]) != provider.codeChallenge {
+		w.WriteHeader(http.StatusBadRequest)
+		json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	idToken := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":            provider.server.URL,
+		"aud":            mockOIDCClientId,
+		"sub":            provider.subject,
+		"email":          provider.email,
+		"email_verified": provider.emailVerified,
+		"nonce":          provider.nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Minute).Unix(),
+	})
+	idToken.Header["kid"] = mockOIDCKeyId
+
+	signedIdToken, err := idToken.SignedString(provider.key)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "mock-access-token",
+		"id_token":     signedIdToken,
+		"token_type":   "Bearer",
+	})
+}
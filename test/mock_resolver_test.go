@@ -0,0 +1,46 @@
+package tests
+
+import (
+	"context"
+	"errors"
+	"net"
+)
+
+// mockResolver - это резолвер без сети для тестов проверки почты. Отдает MX записи из records,
+// для доменов из failing возвращает временную ошибку DNS, для остальных - NXDOMAIN.
+type mockResolver struct {
+	records map[string][]*net.MX
+	failing map[string]bool
+}
+
+func (resolver *mockResolver) LookupMX(ctx context.Context, name string) ([]*net.MX, error) {
+	if resolver.failing[name] {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	}
+
+	records, ok := resolver.records[name]
+	if !ok {
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	}
+
+	return records, nil
+}
+
+// mockDialer направляет соединения к хостам из hosts на локальный адрес, остальные хосты недоступны.
+type mockDialer struct {
+	hosts map[string]string
+}
+
+func (dialer *mockDialer) DialContext(ctx context.Context, network, address string) (net.Conn, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, err
+	}
+
+	target, ok := dialer.hosts[host]
+	if !ok {
+		return nil, errors.New("connection refused")
+	}
+
+	return (&net.Dialer{}).DialContext(ctx, network, target)
+}
@@ -0,0 +1,178 @@
+package tests
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	courseError "github.com/knstch/course/internal/app/course_error"
+	"github.com/knstch/course/internal/domain/dto"
+)
+
+// mockSMTPServer - это локальный SMTP сервер для тестов отправки писем. Не поддерживает расширения,
+// принимает любые письма и запоминает получателей. Получателям из rejected отвечает кодом 550.
+type mockSMTPServer struct {
+	listener net.Listener
+
+	mu         sync.Mutex
+	recipients []string
+	rejected   map[string]bool
+}
+
+func newMockSMTPServer() (*mockSMTPServer, error) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, err
+	}
+
+	server := &mockSMTPServer{
+		listener: listener,
+		rejected: make(map[string]bool),
+	}
+
+	go server.serve()
+
+	return server, nil
+}
+
+func (server *mockSMTPServer) serve() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			return
+		}
+
+		go server.handle(conn)
+	}
+}
+
+func (server *mockSMTPServer) handle(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		conn.Write([]byte(line + "\r\n"))
+	}
+
+	reply("220 mock")
+
+	inData := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if inData {
+			if line == "." {
+				inData = false
+				reply("250 OK")
+			}
+			continue
+		}
+
+		command := strings.ToUpper(line)
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 mock")
+		case strings.HasPrefix(command, "RCPT TO:"):
+			recipient := strings.Trim(line[len("RCPT TO:"):], "<>")
+			server.mu.Lock()
+			server.recipients = append(server.recipients, recipient)
+			rejected := server.rejected[recipient]
+			server.mu.Unlock()
+			if rejected {
+				reply("550 no such user")
+				continue
+			}
+			reply("250 OK")
+		case strings.HasPrefix(command, "DATA"):
+			inData = true
+			reply("354 go ahead")
+		case strings.HasPrefix(command, "QUIT"):
+			reply("221 bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (server *mockSMTPServer) port() string {
+	_, port, _ := net.SplitHostPort(server.listener.Addr().String())
+	return port
+}
+
+func (server *mockSMTPServer) sentTo() []string {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	return append([]string(nil), server.recipients...)
+}
+
+func (server *mockSMTPServer) reject(recipient string) {
+	server.mu.Lock()
+	defer server.mu.Unlock()
+
+	server.rejected[recipient] = true
+}
+
+func (server *mockSMTPServer) Close() {
+	server.listener.Close()
+}
+
+// mockOutbox - это outbox в памяти для тестов отправщика. Отдает письма один раз и останавливает
+// отправщика, когда сохранены результаты всех писем.
+type mockOutbox struct {
+	mu      sync.Mutex
+	emails  []dto.OutboxEmail
+	claimed bool
+	updated map[uint]dto.OutboxEmail
+	stop    context.CancelFunc
+}
+
+func (outbox *mockOutbox) ClaimOutboxEmails(ctx context.Context, limit int, lease time.Duration) ([]dto.OutboxEmail, *courseError.CourseError) {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+
+	if outbox.claimed {
+		return nil, nil
+	}
+	outbox.claimed = true
+
+	return append([]dto.OutboxEmail(nil), outbox.emails...), nil
+}
+
+func (outbox *mockOutbox) UpdateOutboxEmail(ctx context.Context, email *dto.OutboxEmail) *courseError.CourseError {
+	outbox.mu.Lock()
+	defer outbox.mu.Unlock()
+
+	outbox.updated[email.ID] = *email
+	if len(outbox.updated) == len(outbox.emails) {
+		outbox.stop()
+	}
+
+	return nil
+}
+
+type mockOutboxRecorder struct {
+	mu      sync.Mutex
+	results map[string]int
+}
+
+func (recorder *mockOutboxRecorder) RecordOutboxEmail(result string) {
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+
+	recorder.results[result]++
+}
+
+type mockLogger struct{}
+
+func (mockLogger) Error(message, method, errMessage string, code int) {}
+
+func (mockLogger) Info(message, method, request string) {}